@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/dlna/dms"
+)
+
+// Runs "dms check-streams", which reports the problems of the .dms.json files under the paths
+// given, and fails if there are any.
+func checkStreams(args []string) error {
+	fs := flag.NewFlagSet("check-streams", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s check-streams [flags] <path>...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	configFilePath := fs.String("config", "", "json configuration file, for the stream commands and URLs streams may use")
+	allowStreamCommandLines := fs.Bool("allowStreamCommandLines", false, "accept the command lines given in .dms.json files")
+	dryRun := fs.Duration("dryRun", 0, "run each resource for up to this long, and check it produces data of its MIME-type. 0 to only check the files")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no paths to check")
+	}
+	if *configFilePath != "" {
+		config.load(*configFilePath)
+	}
+	srv := &dms.Server{
+		Logger:                  log.Default.WithNames("check-streams"),
+		StreamCommands:          config.StreamCommands,
+		StreamURLs:              config.StreamURLs,
+		AllowStreamCommandLines: config.AllowStreamCommandLines || *allowStreamCommandLines,
+		IgnoreHidden:            config.IgnoreHidden,
+		IgnorePaths:             config.IgnorePaths,
+	}
+	bad := 0
+	for _, path := range fs.Args() {
+		n, err := srv.CheckDynamicStreams(path, *dryRun, func(path string, err error) {
+			fmt.Printf("%s: %v\n", path, err)
+		})
+		bad += n
+		if err != nil {
+			return err
+		}
+	}
+	if bad != 0 {
+		return fmt.Errorf("%d bad dynamic streams", bad)
+	}
+	return nil
+}
@@ -19,10 +19,11 @@ type ContentFeatures struct {
 	ProfileName     string
 	SupportTimeSeek bool
 	SupportRange    bool
-	// Play speeds, DLNA.ORG_PS would go here if supported.
+	// DLNA.ORG_PS, the speeds other than 1 the resource can be played at, like "-2" or "1/2".
+	PlaySpeeds []string
 	Transcoded bool
-	// DLNA.ORG_FLAGS go here if you need to tweak.
-	Flags string
+	// DLNA.ORG_FLAGS. DefaultFlags are used if none are set.
+	Flags Flags
 }
 
 func BinaryInt(b bool) uint {
@@ -37,25 +38,66 @@ func BinaryInt(b bool) uint {
 // "DLNA.ORG_OP=" time-seek-range-supp bytes-range-header-supp
 func (cf ContentFeatures) String() (ret string) {
 	// DLNA.ORG_PN=[a-zA-Z0-9_]*
-	params := make([]string, 0, 3)
+	params := make([]string, 0, 4)
 	if cf.ProfileName != "" {
 		params = append(params, "DLNA.ORG_PN="+cf.ProfileName)
 	}
 	params = append(params, fmt.Sprintf(
-		"DLNA.ORG_OP=%b%b;DLNA.ORG_CI=%b",
+		"DLNA.ORG_OP=%b%b",
 		BinaryInt(cf.SupportTimeSeek),
-		BinaryInt(cf.SupportRange),
-		BinaryInt(cf.Transcoded)))
+		BinaryInt(cf.SupportRange)))
+	if len(cf.PlaySpeeds) != 0 {
+		params = append(params, "DLNA.ORG_PS="+strings.Join(cf.PlaySpeeds, ","))
+	}
+	params = append(params, fmt.Sprintf("DLNA.ORG_CI=%b", BinaryInt(cf.Transcoded)))
 	// https://stackoverflow.com/questions/29182754/c-dlna-generate-dlna-org-flags
-	// DLNA_ORG_FLAG_STREAMING_TRANSFER_MODE | DLNA_ORG_FLAG_BACKGROUND_TRANSFERT_MODE | DLNA_ORG_FLAG_CONNECTION_STALL | DLNA_ORG_FLAG_DLNA_V15
-	flags := "01700000000000000000000000000000"
-	if cf.Flags != "" {
+	flags := DefaultFlags
+	if cf.Flags != 0 {
 		flags = cf.Flags
 	}
-	params = append(params, "DLNA.ORG_FLAGS="+flags)
+	params = append(params, "DLNA.ORG_FLAGS="+flags.String())
 	return strings.Join(params, ";")
 }
 
+// ParseContentFeatures parses the fourth field of a protocolInfo, or a contentFeatures.dlna.org
+// header. Parameters from other vendors, like SONY.COM_PN, are ignored, as is "*".
+func ParseContentFeatures(s string) (cf ContentFeatures, err error) {
+	s = strings.TrimSpace(s)
+	if s == "*" {
+		return
+	}
+	for _, param := range strings.Split(s, ";") {
+		if param == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			err = fmt.Errorf("parsing content features %q: bad parameter %q", s, param)
+			return
+		}
+		switch key {
+		case "DLNA.ORG_PN":
+			cf.ProfileName = value
+		case "DLNA.ORG_OP":
+			if len(value) != 2 || strings.Trim(value, "01") != "" {
+				err = fmt.Errorf("parsing content features %q: bad DLNA.ORG_OP %q", s, value)
+				return
+			}
+			cf.SupportTimeSeek = value[0] == '1'
+			cf.SupportRange = value[1] == '1'
+		case "DLNA.ORG_PS":
+			cf.PlaySpeeds = strings.Split(value, ",")
+		case "DLNA.ORG_CI":
+			cf.Transcoded = value == "1"
+		case "DLNA.ORG_FLAGS":
+			if cf.Flags, err = ParseFlags(value); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
 func ParseNPTTime(s string) (time.Duration, error) {
 	var h, m, sec, ms time.Duration
 	n, err := fmt.Sscanf(s, "%d:%2d:%2d.%3d", &h, &m, &sec, &ms)
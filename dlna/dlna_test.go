@@ -14,3 +14,59 @@ func TestContentFeaturesString(t *testing.T) {
 		t.Fatal(a)
 	}
 }
+
+func TestFlags(t *testing.T) {
+	if s := DefaultFlags.String(); s != "01700000000000000000000000000000" {
+		t.Fatal(s)
+	}
+	serviio := FlagSenderPaced | FlagS0Increasing | FlagSNIncreasing | FlagStreamingTransferMode | FlagBackgroundTransferMode | FlagDLNAV15
+	if s := serviio.String(); s != "8D500000000000000000000000000000" {
+		t.Fatal(s)
+	}
+	for _, s := range []string{
+		"8D500000000000000000000000000000",
+		"8d500000",
+		"senderPaced|s0Increasing|snIncreasing|streaming|background|dlnaV15",
+		"dlnav15, streaming,background,senderpaced,s0Increasing,snIncreasing",
+	} {
+		f, err := ParseFlags(s)
+		if err != nil || f != serviio {
+			t.Errorf("%q: got %s, %v", s, f.Names(), err)
+		}
+	}
+	for _, s := range []string{"8D5000000000", "streaming|bogus"} {
+		if _, err := ParseFlags(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+	if s := DefaultFlags.Names(); s != "streaming|background|connectionStall|dlnaV15" {
+		t.Fatal(s)
+	}
+}
+
+func TestParseContentFeatures(t *testing.T) {
+	cf := ContentFeatures{
+		ProfileName:     "AVC_TS_HD_50_AC3_T",
+		SupportTimeSeek: true,
+		PlaySpeeds:      []string{"-2", "1/2", "2"},
+		Transcoded:      true,
+		Flags:           FlagSenderPaced | FlagStreamingTransferMode | FlagDLNAV15,
+	}
+	s := cf.String()
+	if s != "DLNA.ORG_PN=AVC_TS_HD_50_AC3_T;DLNA.ORG_OP=10;DLNA.ORG_PS=-2,1/2,2;DLNA.ORG_CI=1;DLNA.ORG_FLAGS=81100000000000000000000000000000" {
+		t.Fatal(s)
+	}
+	parsed, err := ParseContentFeatures(s + ";SONY.COM_PN=HD2_50_T")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.String() != s {
+		t.Fatalf("got %+v", parsed)
+	}
+	if parsed, err := ParseContentFeatures("*"); err != nil || parsed.ProfileName != "" {
+		t.Fatalf("got %+v, %v", parsed, err)
+	}
+	if _, err := ParseContentFeatures("DLNA.ORG_OP=2"); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,236 @@
+package dms
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// PathAccess restricts the clients that may use part of the library.
+type PathAccess struct {
+	// The path relative to the root, like "/Movies". The rule covers everything under it.
+	Path string
+	// Clients allowed and denied, given as IPs or CIDR networks. Denials win, and an empty allowed
+	// list allows every client not denied.
+	AllowedIPs []string
+	DeniedIPs  []string
+}
+
+func (p PathAccess) validate() error {
+	for _, ss := range [][]string{p.AllowedIPs, p.DeniedIPs} {
+		for _, s := range ss {
+			if _, err := parseIPNet(s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p PathAccess) covers(objectPath string) bool {
+	rulePath := path.Clean("/" + p.Path)
+	return rulePath == "/" || objectPath == rulePath || strings.HasPrefix(objectPath, rulePath+"/")
+}
+
+func (p PathAccess) allows(ip net.IP) bool {
+	if ipListContains(p.DeniedIPs, ip) {
+		return false
+	}
+	return len(p.AllowedIPs) == 0 || ipListContains(p.AllowedIPs, ip)
+}
+
+func ipListContains(ss []string, ip net.IP) bool {
+	for _, s := range ss {
+		if ipNet, err := parseIPNet(s); err == nil && ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func ipNetsContain(ipNets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range ipNets {
+		if ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the client at the address may use the server. Denied addresses are kept out first.
+// Media receivers in the device registry are then let in or kept out by their status, whatever the
+// allowed IP list says. If approval is required, every other client is kept out unless it's
+// registering, which covers discovering the server too.
+func (me *Server) clientAllowed(clientIp string, registering bool) bool {
+	ip := net.ParseIP(clientIp)
+	if ipNetsContain(me.DeniedIpNets, ip) {
+		return false
+	}
+	if status, ok := me.devices.addrStatus(clientIp); ok {
+		switch status {
+		case deviceApproved:
+			return true
+		case deviceDenied:
+			return false
+		}
+	}
+	if me.RequireDeviceApproval && !registering {
+		return false
+	}
+	return ipNetsContain(me.AllowedIpNets, ip)
+}
+
+// Whether the client at the address may use the debug and admin endpoints.
+func (me *Server) adminAllowed(clientIp string) bool {
+	ip := net.ParseIP(clientIp)
+	if ipNetsContain(me.DeniedIpNets, ip) {
+		return false
+	}
+	if me.AdminIpNets == nil {
+		return ip != nil && ip.IsLoopback()
+	}
+	return ipNetsContain(me.AdminIpNets, ip)
+}
+
+// Whether the client at the address may use the object at the path relative to the root. The
+// rule with the longest path covering it applies.
+func (me *Server) pathAllowed(clientIp, objectPath string) bool {
+	objectPath = path.Clean("/" + objectPath)
+	var rule *PathAccess
+	for i, p := range me.PathAccess {
+		if p.covers(objectPath) && (rule == nil || len(path.Clean("/"+p.Path)) > len(path.Clean("/"+rule.Path))) {
+			rule = &me.PathAccess[i]
+		}
+	}
+	return rule == nil || rule.allows(net.ParseIP(clientIp))
+}
+
+// Removes the CDS objects the client making the request may not see, by the path access rules and
+// its profile.
+func (me *contentDirectoryService) filterAllowedObjects(r *http.Request, objs []interface{}) (ret []interface{}) {
+	profile := me.clientProfile(r)
+	for _, obj := range objs {
+		if obj, ok := me.allowedObject(r, profile, obj); ok {
+			ret = append(ret, obj)
+		}
+	}
+	return
+}
+
+// Returns the CDS object if the client making the request, with the profile, may see it. Items in
+// views are checked as the file they refer to. Containers, for views and directories alike, are
+// only kept if they hold something the client may see, with the count of those as their child
+// count.
+func (me *contentDirectoryService) allowedObject(r *http.Request, profile *ClientProfile, obj interface{}) (interface{}, bool) {
+	if len(me.PathAccess) == 0 && profile == nil {
+		return obj, true
+	}
+	var o upnpav.Object
+	switch v := obj.(type) {
+	case upnpav.Item:
+		o = v.Object
+	case upnpav.Container:
+		if id, ok := parseVirtualID(v.ID); ok {
+			children, err := me.virtualChildren(id, r.Host, r.UserAgent())
+			if err != nil {
+				return nil, false
+			}
+			children = me.filterAllowedObjects(r, children)
+			if len(children) == 0 {
+				return nil, false
+			}
+			v.ChildCount = len(children)
+			return v, true
+		}
+		o = v.Object
+	}
+	if profile != nil && strings.HasPrefix(o.Class, "object.item.videoItem") && !profile.ratingVisible(o.Rating) {
+		return nil, false
+	}
+	id := o.ID
+	if o.RefID != "" {
+		id = o.RefID
+	}
+	if cdsObj, err := me.objectFromID(id); err == nil {
+		clientIp := requestClientIP(r)
+		if !me.pathAllowed(clientIp, cdsObj.Path) || profile != nil && !profile.pathVisible(cdsObj.Path) {
+			return nil, false
+		}
+		if c, ok := obj.(upnpav.Container); ok {
+			c.ChildCount = me.objectChildCount(cdsObj, func(objectPath string) bool {
+				return me.objectVisible(clientIp, profile, objectPath)
+			})
+			if cdsObj.IsRoot() {
+				c.ChildCount += len(me.filterAllowedObjects(r, me.virtualViewContainers(r.Host, r.UserAgent())))
+			}
+			if c.ChildCount == 0 {
+				return nil, false
+			}
+			return c, true
+		}
+	}
+	return obj, true
+}
+
+func isAdminPath(urlPath string) bool {
+	return strings.HasPrefix(urlPath, "/debug/") || urlPath == statusPath || urlPath == devicesPath || urlPath == recordingsPath
+}
+
+// Whether the request is one a device awaiting approval needs to make to register: for the device
+// and service descriptions, or to the X_MS_MediaReceiverRegistrar service.
+func isRegistering(r *http.Request) bool {
+	switch {
+	case r.URL.Path == rootDescPath, strings.HasPrefix(r.URL.Path, "/scpd/"):
+		return true
+	case r.URL.Path == serviceControlURL:
+		sa, err := upnp.ParseActionHTTPHeader(r.Header.Get("SOAPACTION"))
+		return err == nil && sa.Type == mediaReceiverRegistrarServiceType
+	}
+	return false
+}
+
+// Whether a request changing something came from a page served by the same host, so other sites
+// can't have an admin's browser make it. Requests from clients that don't send the headers, like
+// curl, are allowed.
+func sameOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin" || site == "none"
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return true
+}
+
+// Wraps the handler to keep clients out of routes they may not use: the allowed clients for
+// everything, the admin clients for debug and admin routes, and the path access rules and client
+// profiles for requests for objects in the library.
+func (me *Server) accessControl(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientIp := requestClientIP(r)
+		allowed := func() bool {
+			if isAdminPath(r.URL.Path) {
+				return me.adminAllowed(clientIp)
+			}
+			if !me.clientAllowed(clientIp, isRegistering(r)) {
+				return false
+			}
+			if p := r.URL.Query().Get("path"); p != "" && !me.objectAllowed(r, p) {
+				return false
+			}
+			return true
+		}()
+		if !allowed {
+			me.Logger.Printf("not allowed client %s for %s", clientIp, r.URL.Path)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
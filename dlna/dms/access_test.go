@@ -0,0 +1,77 @@
+package dms
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestAccessControl(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("10.0.0.0/24")
+	_, bad, _ := net.ParseCIDR("10.0.0.66/32")
+	srv := &Server{
+		Logger:        log.Default,
+		AllowedIpNets: []*net.IPNet{lan},
+		DeniedIpNets:  []*net.IPNet{bad},
+		PathAccess: []PathAccess{
+			{Path: "/Private", AllowedIPs: []string{"10.0.0.2"}},
+			{Path: "/Private/Shared"},
+		},
+	}
+	h := srv.accessControl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tc := range []struct {
+		addr, target string
+		code         int
+	}{
+		{"10.0.0.3", rootDescPath, http.StatusOK},
+		{"10.1.0.3", rootDescPath, http.StatusForbidden},
+		{"10.0.0.66", resPath + "?path=%2Fa.mkv", http.StatusForbidden},
+		{"10.0.0.3", resPath + "?path=%2Fa.mkv", http.StatusOK},
+		{"10.0.0.3", resPath + "?path=%2FPrivate%2Fa.mkv", http.StatusForbidden},
+		{"10.0.0.2", iconPath + "?path=%2FPrivate%2Fa.mkv", http.StatusOK},
+		{"10.0.0.3", subtitlePath + "?path=%2FPrivate%2FShared%2Fa.srt", http.StatusOK},
+		{"10.0.0.3", "/debug/pprof/", http.StatusForbidden},
+		{"10.0.0.3", statusPath, http.StatusForbidden},
+		{"127.0.0.1", statusPath, http.StatusOK},
+	} {
+		r := httptest.NewRequest("GET", tc.target, nil)
+		r.RemoteAddr = tc.addr + ":1234"
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.code {
+			t.Errorf("%s %s: got %d, expected %d", tc.addr, tc.target, w.Code, tc.code)
+		}
+	}
+	srv.RootObjectPath = t.TempDir()
+	for _, p := range []string{"Private/x.mkv", "Private/Shared/a.mkv", "Public/b.mkv", "Public/Hidden/c.mkv"} {
+		p = filepath.Join(srv.RootObjectPath, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.PathAccess = append(srv.PathAccess, PathAccess{Path: "/Public/Hidden", AllowedIPs: []string{"10.0.0.2"}})
+	cds := &contentDirectoryService{Server: srv}
+	r := httptest.NewRequest("POST", serviceControlURL, nil)
+	r.RemoteAddr = "10.0.0.3:1234"
+	objs := cds.filterAllowedObjects(r, []interface{}{
+		upnpav.Container{Object: upnpav.Object{ID: object{Path: "/Private"}.ID()}},
+		upnpav.Item{Object: upnpav.Object{ID: "@series/Show/1/x", RefID: object{Path: "/Private/x.mkv"}.ID()}},
+		upnpav.Container{Object: upnpav.Object{ID: object{Path: "/Private/Shared"}.ID()}, ChildCount: 1},
+		upnpav.Container{Object: upnpav.Object{ID: object{Path: "/Public"}.ID()}, ChildCount: 2},
+		upnpav.Container{Object: upnpav.Object{ID: "@series"}},
+	})
+	// The series view is empty, so its container goes too. Hidden folders aren't counted.
+	if len(objs) != 2 || objs[1].(upnpav.Container).ChildCount != 1 {
+		t.Fatalf("got %+v", objs)
+	}
+}
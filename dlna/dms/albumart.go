@@ -0,0 +1,321 @@
+package dms
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+	"github.com/nfnt/resize"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Image files looked for in an item's directory when AlbumArtFiles isn't set. Patterns are matched
+// case-insensitively, in order.
+var defaultAlbumArtFiles = []string{
+	"folder.jpg",
+	"folder.png",
+	"cover.jpg",
+	"cover.png",
+	"front.jpg",
+	"AlbumArt*.jpg",
+	"poster.jpg",
+	"poster.png",
+}
+
+// Where the album art for an item or container comes from.
+type albumArtSource struct {
+	// An image file holding the art.
+	File string
+	// A media file with the art embedded, in its tags or as an attached picture stream.
+	Embedded string
+}
+
+var errNoAlbumArt = errors.New("no album art")
+
+func (src albumArtSource) found() bool {
+	return src.File != "" || src.Embedded != ""
+}
+
+func (src albumArtSource) cacheSize() int64 {
+	return int64(len(src.File) + len(src.Embedded))
+}
+
+// Returns the album art for a media file or directory. Videos prefer poster and fanart images
+// named for them. Media files then prefer their own embedded art over image files in their
+// directory. Directories use their image files, or otherwise the embedded art of the first audio
+// file in them.
+//
+// Lookups are cached until the file or its directory changes, so images added beside it are
+// found, and browsing a folder again doesn't list or probe anything.
+func (me *Server) albumArt(filePath string, fi os.FileInfo) (src albumArtSource) {
+	key := fileCacheKey{Path: filePath, ModTime: fi.ModTime().UnixNano()}
+	var dirInfo os.FileInfo
+	if !fi.IsDir() {
+		var err error
+		dirInfo, err = os.Stat(filepath.Dir(filePath))
+		if err != nil {
+			return
+		}
+		key.DirModTime = dirInfo.ModTime().UnixNano()
+	}
+	if src, ok := me.albumArtCache.get(key); ok {
+		return src
+	}
+	defer func() { me.albumArtCache.set(key, src) }()
+	if !fi.IsDir() {
+		if mimeType, err := MimeTypeByPath(filePath); err == nil && mimeType.IsVideo() {
+			names, _ := me.dirNames(filepath.Dir(filePath))
+			if poster := videoPoster(filePath, names); poster != "" {
+				src.File = poster
+				return
+			}
+		}
+		if me.hasEmbeddedArt(filePath) {
+			src.Embedded = filePath
+			return
+		}
+		return albumArtSource{File: me.albumArt(filepath.Dir(filePath), dirInfo).File}
+	}
+	names, err := me.dirNames(filePath)
+	if err != nil {
+		return
+	}
+	if name := matchAlbumArtFile(names, me.AlbumArtFiles); name != "" {
+		src.File = filepath.Join(filePath, name)
+		return
+	}
+	for _, name := range names {
+		childPath := filepath.Join(filePath, name)
+		if mimeType, err := MimeTypeByPath(childPath); err != nil || !mimeType.IsAudio() {
+			continue
+		}
+		if me.hasEmbeddedArt(childPath) {
+			src.Embedded = childPath
+			return
+		}
+		// Albums almost always have art on every track or none, so don't probe them all.
+		break
+	}
+	return
+}
+
+// The sorted names in a directory.
+type dirNames []string
+
+func (names dirNames) cacheSize() (n int64) {
+	for _, name := range names {
+		n += int64(len(name))
+	}
+	return
+}
+
+// Returns the sorted names in the directory, which mustn't be modified. Directories are listed
+// again only once they change, as browsing one looks among them for each of its videos'
+// subtitles and posters.
+func (me *Server) dirNames(dirPath string) ([]string, error) {
+	fi, err := os.Stat(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	key := fileCacheKey{Path: dirPath, ModTime: fi.ModTime().UnixNano()}
+	if names, ok := me.dirNamesCache.get(key); ok {
+		return names, nil
+	}
+	names, err := readDirNames(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	me.dirNamesCache.set(key, names)
+	return names, nil
+}
+
+func readDirNames(dirPath string) ([]string, error) {
+	f, err := os.Open(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	sort.Strings(names)
+	return names, err
+}
+
+// Returns the first name matching the highest priority pattern.
+func matchAlbumArtFile(names, patterns []string) string {
+	if patterns == nil {
+		patterns = defaultAlbumArtFiles
+	}
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		for _, name := range names {
+			if ok, _ := path.Match(pattern, strings.ToLower(name)); ok {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Returns the poster for a video from the sidecar images Kodi and Jellyfin use, falling back to
+// fanart. The names are those in the video's directory.
+func videoPoster(videoPath string, names []string) string {
+	dir := filepath.Dir(videoPath)
+	base := escapeGlob(strings.TrimSuffix(filepath.Base(videoPath), filepath.Ext(videoPath)))
+	name := matchAlbumArtFile(names, []string{
+		base + "-poster.jpg",
+		base + "-poster.png",
+		"poster.jpg",
+		"poster.png",
+		base + "-fanart.jpg",
+		base + "-fanart.png",
+		"fanart.jpg",
+		"fanart.png",
+	})
+	if name == "" {
+		return ""
+	}
+	return filepath.Join(dir, name)
+}
+
+// Escapes the characters special to path.Match.
+func escapeGlob(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`).Replace(s)
+}
+
+// Returns true if the tags of an audio file have a picture, or ffprobe reports an attached
+// picture in the file.
+func (me *Server) hasEmbeddedArt(filePath string) bool {
+	if me.NoEmbeddedAlbumArt {
+		return false
+	}
+	if mimeType, err := MimeTypeByPath(filePath); err == nil && mimeType.IsAudio() {
+		if _, hasPicture, err := me.audioTags(filePath); err == nil && hasPicture {
+			return true
+		}
+	}
+	if me.NoProbe {
+		return false
+	}
+	info, err := me.ffmpegProbe(filePath)
+	if err != nil || info == nil {
+		return false
+	}
+	return hasAttachedPic(info)
+}
+
+func hasAttachedPic(info *ffprobe.Info) bool {
+	for _, stream := range info.Streams {
+		if stream["codec_type"] == "video" && isAttachedPic(stream) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a probed stream is cover art rather than video.
+func isAttachedPic(stream map[string]interface{}) bool {
+	disposition, _ := stream["disposition"].(map[string]interface{})
+	v, err := ffprobe.AnyAsInt64(disposition["attached_pic"])
+	return err == nil && v == 1
+}
+
+// Returns the upnp:albumArtURI elements for a URL, which may be empty.
+func albumArtURIs(url string) []upnpav.AlbumArtURI {
+	if url == "" {
+		return nil
+	}
+	return []upnpav.AlbumArtURI{{URL: url}}
+}
+
+// Returns the URL of the album art for the object, or "" if it has none.
+func (me *Server) albumArtURL(host string, cdsObject object, fi os.FileInfo) string {
+	if !me.albumArt(cdsObject.FilePath(), fi).found() {
+		return ""
+	}
+	return (&url.URL{
+		Scheme: "http",
+		Host:   host,
+		Path:   albumArtPath,
+		RawQuery: url.Values{
+			"path": {cdsObject.Path},
+		}.Encode(),
+	}).String()
+}
+
+// Decodes the album art image, sized to fit within a thumbnail.
+func (me *Server) loadAlbumArt(src albumArtSource) (image.Image, error) {
+	if src.File != "" {
+		return loadOrientedImage(src.File, thumbnailSize, thumbnailSize)
+	}
+	var img image.Image
+	if p, err := audioTagsPicture(src.Embedded); err == nil {
+		img, _, err = image.Decode(bytes.NewReader(p.Data))
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "decoding tagged picture in %q: %v", src.Embedded, err)
+		}
+	}
+	if img == nil {
+		var err error
+		img, err = extractFrame(src.Embedded, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return resize.Thumbnail(thumbnailSize, thumbnailSize, img, resize.Lanczos3), nil
+}
+
+// Serves the album art for an item or container as a JPEG_TN.
+func (me *Server) serveAlbumArt(w http.ResponseWriter, r *http.Request) {
+	filePath := me.filePath(r.URL.Query().Get("path"))
+	if ignored, err := me.IgnorePath(filePath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if ignored {
+		http.Error(w, "no such object", http.StatusNotFound)
+		return
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	src := me.albumArt(filePath, fi)
+	if !src.found() {
+		http.Error(w, errNoAlbumArt.Error(), http.StatusNotFound)
+		return
+	}
+	cacheKeyPath := src.File + src.Embedded
+	cacheKeyInfo, err := os.Stat(cacheKeyPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	body, err := me.cache.getOrCreate(cacheKeyPath, cacheKeyInfo, "albumart:JPEG_TN", func() ([]byte, error) {
+		img, err := me.loadAlbumArt(src)
+		if err != nil {
+			return nil, err
+		}
+		return encodeImage(img, "image/jpeg")
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
+		ProfileName: "JPEG_TN",
+		Transcoded:  true,
+	}.String())
+	http.ServeContent(w, r, "", cacheKeyInfo.ModTime(), bytes.NewReader(body))
+}
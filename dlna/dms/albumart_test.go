@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMatchAlbumArtFile(t *testing.T) {
+	names := []string{"01 Track.flac", "AlbumArtSmall.jpg", "Cover.JPG", "back.jpg"}
+	if got := matchAlbumArtFile(names, nil); got != "Cover.JPG" {
+		t.Errorf("got %q, want Cover.JPG", got)
+	}
+	if got := matchAlbumArtFile(names, []string{"albumart*.jpg", "cover.jpg"}); got != "AlbumArtSmall.jpg" {
+		t.Errorf("got %q, want AlbumArtSmall.jpg", got)
+	}
+	if got := matchAlbumArtFile(names, []string{"folder.jpg"}); got != "" {
+		t.Errorf("got %q, want no match", got)
+	}
+}
+
+func TestAlbumArtFromDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"track.mp3", "folder.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{NoProbe: true}
+	track := filepath.Join(dir, "track.mp3")
+	fi, err := os.Stat(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.albumArt(track, fi); got != (albumArtSource{File: filepath.Join(dir, "folder.png")}) {
+		t.Errorf("item art: got %+v", got)
+	}
+	dirInfo, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.albumArt(dir, dirInfo); got.File != filepath.Join(dir, "folder.png") {
+		t.Errorf("container art: got %+v", got)
+	}
+}
+
+func TestAlbumArtAddedLater(t *testing.T) {
+	dir := t.TempDir()
+	track := filepath.Join(dir, "track.mp3")
+	if err := os.WriteFile(track, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{NoProbe: true}
+	fi, err := os.Stat(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.albumArt(track, fi); got.found() {
+		t.Fatalf("got %+v before adding art", got)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cover.jpg"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the directory's modification time changes on coarse filesystems.
+	later := fi.ModTime().Add(time.Minute)
+	if err := os.Chtimes(dir, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := srv.albumArt(track, fi); got.File != filepath.Join(dir, "cover.jpg") {
+		t.Errorf("got %+v after adding art", got)
+	}
+}
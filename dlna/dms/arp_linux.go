@@ -0,0 +1,29 @@
+//go:build linux
+// +build linux
+
+package dms
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// Returns the MAC address of the host with the IP from the ARP table, or empty if it's not there.
+func arpLookup(ip string) string {
+	f, err := os.Open("/proc/net/arp")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	// The first line is the header: IP address, HW type, Flags, HW address, Mask and Device.
+	s.Scan()
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) >= 4 && fields[0] == ip && fields[3] != "00:00:00:00:00:00" {
+			return fields[3]
+		}
+	}
+	return ""
+}
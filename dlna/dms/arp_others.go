@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package dms
+
+// Returns the MAC address of the host with the IP from the ARP table, or empty if it's not there.
+// The ARP table is only read on Linux.
+func arpLookup(ip string) string {
+	return ""
+}
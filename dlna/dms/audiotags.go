@@ -0,0 +1,83 @@
+package dms
+
+import (
+	"os"
+	"time"
+
+	"github.com/anacrolix/dms/tags"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Tags read from an audio file, without their picture, which is only needed when serving album
+// art.
+type cachedAudioTags struct {
+	tags       tags.Tags
+	hasPicture bool
+	err        error
+}
+
+func (v cachedAudioTags) cacheSize() int64 {
+	t := v.tags
+	return int64(len(t.Title) + len(t.Artist) + len(t.AlbumArtist) + len(t.Album) + len(t.Genre) + 64)
+}
+
+// Returns the tags of an audio file as read natively, without its picture, and whether it has
+// one.
+func (me *Server) audioTags(filePath string) (t tags.Tags, hasPicture bool, err error) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	key := fileCacheKey{Path: filePath, ModTime: fi.ModTime().UnixNano()}
+	if v, ok := me.audioTagsCache.get(key); ok {
+		return v.tags, v.hasPicture, v.err
+	}
+	t, err = tags.ReadFile(filePath)
+	hasPicture = t.Picture != nil
+	t.Picture = nil
+	me.audioTagsCache.set(key, cachedAudioTags{t, hasPicture, err})
+	return
+}
+
+// Returns the picture embedded in the tags of an audio file.
+func audioTagsPicture(filePath string) (*tags.Picture, error) {
+	t, err := tags.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if t.Picture == nil {
+		return nil, errNoAlbumArt
+	}
+	return t.Picture, nil
+}
+
+// Fills in an audio item from its tags. Items with any of a title, artist or album are music
+// tracks.
+func applyAudioTags(obj *upnpav.Object, t tags.Tags) {
+	if t.Title != "" {
+		obj.Title = t.Title
+	}
+	obj.Artists = nil
+	if t.Artist != "" {
+		obj.Artists = append(obj.Artists, upnpav.Person{Name: t.Artist})
+	}
+	if t.AlbumArtist != "" {
+		obj.Artists = append(obj.Artists, upnpav.Person{Name: t.AlbumArtist, Role: "AlbumArtist"})
+	}
+	obj.Creator = t.Artist
+	if obj.Creator == "" {
+		obj.Creator = t.AlbumArtist
+	}
+	obj.Album = t.Album
+	obj.Genres = nil
+	if t.Genre != "" {
+		obj.Genres = []string{t.Genre}
+	}
+	obj.OriginalTrackNumber = t.Track
+	if t.Year > 0 {
+		obj.Date = upnpav.Timestamp{Time: time.Date(t.Year, 1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	if t.Title != "" || t.Artist != "" || t.Album != "" {
+		obj.Class = "object.item.audioItem.musicTrack"
+	}
+}
@@ -0,0 +1,47 @@
+package dms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestAudioItemTags(t *testing.T) {
+	frame := func(id, text string) []byte {
+		b := []byte(id)
+		b = append(b, 0, 0, 0, byte(1+len(text)), 0, 0, 0)
+		return append(b, text...)
+	}
+	var body []byte
+	body = append(body, frame("TIT2", "Song")...)
+	body = append(body, frame("TPE1", "Artist")...)
+	body = append(body, frame("TRCK", "5/9")...)
+	b := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(body))}, body...)
+	// A single 128kbps MPEG-1 Layer III frame.
+	mp3Frame := make([]byte, 417)
+	copy(mp3Frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	b = append(b, mp3Frame...)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "track.mp3"), b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cds := &contentDirectoryService{Server: &Server{RootObjectPath: dir, NoProbe: true, NoTranscode: true}}
+	o := object{"/track.mp3", dir}
+	fi, err := os.Stat(o.FilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := cds.cdsObjectToUpnpavObject(o, fi, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	item := ret.(upnpav.Item)
+	if item.Title != "Song" || item.Creator != "Artist" || item.OriginalTrackNumber != 5 || item.Class != "object.item.audioItem.musicTrack" {
+		t.Fatalf("got %+v", item.Object)
+	}
+	if item.Res[0].Duration == "" {
+		t.Fatal("expected a duration from the frames")
+	}
+}
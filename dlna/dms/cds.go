@@ -18,6 +18,7 @@ import (
 	"github.com/anacrolix/log"
 
 	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/exif"
 	"github.com/anacrolix/dms/misc"
 	"github.com/anacrolix/dms/upnp"
 	"github.com/anacrolix/dms/upnpav"
@@ -37,16 +38,33 @@ func (cds *contentDirectoryService) updateIDString() string {
 type dmsDynamicStreamResource struct {
 	// (optional) DLNA profile name to include in the response e.g. MPEG_PS_PAL
 	DlnaProfileName string
-	// (optional) DLNA.ORG_FLAGS if you need to override the default (8D500000000000000000000000000000)
-	DlnaFlags string
+	// (optional) DLNA.ORG_FLAGS if you need to override the default of senderPaced|s0Increasing|
+	// snIncreasing|streaming|background|dlnaV15. Either the hex digits, or the flag names
+	// separated by "|", as accepted by dlna.ParseFlags.
+	DlnaFlags dlna.Flags
 	// required: mime type, e.g. video/mpeg
 	MimeType string
 	// (optional) resolution, e.g. 640x360
 	Resolution string
 	// (optional) bitrate, e.g. 721
 	Bitrate uint
-	// required: OS command to generate this resource on the fly
-	Command string
+	// The name of the stream command in the server configuration that generates this resource
+	// on the fly, and the parameters to give it.
+	Stream string
+	Params map[string]string `json:",omitempty"`
+	// OS command to generate this resource on the fly, in place of Stream. Only run if the server
+	// allows stream command lines.
+	Command string `json:",omitempty"`
+	// The HTTP or HLS URL of a remote stream to relay, in place of a command. It's remuxed with
+	// ffmpeg only if MimeType differs from the remote stream's.
+	URL string `json:",omitempty"`
+	// (optional) headers to send with requests for the URL, such as Authorization
+	Headers map[string]string `json:",omitempty"`
+	// (optional) reconnect to the URL when a live stream drops
+	Reconnect bool `json:",omitempty"`
+	// (optional) the resource is a live stream, so one producer is shared by every client playing
+	// it, which join at the next keyframe
+	Live bool `json:",omitempty"`
 }
 
 type dmsDynamicMediaItem struct {
@@ -58,8 +76,12 @@ type dmsDynamicMediaItem struct {
 	Duration string
 	// required: an array of available versions
 	Resources []dmsDynamicStreamResource
+	// (optional) recordings to make of the stream
+	Schedule []dmsRecordingSchedule `json:",omitempty"`
 }
 
+// Reads a .dms.json file for browsing and serving it. Unlike parseDynamicStream, unknown keys and
+// values it can't check are let through, so files that worked before keep working.
 func readDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
 	bytes, err := ioutil.ReadFile(metadataPath)
 	if err != nil {
@@ -75,7 +97,7 @@ func readDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
 
 func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObject object, fileInfo os.FileInfo, host, userAgent string) (ret interface{}, err error) {
 	// at this point we know that entryFilePath points to a .dms.json file; slurp and parse
-	dmsMediaItem, err := readDynamicStream(cdsObject.FilePath())
+	dmsMediaItem, err := me.loadDynamicStream(cdsObject.FilePath())
 	if err != nil {
 		me.Logger.Printf("%s ignored: %v", cdsObject.FilePath(), err)
 		return
@@ -83,7 +105,7 @@ func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObjec
 
 	obj := upnpav.Object{
 		ID:         cdsObject.ID(),
-		Restricted: 1,
+		Restricted: true,
 		ParentID:   cdsObject.ParentID(),
 	}
 	iconURI := (&url.URL{
@@ -97,7 +119,7 @@ func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObjec
 	obj.Icon = iconURI
 	// TODO(anacrolix): This might not be necessary due to item res image
 	// element.
-	obj.AlbumArtURI = iconURI
+	obj.AlbumArtURIs = albumArtURIs(iconURI)
 
 	switch dmsMediaItem.Type {
 		case "video":
@@ -120,9 +142,9 @@ func (me *contentDirectoryService) cdsObjectDynamicStreamToUpnpavObject(cdsObjec
 		Res: make([]upnpav.Resource, 0, 1+len(dmsMediaItem.Resources)),
 	}
 	for i, dmsStream := range dmsMediaItem.Resources {
-		// default flags borrowed from Serviio: DLNA_ORG_FLAG_SENDER_PACED | DLNA_ORG_FLAG_S0_INCREASE | DLNA_ORG_FLAG_SN_INCREASE | DLNA_ORG_FLAG_STREAMING_TRANSFER_MODE | DLNA_ORG_FLAG_BACKGROUND_TRANSFERT_MODE | DLNA_ORG_FLAG_DLNA_V15
-		flags := "8D500000000000000000000000000000"
-		if dmsStream.DlnaFlags != "" {
+		// default flags borrowed from Serviio
+		flags := dlna.FlagSenderPaced | dlna.FlagS0Increasing | dlna.FlagSNIncreasing | dlna.FlagStreamingTransferMode | dlna.FlagBackgroundTransferMode | dlna.FlagDLNAV15
+		if dmsStream.DlnaFlags != 0 {
 			flags = dmsStream.DlnaFlags
 		}
 		item.Res = append(item.Res, upnpav.Resource{
@@ -188,14 +210,18 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 
 	obj := upnpav.Object{
 		ID:         cdsObject.ID(),
-		Restricted: 1,
+		Restricted: true,
 		ParentID:   cdsObject.ParentID(),
 	}
 	if fileInfo.IsDir() {
 		obj.Class = "object.container.storageFolder"
 		obj.Title = fileInfo.Name()
-		childCount := me.objectChildCount(cdsObject)
+		childCount := me.objectChildCount(cdsObject, nil)
+		if cdsObject.IsRoot() {
+			childCount += len(me.virtualViewContainers(host, userAgent))
+		}
 		if childCount != 0 {
+			obj.AlbumArtURIs = albumArtURIs(me.albumArtURL(host, cdsObject, fileInfo))
 			ret = upnpav.Container{Object: obj, ChildCount: childCount}
 		}
 		return
@@ -227,17 +253,30 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 		}.Encode(),
 	}).String()
 	obj.Icon = iconURI
-	// TODO(anacrolix): This might not be necessary due to item res image
-	// element.
-	obj.AlbumArtURI = iconURI
+	if mimeType.IsAudio() || mimeType.IsVideo() {
+		obj.AlbumArtURIs = albumArtURIs(me.albumArtURL(host, cdsObject, fileInfo))
+	}
+	if len(obj.AlbumArtURIs) == 0 && !mimeType.IsAudio() {
+		// TODO(anacrolix): This might not be necessary due to item res image
+		// element.
+		obj.AlbumArtURIs = albumArtURIs(iconURI)
+	}
 	obj.Class = "object.item." + mimeType.Type() + "Item"
+	if mimeType.IsVideo() {
+		if n, err := me.readNFO(entryFilePath); err == nil {
+			n.apply(&obj)
+		} else if !os.IsNotExist(err) {
+			me.Logger.Levelf(log.Debug, "ignoring nfo: %v", err)
+		}
+	}
 	var (
 		ffInfo        *ffprobe.Info
 		nativeBitrate uint
 		resDuration   string
 	)
 	if !me.NoProbe {
-		ffInfo, probeErr := me.ffmpegProbe(entryFilePath)
+		var probeErr error
+		ffInfo, probeErr = me.ffmpegProbe(entryFilePath)
 		switch probeErr {
 		case nil:
 			if ffInfo != nil {
@@ -251,6 +290,24 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 			me.Logger.Printf("error probing %s: %s", entryFilePath, probeErr)
 		}
 	}
+	if mimeType.IsAudio() {
+		if t, _, err := me.audioTags(entryFilePath); err == nil {
+			applyAudioTags(&obj, t)
+			if resDuration == "" && t.Duration > 0 {
+				resDuration = misc.FormatDurationSexagesimal(t.Duration)
+			}
+		} else if ffInfo != nil {
+			itemExtra(&obj, ffInfo)
+		}
+	}
+	var imageInfo exif.Info
+	if mimeType.IsImage() {
+		obj.Date = upnpav.Timestamp{Time: fileInfo.ModTime()}
+		if info, err := me.imageExif(entryFilePath); err == nil {
+			imageInfo = info
+			applyExif(&obj, info)
+		}
+	}
 	if obj.Title == "" {
 		obj.Title = fileInfo.Name()
 	}
@@ -260,17 +317,32 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 				if strm["codec_type"] != "video" {
 					continue
 				}
-				width := strm["width"]
-				height := strm["height"]
+				width, _ := ffprobe.AnyAsFloat64(strm["width"])
+				height, _ := ffprobe.AnyAsFloat64(strm["height"])
 				return fmt.Sprintf("%.0fx%.0f", width, height)
 			}
 		}
+		if imageInfo.Width != 0 && imageInfo.Height != 0 {
+			return fmt.Sprintf("%dx%d", imageInfo.Width, imageInfo.Height)
+		}
+		if mimeType.IsImage() {
+			if g, err := me.imageGeometry(entryFilePath); err == nil {
+				// The raw file is stored as it came off the sensor, however it's meant to be shown.
+				width, height := g.stored()
+				return fmt.Sprintf("%dx%d", width, height)
+			}
+		}
 		return ""
 	}()
+	rawMimeType := mimeType.String()
+	profile, ok := me.dlnaProfile(entryFilePath, mimeType, ffInfo)
+	if ok {
+		rawMimeType = profile.MimeType
+	}
 	item := upnpav.Item{
 		Object: obj,
 		// Capacity: 1 for raw, 1 for icon, plus transcodes.
-		Res: make([]upnpav.Resource, 0, 2+len(transcodes)),
+		Res: make([]upnpav.Resource, 0, 2+len(transcodes)+len(audioTranscodes)),
 	}
 	item.Res = append(item.Res, upnpav.Resource{
 		URL: (&url.URL{
@@ -281,7 +353,8 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 				"path": {cdsObject.Path},
 			}.Encode(),
 		}).String(),
-		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", mimeType, dlna.ContentFeatures{
+		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", rawMimeType, dlna.ContentFeatures{
+			ProfileName:  profile.Name,
 			SupportRange: true,
 		}.String()),
 		Bitrate:    nativeBitrate,
@@ -291,19 +364,23 @@ func (me *contentDirectoryService) cdsObjectToUpnpavObject(
 	})
 	if mimeType.IsVideo() {
 		if !me.NoTranscode {
-			item.Res = append(item.Res, transcodeResources(host, cdsObject.Path, resolution, resDuration)...)
+			item.Res = append(item.Res, transcodeResources(host, cdsObject.Path, resolution, resDuration, transcodes, ffInfo)...)
+			item.Res = append(item.Res, me.trackCombinationResources(host, cdsObject.Path, entryFilePath, resolution, resDuration, transcodes, ffInfo)...)
 		}
-		item.Res = append(item.Res, upnpav.Resource{
-			URL: (&url.URL{
-				Scheme: "http",
-				Host:   host,
-				Path:   subtitlePath,
-				RawQuery: url.Values{
-					"path": {cdsObject.Path},
-				}.Encode(),
-			}).String(),
-			ProtocolInfo: "http-get:*:text/plain",
-		})
+		addSubtitles(&item, host, cdsObject.Path, userAgent, me.subtitleTracks(entryFilePath, ffInfo))
+	}
+	if mimeType.IsImage() {
+		item.Res = append(item.Res, me.imageResources(host, cdsObject, mimeType)...)
+	}
+	if mimeType.IsAudio() && !me.NoTranscode {
+		specs := make(map[string]transcodeSpec, len(audioTranscodes))
+		for k, v := range audioTranscodes {
+			// There's no point offering a transcode to the format the file is already in.
+			if v.mimeType != mimeType.String() {
+				specs[k] = v
+			}
+		}
+		item.Res = append(item.Res, transcodeResources(host, cdsObject.Path, "", resDuration, specs, ffInfo)...)
 	}
 	if mimeType.IsVideo() || mimeType.IsImage() {
 		item.Res = append(item.Res, upnpav.Resource{
@@ -337,6 +414,9 @@ func (me *contentDirectoryService) readContainer(
 		return
 	}
 	sort.Sort(sfis)
+	if o.IsRoot() {
+		ret = append(ret, me.virtualViewContainers(host, userAgent)...)
+	}
 	for _, fi := range sfis.fileInfoSlice {
 		child := object{path.Join(o.Path, fi.Name()), me.RootObjectPath}
 		obj, err := me.cdsObjectToUpnpavObject(child, fi, host, userAgent)
@@ -394,10 +474,16 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 		if err := xml.Unmarshal([]byte(argsXML), &browse); err != nil {
 			return nil, err
 		}
+		if id, ok := parseVirtualID(browse.ObjectID); ok {
+			return me.browseVirtual(id, browse, r)
+		}
 		obj, err := me.objectFromID(browse.ObjectID)
 		if err != nil {
 			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
 		}
+		if !me.objectAllowed(r, obj.Path) {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "no such object %q", obj.Path)
+		}
 		switch browse.BrowseFlag {
 		case "BrowseDirectChildren":
 			var objs []interface{}
@@ -409,27 +495,7 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 			if err != nil {
 				return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
 			}
-			totalMatches := len(objs)
-			objs = objs[func() (low int) {
-				low = browse.StartingIndex
-				if low > len(objs) {
-					low = len(objs)
-				}
-				return
-			}():]
-			if browse.RequestedCount != 0 && int(browse.RequestedCount) < len(objs) {
-				objs = objs[:browse.RequestedCount]
-			}
-			result, err := xml.Marshal(objs)
-			if err != nil {
-				return nil, err
-			}
-			return [][2]string{
-				{"Result", didl_lite(string(result))},
-				{"NumberReturned", fmt.Sprint(len(objs))},
-				{"TotalMatches", fmt.Sprint(totalMatches)},
-				{"UpdateID", me.updateIDString()},
-			}, nil
+			return me.browseChildrenResult(me.filterAllowedObjects(r, objs), browse)
 		case "BrowseMetadata":
 			var ret interface{}
 			var err error
@@ -446,22 +512,19 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 					return nil, err
 				}
 				ret, err = me.cdsObjectToUpnpavObject(obj, fileInfo, host, userAgent)
+				if err == nil && ret != nil {
+					var ok bool
+					if ret, ok = me.allowedObject(r, me.clientProfile(r), ret); !ok {
+						return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "no such object %q", obj.Path)
+					}
+				}
 			} else {
 				ret, err = me.OnBrowseMetadata(obj.Path, obj.RootObjectPath, host, userAgent)
 			}
 			if err != nil {
 				return nil, err
 			}
-			buf, err := xml.Marshal(ret)
-			if err != nil {
-				return nil, err
-			}
-			return [][2]string{
-				{"Result", didl_lite(func() string { return string(buf) }())},
-				{"NumberReturned", "1"},
-				{"TotalMatches", "1"},
-				{"UpdateID", me.updateIDString()},
-			}, nil
+			return me.browseMetadataResult(ret)
 		default:
 			return nil, upnp.Errorf(
 				upnp.ArgumentValueInvalidErrorCode,
@@ -494,16 +557,61 @@ func (me *contentDirectoryService) Handle(action string, argsXML []byte, r *http
 	}
 }
 
+// Returns the Browse response for the requested range of the children of a container.
+func (me *contentDirectoryService) browseChildrenResult(objs []interface{}, browse browse) ([][2]string, error) {
+	totalMatches := len(objs)
+	objs = objs[func() (low int) {
+		low = browse.StartingIndex
+		if low > len(objs) {
+			low = len(objs)
+		}
+		return
+	}():]
+	if browse.RequestedCount != 0 && int(browse.RequestedCount) < len(objs) {
+		objs = objs[:browse.RequestedCount]
+	}
+	result, err := xml.Marshal(objs)
+	if err != nil {
+		return nil, err
+	}
+	return [][2]string{
+		{"Result", didl_lite(string(result))},
+		{"NumberReturned", fmt.Sprint(len(objs))},
+		{"TotalMatches", fmt.Sprint(totalMatches)},
+		{"UpdateID", me.updateIDString()},
+	}, nil
+}
+
+// Returns the Browse response for the metadata of a single object.
+func (me *contentDirectoryService) browseMetadataResult(ret interface{}) ([][2]string, error) {
+	buf, err := xml.Marshal(ret)
+	if err != nil {
+		return nil, err
+	}
+	return [][2]string{
+		{"Result", didl_lite(func() string { return string(buf) }())},
+		{"NumberReturned", "1"},
+		{"TotalMatches", "1"},
+		{"UpdateID", me.updateIDString()},
+	}, nil
+}
+
 // Represents a ContentDirectory object.
 type object struct {
 	Path           string // The cleaned, absolute path for the object relative to the server.
 	RootObjectPath string
 }
 
+// Whether the entry is one to list: a media file, dynamic stream, or directory with one of those
+// somewhere under it. If allowed isn't nil, only entries it allows for the path are counted.
 func (me *contentDirectoryService) isOfInterest(
 	cdsObject object,
 	fileInfo os.FileInfo,
+	allowed func(objectPath string) bool,
 ) (ret bool, err error) {
+	if allowed != nil && !allowed(cdsObject.Path) {
+		return
+	}
 	entryFilePath := cdsObject.FilePath()
 	ignored, err := me.IgnorePath(entryFilePath)
 	if err != nil {
@@ -518,7 +626,7 @@ func (me *contentDirectoryService) isOfInterest(
 	}
 
 	if fileInfo.IsDir() {
-		hasChildren, err := me.objectHasChildren(cdsObject, fileInfo)
+		hasChildren, err := me.objectHasChildren(cdsObject, fileInfo, allowed)
 		return hasChildren, err
 	}
 	if !fileInfo.Mode().IsRegular() {
@@ -537,15 +645,16 @@ func (me *contentDirectoryService) isOfInterest(
 	return true, nil
 }
 
-// Returns the number of children this object has, such as for a container.
-func (cds *contentDirectoryService) objectChildCount(me object) (count int) {
+// Returns the number of children this object has, such as for a container. If allowed isn't nil,
+// only the children it allows, and directories with something it allows under them, are counted.
+func (cds *contentDirectoryService) objectChildCount(me object, allowed func(objectPath string) bool) (count int) {
 	fileInfoSlice, err := me.readDir()
 	if err != nil {
 		return
 	}
 	for _, fi := range fileInfoSlice {
 		child := object{path.Join(me.Path, fi.Name()), cds.RootObjectPath}
-		isChild, err := cds.isOfInterest(child, fi)
+		isChild, err := cds.isOfInterest(child, fi, allowed)
 		if err != nil {
 			cds.Logger.Printf("error with %s: %s", child.FilePath(), err)
 			continue
@@ -563,6 +672,7 @@ func (cds *contentDirectoryService) objectChildCount(me object) (count int) {
 func (me *contentDirectoryService) objectHasChildren(
 	cdsObject object,
 	fileInfo os.FileInfo,
+	allowed func(objectPath string) bool,
 ) (ret bool, err error) {
 	if !fileInfo.IsDir() {
 		panic("Expected directory")
@@ -574,7 +684,7 @@ func (me *contentDirectoryService) objectHasChildren(
 	}
 	for _, fi := range files {
 		child := object{path.Join(cdsObject.Path, fi.Name()), me.RootObjectPath}
-		isCdsObj, err := me.isOfInterest(child, fi)
+		isCdsObj, err := me.isOfInterest(child, fi, allowed)
 		if err != nil {
 			return false, err
 		}
@@ -1,42 +1,219 @@
 package dms
 
 import (
+	"encoding/xml"
+	"fmt"
 	"net/http"
+	"sync"
 
 	"github.com/anacrolix/dms/upnp"
 )
 
-// const defaultProtocolInfo = "http-get:*:video/mpeg:*,http-get:*:video/mp4:*,http-get:*:video/vnd.dlna.mpeg-tts:*,http-get:*:video/avi:*,http-get:*:video/x-matroska:*,http-get:*:video/x-ms-wmv:*,http-get:*:video/wtv:*,http-get:*:audio/mpeg:*,http-get:*:audio/mp3:*,http-get:*:audio/mp4:*,http-get:*:audio/x-ms-wma*,http-get:*:audio/wav:*,http-get:*:audio/L16:*,http-get:*image/jpeg:*,http-get:*image/png:*,http-get:*image/gif:*,http-get:*image/tiff:*"
-const defaultProtocolInfo = "http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_SM,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_MED,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_LRG,http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_RES_H_V,http-get:*:image/png:DLNA.ORG_PN=PNG_TN,http-get:*:image/png:DLNA.ORG_PN=PNG_LRG,http-get:*:image/gif:DLNA.ORG_PN=GIF_LRG,http-get:*:audio/mpeg:DLNA.ORG_PN=MP3,http-get:*:audio/L16:DLNA.ORG_PN=LPCM,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_24_AC3_ISO;SONY.COM_PN=AVC_TS_HD_24_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_24_AC3;SONY.COM_PN=AVC_TS_HD_24_AC3,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_24_AC3_T;SONY.COM_PN=AVC_TS_HD_24_AC3_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_PS_PAL,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_PS_NTSC,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_50_L2_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_60_L2_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_SD_EU_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_EU,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_EU_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_50_AC3_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_50_L2_ISO;SONY.COM_PN=HD2_50_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_SD_60_AC3_T,http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_TS_HD_60_L2_ISO;SONY.COM_PN=HD2_60_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_HD_50_L2_T;SONY.COM_PN=HD2_50_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=MPEG_TS_HD_60_L2_T;SONY.COM_PN=HD2_60_T,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_50_AC3_ISO;SONY.COM_PN=AVC_TS_HD_50_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_50_AC3;SONY.COM_PN=AVC_TS_HD_50_AC3,http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_60_AC3_ISO;SONY.COM_PN=AVC_TS_HD_60_AC3_ISO,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_60_AC3;SONY.COM_PN=AVC_TS_HD_60_AC3,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_50_AC3_T;SONY.COM_PN=AVC_TS_HD_50_AC3_T,http-get:*:video/vnd.dlna.mpeg-tts:DLNA.ORG_PN=AVC_TS_HD_60_AC3_T;SONY.COM_PN=AVC_TS_HD_60_AC3_T,http-get:*:video/x-mp2t-mphl-188:*,http-get:*:video/*:*,http-get:*:audio/*:*,http-get:*:image/*:*,http-get:*:text/srt:*,http-get:*:text/smi:*,http-get:*:text/ssa:*,http-get:*:*:*"
+// The ConnectionManager error for a connection ID that doesn't exist.
+const invalidConnectionReferenceErrorCode = 706
 
 type connectionManagerService struct {
 	*Server
 	upnp.Eventing
+	// The Source protocolInfo list, worked out once from the server's configuration.
+	sourceProtocolInfo string
+
+	// Guards the event queues and what's waiting in them.
+	eventsMu    sync.Mutex
+	eventQueues map[string]*eventQueue
+}
+
+// What's waiting to be sent to a subscriber. Control points reject events that arrive out of
+// sequence, so one is sent at a time, and changes made while one is sent are coalesced into a
+// single event with the latest connection IDs. Guarded by eventsMu.
+type eventQueue struct {
+	// Closed once the response to the subscription is sent, as the initial event must follow it.
+	ready chan struct{}
+	// Holds a value while there's something to send, or the queue is closed.
+	wake chan struct{}
+	// The initial event, with every evented state variable, hasn't been sent.
+	initial bool
+	// The connection IDs have changed since the last event.
+	changed bool
+	closed  bool
+}
+
+func (q *eventQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Sends the subscription its events until its queue is closed or it's gone.
+func (cms *connectionManagerService) sendEvents(sid string, q *eventQueue) {
+	<-q.ready
+	for range q.wake {
+		cms.eventsMu.Lock()
+		if q.closed {
+			cms.eventsMu.Unlock()
+			return
+		}
+		var properties []upnp.Property
+		if q.initial {
+			properties = append(properties,
+				stateVariable("SourceProtocolInfo", cms.sourceProtocolInfo),
+				stateVariable("SinkProtocolInfo", ""))
+		}
+		if q.initial || q.changed {
+			properties = append(properties, stateVariable("CurrentConnectionIDs", cms.connections.ids()))
+		}
+		q.initial, q.changed = false, false
+		if properties == nil {
+			cms.eventsMu.Unlock()
+			continue
+		}
+		s, ok := cms.NextEvent(sid)
+		if !ok {
+			// Expired, or unsubscribed.
+			cms.closeEventQueue(sid)
+			cms.eventsMu.Unlock()
+			return
+		}
+		cms.eventsMu.Unlock()
+		cms.sendEvent(s.URLs, s.SID, s.Seq, properties)
+	}
+}
+
+// Stops sending events to the subscription. The caller holds eventsMu.
+func (cms *connectionManagerService) closeEventQueue(sid string) {
+	if q, ok := cms.eventQueues[sid]; ok {
+		q.closed = true
+		q.signal()
+		delete(cms.eventQueues, sid)
+	}
 }
 
 func (cms *connectionManagerService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
 	switch action {
-	case ".GetCurrentConnectionInfo":
+	case "GetCurrentConnectionInfo":
+		var args struct {
+			ConnectionID int
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.ArgumentValueInvalidError
+		}
+		c, ok := cms.connections.get(args.ConnectionID)
+		if !ok && args.ConnectionID == 0 {
+			// The default connection, which control points may ask about when there are no
+			// streams.
+			c, ok = connection{Direction: "Output", Status: "OK"}, true
+		}
+		if !ok {
+			return nil, upnp.Errorf(invalidConnectionReferenceErrorCode, "no connection %d", args.ConnectionID)
+		}
 		return [][2]string{
-			{"ConnectionID", "0"},
 			{"RcsID", "-1"},
 			{"AVTransportID", "-1"},
-			{"ProtocolInfo", ""},
+			{"ProtocolInfo", c.ProtocolInfo},
 			{"PeerConnectionManager", ""},
 			{"PeerConnectionID", "-1"},
-			{"Direction", "Output"},
-			{"Status", "OK"},
+			{"Direction", c.Direction},
+			{"Status", c.Status},
 		}, nil
 	case "GetCurrentConnectionIDs":
 		return [][2]string{
-			{"ConnectionIDs", ""},
+			{"ConnectionIDs", cms.connections.ids()},
 		}, nil
 	case "GetProtocolInfo":
 		return [][2]string{
-			{"Source", defaultProtocolInfo},
+			{"Source", cms.sourceProtocolInfo},
 			{"Sink", ""},
 		}, nil
 	default:
 		return nil, upnp.InvalidActionError
 	}
 }
+
+func stateVariable(name, value string) upnp.Property {
+	return upnp.Property{
+		Variable: upnp.Variable{
+			XMLName: xml.Name{Local: name},
+			Value:   value,
+		},
+	}
+}
+
+// Has every subscriber sent the current connection IDs, once any event it's being sent is done.
+func (cms *connectionManagerService) connectionsChanged() {
+	cms.eventsMu.Lock()
+	defer cms.eventsMu.Unlock()
+	for _, q := range cms.eventQueues {
+		q.changed = true
+		q.signal()
+	}
+}
+
+// Handles GENA subscriptions to the ConnectionManager. New subscribers are sent all the evented
+// state variables.
+func (cms *connectionManagerService) serveEventSubscription(w http.ResponseWriter, r *http.Request) {
+	if cms.StallEventSubscribe {
+		// See contentDirectoryEventSubHandler.
+		<-r.Context().Done()
+		return
+	}
+	var timeout int
+	fmt.Sscanf(r.Header.Get("TIMEOUT"), "Second-%d", &timeout)
+	if timeout <= 0 {
+		timeout = 1800
+	}
+	sid := r.Header.Get("SID")
+	switch {
+	case r.Method == "SUBSCRIBE" && sid == "":
+		urls := upnp.ParseCallbackURLs(r.Header.Get("CALLBACK"))
+		if len(urls) == 0 {
+			http.Error(w, "no callback", http.StatusPreconditionFailed)
+			return
+		}
+		cms.eventsMu.Lock()
+		sid, timeout, err := cms.Subscribe(urls, timeout)
+		if err != nil {
+			cms.eventsMu.Unlock()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q := &eventQueue{
+			ready:   make(chan struct{}),
+			wake:    make(chan struct{}, 1),
+			initial: true,
+		}
+		if cms.eventQueues == nil {
+			cms.eventQueues = make(map[string]*eventQueue)
+		}
+		cms.eventQueues[sid] = q
+		q.signal()
+		go cms.sendEvents(sid, q)
+		cms.eventsMu.Unlock()
+		w.Header()["SID"] = []string{sid}
+		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", timeout)}
+		w.WriteHeader(http.StatusOK)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		close(q.ready)
+	case r.Method == "SUBSCRIBE":
+		timeout, err := cms.Renew(sid, timeout)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		w.Header()["SID"] = []string{sid}
+		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", timeout)}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == "UNSUBSCRIBE":
+		cms.eventsMu.Lock()
+		defer cms.eventsMu.Unlock()
+		if err := cms.Unsubscribe(sid); err != nil {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		cms.closeEventQueue(sid)
+	default:
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+	}
+}
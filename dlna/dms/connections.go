@@ -0,0 +1,114 @@
+package dms
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A stream being served from /res, reported as a ConnectionManager connection and on the status
+// endpoint.
+type connection struct {
+	ID           int
+	Peer         string
+	UserAgent    string
+	Path         string
+	ProtocolInfo string
+	// The transcode being served, or empty for the file itself.
+	Transcode string `json:",omitempty"`
+	// Always Output, as the server only sends.
+	Direction string
+	Status    string
+	Started   time.Time
+}
+
+// Tracks the streams being served. The zero value is ready to use.
+type connections struct {
+	mu     sync.Mutex
+	nextID int
+	conns  map[int]*connection
+	// Called when connections open or close, without the lock held.
+	changed func()
+}
+
+// Registers a stream for the request. It must be closed when the stream ends.
+func (me *connections) open(r *http.Request, path, protocolInfo, transcode string) *connection {
+	me.mu.Lock()
+	// IDs start at 1, as 0 is the default connection for servers that don't track them.
+	me.nextID++
+	c := &connection{
+		ID:           me.nextID,
+		Peer:         requestClientIP(r),
+		UserAgent:    r.UserAgent(),
+		Path:         path,
+		ProtocolInfo: protocolInfo,
+		Transcode:    transcode,
+		Direction:    "Output",
+		Status:       "OK",
+		Started:      time.Now(),
+	}
+	if me.conns == nil {
+		me.conns = make(map[int]*connection)
+	}
+	me.conns[c.ID] = c
+	me.mu.Unlock()
+	me.notify()
+	return c
+}
+
+func (me *connections) close(c *connection) {
+	me.mu.Lock()
+	delete(me.conns, c.ID)
+	me.mu.Unlock()
+	me.notify()
+}
+
+func (me *connections) notify() {
+	if me.changed != nil {
+		me.changed()
+	}
+}
+
+// Returns the open connections, oldest first.
+func (me *connections) list() (ret []connection) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, c := range me.conns {
+		ret = append(ret, *c)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return
+}
+
+func (me *connections) get(id int) (c connection, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	p, ok := me.conns[id]
+	if ok {
+		c = *p
+	}
+	return
+}
+
+// Returns the CurrentConnectionIDs state variable: the IDs, comma separated, or the default
+// connection 0 when there are none.
+func (me *connections) ids() string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if len(me.conns) == 0 {
+		return "0"
+	}
+	ids := make([]int, 0, len(me.conns))
+	for id := range me.conns {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	ss := make([]string, 0, len(ids))
+	for _, id := range ids {
+		ss = append(ss, strconv.Itoa(id))
+	}
+	return strings.Join(ss, ",")
+}
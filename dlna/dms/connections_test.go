@@ -0,0 +1,110 @@
+package dms
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/log"
+)
+
+func TestConnectionManagerConnections(t *testing.T) {
+	srv := &Server{}
+	cms := &connectionManagerService{Server: srv}
+	changes := 0
+	srv.connections.changed = func() { changes++ }
+	r := httptest.NewRequest("GET", "/res?path=%2Fa.mkv", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	// The default connection is there while nothing is streaming.
+	ret, err := cms.Handle("GetCurrentConnectionIDs", []byte(`<u:GetCurrentConnectionIDs/>`), r)
+	if err != nil || ret[0][1] != "0" {
+		t.Fatalf("got %v, %v", ret, err)
+	}
+	ret, err = cms.Handle("GetCurrentConnectionInfo", []byte(`<u:GetCurrentConnectionInfo><ConnectionID>0</ConnectionID></u:GetCurrentConnectionInfo>`), r)
+	if err != nil || ret[5] != [2]string{"Direction", "Output"} || ret[6] != [2]string{"Status", "OK"} {
+		t.Fatalf("got %v, %v", ret, err)
+	}
+	a := srv.connections.open(r, "/a.mkv", "http-get:*:video/x-matroska:*", "")
+	b := srv.connections.open(r, "/b.mkv", "http-get:*:video/mpeg:DLNA.ORG_PN=MPEG_PS_PAL", "t")
+	ret, err = cms.Handle("GetCurrentConnectionIDs", []byte(`<u:GetCurrentConnectionIDs/>`), r)
+	if err != nil || ret[0][1] != "1,2" {
+		t.Fatalf("got %v, %v", ret, err)
+	}
+	ret, err = cms.Handle("GetCurrentConnectionInfo", []byte(`<u:GetCurrentConnectionInfo><ConnectionID>2</ConnectionID></u:GetCurrentConnectionInfo>`), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := make(map[string]string)
+	for _, arg := range ret {
+		info[arg[0]] = arg[1]
+	}
+	if info["ProtocolInfo"] != b.ProtocolInfo || info["Direction"] != "Output" || info["Status"] != "OK" {
+		t.Fatalf("got %v", ret)
+	}
+	srv.connections.close(a)
+	if conns := srv.connections.list(); len(conns) != 1 || conns[0].Path != "/b.mkv" || conns[0].Peer != "10.0.0.2" {
+		t.Fatalf("got %+v", conns)
+	}
+	_, err = cms.Handle("GetCurrentConnectionInfo", []byte(`<u:GetCurrentConnectionInfo><ConnectionID>1</ConnectionID></u:GetCurrentConnectionInfo>`), r)
+	var upnpErr *upnp.Error
+	if !errors.As(err, &upnpErr) || upnpErr.Code != invalidConnectionReferenceErrorCode {
+		t.Fatalf("got %v", err)
+	}
+	if changes != 3 {
+		t.Fatalf("got %d changes", changes)
+	}
+}
+
+func TestConnectionManagerEventsCoalesced(t *testing.T) {
+	type event struct{ seq, body string }
+	var (
+		got     = make(chan event, 100)
+		release = make(chan struct{})
+	)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		got <- event{r.Header.Get("SEQ"), string(b)}
+		<-release
+	}))
+	defer callback.Close()
+	srv := &Server{eventingLogger: log.Default}
+	cms := &connectionManagerService{Server: srv}
+	srv.connections.changed = cms.connectionsChanged
+	r := httptest.NewRequest("SUBSCRIBE", connectionManagerEventSubURL, nil)
+	r.Header.Set("CALLBACK", "<"+callback.URL+">")
+	w := httptest.NewRecorder()
+	cms.serveEventSubscription(w, r)
+	if w.Code != http.StatusOK || len(w.Header()["SID"]) == 0 {
+		t.Fatalf("subscribing: %d", w.Code)
+	}
+	next := func() event {
+		select {
+		case e := <-got:
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("no event")
+			panic("unreachable")
+		}
+	}
+	if e := next(); e.seq != "0" || !strings.Contains(e.body, "<CurrentConnectionIDs>0</CurrentConnectionIDs>") {
+		t.Fatalf("got %+v", e)
+	}
+	// The changes while the initial event is being sent make one event.
+	for i := 0; i < 10; i++ {
+		srv.connections.open(r, "/a.mkv", "", "")
+	}
+	close(release)
+	if e := next(); e.seq != "1" || !strings.Contains(e.body, "<CurrentConnectionIDs>1,2,3,4,5,6,7,8,9,10</CurrentConnectionIDs>") {
+		t.Fatalf("got %+v", e)
+	}
+	select {
+	case e := <-got:
+		t.Fatalf("got %+v", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,286 @@
+package dms
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// The approval state of a media receiver in the device registry.
+type deviceStatus string
+
+const (
+	devicePending  deviceStatus = "pending"
+	deviceApproved deviceStatus = "approved"
+	deviceDenied   deviceStatus = "denied"
+)
+
+// A media receiver that has used the X_MS_MediaReceiverRegistrar service.
+type registeredDevice struct {
+	// The DeviceID given to IsAuthorized and IsValidated, or the address of the device for those
+	// that don't give one, as Xbox consoles don't.
+	ID        string
+	Addr      string
+	UserAgent string
+	Status    deviceStatus
+	// The last RegistrationReqMsg the device sent, if any.
+	RegistrationReqMsg string `json:",omitempty"`
+	FirstSeen          time.Time
+	LastSeen           time.Time
+}
+
+// Records the Windows Media receivers seen by the X_MS_MediaReceiverRegistrar service, and
+// whether they're allowed, persisted to a JSON file. Devices listed in approved or denied have
+// that status whatever the file says, and are matched by ID or address. Devices otherwise
+// unknown or pending are authorized unless requireApproval is set. An approved device stays at
+// the address it was approved at: others giving its ID aren't authorized.
+type deviceRegistry struct {
+	// The file the registry is kept in. Nothing is persisted if empty.
+	path            string
+	approved        []string
+	denied          []string
+	requireApproval bool
+	logger          log.Logger
+
+	mu      sync.Mutex
+	devices map[string]*registeredDevice
+}
+
+// How many pending devices are kept. Any client can make up device IDs, so the least recently
+// seen are forgotten beyond this.
+const maxPendingDevices = 100
+
+func (me *deviceRegistry) load() error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.devices = make(map[string]*registeredDevice)
+	if me.path == "" {
+		return nil
+	}
+	b, err := ioutil.ReadFile(me.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var devices []*registeredDevice
+	if err := json.Unmarshal(b, &devices); err != nil {
+		return err
+	}
+	for _, d := range devices {
+		me.devices[d.ID] = d
+	}
+	return nil
+}
+
+// Writes the registry out, with the lock held.
+func (me *deviceRegistry) save() {
+	if me.path == "" {
+		return
+	}
+	err := func() error {
+		b, err := json.MarshalIndent(me.listLocked(), "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(me.path), 0o750); err != nil {
+			return err
+		}
+		tmp := me.path + ".tmp"
+		if err := ioutil.WriteFile(tmp, b, 0o640); err != nil {
+			return err
+		}
+		return os.Rename(tmp, me.path)
+	}()
+	if err != nil {
+		me.logger.Printf("error saving device registry to %q: %v", me.path, err)
+	}
+}
+
+// Records that the device has been in touch, adding it as pending if it's new. The registry is
+// only written when that changes more than when the device was last seen.
+func (me *deviceRegistry) seen(id, addr, userAgent, registrationReqMsg string) {
+	if id == "" {
+		id = addr
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.devices == nil {
+		me.devices = make(map[string]*registeredDevice)
+	}
+	now := time.Now()
+	d, ok := me.devices[id]
+	if ok && d.Addr != addr && me.statusLocked(d) == deviceApproved {
+		me.logger.Printf("media receiver %q is approved at %s, not %s", id, d.Addr, addr)
+		return
+	}
+	changed := !ok
+	if !ok {
+		me.forgetPendingLocked(maxPendingDevices - 1)
+		d = &registeredDevice{
+			ID:        id,
+			Status:    devicePending,
+			FirstSeen: now,
+		}
+		me.devices[id] = d
+		me.logger.Printf("new media receiver %q at %s (%s)", id, addr, userAgent)
+	}
+	if d.Addr != addr || d.UserAgent != userAgent {
+		d.Addr = addr
+		d.UserAgent = userAgent
+		changed = true
+	}
+	d.LastSeen = now
+	if registrationReqMsg != "" && registrationReqMsg != d.RegistrationReqMsg {
+		d.RegistrationReqMsg = registrationReqMsg
+		changed = true
+	}
+	if changed {
+		me.save()
+	}
+}
+
+// Forgets the least recently seen pending devices until at most max are left.
+func (me *deviceRegistry) forgetPendingLocked(max int) {
+	var pending []*registeredDevice
+	for _, d := range me.devices {
+		if me.statusLocked(d) == devicePending {
+			pending = append(pending, d)
+		}
+	}
+	if len(pending) <= max {
+		return
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].LastSeen.Before(pending[j].LastSeen) })
+	for _, d := range pending[:len(pending)-max] {
+		me.logger.Printf("forgetting pending media receiver %q", d.ID)
+		delete(me.devices, d.ID)
+	}
+}
+
+// Returns the status of the device, taking the configured lists into account.
+func (me *deviceRegistry) statusLocked(d *registeredDevice) deviceStatus {
+	for _, s := range me.denied {
+		if s == d.ID || s == d.Addr {
+			return deviceDenied
+		}
+	}
+	for _, s := range me.approved {
+		if s == d.ID || s == d.Addr {
+			return deviceApproved
+		}
+	}
+	return d.Status
+}
+
+// Whether the device with the ID, or at the address if it doesn't give one, may use the server.
+func (me *deviceRegistry) authorized(id, addr string) bool {
+	if id == "" {
+		id = addr
+	}
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	d, ok := me.devices[id]
+	if !ok {
+		d = &registeredDevice{ID: id, Addr: addr, Status: devicePending}
+	}
+	switch me.statusLocked(d) {
+	case deviceApproved:
+		if addr == "" || d.Addr == "" || d.Addr == addr {
+			return true
+		}
+		return !me.requireApproval
+	case deviceDenied:
+		return false
+	default:
+		return !me.requireApproval
+	}
+}
+
+// Returns the status of the devices at the address, denied if any are, or else approved if any
+// are. ok is false for addresses with no devices in the registry or configured lists, which are
+// left to the allowed IP list.
+func (me *deviceRegistry) addrStatus(addr string) (status deviceStatus, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	statuses := make(map[deviceStatus]bool)
+	for _, s := range me.denied {
+		if s == addr {
+			statuses[deviceDenied] = true
+		}
+	}
+	for _, s := range me.approved {
+		if s == addr {
+			statuses[deviceApproved] = true
+		}
+	}
+	for _, d := range me.devices {
+		if d.Addr == addr {
+			statuses[me.statusLocked(d)] = true
+		}
+	}
+	for _, status := range []deviceStatus{deviceDenied, deviceApproved, devicePending} {
+		if statuses[status] {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// Returns the IDs of the devices last seen at the address.
+func (me *deviceRegistry) idsAt(addr string) (ids []string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, d := range me.devices {
+		if d.Addr == addr {
+			ids = append(ids, d.ID)
+		}
+	}
+	return
+}
+
+// Sets the status of a known device. Returns false if there's no such device.
+func (me *deviceRegistry) setStatus(id string, status deviceStatus) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	d, ok := me.devices[id]
+	if !ok {
+		return false
+	}
+	d.Status = status
+	me.logger.Printf("media receiver %q %s", id, status)
+	me.save()
+	return true
+}
+
+// Returns the known devices, by when they were first seen, with their effective status.
+func (me *deviceRegistry) list() []registeredDevice {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ret := me.listLocked()
+	for i := range ret {
+		ret[i].Status = me.statusLocked(&ret[i])
+	}
+	return ret
+}
+
+func (me *deviceRegistry) listLocked() (ret []registeredDevice) {
+	for _, d := range me.devices {
+		ret = append(ret, *d)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if !ret[i].FirstSeen.Equal(ret[j].FirstSeen) {
+			return ret[i].FirstSeen.Before(ret[j].FirstSeen)
+		}
+		return ret[i].ID < ret[j].ID
+	})
+	return
+}
@@ -0,0 +1,155 @@
+package dms
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestMediaReceiverRegistrar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	_, all, _ := net.ParseCIDR("0.0.0.0/0")
+	srv := &Server{
+		AllowedIpNets:         []*net.IPNet{all},
+		RequireDeviceApproval: true,
+	}
+	srv.devices = deviceRegistry{path: path, requireApproval: true, denied: []string{"10.0.0.9"}, logger: log.Default}
+	mrrs := &mediaReceiverRegistrarService{Server: srv}
+	r := httptest.NewRequest("POST", "/ctl", nil)
+	r.RemoteAddr = "10.0.0.2:1234"
+	isAuthorized := func(deviceID string) string {
+		ret, err := mrrs.Handle("IsAuthorized", []byte(`<u:IsAuthorized><DeviceID>`+deviceID+`</DeviceID></u:IsAuthorized>`), r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret[0][1]
+	}
+	if res := isAuthorized("xbox"); res != "0" {
+		t.Fatalf("pending device authorized: %q", res)
+	}
+	if srv.clientAllowed("10.0.0.2", false) {
+		t.Fatal("pending device allowed")
+	}
+	if !srv.clientAllowed("10.0.0.2", true) {
+		t.Fatal("pending device can't register")
+	}
+	if srv.clientAllowed("10.0.0.3", false) {
+		t.Fatal("client without a device allowed")
+	}
+	if srv.clientAllowed("10.0.0.9", true) {
+		t.Fatal("denied address allowed")
+	}
+	if !srv.devices.setStatus("xbox", deviceApproved) {
+		t.Fatal("device not found")
+	}
+	if res := isAuthorized("xbox"); res != "1" {
+		t.Fatalf("approved device not authorized: %q", res)
+	}
+	if res := isAuthorized("other"); res != "0" {
+		t.Fatalf("unknown device authorized: %q", res)
+	}
+	r.RemoteAddr = "10.0.0.3:1234"
+	if res := isAuthorized("xbox"); res != "0" {
+		t.Fatalf("approved device ID authorized from another address: %q", res)
+	}
+	if srv.clientAllowed("10.0.0.3", false) {
+		t.Fatal("approved device ID rebound to another address")
+	}
+	r.RemoteAddr = "10.0.0.2:1234"
+	reloaded := deviceRegistry{path: path, logger: log.Default}
+	if err := reloaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	devices := reloaded.list()
+	if len(devices) != 2 || devices[0].ID != "xbox" || devices[0].Status != deviceApproved || devices[0].Addr != "10.0.0.2" {
+		t.Fatalf("got %+v", devices)
+	}
+	if !reloaded.authorized("xbox", "") || !reloaded.authorized("other", "") {
+		t.Fatal("devices not authorized without approval required")
+	}
+	reloaded.denied = []string{"10.0.0.2"}
+	if reloaded.authorized("xbox", "") {
+		t.Fatal("configured denial ignored")
+	}
+}
+
+func TestDeviceRegistrySaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	reg := deviceRegistry{path: path, logger: log.Default}
+	reg.seen("xbox", "10.0.0.2", "Xbox", "")
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	reg.seen("xbox", "10.0.0.2", "Xbox", "")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("registry saved for a repeat contact: %v", err)
+	}
+	reg.seen("xbox", "10.0.0.3", "Xbox", "")
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("registry not saved for a new address: %v", err)
+	}
+}
+
+func TestDeviceRegistryPendingLimit(t *testing.T) {
+	reg := deviceRegistry{approved: []string{"kept"}, logger: log.Default}
+	reg.seen("kept", "10.0.0.1", "", "")
+	for i := 0; i < maxPendingDevices+10; i++ {
+		reg.seen(fmt.Sprintf("device-%d", i), "10.0.0.2", "", "")
+		// Give each device its own LastSeen.
+		reg.devices[fmt.Sprintf("device-%d", i)].LastSeen = time.Unix(int64(i), 0)
+	}
+	devices := reg.list()
+	if len(devices) != maxPendingDevices+1 {
+		t.Fatalf("got %d devices", len(devices))
+	}
+	if _, ok := reg.devices["kept"]; !ok {
+		t.Fatal("approved device forgotten")
+	}
+	if _, ok := reg.devices["device-0"]; ok {
+		t.Fatal("least recently seen pending device kept")
+	}
+	if _, ok := reg.devices[fmt.Sprintf("device-%d", maxPendingDevices+9)]; !ok {
+		t.Fatal("newest pending device forgotten")
+	}
+}
+
+func TestServeDevicesCrossOrigin(t *testing.T) {
+	srv := &Server{}
+	srv.devices = deviceRegistry{logger: log.Default}
+	srv.devices.seen("xbox", "10.0.0.2", "Xbox", "")
+	post := func(header, value string) int {
+		form := url.Values{"id": {"xbox"}, "status": {string(deviceApproved)}}
+		r := httptest.NewRequest("POST", "http://192.168.1.2:1338"+devicesPath, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		srv.serveDevices(w, r)
+		return w.Code
+	}
+	if code := post("Sec-Fetch-Site", "cross-site"); code != http.StatusForbidden {
+		t.Fatalf("cross-site POST got %d", code)
+	}
+	if code := post("Origin", "http://evil.example"); code != http.StatusForbidden {
+		t.Fatalf("cross-origin POST got %d", code)
+	}
+	if srv.devices.list()[0].Status != devicePending {
+		t.Fatal("cross-origin POST approved device")
+	}
+	if code := post("Origin", "http://192.168.1.2:1338"); code != http.StatusSeeOther {
+		t.Fatalf("same-origin POST got %d", code)
+	}
+	if srv.devices.list()[0].Status != deviceApproved {
+		t.Fatal("same-origin POST didn't approve device")
+	}
+}
@@ -0,0 +1,154 @@
+package dms
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// Stores files generated from media, such as resized images, on disk. Entries are keyed by the
+// source file's path and modification time, so changed sources never hit stale entries. A cache
+// with an empty dir stores nothing. If maxSize is set, the least recently used entries are removed
+// when the cache grows beyond it.
+type diskCache struct {
+	dir     string
+	maxSize int64
+	logger  log.Logger
+
+	mu sync.Mutex
+	// Total size of all entries. Only valid if sizeKnown.
+	size      int64
+	sizeKnown bool
+}
+
+func (c *diskCache) entryPath(srcPath string, srcInfo os.FileInfo, variant string) string {
+	h := sha1.Sum([]byte(fmt.Sprintf("%s\x00%d\x00%s", srcPath, srcInfo.ModTime().UnixNano(), variant)))
+	name := hex.EncodeToString(h[:])
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// Returns the cached data for the variant of the source file, calling create to generate and
+// store it if necessary.
+func (c *diskCache) getOrCreate(
+	srcPath string, srcInfo os.FileInfo, variant string, create func() ([]byte, error),
+) ([]byte, error) {
+	if c == nil || c.dir == "" {
+		return create()
+	}
+	p := c.entryPath(srcPath, srcInfo, variant)
+	if b, err := ioutil.ReadFile(p); err == nil {
+		// The modification time tracks use, for trimming.
+		now := time.Now()
+		os.Chtimes(p, now, now)
+		return b, nil
+	}
+	b, err := create()
+	if err != nil {
+		return nil, err
+	}
+	if err := c.put(p, b); err != nil {
+		c.logger.Printf("error caching %s of %q: %v", variant, srcPath, err)
+	} else {
+		c.added(int64(len(b)))
+	}
+	return b, nil
+}
+
+// Atomically writes an entry, so concurrent readers never see partial data.
+func (c *diskCache) put(p string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+type diskCacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (c *diskCache) entries() (ret []diskCacheEntry, err error) {
+	err = filepath.WalkDir(c.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			// It may have been removed concurrently.
+			return nil
+		}
+		ret = append(ret, diskCacheEntry{p, fi.Size(), fi.ModTime()})
+		return nil
+	})
+	return
+}
+
+// Accounts for a newly stored entry, trimming the cache if it's now too big.
+func (c *diskCache) added(n int64) {
+	if c.maxSize <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sizeKnown {
+		c.size += n
+		if c.size <= c.maxSize {
+			return
+		}
+	}
+	entries, err := c.entries()
+	if err != nil {
+		c.logger.Printf("error listing cache: %v", err)
+		return
+	}
+	c.size = 0
+	for _, e := range entries {
+		c.size += e.size
+	}
+	c.sizeKnown = true
+	if c.size <= c.maxSize {
+		return
+	}
+	// Trim with some headroom, so we don't have to do this on every addition.
+	target := c.maxSize * 9 / 10
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	var removed int
+	for _, e := range entries {
+		if c.size <= target {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			c.logger.Printf("error trimming cache: %v", err)
+			continue
+		}
+		c.size -= e.size
+		removed++
+	}
+	c.logger.Levelf(log.Debug, "trimmed %d entries from cache, now %d bytes", removed, c.size)
+}
@@ -0,0 +1,50 @@
+package dms
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestDiskCacheTrimsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &diskCache{dir: filepath.Join(dir, "cache"), maxSize: 250, logger: log.Default}
+	create := func(b byte) func() ([]byte, error) {
+		return func() ([]byte, error) { return bytes.Repeat([]byte{b}, 100), nil }
+	}
+	for i, v := range []string{"a", "b", "c"} {
+		if _, err := c.getOrCreate(src, fi, v, create(v[0])); err != nil {
+			t.Fatal(err)
+		}
+		// Make the use order unambiguous regardless of filesystem timestamp resolution.
+		used := time.Now().Add(time.Duration(i-3) * time.Hour)
+		os.Chtimes(c.entryPath(src, fi, v), used, used)
+	}
+	entries, err := c.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, e := range entries {
+		size += e.size
+	}
+	if size > c.maxSize {
+		t.Fatalf("cache is %d bytes, exceeding the %d byte limit", size, c.maxSize)
+	}
+	// The most recent entry must have survived.
+	if _, err := os.Stat(c.entryPath(src, fi, "c")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"context"
 	"crypto/md5"
+	"encoding/json"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"net"
 	"net/http"
 	"net/http/pprof"
@@ -48,33 +48,117 @@ var (
 )
 
 const (
-	userAgentProduct            = "dms"
-	rootDeviceType              = "urn:schemas-upnp-org:device:MediaServer:1"
-	resPath                     = "/res"
-	iconPath                    = "/icon"
-	subtitlePath                = "/subtitle"
-	rootDescPath                = "/rootDesc.xml"
-	contentDirectoryEventSubURL = "/evt/ContentDirectory"
-	serviceControlURL           = "/ctl"
-	deviceIconPath              = "/deviceIcon"
+	userAgentProduct             = "dms"
+	rootDeviceType               = "urn:schemas-upnp-org:device:MediaServer:1"
+	resPath                      = "/res"
+	iconPath                     = "/icon"
+	subtitlePath                 = "/subtitle"
+	imagePath                    = "/image"
+	albumArtPath                 = "/albumart"
+	rootDescPath                 = "/rootDesc.xml"
+	contentDirectoryEventSubURL  = "/evt/ContentDirectory"
+	connectionManagerEventSubURL = "/evt/ConnectionManager"
+	serviceControlURL            = "/ctl"
+	deviceIconPath               = "/deviceIcon"
+	statusPath                   = "/status"
+	devicesPath                  = "/devices"
+	recordingsPath               = "/recordings"
 )
 
 type transcodeSpec struct {
-	mimeType        string
+	mimeType string
+	// (optional) Returns MIME-type parameters for the transcode of a file with the given probe
+	// info, which may be nil. For example ";rate=44100;channels=2".
+	mimeTypeParams  func(info *ffprobe.Info) string
 	DLNAProfileName string
-	DLNAFlags       string
-	Transcode       func(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error)
+	DLNAFlags       dlna.Flags
+	// (optional) Returns the bytes per second of the transcoded stream, if it's known up front.
+	bitrate   func(info *ffprobe.Info) uint
+	Transcode func(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error)
+	// (optional) Transcodes with a choice of audio and subtitle streams. Used in place of
+	// Transcode when set.
+	transcodeWithOptions func(ctx context.Context, path string, start, length time.Duration, opts transcode.Options, stderr io.Writer) (r io.ReadCloser, err error)
+	// (optional) Transcodes to the format advertised for the probe info, which may be nil. Used
+	// in place of Transcode when set.
+	transcodeWithInfo func(ctx context.Context, path string, start, length time.Duration, info *ffprobe.Info, stderr io.Writer) (r io.ReadCloser, err error)
+	// Transcode writes to a log of its own, so none is created for the request.
+	logsItself bool
+	// (optional) Returns how far back the time-shift buffer of a live stream goes, for seeking
+	// within it.
+	liveBuffered func() time.Duration
+}
+
+// Returns the MIME-type of the transcoded stream for a file with the given probe info.
+func (ts transcodeSpec) MimeType(info *ffprobe.Info) string {
+	if ts.mimeTypeParams == nil {
+		return ts.mimeType
+	}
+	return ts.mimeType + ts.mimeTypeParams(info)
 }
 
 var transcodes = map[string]transcodeSpec{
 	"t": {
-		mimeType:        "video/mpeg",
-		DLNAProfileName: "MPEG_PS_PAL",
-		Transcode:       transcode.Transcode,
+		mimeType:             "video/mpeg",
+		DLNAProfileName:      "MPEG_PS_PAL",
+		Transcode:            transcode.Transcode,
+		transcodeWithOptions: transcode.TranscodeWithOptions,
+	},
+	"vp8": {mimeType: "video/webm", Transcode: transcode.VP8Transcode},
+	"chromecast": {
+		mimeType:             "video/mp4",
+		Transcode:            transcode.ChromecastTranscode,
+		transcodeWithOptions: transcode.ChromecastTranscodeWithOptions,
+	},
+	"web": {
+		mimeType:             "video/mp4",
+		Transcode:            transcode.WebTranscode,
+		transcodeWithOptions: transcode.WebTranscodeWithOptions,
+	},
+}
+
+// Transcodes offered for audio items.
+var audioTranscodes = map[string]transcodeSpec{
+	"mp3": {
+		mimeType:        "audio/mpeg",
+		DLNAProfileName: "MP3",
+		bitrate:         constantBitrate(320000 / 8),
+		Transcode:       transcode.MP3Transcode,
+	},
+	"aac": {
+		mimeType:        "audio/vnd.dlna.adts",
+		DLNAProfileName: "AAC_ADTS_320",
+		bitrate:         constantBitrate(256000 / 8),
+		Transcode:       transcode.AACTranscode,
+	},
+	"lpcm": {
+		mimeType: "audio/L16",
+		mimeTypeParams: func(info *ffprobe.Info) string {
+			sampleRate, channels := transcode.LPCMParams(info)
+			return fmt.Sprintf(";rate=%d;channels=%d", sampleRate, channels)
+		},
+		bitrate: func(info *ffprobe.Info) uint {
+			sampleRate, channels := transcode.LPCMParams(info)
+			return uint(sampleRate * channels * 2)
+		},
+		DLNAProfileName: "LPCM",
+		transcodeWithInfo: func(ctx context.Context, path string, start, length time.Duration, info *ffprobe.Info, stderr io.Writer) (io.ReadCloser, error) {
+			sampleRate, channels := transcode.LPCMParams(info)
+			return transcode.LPCMTranscode(ctx, path, start, length, sampleRate, channels, stderr)
+		},
 	},
-	"vp8":        {mimeType: "video/webm", Transcode: transcode.VP8Transcode},
-	"chromecast": {mimeType: "video/mp4", Transcode: transcode.ChromecastTranscode},
-	"web":        {mimeType: "video/mp4", Transcode: transcode.WebTranscode},
+}
+
+func constantBitrate(bytesPerSecond uint) func(*ffprobe.Info) uint {
+	return func(*ffprobe.Info) uint { return bytesPerSecond }
+}
+
+// Looks up a transcode by its key in the video and audio transcodes.
+func transcodeSpecByKey(k string) (ts transcodeSpec, ok bool) {
+	ts, ok = transcodes[k]
+	if !ok {
+		ts, ok = audioTranscodes[k]
+	}
+	return
 }
 
 func makeDeviceUuid(unique string) string {
@@ -106,6 +190,7 @@ var services = []*service{
 		Service: upnp.Service{
 			ServiceType: "urn:schemas-upnp-org:service:ConnectionManager:1",
 			ServiceId:   "urn:upnp-org:serviceId:ConnectionManager",
+			EventSubURL: connectionManagerEventSubURL,
 		},
 		SCPD: connectionManagerServiceDescription,
 	},
@@ -143,6 +228,7 @@ func (me *Server) httpPort() int {
 }
 
 func (me *Server) serveHTTP() error {
+	handler := me.accessControl(me.httpServeMux)
 	srv := &http.Server{
 		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if me.LogHeaders {
@@ -152,7 +238,7 @@ func (me *Server) serveHTTP() error {
 			}
 			w.Header().Set("Ext", "")
 			w.Header().Set("Server", serverField)
-			me.httpServeMux.ServeHTTP(&mitmRespWriter{
+			handler.ServeHTTP(&mitmRespWriter{
 				ResponseWriter: w,
 				logHeader:      me.LogHeaders,
 			}, r)
@@ -198,6 +284,18 @@ func (me *Server) ssdpInterface(if_ net.Interface, addrString string) {
 		Location: func(ip net.IP) string {
 			return me.location(ip)
 		},
+		// Announce on all our addresses, but don't answer clients that would be kept out.
+		IPFilter: func(ip net.IP) bool {
+			if addrs, err := if_.Addrs(); err == nil {
+				for _, addr := range addrs {
+					if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+						return true
+					}
+				}
+			}
+			// Media receivers awaiting approval need to find the server to register.
+			return me.clientAllowed(ip.String(), true)
+		},
 		Server:         serverField,
 		UUID:           me.rootDeviceUUID,
 		NotifyInterval: me.NotifyInterval,
@@ -279,15 +377,114 @@ type Server struct {
 	IgnorePaths []string
 	// White list of clients
 	AllowedIpNets []*net.IPNet
+	// Black list of clients, kept out whatever else allows them
+	DeniedIpNets []*net.IPNet
+	// Clients allowed to use the debug and admin endpoints, such as /debug/pprof/, /status and
+	// /devices. Only the local host if nil.
+	AdminIpNets []*net.IPNet
+	// Restrictions on the clients that may use parts of the library. The rule with the longest
+	// path covering an object applies.
+	PathAccess []PathAccess
+	// Views of the library for particular clients, hiding parts of it or videos above a content
+	// rating. The first matching profile applies, and clients matching none see everything.
+	ClientProfiles []ClientProfile
 	// Activate support for dynamic streams configured via .dms.json metadata files
 	// This feature is not enabled by default, since having write access to a shared media
-	// folder allows executing arbitrary commands in the context of the DLNA server.
+	// folder allows running the stream commands in the context of the DLNA server.
 	AllowDynamicStreams bool
+	// The commands dynamic streams may run, by name.
+	StreamCommands map[string]StreamCommand
+	// The URLs dynamic streams may relay: URL prefixes like "http://radio.example.com/live/", or
+	// hosts like "camera.lan" or "10.0.0.7:8080", allowing any HTTP or HTTPS URL there. This covers
+	// redirects and HLS playlists and segments too. Other URLs are refused, as are loopback and
+	// link-local addresses whatever is listed.
+	StreamURLs []string
+	// Let dynamic streams run arbitrary command lines given in their Command, as they used to.
+	// Anyone with write access to the media folder can then execute anything.
+	AllowStreamCommandLines bool
+	// How long the producer of a live dynamic stream keeps running after its last client leaves,
+	// for clients coming back or switching between streams.
+	LiveStreamGracePeriod time.Duration
+	// How much of live dynamic streams is kept in memory for clients to seek back within. Off if
+	// zero.
+	LiveTimeShift time.Duration
+	// The most bytes of each live dynamic stream kept for time-shifting, whatever LiveTimeShift
+	// is, so a high bitrate stream can't use up the server's memory. Zero means unbounded.
+	LiveTimeShiftMaxSize int64
+	// Directory recordings of dynamic streams are written to, relative to RootObjectPath unless
+	// absolute. It must be under RootObjectPath so recordings are browsed like other videos.
+	// Recording is disabled if empty.
+	RecordingsPath string
 	// pattern where to write transcode logs to. The [tsname] placeholder is replaced with the name
 	// of the item currently being played. The default is $HOME/.dms/log/[tsname]
 	TranscodeLogPattern string
-	Logger              log.Logger
-	eventingLogger      log.Logger
+	// Maximum number of transcodes running at once, across all clients. Further requests get a
+	// 503 response. Zero means unlimited.
+	MaxTranscodes int
+	// Maximum number of transcodes of the same file running at once for a single client, as
+	// starting a transcode of another file always cancels the client's others. A client starting
+	// one beyond this has its oldest cancelled. Zero means unlimited.
+	MaxTranscodesPerClient int
+	// Directory to store generated files in, such as resized images. Nothing is cached on disk if
+	// empty.
+	CachePath string
+	// Size in bytes the disk cache is trimmed to. Zero means unbounded.
+	CacheMaxSize int64
+	// Position in videos to take thumbnails from: a percentage of the duration like "10%", a
+	// duration like "1m30s", or "random". The default is "10%".
+	ThumbnailPosition string
+	// Case-insensitive glob patterns of image files used as album art for the items and
+	// containers in a directory, in order of preference. Defaults to folder.jpg, cover.jpg,
+	// AlbumArt*.jpg and the like.
+	AlbumArtFiles []string
+	// Don't use cover art embedded in media files.
+	NoEmbeddedAlbumArt bool
+	// Language codes in order of preference, like "en" or "ger", used to pick the audio track of
+	// transcodes, and the subtitles burned in when the audio isn't in a preferred language.
+	PreferredAudioLanguages    []string
+	PreferredSubtitleLanguages []string
+	// Language preferences for particular renderers, overriding those above. The first match
+	// applies.
+	RendererTrackPreferences []RendererTrackPreferences
+	// Add a container to the root that gathers the episodes of TV series found anywhere in the
+	// library into series and season containers, identified from names like "Show.S01E02" or
+	// .nfo files.
+	SeriesView bool
+	// Add a container to the root that gathers the photos found anywhere in the library by the
+	// year and month they were taken.
+	PhotosView bool
+	// File to keep the media receivers seen by the X_MS_MediaReceiverRegistrar service in, and
+	// whether they're approved. Nothing is persisted if empty.
+	DeviceRegistryPath string
+	// DeviceIDs or addresses of media receivers to approve or deny, whatever the registry says.
+	// Approved receivers may use the server even from outside AllowedIpNets.
+	ApprovedDevices []string
+	DeniedDevices   []string
+	// Keep media receivers out until they're approved, rather than only once they're denied.
+	RequireDeviceApproval bool
+	// protocolInfo entries appended to those generated for GetProtocolInfo, for vendor-specific
+	// profiles some renderers look for.
+	ExtraProtocolInfo  []string
+	Logger             log.Logger
+	eventingLogger     log.Logger
+	transcodeSlots     *transcodeSlots
+	cache              *diskCache
+	albumArtCache      fileCache[albumArtSource]
+	dirNamesCache      fileCache[dirNames]
+	seriesIndex        seriesIndex
+	audioTagsCache     fileCache[cachedAudioTags]
+	exifCache          fileCache[cachedExif]
+	imageGeometryCache fileCache[cachedImageGeometry]
+	nfoCache           fileCache[cachedNFO]
+	photosIndex        photosIndex
+	connections        connections
+	devices            deviceRegistry
+	liveStreams        liveStreams
+	recorder           recorder
+	recordingSchedules recordingSchedules
+	// The client URL streams are relayed with. relay's default if nil, which tests can't use.
+	relayClient        *http.Client
+	ffmpegNotFoundOnce sync.Once
 }
 
 // UPnP SOAP service.
@@ -320,19 +517,40 @@ type FfprobeCacheItem struct {
 // priority is given the format section, and then the streams sequentially
 func itemExtra(item *upnpav.Object, info *ffprobe.Info) {
 	setFromTags := func(m map[string]interface{}) {
+		// Current ffprobe versions nest tags, where older ones prefixed them with "tag:".
+		if tags, ok := m["tags"].(map[string]interface{}); ok {
+			m = tags
+		}
 		for key, val := range m {
-			setIfUnset := func(s *string) {
-				if *s == "" {
-					*s = val.(string)
+			s, ok := val.(string)
+			if !ok {
+				continue
+			}
+			setIfUnset := func(v *string) {
+				if *v == "" {
+					*v = s
+				}
+			}
+			addArtistIfUnset := func(role string) {
+				for _, a := range item.Artists {
+					if a.Role == role {
+						return
+					}
 				}
+				item.Artists = append(item.Artists, upnpav.Person{Name: s, Role: role})
 			}
-			switch strings.ToLower(key) {
-			case "tag:artist":
-				setIfUnset(&item.Artist)
-			case "tag:album":
+			switch strings.TrimPrefix(strings.ToLower(key), "tag:") {
+			case "artist":
+				addArtistIfUnset("")
+				setIfUnset(&item.Creator)
+			case "album_artist":
+				addArtistIfUnset("AlbumArtist")
+			case "album":
 				setIfUnset(&item.Album)
-			case "tag:genre":
-				setIfUnset(&item.Genre)
+			case "genre":
+				if len(item.Genres) == 0 {
+					item.Genres = []string{s}
+				}
 			}
 		}
 	}
@@ -347,31 +565,45 @@ type ffmpegInfoCacheKey struct {
 	ModTime int64
 }
 
-func transcodeResources(host, path, resolution, duration string) (ret []upnpav.Resource) {
-	ret = make([]upnpav.Resource, 0, len(transcodes))
-	for k, v := range transcodes {
-		ret = append(ret, upnpav.Resource{
-			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", v.mimeType, dlna.ContentFeatures{
-				SupportTimeSeek: true,
-				Transcoded:      true,
-				ProfileName:     v.DLNAProfileName,
-			}.String()),
-			URL: (&url.URL{
-				Scheme: "http",
-				Host:   host,
-				Path:   resPath,
-				RawQuery: url.Values{
-					"path":      {path},
-					"transcode": {k},
-				}.Encode(),
-			}).String(),
-			Resolution: resolution,
-			Duration:   duration,
-		})
+func transcodeResources(host, path, resolution, duration string, specs map[string]transcodeSpec, info *ffprobe.Info) (ret []upnpav.Resource) {
+	ret = make([]upnpav.Resource, 0, len(specs))
+	for k, v := range specs {
+		ret = append(ret, transcodeResource(host, path, resolution, duration, k, v, info, nil))
 	}
 	return
 }
 
+// Returns the resource for a transcode of the file at path, with any extra query parameters.
+func transcodeResource(host, path, resolution, duration, k string, v transcodeSpec, info *ffprobe.Info, extra url.Values) upnpav.Resource {
+	var bitrate uint
+	if v.bitrate != nil {
+		bitrate = v.bitrate(info)
+	}
+	q := url.Values{
+		"path":      {path},
+		"transcode": {k},
+	}
+	for name, values := range extra {
+		q[name] = values
+	}
+	return upnpav.Resource{
+		ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", v.MimeType(info), dlna.ContentFeatures{
+			SupportTimeSeek: true,
+			Transcoded:      true,
+			ProfileName:     v.DLNAProfileName,
+		}.String()),
+		URL: (&url.URL{
+			Scheme:   "http",
+			Host:     host,
+			Path:     resPath,
+			RawQuery: q.Encode(),
+		}).String(),
+		Resolution: resolution,
+		Duration:   duration,
+		Bitrate:    bitrate,
+	}
+}
+
 func parseDLNARangeHeader(val string) (ret dlna.NPTRange, err error) {
 	if !strings.HasPrefix(val, "npt=") {
 		err = errors.New("bad prefix")
@@ -488,15 +720,45 @@ func writeResponseCode(w http.ResponseWriter, partialResponse bool) {
 	}())
 }
 
+// Creates the file to log the transcode with the name to, or returns nil if there isn't one.
+func (me *Server) createTranscodeLog(logTsName string) io.WriteCloser {
+	stderrPath := strings.Replace(me.TranscodeLogPattern, "[tsname]", logTsName, -1)
+	if stderrPath == "" {
+		return nil
+	}
+	os.MkdirAll(filepath.Dir(stderrPath), 0o750)
+	f, err := os.Create(stderrPath)
+	if err != nil {
+		log.Printf("couldn't create transcode log file: %s", err)
+		return nil
+	}
+	log.Printf("logging transcode to %q", stderrPath)
+	return f
+}
+
 func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, path_ string, ts transcodeSpec, tsname string, dynamicMode bool) {
+	if ts.transcodeWithOptions != nil && !dynamicMode {
+		var ffInfo *ffprobe.Info
+		if !me.NoProbe {
+			ffInfo, _ = me.ffmpegProbe(path_)
+		}
+		opts, err := me.transcodeOptions(r, path_, ffInfo)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		ts.Transcode = func(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (io.ReadCloser, error) {
+			return ts.transcodeWithOptions(ctx, path, start, length, opts, stderr)
+		}
+	}
 	w.Header().Set(dlna.TransferModeDomain, "Streaming")
-	w.Header().Set("content-type", ts.mimeType)
-	w.Header().Set(dlna.ContentFeaturesDomain, (dlna.ContentFeatures{
+	contentFeatures := (dlna.ContentFeatures{
 		Transcoded:      true,
-		SupportTimeSeek: !dynamicMode,
+		SupportTimeSeek: !dynamicMode || ts.liveBuffered != nil,
 		ProfileName:     ts.DLNAProfileName,
 		Flags:           ts.DLNAFlags,
-	}).String())
+	}).String()
+	w.Header().Set(dlna.ContentFeaturesDomain, contentFeatures)
 
 	var (
 		ffInfo         *ffprobe.Info
@@ -509,10 +771,30 @@ func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, pat
 		ffInfoSize = getSizeFromFFInfo(ffInfo)
 		ffInfoDuration = getDurationFromFFInfo(ffInfo)
 	}
+	// The format is the one advertised when browsing, which doesn't probe with NoProbe.
+	formatInfo := ffInfo
+	if me.NoProbe {
+		formatInfo = nil
+	}
+	if ts.transcodeWithInfo != nil {
+		ts.Transcode = func(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (io.ReadCloser, error) {
+			return ts.transcodeWithInfo(ctx, path, start, length, formatInfo, stderr)
+		}
+	}
+	w.Header().Set("content-type", ts.MimeType(formatInfo))
 
 	// If a range of any kind is given, we have to respond with 206 if we're
 	// interpreting that range.
-	range_, partialResponse, ok := handleDLNARange(w, r.Header, dynamicMode, ffInfoSize, ffInfoDuration)
+	var (
+		range_          dlna.NPTRange
+		partialResponse bool
+		ok              bool
+	)
+	if ts.liveBuffered != nil {
+		range_, partialResponse, ok = handleTimeShiftRange(w, r.Header, ts.liveBuffered())
+	} else {
+		range_, partialResponse, ok = handleDLNARange(w, r.Header, dynamicMode, ffInfoSize, ffInfoDuration)
+	}
 	if !ok {
 		return
 	}
@@ -524,6 +806,16 @@ func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, pat
 		return
 	}
 
+	session, ctx, ok := me.transcodeSlots.acquire(r.Context(), requestClientIP(r), path_, tsname)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(transcodeRetryAfter/time.Second)))
+		http.Error(w, "too many concurrent transcodes", http.StatusServiceUnavailable)
+		return
+	}
+	defer me.transcodeSlots.release(session)
+	conn := me.connections.open(r, r.URL.Query().Get("path"), fmt.Sprintf("http-get:*:%s:%s", ts.MimeType(formatInfo), contentFeatures), tsname)
+	defer me.connections.close(conn)
+
 	var logTsName string
 	if !dynamicMode {
 		const smallFileThreshold = 100 * 1024 * 1024 // 100M
@@ -551,21 +843,15 @@ func (me *Server) serveDLNATranscode(w http.ResponseWriter, r *http.Request, pat
 	} else {
 		logTsName = tsname
 	}
-	stderrPath := strings.Replace(me.TranscodeLogPattern, "[tsname]", logTsName, -1)
 	var logFile io.Writer
-	if stderrPath != "" {
-		os.MkdirAll(filepath.Dir(stderrPath), 0o750)
-		aLogFile, err := os.Create(stderrPath)
-		if err != nil {
-			log.Printf("couldn't create transcode log file: %s", err)
-		} else {
-			defer aLogFile.Close()
-			log.Printf("logging transcode to %q", stderrPath)
+	if !ts.logsItself {
+		if f := me.createTranscodeLog(logTsName); f != nil {
+			defer f.Close()
+			logFile = f
 		}
-		logFile = aLogFile
 	}
 
-	p, err := ts.Transcode(r.Context(), path_, range_.Start, range_.End-range_.Start, logFile)
+	p, err := ts.Transcode(ctx, path_, range_.Start, range_.End-range_.Start, logFile)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -692,22 +978,6 @@ func (me *Server) soapActionResponse(sa upnp.SoapAction, actionRequestXML []byte
 
 // Handle a service control HTTP request.
 func (me *Server) serviceControlHandler(w http.ResponseWriter, r *http.Request) {
-	found := false
-	clientIp, _, _ := net.SplitHostPort(r.RemoteAddr)
-	if zoneDelimiterIdx := strings.Index(clientIp, "%"); zoneDelimiterIdx != -1 {
-		// IPv6 addresses may have the form address%zone (e.g. ::1%eth0)
-		clientIp = clientIp[:zoneDelimiterIdx]
-	}
-	for _, ipnet := range me.AllowedIpNets {
-		if ipnet.Contains(net.ParseIP(clientIp)) {
-			found = true
-		}
-	}
-	if !found {
-		log.Printf("not allowed client %s, %+v", clientIp, me.AllowedIpNets)
-		http.Error(w, "forbidden", http.StatusForbidden)
-		return
-	}
 	soapActionString := r.Header.Get("SOAPACTION")
 	soapAction, err := upnp.ParseActionHTTPHeader(soapActionString)
 	if err != nil {
@@ -751,68 +1021,77 @@ func (s *Server) filePath(_path string) string {
 
 func (me *Server) serveIcon(w http.ResponseWriter, r *http.Request) {
 	filePath := me.filePath(r.URL.Query().Get("path"))
-	c := r.URL.Query().Get("c")
-	if c == "" {
-		c = "png"
-	}
-	args := []string{}
-	_, fqThumbnail := os.LookupEnv("DMS_THUMBNAIL_FULLQUALITY")
-	if fqThumbnail {
-		args = append(args, "-s", "0", "-q", "10")
+	thumbType := "image/png"
+	if r.URL.Query().Get("c") == "jpeg" {
+		thumbType = "image/jpeg"
 	}
-
-	_, randThumbnail := os.LookupEnv("DMS_THUMBNAIL_RANDOM")
-	if randThumbnail {
-		args = append(args, "-t", strconv.Itoa(rand.Intn(100)))
-	}
-
-	args = append(args, "-i", filePath, "-o", "/dev/stdout", "-c"+c)
-	cmd := exec.Command("ffmpegthumbnailer", args...)
-	// cmd.Stderr = os.Stderr
-	body, err := cmd.Output()
+	body, modTime, err := func() ([]byte, time.Time, error) {
+		fi, err := os.Stat(filePath)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		mimeType, err := MimeTypeByPath(filePath)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		body, err := me.thumbnail(filePath, fi, mimeType, thumbType)
+		return body, fi.ModTime(), err
+	}()
 	if err != nil {
-		// serve 1st Icon if no ffmpegthumbnailer
+		if errors.Is(err, exec.ErrNotFound) {
+			me.ffmpegNotFoundOnce.Do(func() {
+				me.Logger.Printf("ffmpeg not found, serving the device icon in place of video thumbnails")
+			})
+		} else {
+			me.Logger.Levelf(log.Debug, "error generating thumbnail for %q: %v", filePath, err)
+		}
+		// Serve the first device icon if there's no thumbnail.
 		w.Header().Set("Content-Type", me.Icons[0].Mimetype)
 		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(me.Icons[0].Bytes))
-		// http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	http.ServeContent(w, r, "", time.Now(), bytes.NewReader(body))
-}
-
-func (me *Server) serveSubtitle(w http.ResponseWriter, r *http.Request) {
-	filePath := me.filePath(r.URL.Query().Get("path"))
-	subtitleFilePath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".srt"
-	http.ServeFile(w, r, subtitleFilePath)
+	w.Header().Set("Content-Type", thumbType)
+	if thumbType == "image/jpeg" {
+		w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
+			ProfileName: "JPEG_TN",
+			Transcoded:  true,
+		}.String())
+	}
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
 }
 
 func (server *Server) contentDirectoryInitialEvent(urls []*url.URL, sid string) {
-	body := xmlMarshalOrPanic(upnp.PropertySet{
-		Properties: []upnp.Property{
-			{
-				Variable: upnp.Variable{
-					XMLName: xml.Name{
-						Local: "SystemUpdateID",
-					},
-					Value: "0",
+	server.sendEvent(urls, sid, 0, []upnp.Property{
+		{
+			Variable: upnp.Variable{
+				XMLName: xml.Name{
+					Local: "SystemUpdateID",
 				},
+				Value: "0",
 			},
-			// upnp.Property{
-			// 	Variable: upnp.Variable{
-			// 		XMLName: xml.Name{
-			// 			Local: "ContainerUpdateIDs",
-			// 		},
-			// 	},
-			// },
-			// upnp.Property{
-			// 	Variable: upnp.Variable{
-			// 		XMLName: xml.Name{
-			// 			Local: "TransferIDs",
-			// 		},
-			// 	},
-			// },
 		},
-		Space: "urn:schemas-upnp-org:event-1-0",
+		// upnp.Property{
+		// 	Variable: upnp.Variable{
+		// 		XMLName: xml.Name{
+		// 			Local: "ContainerUpdateIDs",
+		// 		},
+		// 	},
+		// },
+		// upnp.Property{
+		// 	Variable: upnp.Variable{
+		// 		XMLName: xml.Name{
+		// 			Local: "TransferIDs",
+		// 		},
+		// 	},
+		// },
+	})
+}
+
+// Sends an event with the given properties to a subscriber.
+func (server *Server) sendEvent(urls []*url.URL, sid string, seq uint32, properties []upnp.Property) {
+	body := xmlMarshalOrPanic(upnp.PropertySet{
+		Properties: properties,
+		Space:      "urn:schemas-upnp-org:event-1-0",
 	})
 	body = append([]byte(`<?xml version="1.0"?>`+"\n"), body...)
 	server.eventingLogger.Print(string(body))
@@ -827,7 +1106,7 @@ func (server *Server) contentDirectoryInitialEvent(urls []*url.URL, sid string)
 		req.Header["NT"] = []string{"upnp:event"}
 		req.Header["NTS"] = []string{"upnp:propchange"}
 		req.Header["SID"] = []string{sid}
-		req.Header["SEQ"] = []string{"0"}
+		req.Header["SEQ"] = []string{strconv.FormatUint(uint64(seq), 10)}
 		// req.Header["TRANSFER-ENCODING"] = []string{"chunked"}
 		// req.ContentLength = int64(bodyReader.Len())
 		server.eventingLogger.Print(req.Header)
@@ -894,8 +1173,12 @@ func (server *Server) contentDirectoryEventSubHandler(w http.ResponseWriter, r *
 	}
 }
 
+func (server *Server) connectionManagerEventSubHandler(w http.ResponseWriter, r *http.Request) {
+	server.services["ConnectionManager"].(*connectionManagerService).serveEventSubscription(w, r)
+}
+
 func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request, metadataPath string) error {
-	dmsMediaItem, err := readDynamicStream(metadataPath)
+	dmsMediaItem, err := server.loadDynamicStream(metadataPath)
 	if err != nil {
 		return err
 	}
@@ -917,30 +1200,229 @@ func (server *Server) serveDynamicStream(w http.ResponseWriter, r *http.Request,
 		DLNAProfileName: dmsStream.DlnaProfileName,
 		DLNAFlags:       dmsStream.DlnaFlags,
 		mimeType:        dmsStream.MimeType,
-		Transcode:       transcode.Exec,
 	}
-	server.serveDLNATranscode(w, r, dmsStream.Command, dmsTsSpec, filepath.Base(metadataPath), true)
+	open, err := server.dynamicStreamOpener(dmsStream)
+	if err != nil {
+		return err
+	}
+	tsname := filepath.Base(metadataPath)
+	if dmsStream.Live {
+		// The producer outlives the request that started it, so it logs on its own.
+		key := liveStreamKey(metadataPath, aindex)
+		dmsTsSpec.logsItself = true
+		if server.LiveTimeShift > 0 {
+			dmsTsSpec.liveBuffered = func() time.Duration {
+				return server.liveStreams.buffered(key)
+			}
+		}
+		seek := dmsTsSpec.liveBuffered != nil && r.Header.Get(dlna.TimeSeekRangeDomain) != ""
+		dmsTsSpec.Transcode = func(ctx context.Context, _ string, start, _ time.Duration, _ io.Writer) (io.ReadCloser, error) {
+			if !seek {
+				start = -1
+			}
+			return server.liveStreams.join(ctx, key, dmsStream.MimeType, start, server.loggedOpener(open, tsname))
+		}
+	} else {
+		dmsTsSpec.Transcode = func(ctx context.Context, _ string, _, _ time.Duration, stderr io.Writer) (io.ReadCloser, error) {
+			return open(ctx, stderr)
+		}
+	}
+	server.serveDLNATranscode(w, r, metadataPath, dmsTsSpec, tsname, true)
 	return nil
 }
 
+// Returns how to start the producer of a dynamic stream resource: relaying its URL, or running
+// its command.
+func (server *Server) dynamicStreamOpener(res dmsDynamicStreamResource) (func(ctx context.Context, stderr io.Writer) (io.ReadCloser, error), error) {
+	switch {
+	case res.URL != "":
+		return func(ctx context.Context, stderr io.Writer) (io.ReadCloser, error) {
+			return server.relayStream(ctx, res, stderr)
+		}, nil
+	case res.Command != "":
+		if !server.AllowStreamCommandLines {
+			return nil, errors.New("stream command lines are disabled, use a configured stream command")
+		}
+		return func(ctx context.Context, stderr io.Writer) (io.ReadCloser, error) {
+			return transcode.Exec(ctx, res.Command, 0, 0, stderr)
+		}, nil
+	}
+	sc, ok := server.StreamCommands[res.Stream]
+	if !ok {
+		return nil, fmt.Errorf("no stream command %q", res.Stream)
+	}
+	args, err := sc.args(res.Params)
+	if err != nil {
+		return nil, fmt.Errorf("stream command %q: %w", res.Stream, err)
+	}
+	sb, err := sc.sandbox()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context, stderr io.Writer) (io.ReadCloser, error) {
+		return transcode.ExecSandboxed(ctx, args, sb, server.Logger, stderr)
+	}, nil
+}
+
+// Wraps the opener of a live stream's producer to log to a transcode log of its own.
+func (server *Server) loggedOpener(open func(ctx context.Context, stderr io.Writer) (io.ReadCloser, error), tsname string) func(ctx context.Context) (io.ReadCloser, error) {
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		logFile := server.createTranscodeLog(tsname)
+		if logFile == nil {
+			return open(ctx, nil)
+		}
+		p, err := open(ctx, logFile)
+		if err != nil {
+			logFile.Close()
+			return nil, err
+		}
+		return &loggedStream{p, logFile}, nil
+	}
+}
+
+// Reports what the server is currently doing, for monitoring.
+func (server *Server) serveStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		Connections []connection
+		Transcodes  transcodeSlotsStatus
+	}{
+		Connections: server.connections.list(),
+		Transcodes:  server.transcodeSlots.status(),
+	}); err != nil {
+		log.Print(err)
+	}
+}
+
+// Lists the media receivers in the device registry as JSON, and approves or denies them with a
+// POST of their id and a status of approved, denied or pending.
+func (server *Server) serveDevices(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(server.devices.list()); err != nil {
+			log.Print(err)
+		}
+	case http.MethodPost:
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		status := deviceStatus(r.FormValue("status"))
+		switch status {
+		case devicePending, deviceApproved, deviceDenied:
+		default:
+			http.Error(w, fmt.Sprintf("invalid status %q", status), http.StatusBadRequest)
+			return
+		}
+		if !server.devices.setStatus(r.FormValue("id"), status) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Lists the recordings in progress as JSON. A POST with an action of start records the resource
+// at the index of the dynamic stream at the path, for the duration if one is given, and one of
+// stop stops the recording with the id.
+func (server *Server) serveRecordings(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(server.recorder.list()); err != nil {
+			log.Print(err)
+		}
+	case http.MethodPost:
+		if !sameOrigin(r) {
+			http.Error(w, "cross-origin request", http.StatusForbidden)
+			return
+		}
+		switch action := r.FormValue("action"); action {
+		case "start":
+			var (
+				index    int
+				duration time.Duration
+				err      error
+			)
+			if s := r.FormValue("index"); s != "" {
+				if index, err = strconv.Atoi(s); err != nil {
+					http.Error(w, fmt.Sprintf("invalid index %q", s), http.StatusBadRequest)
+					return
+				}
+			}
+			if s := r.FormValue("duration"); s != "" {
+				if duration, err = time.ParseDuration(s); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			if _, err := server.startRecording(r.FormValue("path"), index, duration, false); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case "stop":
+			if !server.recorder.stop(r.FormValue("id")) {
+				http.NotFound(w, r)
+				return
+			}
+		default:
+			http.Error(w, fmt.Sprintf("invalid action %q", action), http.StatusBadRequest)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func (server *Server) initMux(mux *http.ServeMux) {
 	// Handle root (presentationURL)
 	mux.HandleFunc("/", func(resp http.ResponseWriter, req *http.Request) {
 		resp.Header().Set("content-type", "text/html")
+		admin := server.adminAllowed(requestClientIP(req))
 		err := rootTmpl.Execute(resp, struct {
-			Readonly bool
-			Path     string
+			Readonly       bool
+			Path           string
+			DevicesPath    string
+			Devices        []registeredDevice
+			RecordingsPath string
+			Recording      bool
+			Recordings     []recording
 		}{
 			true,
 			server.RootObjectPath,
+			devicesPath,
+			func() []registeredDevice {
+				if admin {
+					return server.devices.list()
+				}
+				return nil
+			}(),
+			recordingsPath,
+			admin && server.RecordingsPath != "" && server.AllowDynamicStreams,
+			server.recorder.list(),
 		})
 		if err != nil {
 			log.Println(err)
 		}
 	})
 	mux.HandleFunc(contentDirectoryEventSubURL, server.contentDirectoryEventSubHandler)
+	mux.HandleFunc(connectionManagerEventSubURL, server.connectionManagerEventSubHandler)
 	mux.HandleFunc(iconPath, server.serveIcon)
 	mux.HandleFunc(subtitlePath, server.serveSubtitle)
+	mux.HandleFunc(imagePath, server.serveImage)
+	mux.HandleFunc(albumArtPath, server.serveAlbumArt)
 	mux.HandleFunc(resPath, func(w http.ResponseWriter, r *http.Request) {
 		filePath := server.filePath(r.URL.Query().Get("path"))
 		if ignored, err := server.IgnorePath(filePath); err != nil {
@@ -962,6 +1444,7 @@ func (server *Server) initMux(mux *http.ServeMux) {
 				return
 			}
 		}
+		server.setCaptionInfoHeader(w, r, filePath)
 		var k string
 		if server.ForceTranscodeTo != "" {
 			k = server.ForceTranscodeTo
@@ -977,8 +1460,27 @@ func (server *Server) initMux(mux *http.ServeMux) {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
-			w.Header().Set("Content-Type", string(mimeType))
+			var info *ffprobe.Info
+			if !server.NoProbe && !mimeType.IsImage() {
+				info, _ = server.ffmpegProbe(filePath)
+			}
+			profile, ok := server.dlnaProfile(filePath, mimeType, info)
+			if ok {
+				w.Header().Set("Content-Type", profile.MimeType)
+			} else {
+				w.Header().Set("Content-Type", string(mimeType))
+			}
+			contentFeatures := dlna.ContentFeatures{
+				ProfileName:  profile.Name,
+				SupportRange: true,
+			}.String()
+			w.Header().Set(dlna.ContentFeaturesDomain, contentFeatures)
 			w.Header().Set("Content-Disposition", "attachment; filename="+strconv.Quote(path.Base(filePath)))
+			if r.Method != "HEAD" {
+				protocolInfo := fmt.Sprintf("http-get:*:%s:%s", w.Header().Get("Content-Type"), contentFeatures)
+				c := server.connections.open(r, r.URL.Query().Get("path"), protocolInfo, "")
+				defer server.connections.close(c)
+			}
 			http.ServeFile(w, r, filePath)
 			return
 		}
@@ -986,7 +1488,7 @@ func (server *Server) initMux(mux *http.ServeMux) {
 			http.Error(w, "transcodes disabled", http.StatusNotFound)
 			return
 		}
-		spec, ok := transcodes[k]
+		spec, ok := transcodeSpecByKey(k)
 		if !ok {
 			http.Error(w, fmt.Sprintf("bad transcode spec key: %s", k), http.StatusBadRequest)
 			return
@@ -1002,6 +1504,9 @@ func (server *Server) initMux(mux *http.ServeMux) {
 	handleSCPDs(mux)
 	mux.HandleFunc(serviceControlURL, server.serviceControlHandler)
 	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc(statusPath, server.serveStatus)
+	mux.HandleFunc(devicesPath, server.serveDevices)
+	mux.HandleFunc(recordingsPath, server.serveRecordings)
 	// DeviceIcons
 	iconHandl := func(w http.ResponseWriter, r *http.Request) {
 		idStr := path.Base(r.URL.Path)
@@ -1031,13 +1536,16 @@ func (s *Server) initServices() (err error) {
 	if err != nil {
 		return
 	}
+	cms := &connectionManagerService{
+		Server:             s,
+		sourceProtocolInfo: s.buildSourceProtocolInfo(),
+	}
+	s.connections.changed = cms.connectionsChanged
 	s.services = map[string]UPnPService{
 		urn.Type: &contentDirectoryService{
 			Server: s,
 		},
-		urn1.Type: &connectionManagerService{
-			Server: s,
-		},
+		urn1.Type: cms,
 		urn2.Type: &mediaReceiverRegistrarService{
 			Server: s,
 		},
@@ -1078,6 +1586,69 @@ func (srv *Server) Init() (err error) {
 	if srv.FFProbeCache == nil {
 		srv.FFProbeCache = dummyFFProbeCache{}
 	}
+	srv.transcodeSlots = &transcodeSlots{
+		max:          srv.MaxTranscodes,
+		maxPerClient: srv.MaxTranscodesPerClient,
+		logger:       srv.Logger.WithNames("transcode"),
+	}
+	if _, err = thumbnailOffset(srv.ThumbnailPosition, time.Hour); err != nil {
+		return
+	}
+	for _, p := range srv.RendererTrackPreferences {
+		if err = p.validate(); err != nil {
+			return
+		}
+	}
+	for _, p := range srv.PathAccess {
+		if err = p.validate(); err != nil {
+			return
+		}
+	}
+	for _, p := range srv.ClientProfiles {
+		if err = p.validate(); err != nil {
+			return
+		}
+	}
+	for name, c := range srv.StreamCommands {
+		if err = c.validate(name); err != nil {
+			return
+		}
+	}
+	for _, s := range srv.StreamURLs {
+		if err = validateStreamURL(s); err != nil {
+			return
+		}
+	}
+	srv.devices = deviceRegistry{
+		path:            srv.DeviceRegistryPath,
+		approved:        srv.ApprovedDevices,
+		denied:          srv.DeniedDevices,
+		requireApproval: srv.RequireDeviceApproval,
+		logger:          srv.Logger.WithNames("devices"),
+	}
+	if err = srv.devices.load(); err != nil {
+		return fmt.Errorf("loading device registry: %w", err)
+	}
+	if srv.RecordingsPath != "" {
+		if !filepath.IsAbs(srv.RecordingsPath) {
+			srv.RecordingsPath = filepath.Join(srv.RootObjectPath, srv.RecordingsPath)
+		}
+		if rel, err := filepath.Rel(srv.RootObjectPath, srv.RecordingsPath); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("recordings directory %q isn't under the root %q", srv.RecordingsPath, srv.RootObjectPath)
+		}
+		if err = os.MkdirAll(srv.RecordingsPath, 0o755); err != nil {
+			return
+		}
+	}
+	srv.liveStreams.grace = srv.LiveStreamGracePeriod
+	srv.liveStreams.timeShift = srv.LiveTimeShift
+	srv.liveStreams.timeShiftMaxSize = srv.LiveTimeShiftMaxSize
+	srv.liveStreams.logger = srv.Logger.WithNames("live")
+	srv.cache = &diskCache{
+		dir:     srv.CachePath,
+		maxSize: srv.CacheMaxSize,
+		logger:  srv.Logger.WithNames("cache"),
+	}
 	srv.httpServeMux = http.NewServeMux()
 	srv.rootDeviceUUID = makeDeviceUuid(srv.FriendlyName)
 	srv.rootDescXML, err = xml.MarshalIndent(
@@ -1144,11 +1715,18 @@ func (srv *Server) Run() (err error) {
 		srv.doSSDP()
 		close(srv.ssdpStopped)
 	}()
+	if srv.AllowDynamicStreams {
+		go srv.reportDynamicStreamProblems()
+	}
+	if srv.RecordingsPath != "" && srv.AllowDynamicStreams {
+		go srv.runRecordingSchedules()
+	}
 	return srv.serveHTTP()
 }
 
 func (srv *Server) Close() (err error) {
 	close(srv.closed)
+	srv.recorder.stopAll()
 	err = srv.HTTPConn.Close()
 	<-srv.ssdpStopped
 	return
@@ -1156,10 +1734,12 @@ func (srv *Server) Close() (err error) {
 
 func didl_lite(chardata string) string {
 	return `<DIDL-Lite` +
-		` xmlns:dc="http://purl.org/dc/elements/1.1/"` +
-		` xmlns:upnp="urn:schemas-upnp-org:metadata-1-0/upnp/"` +
-		` xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"` +
-		` xmlns:dlna="urn:schemas-dlna-org:metadata-1-0/">` +
+		` xmlns:dc="` + upnpav.NamespaceDC + `"` +
+		` xmlns:upnp="` + upnpav.NamespaceUPnP + `"` +
+		` xmlns="` + upnpav.NamespaceDIDLLite + `"` +
+		` xmlns:dlna="` + upnpav.NamespaceDLNA + `"` +
+		` xmlns:sec="` + upnpav.NamespaceSEC + `"` +
+		` xmlns:pv="` + upnpav.NamespacePV + `">` +
 		chardata +
 		`</DIDL-Lite>`
 }
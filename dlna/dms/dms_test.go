@@ -2,9 +2,12 @@ package dms
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"runtime"
 	"testing"
+
+	"github.com/anacrolix/ffprobe"
 )
 
 type safeFilePathTestCase struct {
@@ -64,3 +67,32 @@ func TestResponse(t *testing.T) {
 	resp.Write(&buf)
 	t.Logf("%q", buf.String())
 }
+
+func TestAudioTranscodeMimeTypes(t *testing.T) {
+	info := &ffprobe.Info{Streams: []map[string]interface{}{
+		{"codec_type": "audio", "sample_rate": "96000", "channels": json.Number("1")},
+	}}
+	for _, tc := range []struct {
+		key      string
+		info     *ffprobe.Info
+		mimeType string
+		bitrate  uint
+	}{
+		{"mp3", info, "audio/mpeg", 40000},
+		{"aac", info, "audio/vnd.dlna.adts", 32000},
+		{"lpcm", info, "audio/L16;rate=48000;channels=1", 96000},
+		// Without probe info, as with NoProbe.
+		{"lpcm", nil, "audio/L16;rate=44100;channels=2", 176400},
+	} {
+		ts, ok := transcodeSpecByKey(tc.key)
+		if !ok {
+			t.Fatalf("no transcode %q", tc.key)
+		}
+		if mt := ts.MimeType(tc.info); mt != tc.mimeType {
+			t.Errorf("%s: MIME-type %q, expected %q", tc.key, mt, tc.mimeType)
+		}
+		if br := ts.bitrate(tc.info); br != tc.bitrate {
+			t.Errorf("%s: bitrate %d, expected %d", tc.key, br, tc.bitrate)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package dms
+
+import (
+	"sync"
+
+	"github.com/anacrolix/dms/rrcache"
+)
+
+// Roughly the bytes held by each cache of what's read from files.
+const fileCacheSize = 4 << 20
+
+// The key of a value read from a file, which changes along with the file.
+type fileCacheKey struct {
+	Path    string
+	ModTime int64
+	// (optional) The modification time of the file's directory, for values that depend on the
+	// other files in it.
+	DirModTime int64
+}
+
+// A value held by a fileCache, which knows roughly how many bytes it holds.
+type fileCacheValue interface {
+	cacheSize() int64
+}
+
+// Caches what's read from files, by their path and modification time, in a random replacement
+// cache. The zero value is ready to use.
+type fileCache[V fileCacheValue] struct {
+	mu sync.Mutex
+	c  *rrcache.RRCache
+}
+
+func (me *fileCache[V]) lazyInit() {
+	if me.c == nil {
+		me.c = rrcache.New(fileCacheSize)
+	}
+}
+
+func (me *fileCache[V]) get(key fileCacheKey) (v V, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.lazyInit()
+	i, ok := me.c.Get(key)
+	if ok {
+		v = i.(V)
+	}
+	return
+}
+
+func (me *fileCache[V]) set(key fileCacheKey, v V) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.lazyInit()
+	me.c.Set(key, v, int64(len(key.Path))+v.cacheSize())
+}
@@ -0,0 +1,19 @@
+package dms
+
+import "testing"
+
+func TestFileCache(t *testing.T) {
+	var c fileCache[albumArtSource]
+	key := fileCacheKey{Path: "/music/a.mp3", ModTime: 1}
+	if _, ok := c.get(key); ok {
+		t.Fatal("found in an empty cache")
+	}
+	c.set(key, albumArtSource{Embedded: key.Path})
+	if src, ok := c.get(key); !ok || src.Embedded != key.Path {
+		t.Fatalf("got %v, %v", src, ok)
+	}
+	key.DirModTime = 2
+	if _, ok := c.get(key); ok {
+		t.Fatal("found after the directory changed")
+	}
+}
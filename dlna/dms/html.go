@@ -15,5 +15,52 @@ func init() {
 				value="{{.Path}}"
 			/>
 			<input type="submit" value="Update"{{if .Readonly}} disabled="disabled"{{end}}/>
-		</form>`))
+		</form>
+		{{with .Devices}}
+		<table>
+			<tr><th>Media receiver</th><th>Address</th><th>User agent</th><th>Last seen</th><th>Status</th><th></th></tr>
+			{{range .}}
+			<tr>
+				<td>{{.ID}}</td>
+				<td>{{.Addr}}</td>
+				<td>{{.UserAgent}}</td>
+				<td>{{.LastSeen.Format "2006-01-02 15:04:05"}}</td>
+				<td>{{.Status}}</td>
+				<td>
+					<form method="post" action="{{$.DevicesPath}}">
+						<input type="hidden" name="id" value="{{.ID}}"/>
+						<button type="submit" name="status" value="approved">Approve</button>
+						<button type="submit" name="status" value="denied">Deny</button>
+					</form>
+				</td>
+			</tr>
+			{{end}}
+		</table>
+		{{end}}
+		{{if .Recording}}
+		<table>
+			<tr><th>Recording</th><th>Resource</th><th>File</th><th>Started</th><th>Until</th><th></th></tr>
+			{{range .Recordings}}
+			<tr>
+				<td>{{.Path}}</td>
+				<td>{{.Index}}</td>
+				<td>{{.File}}</td>
+				<td>{{.Started.Format "2006-01-02 15:04:05"}}</td>
+				<td>{{with .Until}}{{.Format "2006-01-02 15:04:05"}}{{end}}</td>
+				<td>
+					<form method="post" action="{{$.RecordingsPath}}">
+						<input type="hidden" name="id" value="{{.ID}}"/>
+						<button type="submit" name="action" value="stop">Stop</button>
+					</form>
+				</td>
+			</tr>
+			{{end}}
+		</table>
+		<form method="post" action="{{.RecordingsPath}}">
+			Record: <input type="text" name="path" placeholder="/Cameras/door.dms.json"/>
+			Resource: <input type="number" name="index" value="0" min="0"/>
+			For: <input type="text" name="duration" placeholder="1h30m"/>
+			<button type="submit" name="action" value="start">Start</button>
+		</form>
+		{{end}}`))
 }
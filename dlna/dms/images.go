@@ -0,0 +1,298 @@
+package dms
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/anacrolix/log"
+	"github.com/nfnt/resize"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/exif"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// A DLNA image media format profile that image items can be resized into.
+type imageProfile struct {
+	name      string
+	mimeType  string
+	maxWidth  int
+	maxHeight int
+}
+
+// Ordered by increasing size within each MIME-type.
+var imageProfiles = []imageProfile{
+	{"JPEG_SM", "image/jpeg", 640, 480},
+	{"JPEG_MED", "image/jpeg", 1024, 768},
+	{"JPEG_LRG", "image/jpeg", 4096, 4096},
+	{"PNG_TN", "image/png", 160, 160},
+}
+
+func imageProfileByName(name string) (imageProfile, bool) {
+	for _, p := range imageProfiles {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return imageProfile{}, false
+}
+
+// Returns the dimensions of the image when resized to fit the profile, never enlarging it.
+func (p imageProfile) fit(width, height int) (int, int) {
+	if width <= p.maxWidth && height <= p.maxHeight {
+		return width, height
+	}
+	if width*p.maxHeight > height*p.maxWidth {
+		return p.maxWidth, maxInt(1, height*p.maxWidth/width)
+	}
+	return maxInt(1, width*p.maxHeight/height), p.maxHeight
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Limits how many images are decoded at once. Large photos take a lot of memory while decoding.
+var imageDecodeSem = make(chan struct{}, 2)
+
+// The dimensions of an image file as displayed, taking its orientation into account.
+type imageGeometry struct {
+	Width, Height int
+	Orientation   exif.Orientation
+}
+
+// The geometry read from an image file.
+type cachedImageGeometry struct {
+	geometry imageGeometry
+	err      error
+}
+
+func (cachedImageGeometry) cacheSize() int64 { return 32 }
+
+// Returns the geometry of an image file. It's cached until the file changes, as it's needed for
+// every image listed, for its resolution, profile and resized variants.
+func (me *Server) imageGeometry(filePath string) (g imageGeometry, err error) {
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return
+	}
+	key := fileCacheKey{Path: filePath, ModTime: fi.ModTime().UnixNano()}
+	if v, ok := me.imageGeometryCache.get(key); ok {
+		return v.geometry, v.err
+	}
+	g, err = readImageGeometry(filePath)
+	me.imageGeometryCache.set(key, cachedImageGeometry{g, err})
+	return
+}
+
+func readImageGeometry(filePath string) (g imageGeometry, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	g.Orientation = readImageOrientation(f)
+	if _, err = f.Seek(0, 0); err != nil {
+		return
+	}
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return
+	}
+	g.Width, g.Height = config.Width, config.Height
+	if g.Orientation.SwapsDimensions() {
+		g.Width, g.Height = g.Height, g.Width
+	}
+	return
+}
+
+// Returns the dimensions of the image as stored, before it's turned upright.
+func (g imageGeometry) stored() (width, height int) {
+	if g.Orientation.SwapsDimensions() {
+		return g.Height, g.Width
+	}
+	return g.Width, g.Height
+}
+
+// Returns the EXIF orientation of the image, or normal if it can't be determined.
+func readImageOrientation(f *os.File) exif.Orientation {
+	info, err := exif.Decode(f)
+	if err != nil {
+		return exif.OrientationNormal
+	}
+	return info.Orientation
+}
+
+// Returns the image profiles worth offering for an image. Profiles the raw file already satisfies
+// are skipped, as are profiles of a MIME-type for which a smaller profile already holds the whole
+// image.
+func imageVariants(g imageGeometry, srcMimeType mimeType) (ret []imageProfile) {
+	complete := make(map[string]bool)
+	for _, p := range imageProfiles {
+		if complete[p.mimeType] {
+			continue
+		}
+		fits := g.Width <= p.maxWidth && g.Height <= p.maxHeight
+		if fits {
+			complete[p.mimeType] = true
+			if p.mimeType == srcMimeType.String() && g.Orientation == exif.OrientationNormal {
+				continue
+			}
+		}
+		ret = append(ret, p)
+	}
+	return
+}
+
+func (me *contentDirectoryService) imageResources(host string, cdsObject object, mimeType mimeType) (ret []upnpav.Resource) {
+	g, err := me.imageGeometry(cdsObject.FilePath())
+	if err != nil {
+		me.Logger.Levelf(log.Debug, "not offering resized images of %q: %v", cdsObject.FilePath(), err)
+		return
+	}
+	for _, p := range imageVariants(g, mimeType) {
+		w, h := p.fit(g.Width, g.Height)
+		ret = append(ret, upnpav.Resource{
+			URL: (&url.URL{
+				Scheme: "http",
+				Host:   host,
+				Path:   imagePath,
+				RawQuery: url.Values{
+					"path": {cdsObject.Path},
+					"pn":   {p.name},
+				}.Encode(),
+			}).String(),
+			ProtocolInfo: fmt.Sprintf("http-get:*:%s:%s", p.mimeType, dlna.ContentFeatures{
+				ProfileName: p.name,
+				Transcoded:  true,
+			}.String()),
+			Resolution: fmt.Sprintf("%dx%d", w, h),
+		})
+	}
+	return
+}
+
+// Decodes the image file, resizes it to fit within the given bounds, and rotates it upright.
+func loadOrientedImage(filePath string, maxWidth, maxHeight int) (image.Image, error) {
+	imageDecodeSem <- struct{}{}
+	defer func() { <-imageDecodeSem }()
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	orientation := readImageOrientation(f)
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	// Resize before reorienting, as it's much cheaper on the smaller image.
+	if orientation.SwapsDimensions() {
+		maxWidth, maxHeight = maxHeight, maxWidth
+	}
+	img = resize.Thumbnail(uint(maxWidth), uint(maxHeight), img, resize.Lanczos3)
+	return orientImage(img, orientation), nil
+}
+
+// Transforms the image so that it displays upright given its EXIF orientation.
+func orientImage(src image.Image, o exif.Orientation) image.Image {
+	if o <= exif.OrientationNormal || o > exif.OrientationRotate270 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o.SwapsDimensions() {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch o {
+			case exif.OrientationFlipHorizontal:
+				dx, dy = w-1-x, y
+			case exif.OrientationRotate180:
+				dx, dy = w-1-x, h-1-y
+			case exif.OrientationFlipVertical:
+				dx, dy = x, h-1-y
+			case exif.OrientationTranspose:
+				dx, dy = y, x
+			case exif.OrientationRotate90:
+				dx, dy = h-1-y, x
+			case exif.OrientationTransverse:
+				dx, dy = h-1-y, w-1-x
+			case exif.OrientationRotate270:
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+func encodeImage(img image.Image, mimeType string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch mimeType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	default:
+		err = fmt.Errorf("unsupported image type %q", mimeType)
+	}
+	return buf.Bytes(), err
+}
+
+// Serves an image item resized into one of the DLNA image profiles.
+func (me *Server) serveImage(w http.ResponseWriter, r *http.Request) {
+	filePath := me.filePath(r.URL.Query().Get("path"))
+	if ignored, err := me.IgnorePath(filePath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if ignored {
+		http.Error(w, "no such object", http.StatusNotFound)
+		return
+	}
+	p, ok := imageProfileByName(r.URL.Query().Get("pn"))
+	if !ok {
+		http.Error(w, "bad image profile", http.StatusBadRequest)
+		return
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	body, err := me.cache.getOrCreate(filePath, fi, p.name, func() ([]byte, error) {
+		img, err := loadOrientedImage(filePath, p.maxWidth, p.maxHeight)
+		if err != nil {
+			return nil, err
+		}
+		return encodeImage(img, p.mimeType)
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", p.mimeType)
+	w.Header().Set(dlna.ContentFeaturesDomain, dlna.ContentFeatures{
+		ProfileName: p.name,
+		Transcoded:  true,
+	}.String())
+	http.ServeContent(w, r, "", fi.ModTime(), bytes.NewReader(body))
+}
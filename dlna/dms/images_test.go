@@ -0,0 +1,110 @@
+package dms
+
+import (
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/dms/exif"
+)
+
+func TestImageProfileFit(t *testing.T) {
+	p, _ := imageProfileByName("JPEG_SM")
+	for _, tc := range []struct {
+		width, height, fitWidth, fitHeight int
+	}{
+		{320, 240, 320, 240},
+		{3000, 2000, 640, 426},
+		{2000, 3000, 320, 480},
+		{10000, 1, 640, 1},
+	} {
+		if w, h := p.fit(tc.width, tc.height); w != tc.fitWidth || h != tc.fitHeight {
+			t.Errorf("%dx%d fit to %dx%d, expected %dx%d", tc.width, tc.height, w, h, tc.fitWidth, tc.fitHeight)
+		}
+	}
+}
+
+func imageProfileNames(ps []imageProfile) (ret []string) {
+	for _, p := range ps {
+		ret = append(ret, p.name)
+	}
+	return
+}
+
+func TestImageVariants(t *testing.T) {
+	jpegType := mimeType("image/jpeg")
+	for _, tc := range []struct {
+		g        imageGeometry
+		mimeType mimeType
+		expected []string
+	}{
+		// JPEG_LRG holds the whole image, which the file already is.
+		{imageGeometry{3000, 2000, exif.OrientationNormal}, jpegType, []string{"JPEG_SM", "JPEG_MED", "PNG_TN"}},
+		{imageGeometry{5000, 4000, exif.OrientationNormal}, jpegType, []string{"JPEG_SM", "JPEG_MED", "JPEG_LRG", "PNG_TN"}},
+		{imageGeometry{800, 600, exif.OrientationNormal}, jpegType, []string{"JPEG_SM", "PNG_TN"}},
+		// Rotated files are offered upright, even at their own size.
+		{imageGeometry{600, 700, exif.OrientationRotate90}, jpegType, []string{"JPEG_SM", "JPEG_MED", "PNG_TN"}},
+		{imageGeometry{120, 100, exif.OrientationNormal}, mimeType("image/png"), []string{"JPEG_SM"}},
+	} {
+		actual := imageProfileNames(imageVariants(tc.g, tc.mimeType))
+		if len(actual) != len(tc.expected) {
+			t.Errorf("%v: got %q, expected %q", tc.g, actual, tc.expected)
+			continue
+		}
+		for i := range actual {
+			if actual[i] != tc.expected[i] {
+				t.Errorf("%v: got %q, expected %q", tc.g, actual, tc.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestServeImage(t *testing.T) {
+	dir := t.TempDir()
+	f, err := os.Create(filepath.Join(dir, "photo.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, 800, 600))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	srv := &Server{RootObjectPath: dir}
+	g, err := srv.imageGeometry(f.Name())
+	if err != nil || g.Width != 800 || g.Height != 600 {
+		t.Fatalf("geometry %v, %v", g, err)
+	}
+	for _, tc := range []struct {
+		profile, contentType string
+		width, height        int
+	}{
+		{"JPEG_SM", "image/jpeg", 640, 480},
+		{"JPEG_MED", "image/jpeg", 800, 600},
+		{"PNG_TN", "image/png", 160, 120},
+	} {
+		w := httptest.NewRecorder()
+		srv.serveImage(w, httptest.NewRequest("GET", imagePath+"?"+url.Values{
+			"path": {"/photo.png"},
+			"pn":   {tc.profile},
+		}.Encode(), nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", tc.profile, w.Code, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("%s: Content-Type %q", tc.profile, ct)
+		}
+		config, _, err := image.DecodeConfig(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.profile, err)
+		}
+		if config.Width != tc.width || config.Height != tc.height {
+			t.Errorf("%s: %dx%d, expected %dx%d", tc.profile, config.Width, config.Height, tc.width, tc.height)
+		}
+	}
+}
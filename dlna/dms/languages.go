@@ -0,0 +1,78 @@
+package dms
+
+import (
+	"strings"
+)
+
+// ISO 639-2 codes, both bibliographic and terminologic, of common languages keyed by their ISO
+// 639-1 code. Files and streams are tagged with any of them.
+var iso639Alternatives = map[string][]string{
+	"ar": {"ara"},
+	"bg": {"bul"},
+	"cs": {"cze", "ces"},
+	"da": {"dan"},
+	"de": {"ger", "deu"},
+	"el": {"gre", "ell"},
+	"en": {"eng"},
+	"es": {"spa"},
+	"et": {"est"},
+	"fa": {"per", "fas"},
+	"fi": {"fin"},
+	"fr": {"fre", "fra"},
+	"he": {"heb"},
+	"hi": {"hin"},
+	"hr": {"hrv"},
+	"hu": {"hun"},
+	"id": {"ind"},
+	"is": {"ice", "isl"},
+	"it": {"ita"},
+	"ja": {"jpn"},
+	"ko": {"kor"},
+	"lt": {"lit"},
+	"lv": {"lav"},
+	"nl": {"dut", "nld"},
+	"no": {"nor", "nob", "nno"},
+	"pl": {"pol"},
+	"pt": {"por"},
+	"ro": {"rum", "ron"},
+	"ru": {"rus"},
+	"sk": {"slo", "slk"},
+	"sl": {"slv"},
+	"sr": {"srp"},
+	"sv": {"swe"},
+	"th": {"tha"},
+	"tr": {"tur"},
+	"uk": {"ukr"},
+	"vi": {"vie"},
+	"zh": {"chi", "zho"},
+}
+
+// Maps each known language code to its ISO 639-1 code.
+var canonicalLanguages = func() map[string]string {
+	ret := make(map[string]string)
+	for two, threes := range iso639Alternatives {
+		ret[two] = two
+		for _, three := range threes {
+			ret[three] = two
+		}
+	}
+	return ret
+}()
+
+// Returns the ISO 639-1 code for a language code, ignoring case and any region, so "ger", "deu"
+// and "de-AT" are all "de". Unknown codes are returned lowercased.
+func canonicalLanguage(code string) string {
+	code = strings.ToLower(code)
+	if i := strings.IndexAny(code, "-_"); i >= 0 {
+		code = code[:i]
+	}
+	if c, ok := canonicalLanguages[code]; ok {
+		return c
+	}
+	return code
+}
+
+// Returns true if the language codes refer to the same language.
+func languagesMatch(a, b string) bool {
+	return a != "" && b != "" && canonicalLanguage(a) == canonicalLanguage(b)
+}
@@ -0,0 +1,444 @@
+package dms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/log"
+)
+
+// How many units of a live stream a reader may fall behind before it's dropped, so a stalled
+// client doesn't hold the others up.
+const liveReaderBacklog = 1024
+
+var errLiveReaderTooSlow = errors.New("reader fell too far behind the live stream")
+
+// The running live streams, by resource.
+type liveStreams struct {
+	// How long producers keep running after the last reader leaves.
+	grace time.Duration
+	// How much of each stream is kept for readers to start in the past.
+	timeShift time.Duration
+	// The most bytes of each stream kept for the time-shift. Unbounded if zero.
+	timeShiftMaxSize int64
+	logger           log.Logger
+
+	mu      sync.Mutex
+	streams map[string]*liveStream
+}
+
+// Returns the key of the live stream of the resource of a dynamic stream.
+func liveStreamKey(metadataPath string, index int) string {
+	return fmt.Sprintf("%s#%d", metadataPath, index)
+}
+
+// A live stream's producer and the readers following it.
+type liveStream struct {
+	key    string
+	owner  *liveStreams
+	ctx    context.Context
+	cancel context.CancelFunc
+	logger log.Logger
+	// Closed once the producer is opened, or failed to open with openErr.
+	opened  chan struct{}
+	openErr error
+
+	mu      sync.Mutex
+	split   liveSplitter
+	readers map[*liveReader]struct{}
+	// Retires the stream once the grace period after the last reader left is over.
+	idle *time.Timer
+	done bool
+	// What was broadcast over the time-shift period, starting at a unit readers can join at.
+	history []liveHistoryUnit
+	// The bytes of data in history.
+	historySize int64
+}
+
+type liveHistoryUnit struct {
+	liveUnit
+	at time.Time
+}
+
+// Returns a reader of the live stream with the key, opening its producer if it isn't running. The
+// reader starts at the position in the time-shift buffer, or live if it's negative. It ends when
+// the context is done or the producer stops. The producer is stopped once it's been without
+// readers for the grace period.
+func (me *liveStreams) join(
+	ctx context.Context, key, mimeType string, start time.Duration,
+	open func(ctx context.Context) (io.ReadCloser, error),
+) (io.ReadCloser, error) {
+	me.mu.Lock()
+	s, ok := me.streams[key]
+	if !ok {
+		s = &liveStream{
+			key:     key,
+			owner:   me,
+			logger:  me.logger,
+			opened:  make(chan struct{}),
+			split:   newLiveSplitter(mimeType),
+			readers: make(map[*liveReader]struct{}),
+		}
+		s.ctx, s.cancel = context.WithCancel(context.Background())
+		if me.streams == nil {
+			me.streams = make(map[string]*liveStream)
+		}
+		me.streams[key] = s
+		go me.produce(s, open)
+	}
+	r := s.add(ctx, start)
+	me.mu.Unlock()
+	select {
+	case <-s.opened:
+	case <-ctx.Done():
+		r.Close()
+		return nil, ctx.Err()
+	}
+	if s.openErr != nil {
+		r.Close()
+		return nil, s.openErr
+	}
+	return r, nil
+}
+
+// Runs the producer, handing what it outputs to the readers until it ends or is retired.
+func (me *liveStreams) produce(s *liveStream, open func(ctx context.Context) (io.ReadCloser, error)) {
+	s.logger.Printf("starting live stream %q", s.key)
+	p, err := open(s.ctx)
+	s.openErr = err
+	close(s.opened)
+	if err != nil {
+		me.end(s, err)
+		return
+	}
+	defer p.Close()
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := p.Read(buf)
+		if n > 0 {
+			s.broadcast(buf[:n])
+		}
+		if err != nil {
+			me.end(s, err)
+			return
+		}
+	}
+}
+
+// Forgets the stream once the producer stops, ending its readers.
+func (me *liveStreams) end(s *liveStream, err error) {
+	me.mu.Lock()
+	me.forget(s)
+	me.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() == nil && err != io.EOF {
+		s.logger.Printf("live stream %q failed: %v", s.key, err)
+	} else {
+		s.logger.Printf("live stream %q stopped", s.key)
+	}
+	if err == nil || s.ctx.Err() != nil {
+		err = io.EOF
+	}
+	s.done = true
+	for r := range s.readers {
+		r.finish(err)
+		delete(s.readers, r)
+	}
+	s.cancel()
+}
+
+// Stops the producer if nobody joined during the grace period. New readers can't join a stream
+// once it's retired, they start another.
+func (me *liveStreams) retire(s *liveStream) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.readers) != 0 || s.done {
+		return
+	}
+	me.forget(s)
+	s.cancel()
+}
+
+func (me *liveStreams) forget(s *liveStream) {
+	if me.streams[s.key] == s {
+		delete(me.streams, s.key)
+	}
+}
+
+// Returns how far back the time-shift buffer of the live stream with the key goes.
+func (me *liveStreams) buffered(key string) time.Duration {
+	me.mu.Lock()
+	s, ok := me.streams[key]
+	me.mu.Unlock()
+	if !ok {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.history) == 0 {
+		return 0
+	}
+	return time.Since(s.history[0].at)
+}
+
+func (s *liveStream) add(ctx context.Context, start time.Duration) *liveReader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &liveReader{
+		stream: s,
+		ctx:    ctx,
+		ch:     make(chan []byte, liveReaderBacklog),
+		ended:  make(chan struct{}),
+	}
+	if s.done {
+		r.finish(io.EOF)
+		return r
+	}
+	if s.idle != nil {
+		s.idle.Stop()
+		s.idle = nil
+	}
+	s.readers[r] = struct{}{}
+	if start >= 0 && len(s.history) != 0 {
+		at := s.history[0].at.Add(start)
+		from := 0
+		for i, h := range s.history {
+			if h.at.After(at) {
+				break
+			}
+			if h.join {
+				from = i
+			}
+		}
+		if p := s.split.prefix(); len(p) != 0 {
+			r.replay = append(r.replay, p)
+		}
+		for _, h := range s.history[from:] {
+			r.replay = append(r.replay, h.data)
+		}
+		r.joined = true
+	}
+	return r
+}
+
+func (s *liveStream) remove(r *liveReader) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(r)
+}
+
+func (s *liveStream) removeLocked(r *liveReader) {
+	if _, ok := s.readers[r]; !ok {
+		return
+	}
+	delete(s.readers, r)
+	if len(s.readers) == 0 && !s.done && s.idle == nil {
+		s.idle = time.AfterFunc(s.owner.grace, func() { s.owner.retire(s) })
+	}
+}
+
+// Hands data from the producer to the readers, starting readers that haven't joined yet at the
+// next unit they can join at.
+func (s *liveStream) broadcast(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	units := s.split.split(b)
+	if len(units) == 0 {
+		return
+	}
+	if s.owner.timeShift > 0 {
+		s.record(units, time.Now())
+	}
+	for r := range s.readers {
+		for _, u := range units {
+			if !r.joined {
+				if !u.join {
+					continue
+				}
+				if p := s.split.prefix(); len(p) != 0 && !r.send(p) {
+					break
+				}
+				r.joined = true
+			}
+			if !r.send(u.data) {
+				break
+			}
+		}
+		if r.isFinished() {
+			s.removeLocked(r)
+		}
+	}
+}
+
+// Adds the units to the time-shift buffer, dropping what's gone out of it. The buffer starts at
+// the last unit readers can join at from before the period, or later if that's needed to keep it
+// within the size limit.
+func (s *liveStream) record(units []liveUnit, now time.Time) {
+	for _, u := range units {
+		if len(s.history) == 0 && !u.join {
+			continue
+		}
+		s.history = append(s.history, liveHistoryUnit{u, now})
+		s.historySize += int64(len(u.data))
+	}
+	cutoff := now.Add(-s.owner.timeShift)
+	from := 0
+	for i, h := range s.history {
+		if h.at.After(cutoff) {
+			break
+		}
+		if h.join {
+			from = i
+		}
+	}
+	s.dropHistory(from)
+	maxSize := s.owner.timeShiftMaxSize
+	if maxSize <= 0 || s.historySize <= maxSize {
+		return
+	}
+	// Start at the first unit readers can join at that fits, or at the next one recorded if none
+	// does.
+	from = len(s.history)
+	size := s.historySize
+	for i, h := range s.history {
+		if h.join && size <= maxSize {
+			from = i
+			break
+		}
+		size -= int64(len(h.data))
+	}
+	s.dropHistory(from)
+}
+
+// Drops the first n units of the time-shift buffer.
+func (s *liveStream) dropHistory(n int) {
+	for _, h := range s.history[:n] {
+		s.historySize -= int64(len(h.data))
+	}
+	s.history = s.history[n:]
+}
+
+// A client's view of a live stream.
+type liveReader struct {
+	stream *liveStream
+	ctx    context.Context
+	ch     chan []byte
+	// Closed when no more data is sent, after err is set.
+	ended   chan struct{}
+	err     error
+	endOnce sync.Once
+	// Whether the reader has started getting data. Guarded by the stream.
+	joined bool
+	// Data from the time-shift buffer, read before what's sent.
+	replay [][]byte
+	// What's left of the data last received.
+	buf []byte
+}
+
+// Queues data for the reader, dropping it if it's fallen too far behind.
+func (r *liveReader) send(b []byte) bool {
+	if r.isFinished() {
+		return false
+	}
+	select {
+	case r.ch <- b:
+		return true
+	default:
+		r.stream.logger.Printf("dropping live stream %q reader: %v", r.stream.key, errLiveReaderTooSlow)
+		r.finish(errLiveReaderTooSlow)
+		return false
+	}
+}
+
+func (r *liveReader) finish(err error) {
+	r.endOnce.Do(func() {
+		r.err = err
+		close(r.ended)
+	})
+}
+
+func (r *liveReader) isFinished() bool {
+	select {
+	case <-r.ended:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *liveReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if len(r.replay) != 0 {
+			r.buf, r.replay = r.replay[0], r.replay[1:]
+			continue
+		}
+		select {
+		case r.buf = <-r.ch:
+		case <-r.ended:
+			// Data sent before the end is still read.
+			select {
+			case r.buf = <-r.ch:
+			default:
+				return 0, r.err
+			}
+		case <-r.ctx.Done():
+			return 0, r.ctx.Err()
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *liveReader) Close() error {
+	r.finish(io.ErrClosedPipe)
+	r.stream.remove(r)
+	return nil
+}
+
+// Closes the log of a live stream's producer along with it.
+type loggedStream struct {
+	io.ReadCloser
+	log io.Closer
+}
+
+func (me *loggedStream) Close() error {
+	err := me.ReadCloser.Close()
+	me.log.Close()
+	return err
+}
+
+// Handles the range of a request for a live stream with a time-shift buffer going back buffered.
+// Time seeks are positions in the buffer, and a client asking for the seekable range is told
+// it's the buffer.
+func handleTimeShiftRange(w http.ResponseWriter, hs http.Header, buffered time.Duration) (r dlna.NPTRange, partialResponse, ok bool) {
+	if hs.Get("getAvailableSeekRange.dlna.org") == "1" {
+		w.Header().Set("availableSeekRange.dlna.org", fmt.Sprintf("0 npt=0-%s", dlna.FormatNPTTime(buffered)))
+	}
+	h := hs.Get(dlna.TimeSeekRangeDomain)
+	if h == "" {
+		ok = true
+		return
+	}
+	r, err := parseDLNARangeHeader(h)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Start > buffered {
+		http.Error(w, "seek beyond the time-shift buffer", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	w.Header().Set(dlna.TimeSeekRangeDomain,
+		fmt.Sprintf("npt=%s-%s/*", dlna.FormatNPTTime(r.Start), dlna.FormatNPTTime(buffered)))
+	return r, true, true
+}
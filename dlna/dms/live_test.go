@@ -0,0 +1,288 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/log"
+)
+
+func TestLiveStreamsShareProducer(t *testing.T) {
+	ls := liveStreams{grace: 10 * time.Millisecond, logger: log.Default}
+	var opens int32
+	var pw *io.PipeWriter
+	stopped := make(chan struct{})
+	open := func(ctx context.Context) (io.ReadCloser, error) {
+		n := atomic.AddInt32(&opens, 1)
+		var pr *io.PipeReader
+		pr, pw = io.Pipe()
+		go func(pw *io.PipeWriter) {
+			<-ctx.Done()
+			pw.CloseWithError(ctx.Err())
+			if n == 1 {
+				close(stopped)
+			}
+		}(pw)
+		return pr, nil
+	}
+	join := func() io.ReadCloser {
+		r, err := ls.join(context.Background(), "radio", "audio/mpeg", -1, open)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+	r1 := join()
+	r2 := join()
+	if opens := atomic.LoadInt32(&opens); opens != 1 {
+		t.Fatalf("opened %d producers", opens)
+	}
+	if _, err := pw.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []io.ReadCloser{r1, r2} {
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(r, b); err != nil || string(b) != "abc" {
+			t.Fatalf("read %q, %v", b, err)
+		}
+	}
+	r1.Close()
+	r2.Close()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("producer still running after the grace period")
+	}
+	r3 := join()
+	defer r3.Close()
+	if opens := atomic.LoadInt32(&opens); opens != 2 {
+		t.Fatalf("opened %d producers", opens)
+	}
+}
+
+func TestLiveStreamOpenError(t *testing.T) {
+	ls := liveStreams{logger: log.Default}
+	_, err := ls.join(context.Background(), "cam", "video/mp2t", -1, func(context.Context) (io.ReadCloser, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("got %v", err)
+	}
+}
+
+func TestLiveStreamTimeShift(t *testing.T) {
+	ls := liveStreams{timeShift: time.Hour, logger: log.Default}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	open := func(context.Context) (io.ReadCloser, error) { return pr, nil }
+	live, err := ls.join(context.Background(), "radio", "audio/mpeg", -1, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+	for _, s := range []string{"abc", "def"} {
+		pw.Write([]byte(s))
+		b := make([]byte, 3)
+		if _, err := io.ReadFull(live, b); err != nil || string(b) != s {
+			t.Fatalf("read %q, %v", b, err)
+		}
+	}
+	if ls.buffered("radio") <= 0 {
+		t.Fatal("nothing buffered")
+	}
+	shifted, err := ls.join(context.Background(), "radio", "audio/mpeg", 0, open)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shifted.Close()
+	b := make([]byte, 6)
+	if _, err := io.ReadFull(shifted, b); err != nil || string(b) != "abcdef" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+}
+
+func TestLiveStreamTimeShiftMaxSize(t *testing.T) {
+	s := &liveStream{owner: &liveStreams{timeShift: time.Hour, timeShiftMaxSize: 10}}
+	now := time.Now()
+	history := func() (ret string) {
+		for _, h := range s.history {
+			if h.join {
+				ret += "|"
+			}
+			ret += string(h.data)
+		}
+		return
+	}
+	for _, c := range []struct {
+		units    []liveUnit
+		expected string
+	}{
+		{[]liveUnit{{[]byte("a"), false}, {[]byte("bcd"), true}, {[]byte("ef"), false}}, "|bcdef"},
+		{[]liveUnit{{[]byte("ghi"), true}, {[]byte("jk"), false}}, "|bcdef|ghijk"},
+		// Over the limit, the buffer starts at the first join that fits.
+		{[]liveUnit{{[]byte("l"), false}}, "|ghijkl"},
+		// No join fits, so the buffer starts again at the next one.
+		{[]liveUnit{{[]byte("mnopqrs"), false}}, ""},
+		{[]liveUnit{{[]byte("t"), false}, {[]byte("uv"), true}}, "|uv"},
+	} {
+		s.record(c.units, now)
+		if h := history(); h != c.expected {
+			t.Fatalf("got %q, expected %q", h, c.expected)
+		}
+		size := 0
+		for _, h := range s.history {
+			size += len(h.data)
+		}
+		if int64(size) != s.historySize || s.historySize > 10 {
+			t.Fatalf("history size %d, recorded %d", size, s.historySize)
+		}
+	}
+}
+
+func tsPacket(pid uint16, unitStart, randomAccess bool, payload ...byte) []byte {
+	pkt := []byte{tsSyncByte, byte(pid >> 8), byte(pid), 0x10}
+	if unitStart {
+		pkt[1] |= 0x40
+	}
+	if randomAccess {
+		pkt[3] |= 0x20
+		pkt = append(pkt, 1, 0x40)
+	}
+	pkt = append(pkt, payload...)
+	for len(pkt) < tsPacketSize {
+		pkt = append(pkt, 0xff)
+	}
+	return pkt
+}
+
+func TestTSSplitter(t *testing.T) {
+	pat := tsPacket(0, true, false, 0, 0x00, 0xb0, 13, 0, 1, 0xc1, 0, 0, 0, 1, 0xe1, 0x00, 0, 0, 0, 0)
+	pmt := tsPacket(0x100, true, false, 0, 0x02, 0xb0, 23, 0, 1, 0xc1, 0, 0, 0xe1, 0x01, 0xf0, 0,
+		0x1b, 0xe1, 0x01, 0xf0, 0,
+		0x0f, 0xe1, 0x02, 0xf0, 0,
+		0, 0, 0, 0)
+	video := tsPacket(0x101, true, false)
+	audioKey := tsPacket(0x102, true, true)
+	videoKey := tsPacket(0x101, true, true)
+	var stream []byte
+	for _, p := range [][]byte{pat, pmt, video, audioKey, videoKey, video} {
+		stream = append(stream, p...)
+	}
+	var s tsSplitter
+	var units []liveUnit
+	// Cut packets across calls.
+	units = append(units, s.split(stream[:500])...)
+	units = append(units, s.split(stream[500:])...)
+	var joins [][]byte
+	for _, u := range units {
+		if u.join {
+			joins = append(joins, u.data)
+		}
+	}
+	if len(joins) != 1 || !bytes.HasPrefix(joins[0], videoKey) {
+		t.Fatalf("joins at %d units", len(joins))
+	}
+	if !bytes.Equal(s.prefix(), append(append([]byte(nil), pat...), pmt...)) {
+		t.Fatal("prefix isn't the PAT and PMT")
+	}
+}
+
+func TestMatroskaSplitter(t *testing.T) {
+	header := []byte("\x1a\x45\xdf\xa3header")
+	cluster1 := append(append([]byte(nil), matroskaClusterID...), "one"...)
+	cluster2 := append(append([]byte(nil), matroskaClusterID...), "two"...)
+	stream := append(append(append([]byte(nil), header...), cluster1...), cluster2...)
+	var s matroskaSplitter
+	var units []liveUnit
+	// Cut the second cluster ID across calls.
+	cut := len(header) + len(cluster1) + 2
+	units = append(units, s.split(stream[:cut])...)
+	units = append(units, s.split(stream[cut:])...)
+	units = append(units, s.split(nil)...)
+	if !bytes.Equal(s.prefix(), header) {
+		t.Fatalf("prefix %q", s.prefix())
+	}
+	var joins int
+	var all []byte
+	for _, u := range units {
+		if u.join {
+			if !bytes.HasPrefix(u.data, matroskaClusterID) {
+				t.Fatalf("join at %q", u.data)
+			}
+			joins++
+		}
+		all = append(all, u.data...)
+	}
+	if joins != 2 {
+		t.Fatalf("%d joins", joins)
+	}
+	if !bytes.Equal(all, append(append([]byte(nil), cluster1...), cluster2[:len(cluster2)-3]...)) {
+		t.Fatalf("units %q", all)
+	}
+}
+
+func mp4Box(typ string, body string) []byte {
+	b := []byte{0, 0, 0, byte(8 + len(body))}
+	return append(append(b, typ...), body...)
+}
+
+func TestMP4Splitter(t *testing.T) {
+	header := append(mp4Box("ftyp", "isom"), mp4Box("moov", "tracks")...)
+	frag := append(mp4Box("moof", "one"), mp4Box("mdat", "data")...)
+	stream := append(append(append([]byte(nil), header...), frag...), frag...)
+	var s mp4Splitter
+	var units []liveUnit
+	for i := 0; i < len(stream); i += 5 {
+		end := i + 5
+		if end > len(stream) {
+			end = len(stream)
+		}
+		units = append(units, s.split(stream[i:end])...)
+	}
+	if !bytes.Equal(s.prefix(), header) {
+		t.Fatalf("prefix %q", s.prefix())
+	}
+	var all []byte
+	joins := 0
+	for _, u := range units {
+		if u.join {
+			if !bytes.Equal(u.data[4:8], []byte("moof")) {
+				t.Fatalf("join at %q", u.data)
+			}
+			joins++
+		}
+		all = append(all, u.data...)
+	}
+	if joins != 2 || !bytes.Equal(all, stream[len(header):]) {
+		t.Fatalf("%d joins, units %q", joins, all)
+	}
+}
+
+func TestHandleTimeShiftRange(t *testing.T) {
+	w := httptest.NewRecorder()
+	hs := http.Header{}
+	hs.Set("getAvailableSeekRange.dlna.org", "1")
+	hs.Set(dlna.TimeSeekRangeDomain, "npt=00:00:30.000-")
+	r, partial, ok := handleTimeShiftRange(w, hs, time.Minute)
+	if !ok || !partial || r.Start != 30*time.Second {
+		t.Fatalf("got %v, %v, %v", r, partial, ok)
+	}
+	if h := w.Header().Get(dlna.TimeSeekRangeDomain); h != "npt=00:00:30.000-00:01:00.000/*" {
+		t.Fatalf("TimeSeekRange %q", h)
+	}
+	if h := w.Header().Get("availableSeekRange.dlna.org"); h != "0 npt=0-00:01:00.000" {
+		t.Fatalf("availableSeekRange %q", h)
+	}
+	w = httptest.NewRecorder()
+	hs.Set(dlna.TimeSeekRangeDomain, "npt=00:02:00.000-")
+	if _, _, ok := handleTimeShiftRange(w, hs, time.Minute); ok || w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("seek beyond the buffer: %v, %d", ok, w.Code)
+	}
+}
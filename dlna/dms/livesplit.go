@@ -0,0 +1,327 @@
+package dms
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+)
+
+// Finds where readers can join a live stream, so they start with something they can play.
+type liveSplitter interface {
+	// Takes the next data from the producer, returning it as units. Data that can't be placed yet
+	// is held over to the next call. Units aren't modified afterwards.
+	split(b []byte) []liveUnit
+	// What readers need before the unit they join at, like the container's header.
+	prefix() []byte
+}
+
+// A run of a live stream. Readers can start at its beginning if join is set.
+type liveUnit struct {
+	data []byte
+	join bool
+}
+
+// Returns the splitter for a stream of the MIME-type. Streams that can't be split by their
+// structure are joined at any chunk, which suits formats decoders can sync to, like MP3 and ADTS.
+func newLiveSplitter(mimeType string) liveSplitter {
+	switch canonicalMimeType(mimeType) {
+	case "video/mp2t", "video/mpeg":
+		return &tsSplitter{}
+	case "video/x-matroska", "video/webm", "audio/webm":
+		return &matroskaSplitter{}
+	case "video/mp4", "audio/mp4":
+		return &mp4Splitter{}
+	}
+	return chunkSplitter{}
+}
+
+type chunkSplitter struct{}
+
+func (chunkSplitter) split(b []byte) []liveUnit {
+	return []liveUnit{{append([]byte(nil), b...), true}}
+}
+
+func (chunkSplitter) prefix() []byte { return nil }
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// How many PES packets of a transport stream may start without the random access indicator set
+// before it's taken to never set it.
+const tsMaxUnitsWithoutRAI = 250
+
+// Splits MPEG transport streams at keyframes: packets with the random access indicator set, of a
+// video stream if there is one. Streams that don't set it are split at the start of PES packets.
+// Readers get the latest PAT and PMT first.
+type tsSplitter struct {
+	// The data of an incomplete packet.
+	partial []byte
+	pat     []byte
+	pmts    map[uint16][]byte
+	// The elementary streams by PID, and whether they're video.
+	streams map[uint16]bool
+	hasRAI  bool
+	// PES packets started before the random access indicator was seen.
+	unitsWithoutRAI int
+	// Not a transport stream after all, perhaps an MPEG program stream.
+	notTS   bool
+	started bool
+	pre     []byte
+}
+
+func (me *tsSplitter) split(b []byte) (units []liveUnit) {
+	if !me.started && len(b) != 0 {
+		me.started = true
+		me.notTS = b[0] != tsSyncByte
+	}
+	if me.notTS {
+		return chunkSplitter{}.split(b)
+	}
+	data := make([]byte, 0, len(me.partial)+len(b))
+	data = append(append(data, me.partial...), b...)
+	for len(data) >= tsPacketSize {
+		if data[0] != tsSyncByte {
+			i := bytes.IndexByte(data, tsSyncByte)
+			if i < 0 {
+				data = nil
+				break
+			}
+			data = data[i:]
+			continue
+		}
+		pkt := data[:tsPacketSize]
+		data = data[tsPacketSize:]
+		join := me.packet(pkt)
+		if join || len(units) == 0 {
+			units = append(units, liveUnit{join: join})
+		}
+		u := &units[len(units)-1]
+		u.data = append(u.data, pkt...)
+	}
+	me.partial = append(me.partial[:0:0], data...)
+	return
+}
+
+// Takes note of the tables in the packet, returning whether a reader can start at it.
+func (me *tsSplitter) packet(pkt []byte) bool {
+	pid := binary.BigEndian.Uint16(pkt[1:3]) & 0x1fff
+	unitStart := pkt[1]&0x40 != 0
+	adaptation := pkt[3]&0x20 != 0
+	var payload []byte
+	randomAccess := false
+	if adaptation {
+		l := int(pkt[4])
+		if 5+l > len(pkt) {
+			return false
+		}
+		randomAccess = l > 0 && pkt[5]&0x40 != 0
+		if pkt[3]&0x10 != 0 {
+			payload = pkt[5+l:]
+		}
+	} else if pkt[3]&0x10 != 0 {
+		payload = pkt[4:]
+	}
+	if pid == 0 && unitStart {
+		me.pat = append([]byte(nil), pkt...)
+		me.parsePAT(payload)
+		me.pre = nil
+		return false
+	}
+	if _, ok := me.pmts[pid]; ok && unitStart {
+		me.pmts[pid] = append([]byte(nil), pkt...)
+		me.parsePMT(payload)
+		me.pre = nil
+		return false
+	}
+	video, ok := me.streams[pid]
+	if !ok || me.pat == nil {
+		return false
+	}
+	for _, v := range me.streams {
+		if v && !video {
+			return false
+		}
+	}
+	if randomAccess {
+		me.hasRAI = true
+	}
+	if me.hasRAI {
+		return randomAccess
+	}
+	if unitStart {
+		me.unitsWithoutRAI++
+	}
+	return unitStart && me.unitsWithoutRAI > tsMaxUnitsWithoutRAI
+}
+
+// Returns the section of PSI in the payload, without its CRC.
+func psiSection(payload []byte) []byte {
+	if len(payload) == 0 || 1+int(payload[0]) > len(payload) {
+		return nil
+	}
+	t := payload[1+int(payload[0]):]
+	if len(t) < 3 {
+		return nil
+	}
+	end := 3 + int(binary.BigEndian.Uint16(t[1:3])&0xfff) - 4
+	if end > len(t) || end < 8 {
+		return nil
+	}
+	return t[:end]
+}
+
+func (me *tsSplitter) parsePAT(payload []byte) {
+	t := psiSection(payload)
+	if t == nil {
+		return
+	}
+	pmts := make(map[uint16][]byte)
+	for i := 8; i+4 <= len(t); i += 4 {
+		if program := binary.BigEndian.Uint16(t[i:]); program != 0 {
+			pid := binary.BigEndian.Uint16(t[i+2:]) & 0x1fff
+			pmts[pid] = me.pmts[pid]
+		}
+	}
+	me.pmts = pmts
+}
+
+// Stream types of video streams: MPEG-1 and 2, AVC, HEVC, CAVS and VC-1.
+var tsVideoStreamTypes = map[byte]bool{0x01: true, 0x02: true, 0x10: true, 0x1b: true, 0x24: true, 0x42: true, 0xea: true}
+
+func (me *tsSplitter) parsePMT(payload []byte) {
+	t := psiSection(payload)
+	if len(t) < 12 {
+		return
+	}
+	if me.streams == nil {
+		me.streams = make(map[uint16]bool)
+	}
+	for i := 12 + int(binary.BigEndian.Uint16(t[10:12])&0xfff); i+5 <= len(t); {
+		pid := binary.BigEndian.Uint16(t[i+1:]) & 0x1fff
+		me.streams[pid] = tsVideoStreamTypes[t[i]]
+		i += 5 + int(binary.BigEndian.Uint16(t[i+3:])&0xfff)
+	}
+}
+
+func (me *tsSplitter) prefix() []byte {
+	if me.pre == nil && me.pat != nil {
+		pids := make([]int, 0, len(me.pmts))
+		for pid := range me.pmts {
+			pids = append(pids, int(pid))
+		}
+		sort.Ints(pids)
+		pre := append([]byte(nil), me.pat...)
+		for _, pid := range pids {
+			pre = append(pre, me.pmts[uint16(pid)]...)
+		}
+		me.pre = pre
+	}
+	return me.pre
+}
+
+// The ID of Matroska Cluster elements. Clusters of live streams muxed by ffmpeg start at
+// keyframes.
+var matroskaClusterID = []byte{0x1f, 0x43, 0xb6, 0x75}
+
+// Splits Matroska and WebM streams at clusters. Readers get everything before the first cluster,
+// with the EBML header and the tracks, first.
+type matroskaSplitter struct {
+	header     []byte
+	inClusters bool
+	// The end of the last data, which may be the start of a cluster ID.
+	held []byte
+}
+
+func (me *matroskaSplitter) split(b []byte) (units []liveUnit) {
+	data := make([]byte, 0, len(me.held)+len(b))
+	data = append(append(data, me.held...), b...)
+	end := len(data) - (len(matroskaClusterID) - 1)
+	if end < 0 {
+		end = 0
+	}
+	me.held = append(me.held[:0:0], data[end:]...)
+	start := 0
+	for start < end {
+		next := end
+		if i := bytes.Index(data[start+1:], matroskaClusterID); i >= 0 && start+1+i < end {
+			next = start + 1 + i
+		}
+		cluster := bytes.HasPrefix(data[start:], matroskaClusterID)
+		if cluster {
+			me.inClusters = true
+		}
+		if !me.inClusters {
+			me.header = append(me.header, data[start:next]...)
+		} else {
+			units = append(units, liveUnit{data[start:next], cluster})
+		}
+		start = next
+	}
+	return
+}
+
+func (me *matroskaSplitter) prefix() []byte { return me.header }
+
+// Splits fragmented MP4 streams at movie fragments, which ffmpeg's frag_keyframe starts at
+// keyframes. Readers get the boxes before the first fragment, with the ftyp and moov, first.
+type mp4Splitter struct {
+	header      []byte
+	inFragments bool
+	// Bytes left in the current top-level box, and whether it runs to the end of the stream.
+	left  int64
+	toEnd bool
+	// The start of a box header.
+	held []byte
+}
+
+func (me *mp4Splitter) split(b []byte) (units []liveUnit) {
+	data := make([]byte, 0, len(me.held)+len(b))
+	data = append(append(data, me.held...), b...)
+	emit := func(d []byte, join bool) {
+		if !me.inFragments {
+			me.header = append(me.header, d...)
+		} else if join || len(units) == 0 {
+			units = append(units, liveUnit{d, join})
+		} else {
+			u := &units[len(units)-1]
+			u.data = u.data[:len(u.data)+len(d)]
+		}
+	}
+	for len(data) != 0 {
+		if me.toEnd || me.left > 0 {
+			n := len(data)
+			if !me.toEnd && int64(n) > me.left {
+				n = int(me.left)
+			}
+			emit(data[:n], false)
+			data = data[n:]
+			me.left -= int64(n)
+			continue
+		}
+		if len(data) < 8 {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(data))
+		if size == 1 {
+			if len(data) < 16 {
+				break
+			}
+			size = int64(binary.BigEndian.Uint64(data[8:]))
+		}
+		if size < 8 {
+			me.toEnd = true
+		}
+		me.left = size
+		fragment := string(data[4:8]) == "moof"
+		if fragment {
+			me.inFragments = true
+		}
+		emit(data[:0], fragment)
+	}
+	me.held = append(me.held[:0:0], data...)
+	return
+}
+
+func (me *mp4Splitter) prefix() []byte { return me.header }
@@ -20,6 +20,15 @@ func init() {
 	if err := mime.AddExtensionType(".ogg", "audio/ogg"); err != nil {
 		log.Printf("Could not register audio/ogg MIME type: %s", err)
 	}
+	if err := mime.AddExtensionType(".ts", "video/mp2t"); err != nil {
+		log.Printf("Could not register video/mp2t MIME type: %s", err)
+	}
+	if err := mime.AddExtensionType(".heic", "image/heic"); err != nil {
+		log.Printf("Could not register image/heic MIME type: %s", err)
+	}
+	if err := mime.AddExtensionType(".heif", "image/heif"); err != nil {
+		log.Printf("Could not register image/heif MIME type: %s", err)
+	}
 }
 
 // Example: "video/mpeg"
@@ -1,11 +1,15 @@
 package dms
 
 import (
+	"encoding/xml"
 	"net/http"
 
 	"github.com/anacrolix/dms/upnp"
 )
 
+// The type of the service in SOAP actions.
+const mediaReceiverRegistrarServiceType = "X_MS_MediaReceiverRegistrar"
+
 type mediaReceiverRegistrarService struct {
 	*Server
 	upnp.Eventing
@@ -14,14 +18,32 @@ type mediaReceiverRegistrarService struct {
 func (mrrs *mediaReceiverRegistrarService) Handle(action string, argsXML []byte, r *http.Request) ([][2]string, error) {
 	switch action {
 	case "IsAuthorized", "IsValidated":
+		var args struct {
+			DeviceID string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.ArgumentValueInvalidError
+		}
+		addr := requestClientIP(r)
+		mrrs.devices.seen(args.DeviceID, addr, r.UserAgent(), "")
+		result := "0"
+		if mrrs.devices.authorized(args.DeviceID, addr) {
+			result = "1"
+		}
 		return [][2]string{
-			{"Result", "1"},
+			{"Result", result},
 		}, nil
 	case "RegisterDevice":
+		var args struct {
+			RegistrationReqMsg string
+		}
+		if err := xml.Unmarshal(argsXML, &args); err != nil {
+			return nil, upnp.ArgumentValueInvalidError
+		}
+		mrrs.devices.seen("", requestClientIP(r), r.UserAgent(), args.RegistrationReqMsg)
 		return [][2]string{
 			{"RegistrationRespMsg", mrrs.rootDeviceUUID},
 		}, nil
-		//		return nil, nil
 	default:
 		return nil, upnp.InvalidActionError
 	}
@@ -0,0 +1,210 @@
+package dms
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Metadata from a Kodi style .nfo file, as also written by Jellyfin, Emby and most scrapers. Only
+// movie and episodedetails documents are used.
+type nfo struct {
+	XMLName   xml.Name
+	Title     string     `xml:"title"`
+	ShowTitle string     `xml:"showtitle"`
+	Plot      string     `xml:"plot"`
+	Outline   string     `xml:"outline"`
+	Year      int        `xml:"year"`
+	Premiered string     `xml:"premiered"`
+	Aired     string     `xml:"aired"`
+	Genres    []string   `xml:"genre"`
+	Rating    string     `xml:"rating"`
+	Ratings   []nfoValue `xml:"ratings>rating"`
+	MPAA      string     `xml:"mpaa"`
+	Actors    []nfoActor `xml:"actor"`
+	Season    int        `xml:"season"`
+	Episode   int        `xml:"episode"`
+}
+
+type nfoActor struct {
+	Name string `xml:"name"`
+	Role string `xml:"role"`
+}
+
+type nfoValue struct {
+	Default bool   `xml:"default,attr"`
+	Value   string `xml:"value"`
+}
+
+func (n nfo) isEpisode() bool {
+	return n.XMLName.Local == "episodedetails"
+}
+
+// Returns the .nfo file for a video: one named like it, or movie.nfo in its directory unless
+// another video there has its own, as then movie.nfo is likely for that one.
+func (me *Server) nfoPath(videoPath string) (string, bool) {
+	isFile := func(p string) bool {
+		fi, err := os.Stat(p)
+		return err == nil && fi.Mode().IsRegular()
+	}
+	if p := strings.TrimSuffix(videoPath, filepath.Ext(videoPath)) + ".nfo"; isFile(p) {
+		return p, true
+	}
+	dir := filepath.Dir(videoPath)
+	p := filepath.Join(dir, "movie.nfo")
+	if !isFile(p) {
+		return "", false
+	}
+	names, err := me.dirNames(dir)
+	if err != nil {
+		return "", false
+	}
+	nfos := make(map[string]bool)
+	for _, name := range names {
+		if strings.HasSuffix(name, ".nfo") {
+			nfos[strings.TrimSuffix(name, ".nfo")] = true
+		}
+	}
+	for _, name := range names {
+		if name == filepath.Base(videoPath) || !nfos[strings.TrimSuffix(name, filepath.Ext(name))] {
+			continue
+		}
+		if mimeType, err := MimeTypeByPath(name); err == nil && mimeType.IsVideo() {
+			return "", false
+		}
+	}
+	return p, true
+}
+
+// A parsed .nfo file, or the error parsing it.
+type cachedNFO struct {
+	n   nfo
+	err error
+}
+
+func (v cachedNFO) cacheSize() (n int64) {
+	n = int64(len(v.n.Title) + len(v.n.ShowTitle) + len(v.n.Plot) + len(v.n.Outline) + 256)
+	for _, g := range v.n.Genres {
+		n += int64(len(g))
+	}
+	for _, a := range v.n.Actors {
+		n += int64(len(a.Name) + len(a.Role))
+	}
+	return
+}
+
+// Reads the .nfo for a video. Some scrapers append a URL after the document, which is ignored.
+// Files are parsed again only once they change, as they're read for browsing, client profile
+// ratings and the series view.
+func (me *Server) readNFO(videoPath string) (n nfo, err error) {
+	p, ok := me.nfoPath(videoPath)
+	if !ok {
+		err = os.ErrNotExist
+		return
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	key := fileCacheKey{Path: p, ModTime: fi.ModTime().UnixNano()}
+	if v, ok := me.nfoCache.get(key); ok {
+		return v.n, v.err
+	}
+	n, err = parseNFO(p, f)
+	me.nfoCache.set(key, cachedNFO{n, err})
+	return
+}
+
+func parseNFO(p string, r io.Reader) (n nfo, err error) {
+	d := xml.NewDecoder(r)
+	// Some are declared as other encodings, but are almost always UTF-8 anyway.
+	d.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+	if err = d.Decode(&n); err != nil {
+		err = fmt.Errorf("parsing %q: %w", p, err)
+		return
+	}
+	switch n.XMLName.Local {
+	case "movie", "episodedetails":
+	default:
+		err = fmt.Errorf("parsing %q: unsupported root element %q", p, n.XMLName.Local)
+	}
+	return
+}
+
+// Returns the release date, from the most precise field given.
+func (n nfo) date() (time.Time, bool) {
+	for _, s := range []string{n.Aired, n.Premiered} {
+		if t, err := time.Parse("2006-01-02", strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	if n.Year > 0 {
+		return time.Date(n.Year, 1, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	return time.Time{}, false
+}
+
+// Returns the content rating, or failing that the user rating out of 10.
+func (n nfo) rating() string {
+	if n.MPAA != "" {
+		return n.MPAA
+	}
+	rating := strings.TrimSpace(n.Rating)
+	for _, r := range n.Ratings {
+		if rating == "" || r.Default {
+			rating = strings.TrimSpace(r.Value)
+		}
+	}
+	if f, err := strconv.ParseFloat(rating, 64); err == nil && f > 0 {
+		return strconv.FormatFloat(f, 'f', -1, 64) + "/10"
+	}
+	return ""
+}
+
+// Fills in the object from the .nfo, and gives it the movie or broadcast class.
+func (n nfo) apply(obj *upnpav.Object) {
+	if n.isEpisode() {
+		obj.Class = "object.item.videoItem.videoBroadcast"
+	} else {
+		obj.Class = "object.item.videoItem.movie"
+	}
+	if title := strings.TrimSpace(n.Title); title != "" {
+		obj.Title = title
+	}
+	obj.Description = strings.TrimSpace(n.Plot)
+	if obj.Description == "" {
+		obj.Description = strings.TrimSpace(n.Outline)
+	}
+	if t, ok := n.date(); ok {
+		obj.Date = upnpav.Timestamp{Time: t}
+	}
+	obj.Genres = nil
+	for _, g := range n.Genres {
+		if g = strings.TrimSpace(g); g != "" {
+			obj.Genres = append(obj.Genres, g)
+		}
+	}
+	obj.Rating = n.rating()
+	for _, a := range n.Actors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			obj.Actors = append(obj.Actors, upnpav.Person{Name: name, Role: strings.TrimSpace(a.Role)})
+		}
+	}
+	if n.isEpisode() {
+		obj.EpisodeSeason = n.Season
+		obj.EpisodeNumber = n.Episode
+		obj.SeriesTitle = strings.TrimSpace(n.ShowTitle)
+	}
+}
@@ -0,0 +1,138 @@
+package dms
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func writeTestFile(t *testing.T, name, contents string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNFOEpisode(t *testing.T) {
+	srv := &Server{}
+	dir := t.TempDir()
+	video := filepath.Join(dir, "Show S01E02.mkv")
+	writeTestFile(t, filepath.Join(dir, "Show S01E02.nfo"), `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<episodedetails>
+  <title>The Second</title>
+  <showtitle>Show</showtitle>
+  <plot>Things happen.</plot>
+  <aired>2020-03-04</aired>
+  <genre>Drama</genre>
+  <genre>Comedy</genre>
+  <ratings>
+    <rating name="imdb" max="10"><value>6.5</value></rating>
+    <rating name="tmdb" max="10" default="true"><value>7.25</value></rating>
+  </ratings>
+  <actor><name>Someone</name><role>Themselves</role></actor>
+  <season>1</season>
+  <episode>2</episode>
+</episodedetails>
+https://example.com/episode/2`)
+	n, err := srv.readNFO(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj upnpav.Object
+	n.apply(&obj)
+	if obj.Class != "object.item.videoItem.videoBroadcast" {
+		t.Errorf("class %q", obj.Class)
+	}
+	if obj.Title != "The Second" || obj.SeriesTitle != "Show" || obj.Description != "Things happen." {
+		t.Errorf("got %+v", obj)
+	}
+	if obj.EpisodeSeason != 1 || obj.EpisodeNumber != 2 {
+		t.Errorf("season %d episode %d", obj.EpisodeSeason, obj.EpisodeNumber)
+	}
+	if obj.Date.Format("2006-01-02") != "2020-03-04" {
+		t.Errorf("date %v", obj.Date)
+	}
+	if !reflect.DeepEqual(obj.Genres, []string{"Drama", "Comedy"}) || obj.Rating != "7.25/10" {
+		t.Errorf("genres %q rating %q", obj.Genres, obj.Rating)
+	}
+	if !reflect.DeepEqual(obj.Actors, []upnpav.Person{{Name: "Someone", Role: "Themselves"}}) {
+		t.Errorf("actors %+v", obj.Actors)
+	}
+}
+
+func TestNFOMovie(t *testing.T) {
+	srv := &Server{}
+	dir := t.TempDir()
+	video := filepath.Join(dir, "film.mp4")
+	if _, err := srv.readNFO(video); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "movie.nfo"),
+		`<movie><title>Film</title><outline>Short.</outline><year>1999</year><mpaa>PG-13</mpaa></movie>`)
+	n, err := srv.readNFO(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var obj upnpav.Object
+	n.apply(&obj)
+	if obj.Class != "object.item.videoItem.movie" || obj.Title != "Film" || obj.Description != "Short." {
+		t.Errorf("got %+v", obj)
+	}
+	if obj.Date.Year() != 1999 || obj.Rating != "PG-13" {
+		t.Errorf("date %v rating %q", obj.Date, obj.Rating)
+	}
+	// Parsed again only once it changes.
+	movieNFO := filepath.Join(dir, "movie.nfo")
+	fi, err := os.Stat(movieNFO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, movieNFO, `<movie><title>Changed</title></movie>`)
+	if err := os.Chtimes(movieNFO, fi.ModTime(), fi.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := srv.readNFO(video); err != nil || n.Title != "Film" {
+		t.Fatalf("got %+v, %v", n, err)
+	}
+	if err := os.Chtimes(movieNFO, fi.ModTime(), fi.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := srv.readNFO(video); err != nil || n.Title != "Changed" {
+		t.Fatalf("got %+v, %v", n, err)
+	}
+	// movie.nfo is for the whole directory only while no other video has its own.
+	writeTestFile(t, filepath.Join(dir, "sample.mp4"), "")
+	if _, err := srv.readNFO(video); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(dir, "other.mkv"), "")
+	writeTestFile(t, filepath.Join(dir, "other.nfo"), `<movie><title>Other</title></movie>`)
+	if _, err := srv.readNFO(video); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	writeTestFile(t, filepath.Join(dir, "film.nfo"), `<tvshow><title>Nope</title></tvshow>`)
+	if _, err := srv.readNFO(video); err == nil {
+		t.Fatal("expected error for tvshow root")
+	}
+}
+
+func TestVideoPoster(t *testing.T) {
+	dir := "/videos"
+	video := filepath.Join(dir, "film [1999].mkv")
+	names := []string{"film [1999].mkv"}
+	if p := videoPoster(video, names); p != "" {
+		t.Fatalf("unexpected poster %q", p)
+	}
+	names = append(names, "fanart.jpg")
+	if p := videoPoster(video, names); p != filepath.Join(dir, "fanart.jpg") {
+		t.Fatalf("got %q", p)
+	}
+	names = append(names, "film [1999]-poster.JPG")
+	if p := videoPoster(video, names); p != filepath.Join(dir, "film [1999]-poster.JPG") {
+		t.Fatalf("got %q", p)
+	}
+}
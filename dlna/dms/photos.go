@@ -0,0 +1,176 @@
+package dms
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/exif"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Photos from anywhere in the library by the year and then month they were taken.
+var photosView = virtualView{
+	name:     "photos",
+	title:    "Photos by Date",
+	enabled:  func(me *Server) bool { return me.PhotosView },
+	children: (*contentDirectoryService).photosChildren,
+}
+
+// The EXIF metadata read from an image, which is read for every image listed.
+type cachedExif struct {
+	info exif.Info
+	err  error
+}
+
+func (v cachedExif) cacheSize() int64 {
+	return int64(len(v.info.Make) + len(v.info.Model) + 128)
+}
+
+// Returns the EXIF metadata of an image file.
+func (me *Server) imageExif(filePath string) (info exif.Info, err error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+	key := fileCacheKey{Path: filePath, ModTime: fi.ModTime().UnixNano()}
+	if v, ok := me.exifCache.get(key); ok {
+		return v.info, v.err
+	}
+	info, err = exif.Decode(f)
+	me.exifCache.set(key, cachedExif{info, err})
+	return
+}
+
+// Fills in an image item from its EXIF metadata. Images with a camera or time taken recorded are
+// photos.
+func applyExif(obj *upnpav.Object, info exif.Info) {
+	if !info.DateTime.IsZero() {
+		obj.Date = upnpav.Timestamp{Time: info.DateTime}
+	}
+	if !info.DateTime.IsZero() || info.Make != "" || info.Model != "" {
+		obj.Class = "object.item.imageItem.photo"
+	}
+}
+
+// Returns when a photo was taken, or failing that when the file was last modified. The time is as
+// the photographer's clock read.
+func (me *Server) photoTime(filePath string) (time.Time, error) {
+	if info, err := me.imageExif(filePath); err == nil && !info.DateTime.IsZero() {
+		return info.DateTime, nil
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+type photo struct {
+	taken time.Time
+	// The object path of the file.
+	path string
+}
+
+type photosIndex struct {
+	mu    sync.Mutex
+	built time.Time
+	// Photos by year and then month.
+	years map[int]map[time.Month][]photo
+}
+
+// Returns the photos in the library, scanning it if it hasn't been lately.
+func (me *Server) photosIndexYears() (map[int]map[time.Month][]photo, error) {
+	me.photosIndex.mu.Lock()
+	defer me.photosIndex.mu.Unlock()
+	if me.photosIndex.years != nil && time.Since(me.photosIndex.built) < viewIndexMaxAge {
+		return me.photosIndex.years, nil
+	}
+	years := make(map[int]map[time.Month][]photo)
+	err := me.walkLibrary(func(filePath, objectPath string, mimeType mimeType) {
+		if !mimeType.IsImage() {
+			return
+		}
+		taken, err := me.photoTime(filePath)
+		if err != nil {
+			return
+		}
+		months := years[taken.Year()]
+		if months == nil {
+			months = make(map[time.Month][]photo)
+			years[taken.Year()] = months
+		}
+		months[taken.Month()] = append(months[taken.Month()], photo{taken, objectPath})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, months := range years {
+		for _, photos := range months {
+			sort.SliceStable(photos, func(i, j int) bool {
+				if !photos[i].taken.Equal(photos[j].taken) {
+					return photos[i].taken.Before(photos[j].taken)
+				}
+				return photos[i].path < photos[j].path
+			})
+		}
+	}
+	me.photosIndex.years = years
+	me.photosIndex.built = time.Now()
+	return years, nil
+}
+
+// Lists the years, newest first, the months of a year, or the photos of a month in the order they
+// were taken.
+func (me *contentDirectoryService) photosChildren(id virtualID, host, userAgent string) (ret []interface{}, err error) {
+	years, err := me.photosIndexYears()
+	if err != nil {
+		return
+	}
+	if len(id) == 1 {
+		var ys []int
+		for y := range years {
+			ys = append(ys, y)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(ys)))
+		for _, y := range ys {
+			ret = append(ret, virtualContainer(id.child(strconv.Itoa(y)), strconv.Itoa(y), len(years[y])))
+		}
+		return
+	}
+	y, err := strconv.Atoi(id[1])
+	months, ok := years[y]
+	if err != nil || !ok {
+		return nil, fmt.Errorf("no photos from %q", id[1])
+	}
+	if len(id) == 2 {
+		for m := time.January; m <= time.December; m++ {
+			if photos, ok := months[m]; ok {
+				ret = append(ret, virtualContainer(id.child(strconv.Itoa(int(m))), m.String()+" "+strconv.Itoa(y), len(photos)))
+			}
+		}
+		return
+	}
+	m, err := strconv.Atoi(id[2])
+	photos, ok := months[time.Month(m)]
+	if err != nil || !ok || len(id) != 3 {
+		return nil, fmt.Errorf("no photos from %q", id[1:])
+	}
+	for _, p := range photos {
+		item, err := me.virtualItem(id, p.path, host, userAgent)
+		if err != nil {
+			me.Logger.Printf("error with %s: %s", p.path, err)
+			continue
+		}
+		ret = append(ret, item)
+	}
+	return
+}
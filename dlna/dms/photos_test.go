@@ -0,0 +1,106 @@
+package dms
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/exif"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestApplyExif(t *testing.T) {
+	taken := time.Date(2019, 7, 4, 12, 30, 0, 0, time.UTC)
+	obj := upnpav.Object{Class: "object.item.imageItem"}
+	applyExif(&obj, exif.Info{DateTime: taken, Make: "Canon"})
+	if obj.Class != "object.item.imageItem.photo" || !obj.Date.Equal(taken) {
+		t.Fatalf("got %+v", obj)
+	}
+	obj = upnpav.Object{Class: "object.item.imageItem"}
+	applyExif(&obj, exif.Info{Orientation: exif.OrientationRotate90})
+	if obj.Class != "object.item.imageItem" {
+		t.Fatalf("got %+v", obj)
+	}
+}
+
+func TestPhotosView(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 1))
+	var jpg, pngData bytes.Buffer
+	if err := jpeg.Encode(&jpg, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(&pngData, img); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, modTime := range map[string]time.Time{
+		"a/late.jpg":  time.Date(2019, 7, 20, 0, 0, 0, 0, time.Local),
+		"b/early.jpg": time.Date(2019, 7, 4, 0, 0, 0, 0, time.Local),
+		"b/jan.png":   time.Date(2019, 1, 1, 12, 0, 0, 0, time.Local),
+		"old.jpg":     time.Date(2008, 2, 1, 0, 0, 0, 0, time.Local),
+		"song.mp3":    time.Date(2019, 7, 4, 0, 0, 0, 0, time.Local),
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		var data []byte
+		switch filepath.Ext(name) {
+		case ".jpg":
+			data = jpg.Bytes()
+		case ".png":
+			data = pngData.Bytes()
+		}
+		if err := os.WriteFile(p, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cds := &contentDirectoryService{Server: &Server{RootObjectPath: dir, PhotosView: true, NoProbe: true}}
+	titles := func(objs []interface{}) (ret []string) {
+		for _, o := range objs {
+			switch o := o.(type) {
+			case upnpav.Container:
+				ret = append(ret, o.Title)
+			case upnpav.Item:
+				ret = append(ret, o.Title)
+			}
+		}
+		return
+	}
+	years, err := cds.virtualChildren(virtualID{"photos"}, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := titles(years); len(s) != 2 || s[0] != "2019" || s[1] != "2008" {
+		t.Fatalf("got %q", s)
+	}
+	months, err := cds.virtualChildren(virtualID{"photos", "2019"}, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := titles(months); len(s) != 2 || s[0] != "January 2019" || s[1] != "July 2019" {
+		t.Fatalf("got %q", s)
+	}
+	photos, err := cds.virtualChildren(virtualID{"photos", "2019", "7"}, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := titles(photos); len(s) != 2 || s[0] != "early.jpg" || s[1] != "late.jpg" {
+		t.Fatalf("got %q", s)
+	}
+	item := photos[0].(upnpav.Item)
+	if item.RefID != (object{Path: "/b/early.jpg"}).ID() || item.ParentID != "@photos/2019/7" || item.Res[0].Resolution != "2x1" || item.Date.Year() != 2019 {
+		t.Fatalf("got %+v", item.Object)
+	}
+	if _, err := cds.virtualChildren(virtualID{"photos", "2020"}, "localhost", ""); err == nil {
+		t.Fatal("expected error for a year without photos")
+	}
+}
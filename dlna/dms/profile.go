@@ -0,0 +1,73 @@
+package dms
+
+import (
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna"
+)
+
+// Returns what the DLNA profile detector needs from a probed file. Cover art attached to audio
+// isn't video.
+func probedMedia(info *ffprobe.Info, filePath string) (m dlna.Media) {
+	m.Container, _ = info.Format["format_name"].(string)
+	if s, ok := info.Format["bit_rate"].(string); ok {
+		m.Bitrate, _ = strconv.ParseInt(s, 10, 64)
+	}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".m2ts", ".mts", ".m2t":
+		m.Timestamped = true
+	}
+	for _, stream := range info.Streams {
+		codec, _ := stream["codec_name"].(string)
+		switch stream["codec_type"] {
+		case "video":
+			if m.VideoCodec != "" || isAttachedPic(stream) {
+				continue
+			}
+			m.VideoCodec = codec
+			m.VideoProfile, _ = stream["profile"].(string)
+			width, _ := ffprobe.AnyAsInt64(stream["width"])
+			height, _ := ffprobe.AnyAsInt64(stream["height"])
+			m.Width, m.Height = int(width), int(height)
+			if s, ok := stream["avg_frame_rate"].(string); ok {
+				if r, ok := new(big.Rat).SetString(s); ok {
+					m.FrameRate, _ = r.Float64()
+				}
+			}
+		case "audio":
+			if m.AudioCodec != "" {
+				continue
+			}
+			m.AudioCodec = codec
+			channels, _ := ffprobe.AnyAsInt64(stream["channels"])
+			m.AudioChannels = int(channels)
+			if s, ok := stream["sample_rate"].(string); ok {
+				m.SampleRate, _ = strconv.Atoi(s)
+			}
+		}
+	}
+	return
+}
+
+// Returns the DLNA profile of a file, from its probe data, or its dimensions for images. The
+// profile's MIME-type should be used in place of the one from the file name, as strict renderers
+// check it.
+func (me *Server) dlnaProfile(filePath string, mimeType mimeType, info *ffprobe.Info) (dlna.Profile, bool) {
+	if mimeType.IsImage() {
+		g, err := me.imageGeometry(filePath)
+		if err != nil {
+			return dlna.Profile{}, false
+		}
+		width, height := g.stored()
+		return dlna.DetectImageProfile(mimeType.String(), width, height)
+	}
+	if info == nil {
+		return dlna.Profile{}, false
+	}
+	return dlna.DetectProfile(probedMedia(info, filePath))
+}
@@ -0,0 +1,56 @@
+package dms
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/dlna"
+)
+
+func TestProbedMedia(t *testing.T) {
+	info := &ffprobe.Info{
+		Format: map[string]interface{}{
+			"format_name": "mp3",
+			"bit_rate":    "320000",
+		},
+		Streams: []map[string]interface{}{
+			{
+				"codec_type":  "audio",
+				"codec_name":  "mp3",
+				"channels":    json.Number("2"),
+				"sample_rate": "44100",
+			},
+			{
+				"codec_type":  "video",
+				"codec_name":  "mjpeg",
+				"disposition": map[string]interface{}{"attached_pic": json.Number("1")},
+			},
+		},
+	}
+	m := probedMedia(info, "/song.mp3")
+	expected := dlna.Media{Container: "mp3", AudioCodec: "mp3", AudioChannels: 2, SampleRate: 44100, Bitrate: 320000}
+	if m != expected {
+		t.Fatalf("got %+v", m)
+	}
+	if p, _ := dlna.DetectProfile(m); p.Name != "MP3" {
+		t.Fatalf("got %+v", p)
+	}
+
+	info = &ffprobe.Info{
+		Format: map[string]interface{}{"format_name": "mpegts"},
+		Streams: []map[string]interface{}{{
+			"codec_type":     "video",
+			"codec_name":     "h264",
+			"profile":        "High",
+			"width":          json.Number("1920"),
+			"height":         json.Number("1080"),
+			"avg_frame_rate": "24000/1001",
+		}},
+	}
+	m = probedMedia(info, "/film.m2ts")
+	if m.VideoCodec != "h264" || m.Width != 1920 || !m.Timestamped || m.FrameRate < 23.97 || m.FrameRate > 23.98 {
+		t.Fatalf("got %+v", m)
+	}
+}
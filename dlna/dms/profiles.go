@@ -0,0 +1,221 @@
+package dms
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ClientProfile is a named view of the library, for the clients matching it by address, MAC
+// address, User-Agent or media receiver DeviceID.
+type ClientProfile struct {
+	Name string
+	// Matches clients with a User-Agent containing this, ignoring case.
+	UserAgent string
+	// Matches clients with these addresses, given as IPs or CIDR networks.
+	IPs []string
+	// Matches clients with these MAC addresses, found from their address in the ARP table. Only
+	// supported on Linux.
+	MACs []string
+	// Matches media receivers that gave these DeviceIDs to the X_MS_MediaReceiverRegistrar
+	// service.
+	DeviceIDs []string
+	// Paths relative to the root the clients see, with everything under them. Everything is seen
+	// if empty.
+	Paths []string
+	// Paths relative to the root hidden from the clients, with everything under them.
+	HiddenPaths []string
+	// The most restrictive content rating the clients see, like "PG" or "TV-PG". Videos rated
+	// above it are hidden. No limit if empty.
+	MaxRating string
+	// Hide videos without a known content rating too, when MaxRating is set.
+	HideUnrated bool
+}
+
+// US film and TV content ratings, by how restricted they are.
+var ratingLevels = map[string]int{
+	"G":     0,
+	"TV-Y":  0,
+	"TV-G":  0,
+	"TV-Y7": 1,
+	"PG":    1,
+	"TV-PG": 1,
+	"PG-13": 2,
+	"TV-14": 2,
+	"R":     3,
+	"NC-17": 4,
+	"TV-MA": 4,
+}
+
+// Returns how restricted a content rating is. Ratings from .nfo files come in forms like "PG-13",
+// "Rated PG-13" and "US:PG-13".
+func ratingLevel(rating string) (int, bool) {
+	rating = strings.ToUpper(strings.TrimSpace(rating))
+	if i := strings.LastIndexByte(rating, ':'); i >= 0 {
+		rating = rating[i+1:]
+	}
+	rating = strings.TrimSpace(strings.TrimPrefix(rating, "RATED "))
+	level, ok := ratingLevels[rating]
+	return level, ok
+}
+
+func (p ClientProfile) validate() error {
+	for _, s := range p.IPs {
+		if _, err := parseIPNet(s); err != nil {
+			return err
+		}
+	}
+	for _, s := range p.MACs {
+		if _, err := net.ParseMAC(s); err != nil {
+			return fmt.Errorf("bad MAC address %q in client profile %q", s, p.Name)
+		}
+	}
+	if _, ok := ratingLevel(p.MaxRating); p.MaxRating != "" && !ok {
+		return fmt.Errorf("unknown rating %q in client profile %q", p.MaxRating, p.Name)
+	}
+	return nil
+}
+
+// The client a profile is matched against. The MAC address and DeviceIDs are only looked up if a
+// profile needs them.
+type profileClient struct {
+	ip        net.IP
+	userAgent string
+	mac       func() string
+	deviceIDs func() []string
+}
+
+func (p ClientProfile) matches(c profileClient) bool {
+	if p.UserAgent != "" && strings.Contains(strings.ToLower(c.userAgent), strings.ToLower(p.UserAgent)) {
+		return true
+	}
+	if ipListContains(p.IPs, c.ip) {
+		return true
+	}
+	if len(p.MACs) != 0 {
+		if mac, err := net.ParseMAC(c.mac()); err == nil {
+			for _, s := range p.MACs {
+				if m, err := net.ParseMAC(s); err == nil && m.String() == mac.String() {
+					return true
+				}
+			}
+		}
+	}
+	if len(p.DeviceIDs) != 0 {
+		for _, id := range c.deviceIDs() {
+			for _, s := range p.DeviceIDs {
+				if s == id {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Whether the object at the path relative to the root is in the view. The containers leading to
+// the visible paths are in it too, though only showing those paths.
+func (p *ClientProfile) pathVisible(objectPath string) bool {
+	objectPath = path.Clean("/" + objectPath)
+	for _, h := range p.HiddenPaths {
+		if (PathAccess{Path: h}).covers(objectPath) {
+			return false
+		}
+	}
+	if len(p.Paths) == 0 {
+		return true
+	}
+	for _, s := range p.Paths {
+		s = path.Clean("/" + s)
+		if (PathAccess{Path: s}).covers(objectPath) || objectPath == "/" || strings.HasPrefix(s, objectPath+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a video with the content rating is in the view.
+func (p *ClientProfile) ratingVisible(rating string) bool {
+	if p.MaxRating == "" {
+		return true
+	}
+	maxLevel, _ := ratingLevel(p.MaxRating)
+	level, ok := ratingLevel(rating)
+	if !ok {
+		return !p.HideUnrated
+	}
+	return level <= maxLevel
+}
+
+// Returns the profile of the client making the request: the first matching one, or nil if none
+// match and it sees everything.
+func (me *Server) clientProfile(r *http.Request) *ClientProfile {
+	if len(me.ClientProfiles) == 0 {
+		return nil
+	}
+	clientIp := requestClientIP(r)
+	var (
+		mac       string
+		macLooked bool
+	)
+	c := profileClient{
+		ip:        net.ParseIP(clientIp),
+		userAgent: r.UserAgent(),
+		mac: func() string {
+			if !macLooked {
+				mac = arpLookup(clientIp)
+				macLooked = true
+			}
+			return mac
+		},
+		deviceIDs: func() []string {
+			return me.devices.idsAt(clientIp)
+		},
+	}
+	for i := range me.ClientProfiles {
+		if me.ClientProfiles[i].matches(c) {
+			return &me.ClientProfiles[i]
+		}
+	}
+	return nil
+}
+
+// Returns the content rating of the file at the path from its .nfo file, and whether it's a video,
+// as only videos are rated.
+func (me *Server) contentRating(filePath string) (rating string, isVideo bool) {
+	mimeType, err := MimeTypeByPath(filePath)
+	if err != nil || !mimeType.IsVideo() {
+		return "", false
+	}
+	if n, err := me.readNFO(filePath); err == nil {
+		rating = n.MPAA
+	}
+	return rating, true
+}
+
+// Whether the client making the request may see and fetch the object at the path relative to the
+// root, by the path access rules and its profile.
+func (me *Server) objectAllowed(r *http.Request, objectPath string) bool {
+	return me.objectVisible(requestClientIP(r), me.clientProfile(r), objectPath)
+}
+
+// Whether the client at the address, with the profile, may see and fetch the object at the path
+// relative to the root. For checking many objects without looking up the profile for each.
+func (me *Server) objectVisible(clientIp string, p *ClientProfile, objectPath string) bool {
+	if !me.pathAllowed(clientIp, objectPath) {
+		return false
+	}
+	if p == nil {
+		return true
+	}
+	if !p.pathVisible(objectPath) {
+		return false
+	}
+	if p.MaxRating == "" {
+		return true
+	}
+	rating, isVideo := me.contentRating(me.filePath(objectPath))
+	return !isVideo || p.ratingVisible(rating)
+}
@@ -0,0 +1,142 @@
+package dms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestClientProfiles(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "Kids"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"Kids/cartoon.mkv": "",
+		"Kids/cartoon.nfo": "<movie><mpaa>Rated G</mpaa></movie>",
+		"Kids/scary.mkv":   "",
+		"Kids/scary.nfo":   "<movie><mpaa>US:PG-13</mpaa></movie>",
+		"Kids/plain.mkv":   "",
+	} {
+		if err := os.WriteFile(filepath.Join(root, filepath.FromSlash(name)), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{
+		Logger:         log.Default,
+		RootObjectPath: root,
+		ClientProfiles: []ClientProfile{
+			{Name: "kids", UserAgent: "KidsTV", Paths: []string{"/Kids"}, MaxRating: "PG"},
+			{Name: "xbox", DeviceIDs: []string{"xbox-1"}, HiddenPaths: []string{"/Private"}, MaxRating: "TV-PG", HideUnrated: true},
+		},
+	}
+	for _, p := range srv.ClientProfiles {
+		if err := p.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv.devices.logger = log.Default
+	srv.devices.seen("xbox-1", "10.0.0.5", "Xbox", "")
+	kids := httptest.NewRequest("GET", "/", nil)
+	kids.Header.Set("User-Agent", "KidsTV/1.0")
+	xbox := httptest.NewRequest("GET", "/", nil)
+	xbox.RemoteAddr = "10.0.0.5:1234"
+	other := httptest.NewRequest("GET", "/", nil)
+	if p := srv.clientProfile(xbox); p == nil || p.Name != "xbox" {
+		t.Fatalf("got %+v", p)
+	}
+	if p := srv.clientProfile(other); p != nil {
+		t.Fatalf("got %+v", p)
+	}
+	for _, tc := range []struct {
+		name    string
+		r       *http.Request
+		path    string
+		allowed bool
+	}{
+		{"kids root", kids, "/", true},
+		{"kids folder", kids, "/Kids", true},
+		{"kids other folder", kids, "/Movies/a.mkv", false},
+		{"kids G", kids, "/Kids/cartoon.mkv", true},
+		{"kids PG-13", kids, "/Kids/scary.mkv", false},
+		{"kids unrated", kids, "/Kids/plain.mkv", true},
+		{"xbox unrated", xbox, "/Kids/plain.mkv", false},
+		{"xbox hidden", xbox, "/Private/a.jpg", false},
+		{"xbox other", xbox, "/Movies", true},
+		{"other", other, "/Kids/scary.mkv", true},
+	} {
+		if allowed := srv.objectAllowed(tc.r, tc.path); allowed != tc.allowed {
+			t.Errorf("%s: got %v", tc.name, allowed)
+		}
+	}
+	cds := &contentDirectoryService{Server: srv}
+	objs := cds.filterAllowedObjects(kids, []interface{}{
+		upnpav.Container{Object: upnpav.Object{ID: object{Path: "/Kids"}.ID()}},
+		upnpav.Container{Object: upnpav.Object{ID: object{Path: "/Movies"}.ID()}},
+		upnpav.Item{Object: upnpav.Object{ID: object{Path: "/Kids/scary.mkv"}.ID(), Class: "object.item.videoItem.movie", Rating: "PG-13"}},
+		upnpav.Item{Object: upnpav.Object{ID: object{Path: "/Kids/cartoon.mkv"}.ID(), Class: "object.item.videoItem.movie", Rating: "G"}},
+	})
+	if len(objs) != 2 {
+		t.Fatalf("got %+v", objs)
+	}
+	if err := (ClientProfile{MaxRating: "XXX"}).validate(); err == nil {
+		t.Fatal("expected error for unknown rating")
+	}
+}
+
+func TestClientProfileViews(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{
+		"Kids/Cartoon.S01E01.mkv",
+		"Kids/Cartoon.S01E02.mkv",
+		"Movies/Drama.S01E01.mkv",
+	} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{
+		Logger:         log.Default,
+		RootObjectPath: root,
+		SeriesView:     true,
+		NoProbe:        true,
+		ClientProfiles: []ClientProfile{{Name: "kids", UserAgent: "KidsTV", Paths: []string{"/Kids"}}},
+	}
+	cds := &contentDirectoryService{Server: srv}
+	kids := httptest.NewRequest("POST", serviceControlURL, nil)
+	kids.Header.Set("User-Agent", "KidsTV/1.0")
+	objs := cds.filterAllowedObjects(kids, cds.virtualViewContainers(kids.Host, kids.UserAgent()))
+	if len(objs) != 1 || objs[0].(upnpav.Container).ChildCount != 1 {
+		t.Fatalf("got %+v", objs)
+	}
+	other := httptest.NewRequest("POST", serviceControlURL, nil)
+	browseMetadata := func(r *http.Request, id string) error {
+		_, err := cds.Handle("Browse", []byte(`<u:Browse><ObjectID>`+id+`</ObjectID><BrowseFlag>BrowseMetadata</BrowseFlag></u:Browse>`), r)
+		return err
+	}
+	if err := browseMetadata(kids, "@series/Cartoon/1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{
+		"@series/Drama",
+		"@series/Drama/1",
+		virtualID{"series", "Drama", "1", object{Path: "/Movies/Drama.S01E01.mkv"}.ID()}.String(),
+	} {
+		if err := browseMetadata(other, id); err != nil {
+			t.Errorf("%s: %v", id, err)
+		}
+		if err := browseMetadata(kids, id); err == nil {
+			t.Errorf("%s: hidden object browsed", id)
+		}
+	}
+}
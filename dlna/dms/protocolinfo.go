@@ -0,0 +1,68 @@
+package dms
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/anacrolix/dms/dlna"
+	"github.com/anacrolix/dms/subtitles"
+)
+
+// Returns a protocolInfo for HTTP, with the DLNA profile if there is one.
+func httpProtocolInfo(mimeType, profileName string) string {
+	if profileName == "" {
+		return "http-get:*:" + mimeType + ":*"
+	}
+	return "http-get:*:" + mimeType + ":DLNA.ORG_PN=" + profileName
+}
+
+// Builds the GetProtocolInfo Source from what the server can actually serve: transcodes, files
+// with detectable DLNA profiles, image variants, thumbnails and subtitles. Profiled entries come
+// first, then bare MIME-types, then the wildcards for files served as they are, then any extra
+// entries configured.
+func (me *Server) buildSourceProtocolInfo() string {
+	var profiled, bare []string
+	add := func(mimeType, profileName string) {
+		pi := httpProtocolInfo(mimeType, profileName)
+		if profileName == "" {
+			bare = append(bare, pi)
+		} else {
+			profiled = append(profiled, pi)
+		}
+	}
+	if !me.NoTranscode {
+		for _, specs := range []map[string]transcodeSpec{transcodes, audioTranscodes} {
+			for _, ts := range specs {
+				add(ts.mimeType, ts.DLNAProfileName)
+			}
+		}
+	}
+	for _, p := range dlna.Profiles() {
+		add(p.MimeType, p.Name)
+	}
+	for _, p := range imageProfiles {
+		add(p.mimeType, p.name)
+	}
+	// Thumbnails and album art.
+	add("image/jpeg", "JPEG_TN")
+	for _, f := range []subtitles.Format{subtitles.SRT, subtitles.VTT, subtitles.ASS} {
+		add(f.MimeType(), "")
+	}
+	add("text/x-microdvd", "")
+	sort.Strings(profiled)
+	sort.Strings(bare)
+	infos := append(profiled, bare...)
+	infos = append(infos, "http-get:*:video/*:*", "http-get:*:audio/*:*", "http-get:*:image/*:*")
+	infos = append(infos, me.ExtraProtocolInfo...)
+	ret := infos[:0]
+	seen := make(map[string]bool, len(infos))
+	for _, pi := range infos {
+		pi = strings.TrimSpace(pi)
+		if pi == "" || seen[pi] {
+			continue
+		}
+		seen[pi] = true
+		ret = append(ret, pi)
+	}
+	return strings.Join(ret, ",")
+}
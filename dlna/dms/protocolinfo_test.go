@@ -0,0 +1,43 @@
+package dms
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSourceProtocolInfo(t *testing.T) {
+	srv := &Server{ExtraProtocolInfo: []string{
+		"http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_24_AC3;SONY.COM_PN=AVC_TS_HD_24_AC3",
+		"http-get:*:video/*:*",
+	}}
+	infos := strings.Split(srv.buildSourceProtocolInfo(), ",")
+	index := make(map[string]int, len(infos))
+	for i, pi := range infos {
+		if _, ok := index[pi]; ok {
+			t.Fatalf("duplicate %q", pi)
+		}
+		index[pi] = i
+	}
+	for _, pi := range []string{
+		"http-get:*:video/webm:*",
+		"http-get:*:video/mp4:*",
+		"http-get:*:audio/mpeg:DLNA.ORG_PN=MP3",
+		"http-get:*:image/jpeg:DLNA.ORG_PN=JPEG_TN",
+		"http-get:*:image/png:DLNA.ORG_PN=PNG_TN",
+		"http-get:*:video/*:*",
+	} {
+		if _, ok := index[pi]; !ok {
+			t.Errorf("missing %q", pi)
+		}
+	}
+	if last := infos[len(infos)-1]; !strings.Contains(last, "SONY.COM_PN") {
+		t.Errorf("extra entry not last: %q", last)
+	}
+	if index["http-get:*:audio/mpeg:DLNA.ORG_PN=MP3"] > index["http-get:*:video/webm:*"] {
+		t.Error("profiled entries should come before bare ones")
+	}
+	srv.NoTranscode = true
+	if pi := srv.buildSourceProtocolInfo(); strings.Contains(pi, "video/webm") {
+		t.Errorf("transcodes advertised with NoTranscode: %q", pi)
+	}
+}
@@ -0,0 +1,426 @@
+package dms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often recording schedules are checked for recordings to start, and how often the files
+// with schedules are read again for changes.
+const (
+	recordingScheduleCheckInterval = 30 * time.Second
+	recordingScheduleScanInterval  = 5 * time.Minute
+)
+
+// A recording of a dynamic stream being written to the recordings directory. The file has a
+// ".part" suffix until the recording ends.
+type recording struct {
+	ID string
+	// The .dms.json file relative to the root, and the index of the resource recorded.
+	Path  string
+	Index int
+	// The file written, relative to the root.
+	File    string
+	Started time.Time
+	// When it's stopped, if it's for a duration.
+	Until     *time.Time `json:",omitempty"`
+	Scheduled bool
+	cancel    context.CancelFunc
+}
+
+// The recordings in progress.
+type recorder struct {
+	mu         sync.Mutex
+	nextID     int
+	recordings map[string]*recording
+}
+
+func (me *recorder) add(rec *recording) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	me.nextID++
+	rec.ID = fmt.Sprintf("%d", me.nextID)
+	if me.recordings == nil {
+		me.recordings = make(map[string]*recording)
+	}
+	me.recordings[rec.ID] = rec
+}
+
+func (me *recorder) remove(rec *recording) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	delete(me.recordings, rec.ID)
+}
+
+func (me *recorder) stop(id string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	rec, ok := me.recordings[id]
+	if ok {
+		rec.cancel()
+	}
+	return ok
+}
+
+func (me *recorder) stopAll() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, rec := range me.recordings {
+		rec.cancel()
+	}
+}
+
+func (me *recorder) list() (ret []recording) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, rec := range me.recordings {
+		ret = append(ret, *rec)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Started.Before(ret[j].Started) })
+	return
+}
+
+// Returns the extension of recordings of streams of the MIME-type.
+func recordingExtension(mimeType string) (string, error) {
+	switch mt := canonicalMimeType(mimeType); mt {
+	case "video/mp2t":
+		return ".ts", nil
+	case "video/mpeg":
+		return ".mpg", nil
+	default:
+		exts, _ := mime.ExtensionsByType(mt)
+		if len(exts) == 0 {
+			return "", fmt.Errorf("can't record streams of type %q", mimeType)
+		}
+		sort.Strings(exts)
+		return exts[0], nil
+	}
+}
+
+// Returns the name of a recording of the title started at the time, without the extension.
+func recordingFileName(title string, started time.Time) string {
+	title = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, title)
+	return title + " " + started.Format("2006-01-02 15.04.05")
+}
+
+// Starts recording the resource of the dynamic stream at the path relative to the root, for the
+// duration if it's positive, else until it's stopped. Live resources share their producer with
+// the clients playing them.
+func (me *Server) startRecording(objectPath string, index int, duration time.Duration, scheduled bool) (*recording, error) {
+	if me.RecordingsPath == "" {
+		return nil, errors.New("recording is disabled")
+	}
+	if !me.AllowDynamicStreams {
+		return nil, errors.New("dynamic streams are disabled")
+	}
+	metadataPath := me.filePath(objectPath)
+	if !strings.HasSuffix(metadataPath, dmsMetadataSuffix) {
+		return nil, fmt.Errorf("%q isn't a dynamic stream", objectPath)
+	}
+	item, err := me.loadDynamicStream(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= len(item.Resources) {
+		return nil, fmt.Errorf("invalid index %d, corresponding stream not found", index)
+	}
+	res := item.Resources[index]
+	open, err := me.dynamicStreamOpener(res)
+	if err != nil {
+		return nil, err
+	}
+	ext, err := recordingExtension(res.MimeType)
+	if err != nil {
+		return nil, err
+	}
+	title := item.Title
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(metadataPath), dmsMetadataSuffix)
+	}
+	rec := &recording{
+		Path:      objectPath,
+		Index:     index,
+		Started:   time.Now(),
+		Scheduled: scheduled,
+	}
+	filePath := filepath.Join(me.RecordingsPath, recordingFileName(title, rec.Started)+ext)
+	rel, err := filepath.Rel(me.RootObjectPath, filePath)
+	if err != nil {
+		return nil, err
+	}
+	rec.File = "/" + filepath.ToSlash(rel)
+	f, err := os.OpenFile(filePath+".part", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if duration > 0 {
+		until := rec.Started.Add(duration)
+		rec.Until = &until
+		ctx, cancel = context.WithDeadline(context.Background(), until)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	rec.cancel = cancel
+	p, err := me.liveStreams.join(ctx, liveStreamKey(metadataPath, index), res.MimeType, -1, me.loggedOpener(open, filepath.Base(metadataPath)))
+	if err != nil {
+		cancel()
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	me.recorder.add(rec)
+	me.Logger.Printf("recording %q to %q", objectPath, filePath)
+	go me.record(rec, p, f, filePath)
+	return rec, nil
+}
+
+// Writes the recording until it's stopped or the stream ends, then gives the file its name.
+func (me *Server) record(rec *recording, p io.ReadCloser, f *os.File, filePath string) {
+	defer me.recorder.remove(rec)
+	_, err := io.Copy(f, p)
+	p.Close()
+	rec.cancel()
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		err = nil
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		me.Logger.Printf("error recording %q: %v", rec.Path, err)
+	}
+	if err := os.Rename(f.Name(), filePath); err != nil {
+		me.Logger.Printf("error finishing recording %q: %v", rec.Path, err)
+		return
+	}
+	me.Logger.Printf("recorded %q to %q", rec.Path, filePath)
+}
+
+// A recording to make of a dynamic stream, once or on days of the week.
+type dmsRecordingSchedule struct {
+	// required: when to start, in local time. A date and time like "2006-01-02 15:04" records
+	// once, a time like "15:04" records every day.
+	Start string
+	// (optional) the days of the week to record on with a daily Start, e.g. ["Sat", "Sun"].
+	// Every day if omitted.
+	Days []string `json:",omitempty"`
+	// required: how long to record, e.g. 1h30m
+	Duration string
+	// (optional) index of the resource to record. The first if omitted.
+	Index int `json:",omitempty"`
+}
+
+const (
+	recordingScheduleDateTimeLayout = "2006-01-02 15:04"
+	recordingScheduleTimeLayout     = "15:04"
+)
+
+func (s dmsRecordingSchedule) validate() error {
+	if _, err := time.ParseInLocation(recordingScheduleDateTimeLayout, s.Start, time.Local); err != nil {
+		if _, err := time.Parse(recordingScheduleTimeLayout, s.Start); err != nil {
+			return fmt.Errorf("bad schedule start %q", s.Start)
+		}
+	} else if len(s.Days) != 0 {
+		return fmt.Errorf("schedule starting at %q is once, but gives days", s.Start)
+	}
+	for _, d := range s.Days {
+		if _, ok := parseWeekday(d); !ok {
+			return fmt.Errorf("bad schedule day %q", d)
+		}
+	}
+	d, err := time.ParseDuration(s.Duration)
+	if err != nil {
+		return fmt.Errorf("bad schedule duration: %w", err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("schedule duration %q isn't positive", s.Duration)
+	}
+	return nil
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// Returns when the schedule starts a recording after from and no later than to, if it does.
+func (s dmsRecordingSchedule) startsBetween(from, to time.Time) (time.Time, bool) {
+	within := func(t time.Time) bool {
+		return t.After(from) && !t.After(to)
+	}
+	if t, err := time.ParseInLocation(recordingScheduleDateTimeLayout, s.Start, time.Local); err == nil {
+		return t, within(t)
+	}
+	clock, err := time.Parse(recordingScheduleTimeLayout, s.Start)
+	if err != nil {
+		return time.Time{}, false
+	}
+	from, to = from.Local(), to.Local()
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.Local); !day.After(to); day = day.AddDate(0, 0, 1) {
+		t := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		if !within(t) || !s.onDay(t.Weekday()) {
+			continue
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func (s dmsRecordingSchedule) onDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if wd, ok := parseWeekday(d); ok && wd == day {
+			return true
+		}
+	}
+	return false
+}
+
+// A schedule found in the .dms.json file at the path relative to the root.
+type foundRecordingSchedule struct {
+	path     string
+	schedule dmsRecordingSchedule
+}
+
+// The valid recording schedules of the .dms.json files in the library, by the path of the file
+// relative to the root. Files are added as they're read, which they all are by the check of the
+// library at startup, so it needn't be searched for them again.
+type recordingSchedules struct {
+	mu     sync.Mutex
+	byPath map[string][]dmsRecordingSchedule
+}
+
+func (me *recordingSchedules) set(objectPath string, schedules []dmsRecordingSchedule) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if len(schedules) == 0 {
+		delete(me.byPath, objectPath)
+		return
+	}
+	if me.byPath == nil {
+		me.byPath = make(map[string][]dmsRecordingSchedule)
+	}
+	me.byPath[objectPath] = schedules
+}
+
+func (me *recordingSchedules) list() (ret []foundRecordingSchedule) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for path, schedules := range me.byPath {
+		for _, s := range schedules {
+			ret = append(ret, foundRecordingSchedule{path, s})
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].path < ret[j].path })
+	return
+}
+
+// Reads the .dms.json file, keeping the index of recording schedules up to date with it if it's
+// in the library.
+func (me *Server) loadDynamicStream(metadataPath string) (*dmsDynamicMediaItem, error) {
+	item, err := readDynamicStream(metadataPath)
+	rel, ok := me.libraryRelPath(metadataPath)
+	if !ok {
+		return item, err
+	}
+	objectPath := "/" + filepath.ToSlash(rel)
+	switch {
+	case err == nil:
+		var schedules []dmsRecordingSchedule
+		for _, s := range item.Schedule {
+			if s.validate() == nil {
+				schedules = append(schedules, s)
+			}
+		}
+		me.recordingSchedules.set(objectPath, schedules)
+	case errors.Is(err, os.ErrNotExist):
+		me.recordingSchedules.set(objectPath, nil)
+	}
+	return item, err
+}
+
+// Returns the path of the file relative to the root, if it's under it.
+func (me *Server) libraryRelPath(filePath string) (string, bool) {
+	root, err := filepath.Abs(me.RootObjectPath)
+	if err != nil {
+		return "", false
+	}
+	filePath, err = filepath.Abs(filePath)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+// Reads the files with recording schedules again, for changes to them.
+func (me *Server) reloadRecordingSchedules() {
+	seen := make(map[string]bool)
+	for _, s := range me.recordingSchedules.list() {
+		if seen[s.path] {
+			continue
+		}
+		seen[s.path] = true
+		if _, err := me.loadDynamicStream(me.filePath(s.path)); err != nil {
+			me.Logger.Printf("error reading recording schedules of %q: %v", s.path, err)
+		}
+	}
+}
+
+// Starts the recordings scheduled in .dms.json files as they come due, until the server is
+// closed.
+func (me *Server) runRecordingSchedules() {
+	last := time.Now()
+	reloaded := last
+	t := time.NewTicker(recordingScheduleCheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-me.closed:
+			return
+		case now := <-t.C:
+			if now.Sub(reloaded) >= recordingScheduleScanInterval {
+				me.reloadRecordingSchedules()
+				reloaded = now
+			}
+			for _, s := range me.recordingSchedules.list() {
+				start, ok := s.schedule.startsBetween(last, now)
+				if !ok {
+					continue
+				}
+				d, _ := time.ParseDuration(s.schedule.Duration)
+				if _, err := me.startRecording(s.path, s.schedule.Index, d-now.Sub(start), true); err != nil {
+					me.Logger.Printf("error starting scheduled recording of %q: %v", s.path, err)
+				}
+			}
+			last = now
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package dms
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestRecordingScheduleStartsBetween(t *testing.T) {
+	at := func(s string) time.Time {
+		t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	for _, c := range []struct {
+		schedule dmsRecordingSchedule
+		from, to string
+		start    string
+	}{
+		{dmsRecordingSchedule{Start: "2026-10-17 20:00"}, "2026-10-17 19:59", "2026-10-17 20:00", "2026-10-17 20:00"},
+		{dmsRecordingSchedule{Start: "2026-10-17 20:00"}, "2026-10-17 20:00", "2026-10-17 20:01", ""},
+		{dmsRecordingSchedule{Start: "20:00"}, "2026-10-17 19:59", "2026-10-17 20:00", "2026-10-17 20:00"},
+		{dmsRecordingSchedule{Start: "00:00"}, "2026-10-17 23:59", "2026-10-18 00:01", "2026-10-18 00:00"},
+		// 2026-10-17 is a Saturday.
+		{dmsRecordingSchedule{Start: "20:00", Days: []string{"Sun"}}, "2026-10-17 19:59", "2026-10-17 20:00", ""},
+		{dmsRecordingSchedule{Start: "20:00", Days: []string{"sunday"}}, "2026-10-18 19:59", "2026-10-18 20:00", "2026-10-18 20:00"},
+	} {
+		start, ok := c.schedule.startsBetween(at(c.from), at(c.to))
+		if c.start == "" {
+			if ok {
+				t.Errorf("%v starts between %s and %s at %v", c.schedule, c.from, c.to, start)
+			}
+		} else if !ok || !start.Equal(at(c.start)) {
+			t.Errorf("%v starts between %s and %s at %v, %v", c.schedule, c.from, c.to, start, ok)
+		}
+	}
+}
+
+func TestRecordingScheduleValidate(t *testing.T) {
+	for _, s := range []dmsRecordingSchedule{
+		{Start: "20:00", Duration: "1h"},
+		{Start: "2026-10-17 20:00", Duration: "30m"},
+		{Start: "06:30", Days: []string{"Mon", "friday"}, Duration: "1h"},
+	} {
+		if err := s.validate(); err != nil {
+			t.Errorf("%v: %v", s, err)
+		}
+	}
+	for _, s := range []dmsRecordingSchedule{
+		{Start: "8pm", Duration: "1h"},
+		{Start: "20:00", Duration: "an hour"},
+		{Start: "20:00", Duration: "0s"},
+		{Start: "20:00", Days: []string{"Someday"}, Duration: "1h"},
+		{Start: "2026-10-17 20:00", Days: []string{"Mon"}, Duration: "1h"},
+	} {
+		if err := s.validate(); err == nil {
+			t.Errorf("%v: expected error", s)
+		}
+	}
+}
+
+func TestStartRecording(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("radio"))
+	}))
+	defer remote.Close()
+	root := t.TempDir()
+	srv := &Server{
+		RootObjectPath:      root,
+		RecordingsPath:      filepath.Join(root, "Recordings"),
+		AllowDynamicStreams: true,
+		StreamURLs:          []string{remote.URL},
+		relayClient:         remote.Client(),
+		Logger:              log.Default,
+	}
+	srv.liveStreams.logger = log.Default
+	os.Mkdir(srv.RecordingsPath, 0o755)
+	metadata := fmt.Sprintf(`{"Title": "Radio: live", "Resources": [{"MimeType": "audio/mpeg", "URL": %q}]}`, remote.URL)
+	if err := os.WriteFile(filepath.Join(root, "radio.dms.json"), []byte(metadata), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.startRecording("/radio.dms.json", 1, 0, false); err == nil {
+		t.Fatal("expected error recording a missing resource")
+	}
+	rec, err := srv.startRecording("/radio.dms.json", 0, time.Minute, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(rec.File) != "/Recordings" || filepath.Ext(rec.File) != ".mp3" {
+		t.Fatalf("recording to %q", rec.File)
+	}
+	// The recording ends with the remote stream, and loses its .part suffix.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		b, err := os.ReadFile(srv.filePath(rec.File))
+		if err == nil && string(b) == "radio" && len(srv.recorder.list()) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("recording not finished: %q, %v", b, err)
+		}
+	}
+}
+
+func TestRecordingSchedulesIndexed(t *testing.T) {
+	root := t.TempDir()
+	srv := &Server{RootObjectPath: root, Logger: log.Default}
+	write := func(name, schedule string) string {
+		p := filepath.Join(root, name)
+		metadata := `{"Resources": [{"MimeType": "audio/mpeg", "URL": "http://radio.example.com/"}]` + schedule + `}`
+		if err := os.WriteFile(p, []byte(metadata), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	paths := func() (ret []string) {
+		for _, s := range srv.recordingSchedules.list() {
+			ret = append(ret, s.path+" "+s.schedule.Start)
+		}
+		return
+	}
+	radio := write("radio.dms.json", `, "Schedule": [{"Start": "20:00", "Duration": "1h"}, {"Start": "bad", "Duration": "1h"}]`)
+	plain := write("plain.dms.json", "")
+	// Only files that have been read are indexed, so the library needn't be searched.
+	if p := paths(); len(p) != 0 {
+		t.Fatalf("got %q", p)
+	}
+	for _, p := range []string{radio, plain} {
+		if _, err := srv.loadDynamicStream(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if p := paths(); len(p) != 1 || p[0] != "/radio.dms.json 20:00" {
+		t.Fatalf("got %q", p)
+	}
+	write("radio.dms.json", `, "Schedule": [{"Start": "21:00", "Duration": "1h"}]`)
+	srv.reloadRecordingSchedules()
+	if p := paths(); len(p) != 1 || p[0] != "/radio.dms.json 21:00" {
+		t.Fatalf("got %q", p)
+	}
+	os.Remove(radio)
+	srv.reloadRecordingSchedules()
+	if p := paths(); len(p) != 0 {
+		t.Fatalf("got %q", p)
+	}
+}
+
+func TestServeRecordingsCrossOrigin(t *testing.T) {
+	srv := &Server{Logger: log.Default}
+	r := httptest.NewRequest("POST", recordingsPath+"?action=stop&id=1", nil)
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	w := httptest.NewRecorder()
+	srv.serveRecordings(w, r)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("got %d", w.Code)
+	}
+}
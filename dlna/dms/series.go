@@ -0,0 +1,294 @@
+package dms
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// Series, then seasons, then episodes sorted by number, gathered from wherever they are in the
+// library.
+var seriesView = virtualView{
+	name:     "series",
+	title:    "TV Series",
+	enabled:  func(me *Server) bool { return me.SeriesView },
+	children: (*contentDirectoryService).seriesChildren,
+}
+
+// An episode of a series, as identified from its file name or .nfo.
+type episode struct {
+	Show   string
+	Season int
+	// Zero for episodes identified by date, which use the year as the season.
+	Episode int
+	Date    time.Time
+	Title   string
+}
+
+var (
+	// Show.Name.S01E02.Title, allowing for multi-episode files like S01E02E03 and S01E02-E03.
+	seasonEpisodePattern = regexp.MustCompile(`(?i)^(.*?)\bs(\d{1,2})[ ._-]*e(\d{1,3})(?:-?e\d{1,3})*\b(.*)$`)
+	// Show Name 1x02 Title
+	crossEpisodePattern = regexp.MustCompile(`(?i)^(.*?)\b(\d{1,2})x(\d{2,3})\b(.*)$`)
+	// Show.Name.2020.03.04.Title, for daily shows.
+	dateEpisodePattern = regexp.MustCompile(`^(.*?)\b((?:19|20)\d\d)[ ._-](\d\d)[ ._-](\d\d)\b(.*)$`)
+	// Directories that hold a season of a show, named for the show in their parent.
+	seasonDirPattern = regexp.MustCompile(`(?i)^(?:season[ ._-]*\d+|s\d+|specials)$`)
+	// The start of the release details that often follow the episode title.
+	releaseTagPattern = regexp.MustCompile(`(?i)\b(?:480p|576p|720p|1080[pi]|2160p|4k|uhd|hdtv|pdtv|web(?:-?dl|-?rip)?|bluray|blu-ray|bdrip|brrip|dvdrip|hdrip|x26[45]|[hx]\.?26[45]|hevc|xvid|divx|aac|ac3|dts|proper|repack|internal)\b`)
+)
+
+// Identifies an episode from its path relative to the library. Names without a show fall back to
+// the directory, skipping any season directory.
+func parseEpisode(objectPath string) (ep episode, ok bool) {
+	name := path.Base(objectPath)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	var rest string
+	if m := seasonEpisodePattern.FindStringSubmatch(name); m != nil {
+		ep.Show, rest = m[1], m[4]
+		ep.Season, _ = strconv.Atoi(m[2])
+		ep.Episode, _ = strconv.Atoi(m[3])
+	} else if m := crossEpisodePattern.FindStringSubmatch(name); m != nil {
+		ep.Show, rest = m[1], m[4]
+		ep.Season, _ = strconv.Atoi(m[2])
+		ep.Episode, _ = strconv.Atoi(m[3])
+	} else if m := dateEpisodePattern.FindStringSubmatch(name); m != nil {
+		date, err := time.Parse("2006-01-02", m[2]+"-"+m[3]+"-"+m[4])
+		if err != nil {
+			return
+		}
+		ep.Show, rest = m[1], m[5]
+		ep.Date = date
+		ep.Season = date.Year()
+	} else {
+		return
+	}
+	ep.Show = cleanEpisodeName(ep.Show)
+	if ep.Show == "" {
+		dir := path.Dir(objectPath)
+		if seasonDirPattern.MatchString(path.Base(dir)) {
+			dir = path.Dir(dir)
+		}
+		if dir == "/" || dir == "." {
+			return
+		}
+		ep.Show = cleanEpisodeName(path.Base(dir))
+	}
+	if loc := releaseTagPattern.FindStringIndex(rest); loc != nil {
+		rest = rest[:loc[0]]
+	}
+	ep.Title = cleanEpisodeName(rest)
+	return ep, ep.Show != ""
+}
+
+// Turns the separators used in release names into spaces, and trims the punctuation left around
+// the parts of a name.
+func cleanEpisodeName(s string) string {
+	s = strings.ReplaceAll(s, "_", " ")
+	if !strings.Contains(s, " ") {
+		s = strings.ReplaceAll(s, ".", " ")
+	}
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.Trim(s, " .-–[(")
+}
+
+// Fills in what the .nfo for an episode says, which beats what the file name implies.
+func (ep *episode) applyNFO(n nfo) {
+	if !n.isEpisode() {
+		return
+	}
+	if s := strings.TrimSpace(n.ShowTitle); s != "" {
+		ep.Show = s
+	}
+	if n.Episode > 0 {
+		ep.Season = n.Season
+		ep.Episode = n.Episode
+	}
+	if s := strings.TrimSpace(n.Title); s != "" {
+		ep.Title = s
+	}
+}
+
+// Returns a title like "Show – S01E02 – Episode Title".
+func (ep episode) displayTitle() string {
+	parts := []string{ep.Show}
+	if ep.Episode == 0 && !ep.Date.IsZero() {
+		parts = append(parts, ep.Date.Format("2006-01-02"))
+	} else {
+		parts = append(parts, fmt.Sprintf("S%02dE%02d", ep.Season, ep.Episode))
+	}
+	if ep.Title != "" {
+		parts = append(parts, ep.Title)
+	}
+	return strings.Join(parts, " – ")
+}
+
+func seasonTitle(season int) string {
+	switch {
+	case season == 0:
+		return "Specials"
+	case season >= 1000:
+		// A year, for episodes identified by date.
+		return strconv.Itoa(season)
+	}
+	return fmt.Sprintf("Season %d", season)
+}
+
+type seriesEpisode struct {
+	episode
+	// The object path of the file.
+	path string
+}
+
+type series struct {
+	title   string
+	seasons map[int][]seriesEpisode
+}
+
+func (s *series) seasonNumbers() (ret []int) {
+	for n := range s.seasons {
+		ret = append(ret, n)
+	}
+	// Specials go last.
+	sort.Slice(ret, func(i, j int) bool {
+		if (ret[i] == 0) != (ret[j] == 0) {
+			return ret[j] == 0
+		}
+		return ret[i] < ret[j]
+	})
+	return
+}
+
+type seriesIndex struct {
+	mu    sync.Mutex
+	built time.Time
+	// Keyed by the lower cased title, so differences in case in file names don't split a show.
+	shows map[string]*series
+}
+
+// Returns the series in the library, scanning it if it hasn't been lately.
+func (me *Server) seriesIndexShows() (map[string]*series, error) {
+	me.seriesIndex.mu.Lock()
+	defer me.seriesIndex.mu.Unlock()
+	if me.seriesIndex.shows != nil && time.Since(me.seriesIndex.built) < viewIndexMaxAge {
+		return me.seriesIndex.shows, nil
+	}
+	shows, err := me.scanSeries()
+	if err != nil {
+		return nil, err
+	}
+	me.seriesIndex.shows = shows
+	me.seriesIndex.built = time.Now()
+	return shows, nil
+}
+
+func (me *Server) scanSeries() (shows map[string]*series, err error) {
+	shows = make(map[string]*series)
+	err = me.walkLibrary(func(filePath, objectPath string, mimeType mimeType) {
+		if !mimeType.IsVideo() {
+			return
+		}
+		ep, ok := parseEpisode(objectPath)
+		if n, err := me.readNFO(filePath); err == nil && n.isEpisode() {
+			if !ok {
+				ep = episode{Show: cleanEpisodeName(n.ShowTitle)}
+			}
+			ep.applyNFO(n)
+			ok = ep.Show != "" && (ep.Episode > 0 || !ep.Date.IsZero())
+		}
+		if !ok {
+			return
+		}
+		key := strings.ToLower(ep.Show)
+		s := shows[key]
+		if s == nil {
+			s = &series{title: ep.Show, seasons: make(map[int][]seriesEpisode)}
+			shows[key] = s
+		}
+		// The first spelling of the show found is used throughout.
+		ep.Show = s.title
+		s.seasons[ep.Season] = append(s.seasons[ep.Season], seriesEpisode{ep, objectPath})
+	})
+	for _, s := range shows {
+		for _, eps := range s.seasons {
+			sort.SliceStable(eps, func(i, j int) bool {
+				if eps[i].Episode != eps[j].Episode {
+					return eps[i].Episode < eps[j].Episode
+				}
+				if !eps[i].Date.Equal(eps[j].Date) {
+					return eps[i].Date.Before(eps[j].Date)
+				}
+				return eps[i].path < eps[j].path
+			})
+		}
+	}
+	return
+}
+
+// Lists the series, the seasons of a series, or the episodes of a season.
+func (me *contentDirectoryService) seriesChildren(id virtualID, host, userAgent string) (ret []interface{}, err error) {
+	shows, err := me.seriesIndexShows()
+	if err != nil {
+		return
+	}
+	switch len(id) {
+	case 1:
+		titles := make([]string, 0, len(shows))
+		for _, s := range shows {
+			titles = append(titles, s.title)
+		}
+		sort.Slice(titles, func(i, j int) bool { return strings.ToLower(titles[i]) < strings.ToLower(titles[j]) })
+		for _, title := range titles {
+			ret = append(ret, virtualContainer(id.child(title), title, len(shows[strings.ToLower(title)].seasons)))
+		}
+		return
+	}
+	s, ok := shows[strings.ToLower(id[1])]
+	if !ok {
+		return nil, fmt.Errorf("no series %q", id[1])
+	}
+	if len(id) == 2 {
+		for _, n := range s.seasonNumbers() {
+			ret = append(ret, virtualContainer(id.child(strconv.Itoa(n)), seasonTitle(n), len(s.seasons[n])))
+		}
+		return
+	}
+	n, err := strconv.Atoi(id[2])
+	if err != nil || len(id) != 3 {
+		return nil, fmt.Errorf("no season %q", id[2:])
+	}
+	eps, ok := s.seasons[n]
+	if !ok {
+		return nil, fmt.Errorf("no season %d of %q", n, s.title)
+	}
+	for _, ep := range eps {
+		item, err := me.episodeItem(id, ep, host, userAgent)
+		if err != nil {
+			me.Logger.Printf("error with %s: %s", ep.path, err)
+			continue
+		}
+		ret = append(ret, item)
+	}
+	return
+}
+
+// Returns the item for an episode in a season container.
+func (me *contentDirectoryService) episodeItem(seasonID virtualID, ep seriesEpisode, host, userAgent string) (item upnpav.Item, err error) {
+	item, err = me.virtualItem(seasonID, ep.path, host, userAgent)
+	if err != nil {
+		return
+	}
+	item.Title = ep.displayTitle()
+	item.Class = "object.item.videoItem.videoBroadcast"
+	item.SeriesTitle = ep.Show
+	item.EpisodeSeason = ep.Season
+	item.EpisodeNumber = ep.Episode
+	return
+}
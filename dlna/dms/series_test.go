@@ -0,0 +1,115 @@
+package dms
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestParseEpisode(t *testing.T) {
+	for _, tc := range []struct {
+		path     string
+		expected episode
+	}{
+		{"/TV/Some.Show.S01E02.The.Title.720p.HDTV.x264.mkv", episode{Show: "Some Show", Season: 1, Episode: 2, Title: "The Title"}},
+		{"/TV/Mr. Robot - s02e10 - eps2.8_h1dden-pr0cess.axx.mkv", episode{Show: "Mr. Robot", Season: 2, Episode: 10, Title: "eps2.8 h1dden-pr0cess.axx"}},
+		{"/TV/Show/Season 3/3x07 Name.mp4", episode{Show: "Show", Season: 3, Episode: 7, Title: "Name"}},
+		{"/TV/Show/S01E01E02.mkv", episode{Show: "Show", Season: 1, Episode: 1}},
+		{"/News/The.Daily.2020.03.04.WEB.mkv", episode{Show: "The Daily", Season: 2020, Date: time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC)}},
+	} {
+		ep, ok := parseEpisode(tc.path)
+		if !ok || ep != tc.expected {
+			t.Errorf("%s: got %+v, %v", tc.path, ep, ok)
+		}
+	}
+	for _, p := range []string{"/Movies/Film (1999).mkv", "/S01E02.mkv"} {
+		if ep, ok := parseEpisode(p); ok {
+			t.Errorf("%s: unexpected %+v", p, ep)
+		}
+	}
+}
+
+func TestEpisodeDisplayTitle(t *testing.T) {
+	ep := episode{Show: "Show", Season: 1, Episode: 2, Title: "Title"}
+	if s := ep.displayTitle(); s != "Show – S01E02 – Title" {
+		t.Fatal(s)
+	}
+	ep = episode{Show: "Show", Season: 2020, Date: time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC)}
+	if s := ep.displayTitle(); s != "Show – 2020-03-04" {
+		t.Fatal(s)
+	}
+}
+
+func TestSeriesView(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a/Show.S01E02.mkv",
+		"b/show.s01e01.mkv",
+		"b/Show.S00E01.Special.mkv",
+		"b/Show.S02E01.mkv",
+		"Film.mkv",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cds := &contentDirectoryService{Server: &Server{RootObjectPath: dir, SeriesView: true, NoProbe: true}}
+	titles := func(objs []interface{}) (ret []string) {
+		for _, o := range objs {
+			switch o := o.(type) {
+			case upnpav.Container:
+				ret = append(ret, o.Title)
+			case upnpav.Item:
+				ret = append(ret, o.Title)
+			}
+		}
+		return
+	}
+	root, err := cds.readContainer(object{"/", dir}, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(root) == 0 || didlObjectID(root[0]) != "@series" {
+		t.Fatalf("got %q", titles(root))
+	}
+	shows, err := cds.virtualChildren(virtualID{"series"}, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shows) != 1 || didlObjectID(shows[0]) != "@series/Show" {
+		t.Fatalf("got %q", titles(shows))
+	}
+	seasons, err := cds.virtualChildren(virtualID{"series", "Show"}, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := titles(seasons); len(s) != 3 || s[0] != "Season 1" || s[1] != "Season 2" || s[2] != "Specials" {
+		t.Fatalf("got %q", s)
+	}
+	seasonID, _ := parseVirtualID(didlObjectID(seasons[0]))
+	episodes, err := cds.virtualChildren(seasonID, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := titles(episodes); len(s) != 2 || s[0] != "Show – S01E01" || s[1] != "Show – S01E02" {
+		t.Fatalf("got %q", s)
+	}
+	item := episodes[1].(upnpav.Item)
+	if item.RefID != (object{Path: "/a/Show.S01E02.mkv"}).ID() || item.ParentID != "@series/Show/1" {
+		t.Fatalf("got %+v", item.Object)
+	}
+	id, ok := parseVirtualID(item.ID)
+	if !ok {
+		t.Fatal(item.ID)
+	}
+	if o, err := cds.virtualObject(id, "localhost", ""); err != nil || didlObjectID(o) != item.ID {
+		t.Fatalf("got %v, %v", o, err)
+	}
+}
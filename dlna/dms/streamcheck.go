@@ -0,0 +1,274 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The problems found with a .dms.json file.
+type dynamicStreamError []string
+
+func (e dynamicStreamError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+var (
+	dynamicStreamDurationRegexp   = regexp.MustCompile(`^\d+:[0-5]\d:[0-5]\d(\.\d+)?$`)
+	dynamicStreamResolutionRegexp = regexp.MustCompile(`^[1-9]\d*x[1-9]\d*$`)
+)
+
+// Parses the contents of a .dms.json file, rejecting keys it doesn't know, and checks them.
+func parseDynamicStream(data []byte) (*dmsDynamicMediaItem, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var item dmsDynamicMediaItem
+	if err := dec.Decode(&item); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after the object")
+	}
+	if err := item.validate(); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (item dmsDynamicMediaItem) validate() error {
+	var problems dynamicStreamError
+	add := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+	switch item.Type {
+	case "", "audio", "video":
+	default:
+		add("Type %q isn't audio or video", item.Type)
+	}
+	if item.Duration != "" && !dynamicStreamDurationRegexp.MatchString(item.Duration) {
+		add("Duration %q isn't like 0:21:37.922", item.Duration)
+	}
+	if len(item.Resources) == 0 {
+		add("no Resources")
+	}
+	for i, res := range item.Resources {
+		for _, p := range res.problems() {
+			add("resource %d: %s", i, p)
+		}
+	}
+	for i, s := range item.Schedule {
+		if err := s.validate(); err != nil {
+			add("schedule %d: %v", i, err)
+		}
+		if s.Index < 0 || s.Index >= len(item.Resources) {
+			add("schedule %d: no resource %d", i, s.Index)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+func (res dmsDynamicStreamResource) problems() (ret []string) {
+	if res.MimeType == "" {
+		ret = append(ret, "no MimeType")
+	} else if _, _, err := mime.ParseMediaType(res.MimeType); err != nil {
+		ret = append(ret, fmt.Sprintf("bad MimeType %q", res.MimeType))
+	}
+	sources := 0
+	for _, s := range []string{res.Stream, res.Command, res.URL} {
+		if s != "" {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		ret = append(ret, "no Stream, Command or URL")
+	case sources > 1:
+		ret = append(ret, "more than one of Stream, Command and URL")
+	}
+	if len(res.Params) != 0 && res.Stream == "" {
+		ret = append(ret, "Params without a Stream")
+	}
+	if res.URL != "" {
+		if u, err := url.Parse(res.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			ret = append(ret, fmt.Sprintf("URL %q isn't an HTTP URL", res.URL))
+		}
+	} else if len(res.Headers) != 0 || res.Reconnect {
+		ret = append(ret, "Headers or Reconnect without a URL")
+	}
+	if res.Resolution != "" && !dynamicStreamResolutionRegexp.MatchString(res.Resolution) {
+		ret = append(ret, fmt.Sprintf("Resolution %q isn't like 640x360", res.Resolution))
+	}
+	return
+}
+
+// Checks what a parsed .dms.json file needs of the server's configuration: the stream commands
+// it names and the parameters it gives them, and that its URLs are allowed.
+func (me *Server) checkDynamicStream(item *dmsDynamicMediaItem) error {
+	var problems dynamicStreamError
+	for i, res := range item.Resources {
+		var err error
+		switch {
+		case res.URL != "":
+			err = me.streamURLAllowed(res.URL)
+		case res.Stream != "" || res.Command != "":
+			_, err = me.dynamicStreamOpener(res)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("resource %d: %v", i, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// CheckDynamicStreams checks the .dms.json files under root, by the server's configuration,
+// calling report with the problems of each bad one. With a positive dryRun, every resource is
+// also run for up to that long, and must produce data of its MimeType. Returns how many files are
+// bad.
+func (me *Server) CheckDynamicStreams(root string, dryRun time.Duration, report func(path string, err error)) (bad int, err error) {
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return
+	}
+	err = filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ignored, _ := me.IgnorePath(filePath); ignored {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(filePath, dmsMetadataSuffix) {
+			return nil
+		}
+		if err := me.checkDynamicStreamFile(filePath, dryRun); err != nil {
+			report(filePath, err)
+			bad++
+		}
+		return nil
+	})
+	return
+}
+
+func (me *Server) checkDynamicStreamFile(filePath string, dryRun time.Duration) error {
+	// Read it as browsing would too, to find its recording schedules.
+	if _, err := me.loadDynamicStream(filePath); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	item, err := parseDynamicStream(data)
+	if err != nil {
+		return err
+	}
+	if err := me.checkDynamicStream(item); err != nil {
+		return err
+	}
+	if dryRun <= 0 {
+		return nil
+	}
+	var problems dynamicStreamError
+	for i, res := range item.Resources {
+		if err := me.dryRunResource(res, dryRun); err != nil {
+			problems = append(problems, fmt.Sprintf("resource %d: %v", i, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
+
+// Runs the resource for up to the duration, checking it produces data of its MIME-type.
+func (me *Server) dryRunResource(res dmsDynamicStreamResource, d time.Duration) error {
+	open, err := me.dynamicStreamOpener(res)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	p, err := open(ctx, ioutil.Discard)
+	if err != nil {
+		return err
+	}
+	defer p.Close()
+	buf := make([]byte, 64<<10)
+	n, err := io.ReadAtLeast(p, buf, len(buf))
+	if n == 0 {
+		if err == nil || err == io.EOF || errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("produced nothing in %v", d)
+		}
+		return fmt.Errorf("produced nothing: %w", err)
+	}
+	if matches, known := sniffMimeType(res.MimeType, buf[:n]); known && !matches {
+		return fmt.Errorf("produced data that isn't %s", res.MimeType)
+	}
+	return nil
+}
+
+// Checks data from the start of a stream is of the MIME-type, for the types whose signatures are
+// known.
+func sniffMimeType(mimeType string, b []byte) (matches, known bool) {
+	switch canonicalMimeType(mimeType) {
+	case "video/mp2t":
+		return len(b) != 0 && b[0] == tsSyncByte && (len(b) <= tsPacketSize || b[tsPacketSize] == tsSyncByte), true
+	case "video/mpeg":
+		return bytes.HasPrefix(b, []byte{0, 0, 1, 0xba}) || bytes.HasPrefix(b, []byte{0, 0, 1, 0xb3}) ||
+			len(b) != 0 && b[0] == tsSyncByte, true
+	case "video/x-matroska", "video/webm", "audio/webm":
+		return bytes.HasPrefix(b, []byte{0x1a, 0x45, 0xdf, 0xa3}), true
+	case "video/mp4", "audio/mp4":
+		if len(b) < 8 {
+			return false, true
+		}
+		switch string(b[4:8]) {
+		case "ftyp", "moov", "moof", "styp":
+			return true, true
+		}
+		return false, true
+	case "audio/mpeg":
+		return bytes.HasPrefix(b, []byte("ID3")) || len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0, true
+	case "audio/aac":
+		return len(b) >= 2 && b[0] == 0xff && b[1]&0xf6 == 0xf0, true
+	case "audio/flac":
+		return bytes.HasPrefix(b, []byte("fLaC")), true
+	case "audio/ogg", "video/ogg":
+		return bytes.HasPrefix(b, []byte("OggS")), true
+	case "audio/wav":
+		return len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WAVE", true
+	}
+	return false, false
+}
+
+// Logs the problems of the .dms.json files in the library.
+func (me *Server) reportDynamicStreamProblems() {
+	bad, err := me.CheckDynamicStreams(me.RootObjectPath, 0, func(path string, err error) {
+		me.Logger.Printf("%s: %v", path, err)
+	})
+	if err != nil {
+		me.Logger.Printf("error checking dynamic streams: %v", err)
+	}
+	if bad != 0 {
+		me.Logger.Printf("%d dynamic streams have problems", bad)
+	}
+}
@@ -0,0 +1,161 @@
+package dms
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/upnpav"
+)
+
+func TestParseDynamicStream(t *testing.T) {
+	const res = `"MimeType": "video/mp2t", "Stream": "cam"`
+	for _, c := range []struct {
+		json    string
+		problem string
+	}{
+		{`{"Title": "Cam", "Type": "video", "Duration": "0:21:37.922", "Resources": [{` + res + `, "Resolution": "640x360", "DlnaFlags": "streaming|dlnaV15"}]}`, ""},
+		{`{"Resources": [{"MimeType": "audio/mpeg", "URL": "http://radio.example.com/", "Reconnect": true}]}`, ""},
+		{`{"Resources": [{` + res + `}], "Colour": "red"}`, "unknown field"},
+		{`{"Resources": [{` + res + `}]} {}`, "after the object"},
+		{`{"Type": "picture", "Resources": [{` + res + `}]}`, "Type"},
+		{`{"Duration": "21 minutes", "Resources": [{` + res + `}]}`, "Duration"},
+		{`{"Resources": []}`, "no Resources"},
+		{`{"Resources": [{"Stream": "cam"}]}`, "no MimeType"},
+		{`{"Resources": [{"MimeType": "video/mp2t"}]}`, "no Stream, Command or URL"},
+		{`{"Resources": [{` + res + `, "URL": "http://cam/"}]}`, "more than one"},
+		{`{"Resources": [{"MimeType": "video/mp2t", "URL": "rtsp://cam/"}]}`, "HTTP URL"},
+		{`{"Resources": [{` + res + `, "Reconnect": true}]}`, "without a URL"},
+		{`{"Resources": [{` + res + `, "Resolution": "big"}]}`, "Resolution"},
+		{`{"Resources": [{` + res + `, "DlnaFlags": "fast"}]}`, "fast"},
+		{`{"Resources": [{` + res + `}], "Schedule": [{"Start": "20:00", "Duration": "1h", "Index": 1}]}`, "no resource 1"},
+	} {
+		_, err := parseDynamicStream([]byte(c.json))
+		if c.problem == "" {
+			if err != nil {
+				t.Errorf("%s: %v", c.json, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), c.problem) {
+			t.Errorf("%s: got %v, expected %q", c.json, err, c.problem)
+		}
+	}
+}
+
+func TestCheckDynamicStreams(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/page" {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Write([]byte("ID3\x04\x00"))
+	}))
+	defer remote.Close()
+	root := t.TempDir()
+	files := map[string]string{
+		"radio.dms.json":   fmt.Sprintf(`{"Resources": [{"MimeType": "audio/mpeg", "URL": %q}]}`, remote.URL+"/radio"),
+		"page.dms.json":    fmt.Sprintf(`{"Resources": [{"MimeType": "audio/mpeg", "URL": %q}]}`, remote.URL+"/page"),
+		"blocked.dms.json": `{"Resources": [{"MimeType": "audio/mpeg", "URL": "http://radio.example.com/"}]}`,
+		"cam.dms.json":     `{"Resources": [{"MimeType": "video/mp2t", "Stream": "cam", "Params": {"host": "10.0.0.1"}}]}`,
+		"unknown.dms.json": `{"Resources": [{"MimeType": "video/mp2t", "Stream": "doorbell"}]}`,
+		"command.dms.json": `{"Resources": [{"MimeType": "video/mp2t", "Command": "ffmpeg -i x -f mpegts -"}]}`,
+		"broken.dms.json":  `{"Resources": [`,
+		"movie.mkv":        "",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{
+		Logger:      log.Default,
+		StreamURLs:  []string{remote.URL + "/"},
+		relayClient: remote.Client(),
+		StreamCommands: map[string]StreamCommand{
+			"cam": {Path: "/usr/bin/ffmpeg", Args: []string{"-i", "rtsp://[host]/"}, Params: map[string]string{"host": `[\d.]+`}},
+		},
+	}
+	check := func(dryRun time.Duration) map[string]bool {
+		reported := make(map[string]bool)
+		bad, err := srv.CheckDynamicStreams(root, dryRun, func(path string, err error) {
+			reported[filepath.Base(path)] = true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bad != len(reported) {
+			t.Fatalf("%d bad, %d reported", bad, len(reported))
+		}
+		return reported
+	}
+	reported := check(0)
+	for _, name := range []string{"unknown.dms.json", "command.dms.json", "broken.dms.json", "blocked.dms.json"} {
+		if !reported[name] {
+			t.Errorf("%s not reported", name)
+		}
+	}
+	if len(reported) != 4 {
+		t.Errorf("reported %v", reported)
+	}
+	// Keep the camera out of the dry run, as it would run ffmpeg.
+	delete(srv.StreamCommands, "cam")
+	reported = check(5 * time.Second)
+	if !reported["page.dms.json"] || !reported["blocked.dms.json"] || reported["radio.dms.json"] {
+		t.Errorf("dry run reported %v", reported)
+	}
+}
+
+func TestSniffMimeType(t *testing.T) {
+	for _, c := range []struct {
+		mimeType       string
+		data           string
+		matches, known bool
+	}{
+		{"video/x-matroska", "\x1a\x45\xdf\xa3\x01", true, true},
+		{"video/webm", "<html>", false, true},
+		{"video/mp4", "\x00\x00\x00\x20ftypisom", true, true},
+		{"video/vnd.dlna.mpeg-tts", "G\x00", true, true},
+		{"audio/mp3", "\xff\xfb\x90", true, true},
+		{"audio/flac", "fLaC", true, true},
+		{"video/x-ms-wmv", "anything", false, false},
+	} {
+		matches, known := sniffMimeType(c.mimeType, []byte(c.data))
+		if matches != c.matches || known != c.known {
+			t.Errorf("%s %q: got %v, %v", c.mimeType, c.data, matches, known)
+		}
+	}
+}
+
+func TestBrowseLenientDynamicStream(t *testing.T) {
+	root := t.TempDir()
+	// An unknown key and a Duration the check rejects don't stop the file being browsed.
+	metadata := `{"Title": "Cam", "Colour": "red", "Duration": "21 minutes", "Resources": [{"MimeType": "video/mp2t", "Stream": "cam"}]}`
+	filePath := filepath.Join(root, "cam.dms.json")
+	if err := os.WriteFile(filePath, []byte(metadata), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{Logger: log.Default, RootObjectPath: root, AllowDynamicStreams: true}
+	if err := srv.checkDynamicStreamFile(filePath, 0); err == nil {
+		t.Fatal("expected the check to report the file")
+	}
+	cds := &contentDirectoryService{Server: srv}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := cds.cdsObjectToUpnpavObject(object{"/cam.dms.json", root}, fi, "localhost", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item, ok := obj.(upnpav.Item); !ok || item.Title != "Cam" {
+		t.Fatalf("got %+v", obj)
+	}
+}
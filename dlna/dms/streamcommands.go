@@ -0,0 +1,130 @@
+package dms
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/dms/transcode"
+)
+
+// StreamCommand is a command dynamic streams may run, declared in the server configuration and
+// referred to by name from .dms.json files, along with the parameters it takes.
+type StreamCommand struct {
+	// Absolute path of the executable.
+	Path string
+	// The arguments, in which "[name]" is replaced by the value of the parameter of that name. No
+	// shell is involved, so a parameter can't add arguments.
+	Args []string
+	// The parameters streams give, with a regular expression each value must match in full. An
+	// empty expression allows any value. Streams must give every parameter, and no others.
+	Params map[string]string
+	// The working directory. The system's temporary directory if empty.
+	Dir string
+	// The entire environment of the process, as "key=value" strings.
+	Env []string
+	// How long the process may run, like "4h". No limit if empty.
+	Timeout string
+	// Limits on the CPU seconds, address space in bytes and open files of the process. No limit
+	// if zero. Only supported on Linux.
+	MaxCPUTime   uint64
+	MaxMemory    uint64
+	MaxOpenFiles uint64
+	// The user to run the process as, by name or ID, which usually requires running dms as root.
+	// Only supported on Unix.
+	User string
+}
+
+func (c StreamCommand) validate(name string) error {
+	if !filepath.IsAbs(c.Path) {
+		return fmt.Errorf("stream command %q: path %q is not absolute", name, c.Path)
+	}
+	for param, expr := range c.Params {
+		if _, err := regexp.Compile("^(?:" + expr + ")$"); err != nil {
+			return fmt.Errorf("stream command %q: parameter %q: %w", name, param, err)
+		}
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("stream command %q: %w", name, err)
+		}
+	}
+	if c.User != "" {
+		if _, err := c.credential(); err != nil {
+			return fmt.Errorf("stream command %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (c StreamCommand) credential() (*transcode.Credential, error) {
+	if c.User == "" {
+		return nil, nil
+	}
+	u, err := user.Lookup(c.User)
+	if err != nil {
+		if u, err = user.LookupId(c.User); err != nil {
+			return nil, err
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has no numeric uid", c.User)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has no numeric gid", c.User)
+	}
+	return &transcode.Credential{UID: uint32(uid), GID: uint32(gid)}, nil
+}
+
+// Returns the command line for the parameters a stream gives, after checking them.
+func (c StreamCommand) args(params map[string]string) ([]string, error) {
+	for name := range params {
+		if _, ok := c.Params[name]; !ok {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	names := make([]string, 0, len(c.Params))
+	for name, expr := range c.Params {
+		value, ok := params[name]
+		if !ok {
+			return nil, fmt.Errorf("missing parameter %q", name)
+		}
+		if !regexp.MustCompile("^(?:" + expr + ")$").MatchString(value) {
+			return nil, fmt.Errorf("bad value %q for parameter %q", value, name)
+		}
+		names = append(names, name)
+	}
+	oldnew := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		oldnew = append(oldnew, "["+name+"]", params[name])
+	}
+	r := strings.NewReplacer(oldnew...)
+	args := []string{c.Path}
+	for _, a := range c.Args {
+		args = append(args, r.Replace(a))
+	}
+	return args, nil
+}
+
+func (c StreamCommand) sandbox() (sb transcode.Sandbox, err error) {
+	sb = transcode.Sandbox{
+		Dir:          c.Dir,
+		Env:          c.Env,
+		MaxCPUTime:   c.MaxCPUTime,
+		MaxMemory:    c.MaxMemory,
+		MaxOpenFiles: c.MaxOpenFiles,
+	}
+	if c.Timeout != "" {
+		if sb.Timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return
+		}
+	}
+	sb.Credential, err = c.credential()
+	return
+}
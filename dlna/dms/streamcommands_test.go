@@ -0,0 +1,41 @@
+package dms
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStreamCommandArgs(t *testing.T) {
+	c := StreamCommand{
+		Path:   "/usr/bin/ffmpeg",
+		Args:   []string{"-i", "rtsp://[host]/Streaming/Channels/[channel]/", "-f", "matroska", "-"},
+		Params: map[string]string{"host": `[\w.-]+(:\d+)?`, "channel": `\d+`},
+	}
+	if err := c.validate("webcam"); err != nil {
+		t.Fatal(err)
+	}
+	args, err := c.args(map[string]string{"host": "10.6.8.161:554", "channel": "502"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/usr/bin/ffmpeg", "-i", "rtsp://10.6.8.161:554/Streaming/Channels/502/", "-f", "matroska", "-"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("got %q", args)
+	}
+	for _, params := range []map[string]string{
+		{"host": "10.6.8.161"},
+		{"host": "10.6.8.161", "channel": "502", "extra": "x"},
+		{"host": "10.6.8.161 -y", "channel": "502"},
+		{"host": "10.6.8.161", "channel": "502; rm -rf /"},
+	} {
+		if _, err := c.args(params); err == nil {
+			t.Errorf("expected error for %q", params)
+		}
+	}
+	if err := (StreamCommand{Path: "ffmpeg"}).validate("relative"); err == nil {
+		t.Error("expected error for relative path")
+	}
+	if err := (StreamCommand{Path: "/bin/true", Timeout: "soon"}).validate("timeout"); err == nil {
+		t.Error("expected error for bad timeout")
+	}
+}
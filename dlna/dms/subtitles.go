@@ -0,0 +1,397 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+	"github.com/anacrolix/log"
+
+	"github.com/anacrolix/dms/subtitles"
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// How long extracting an embedded subtitle stream may take. The whole container has to be read.
+const subtitleExtractionTimeout = 2 * time.Minute
+
+// A subtitle track for a video, either a file alongside it or a text stream embedded in it.
+type subtitleTrack struct {
+	// The subtitle file, if external.
+	File string
+	// The index of the stream in the container, if embedded.
+	Stream int
+	// The format served: "srt", "vtt", "ass", "ssa" or "sub". Empty for bitmap subtitles.
+	Format string
+	// Bitmap subtitles can't be served, only burned into transcodes.
+	Bitmap bool
+	// ISO 639 language code, if known.
+	Lang  string
+	Title string
+}
+
+func (t subtitleTrack) embedded() bool {
+	return t.File == ""
+}
+
+func (t subtitleTrack) MimeType() string {
+	if f, ok := t.convertibleFormat(); ok {
+		return f.MimeType()
+	}
+	// MicroDVD, the only other format found.
+	return "text/x-microdvd"
+}
+
+// Subtitle file extensions and the formats they hold.
+var subtitleFileFormats = map[string]string{
+	".srt": "srt",
+	".vtt": "vtt",
+	".ass": "ass",
+	".ssa": "ssa",
+	".sub": "sub",
+}
+
+// Embedded subtitle codecs that can be extracted as text, and the format they're extracted to.
+var embeddedSubtitleFormats = map[string]string{
+	"subrip":   "srt",
+	"mov_text": "srt",
+	"text":     "srt",
+	"webvtt":   "vtt",
+	"ass":      "ass",
+	"ssa":      "ass",
+}
+
+// Returns the subtitle tracks for the video: files named like the video with an optional
+// language, such as "movie.en.srt", followed by the embedded streams.
+func (me *Server) subtitleTracks(filePath string, info *ffprobe.Info) (ret []subtitleTrack) {
+	names, _ := me.dirNames(filepath.Dir(filePath))
+	ret = externalSubtitleTracks(filePath, names)
+	if info == nil {
+		return
+	}
+	for _, stream := range info.Streams {
+		if stream["codec_type"] != "subtitle" {
+			continue
+		}
+		codec, _ := stream["codec_name"].(string)
+		format, ok := embeddedSubtitleFormats[codec]
+		bitmap := transcode.IsBitmapSubtitle(codec)
+		if !ok && !bitmap {
+			continue
+		}
+		index, err := ffprobe.AnyAsInt64(stream["index"])
+		if err != nil {
+			continue
+		}
+		t := subtitleTrack{
+			Stream: int(index),
+			Format: format,
+			Bitmap: bitmap,
+		}
+		if tags, ok := stream["tags"].(map[string]interface{}); ok {
+			t.Lang, _ = tags["language"].(string)
+			t.Title, _ = tags["title"].(string)
+		}
+		if t.Lang == "und" {
+			t.Lang = ""
+		}
+		ret = append(ret, t)
+	}
+	return
+}
+
+// Returns the subtitle files for the video among the names in its directory.
+func externalSubtitleTracks(filePath string, names []string) (ret []subtitleTrack) {
+	dir := filepath.Dir(filePath)
+	base := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	present := make(map[string]bool, len(names))
+	for _, name := range names {
+		present[strings.ToLower(name)] = true
+	}
+	for _, name := range names {
+		ext := strings.ToLower(filepath.Ext(name))
+		format, ok := subtitleFileFormats[ext]
+		if !ok {
+			continue
+		}
+		stem := strings.TrimSuffix(name, filepath.Ext(name))
+		if stem != base && !strings.HasPrefix(stem, base+".") {
+			continue
+		}
+		// A .sub with an .idx is VobSub, which is bitmap based.
+		if format == "sub" && present[strings.ToLower(stem)+".idx"] {
+			continue
+		}
+		t := subtitleTrack{
+			File:   filepath.Join(dir, name),
+			Stream: -1,
+			Format: format,
+		}
+		if qualifiers := strings.TrimPrefix(stem, base+"."); qualifiers != stem {
+			t.Title = qualifiers
+			parts := strings.Split(qualifiers, ".")
+			if lang := parts[len(parts)-1]; isLanguageCode(lang) {
+				t.Lang = lang
+			}
+		}
+		ret = append(ret, t)
+	}
+	return
+}
+
+// Returns true for strings shaped like ISO 639 codes, optionally with a region, like "en", "ger"
+// or "pt-BR".
+func isLanguageCode(s string) bool {
+	lang, region, hasRegion := strings.Cut(s, "-")
+	if len(lang) < 2 || len(lang) > 3 || (hasRegion && len(region) != 2) {
+		return false
+	}
+	for _, r := range lang + region {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the URL of a subtitle track, converted to the format given if it's not empty.
+func subtitleURL(host, path string, track int, format subtitles.Format) string {
+	q := url.Values{
+		"path":  {path},
+		"track": {strconv.Itoa(track)},
+	}
+	if format != "" {
+		q.Set("format", string(format))
+	}
+	return (&url.URL{
+		Scheme:   "http",
+		Host:     host,
+		Path:     subtitlePath,
+		RawQuery: q.Encode(),
+	}).String()
+}
+
+// Returns the format the track can be converted from, if it can be.
+func (t subtitleTrack) convertibleFormat() (subtitles.Format, bool) {
+	f, err := subtitles.ParseFormat(t.Format)
+	return f, err == nil
+}
+
+// Each track is offered as is, and converted to SRT if it's in another format that can be
+// converted, as that's what most renderers understand.
+func subtitleResources(host, path string, tracks []subtitleTrack) (ret []upnpav.Resource) {
+	for i, t := range tracks {
+		if t.Bitmap {
+			continue
+		}
+		ret = append(ret, upnpav.Resource{
+			URL:          subtitleURL(host, path, i, ""),
+			ProtocolInfo: fmt.Sprintf("http-get:*:%s:*", t.MimeType()),
+			Language:     t.Lang,
+		})
+		if f, ok := t.convertibleFormat(); ok && f != subtitles.SRT {
+			ret = append(ret, upnpav.Resource{
+				URL:          subtitleURL(host, path, i, subtitles.SRT),
+				ProtocolInfo: fmt.Sprintf("http-get:*:%s:*", subtitles.SRT.MimeType()),
+				Language:     t.Lang,
+			})
+		}
+	}
+	return
+}
+
+// Returns the URL Samsung and Panasonic renderers should be pointed at for captions. They only
+// take one, and only understand SRT, so a track already in SRT is preferred over converting one.
+func captionURL(host, path string, tracks []subtitleTrack) (string, bool) {
+	for i, t := range tracks {
+		if t.Format == "srt" {
+			return subtitleURL(host, path, i, ""), true
+		}
+	}
+	for i, t := range tracks {
+		if _, ok := t.convertibleFormat(); ok {
+			return subtitleURL(host, path, i, subtitles.SRT), true
+		}
+	}
+	return "", false
+}
+
+// Adds the subtitle tracks to the video item, along with the vendor specific elements renderers
+// use to find captions.
+func addSubtitles(item *upnpav.Item, host, path, userAgent string, tracks []subtitleTrack) {
+	item.Res = append(item.Res, subtitleResources(host, path, tracks)...)
+	captionURL, ok := captionURL(host, path, tracks)
+	if !ok {
+		return
+	}
+	item.CaptionInfoEx = append(item.CaptionInfoEx, upnpav.SecCaptionInfo{
+		Type: "srt",
+		URL:  captionURL,
+	})
+	if strings.Contains(userAgent, "Panasonic") && len(item.Res) != 0 {
+		item.Res[0].PVSubtitleFileURI = captionURL
+		item.Res[0].PVSubtitleFileType = "SRT"
+	}
+}
+
+// Samsung renderers request the caption URL for a video with a header when fetching it.
+func (me *Server) setCaptionInfoHeader(w http.ResponseWriter, r *http.Request, filePath string) {
+	if r.Header.Get("getCaptionInfo.sec") != "1" {
+		return
+	}
+	var info *ffprobe.Info
+	if !me.NoProbe {
+		info, _ = me.ffmpegProbe(filePath)
+	}
+	tracks := me.subtitleTracks(filePath, info)
+	if u, ok := captionURL(r.Host, r.URL.Query().Get("path"), tracks); ok {
+		w.Header().Set("CaptionInfo.sec", u)
+	}
+}
+
+// Parses a subtitle time offset, either a duration like "-1.5s" or a number of seconds.
+func parseSubtitleOffset(s string) (time.Duration, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad offset %q", s)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// Extracts an embedded subtitle stream with ffmpeg, in the track's format.
+func extractSubtitle(filePath string, t subtitleTrack) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), subtitleExtractionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-nostdin", "-v", "error",
+		"-i", filePath,
+		"-map", fmt.Sprintf("0:%d", t.Stream),
+		"-f", map[string]string{"srt": "srt", "vtt": "webvtt", "ass": "ass"}[t.Format],
+		"pipe:",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("extracting subtitle stream %d: %w: %s", t.Stream, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return out, nil
+}
+
+// Serves a subtitle track of a video. The track query parameter indexes the tracks listed for the
+// item in the CDS, defaulting to the first. The track is converted if a format of "srt", "vtt" or
+// "ass" is given, and its cues moved by an offset if one is given.
+func (me *Server) serveSubtitle(w http.ResponseWriter, r *http.Request) {
+	filePath := me.filePath(r.URL.Query().Get("path"))
+	if ignored, err := me.IgnorePath(filePath); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if ignored {
+		http.Error(w, "no such object", http.StatusNotFound)
+		return
+	}
+	fi, err := os.Stat(filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	index := 0
+	if s := r.URL.Query().Get("track"); s != "" {
+		index, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "bad track", http.StatusBadRequest)
+			return
+		}
+	}
+	var info *ffprobe.Info
+	if !me.NoProbe {
+		info, _ = me.ffmpegProbe(filePath)
+	}
+	tracks := me.subtitleTracks(filePath, info)
+	if index < 0 || index >= len(tracks) {
+		http.Error(w, "no such subtitle track", http.StatusNotFound)
+		return
+	}
+	t := tracks[index]
+	if t.Bitmap {
+		http.Error(w, "bitmap subtitles can only be burned into transcodes", http.StatusUnsupportedMediaType)
+		return
+	}
+	var (
+		to     subtitles.Format
+		offset time.Duration
+	)
+	if s := r.URL.Query().Get("format"); s != "" {
+		to, err = subtitles.ParseFormat(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if s := r.URL.Query().Get("offset"); s != "" {
+		offset, err = parseSubtitleOffset(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var body []byte
+	modTime := fi.ModTime()
+	if t.embedded() {
+		body, err = me.cache.getOrCreate(filePath, fi, fmt.Sprintf("subtitle:%d:%s", t.Stream, t.Format), func() ([]byte, error) {
+			return extractSubtitle(filePath, t)
+		})
+		if err != nil {
+			me.Logger.Levelf(log.Debug, "error extracting subtitle from %q: %v", filePath, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	} else {
+		body, err = os.ReadFile(t.File)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Files are served in UTF-8 even unconverted, as renderers like Samsung's expect it.
+		body = subtitles.ToUTF8(body)
+		if subFi, err := os.Stat(t.File); err == nil {
+			modTime = subFi.ModTime()
+		}
+	}
+	mimeType := t.MimeType()
+	if to != "" || offset != 0 {
+		from, ok := t.convertibleFormat()
+		if !ok {
+			http.Error(w, fmt.Sprintf("can't convert %s subtitles", t.Format), http.StatusUnsupportedMediaType)
+			return
+		}
+		if to == "" {
+			to = from
+		}
+		var buf bytes.Buffer
+		if err := subtitles.Convert(&buf, body, from, to, offset); err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		body, mimeType = buf.Bytes(), to.MimeType()
+	}
+	// ffmpeg and the converter always write UTF-8, and files are converted to it.
+	w.Header().Set("Content-Type", mimeType+"; charset=utf-8")
+	http.ServeContent(w, r, "", modTime, bytes.NewReader(body))
+}
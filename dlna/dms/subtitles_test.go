@@ -0,0 +1,85 @@
+package dms
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestExternalSubtitleTracks(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"movie.mkv",
+		"movie.srt",
+		"movie.de.ass",
+		"movie.forced.en.vtt",
+		"movie.sub",
+		"movie.idx",
+		"movie2.srt",
+		"other.en.srt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := readDirNames(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracks := externalSubtitleTracks(filepath.Join(dir, "movie.mkv"), names)
+	expected := []subtitleTrack{
+		{File: filepath.Join(dir, "movie.de.ass"), Stream: -1, Format: "ass", Lang: "de", Title: "de"},
+		{File: filepath.Join(dir, "movie.forced.en.vtt"), Stream: -1, Format: "vtt", Lang: "en", Title: "forced.en"},
+		{File: filepath.Join(dir, "movie.srt"), Stream: -1, Format: "srt"},
+	}
+	if !reflect.DeepEqual(tracks, expected) {
+		t.Fatalf("got %+v, expected %+v", tracks, expected)
+	}
+	if u, ok := captionURL("host", "/movie.mkv", tracks); !ok || u != "http://host/subtitle?path=%2Fmovie.mkv&track=2" {
+		t.Errorf("caption url: got %q, %v", u, ok)
+	}
+	if u, _ := captionURL("host", "/movie.mkv", tracks[:1]); u != "http://host/subtitle?format=srt&path=%2Fmovie.mkv&track=0" {
+		t.Errorf("converted caption url: got %q", u)
+	}
+}
+
+func TestIsLanguageCode(t *testing.T) {
+	for _, s := range []string{"en", "ger", "pt-BR"} {
+		if !isLanguageCode(s) {
+			t.Errorf("%q should be a language code", s)
+		}
+	}
+	for _, s := range []string{"forced", "e", "en-", "sdh1"} {
+		if isLanguageCode(s) {
+			t.Errorf("%q shouldn't be a language code", s)
+		}
+	}
+}
+
+func TestServeSubtitleFileAsUTF8(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"movie.mkv": "",
+		// "Café" in Windows-1252.
+		"movie.srt": "1\r\n00:00:01,000 --> 00:00:02,000\r\nCaf\xe9\r\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{RootObjectPath: dir, NoProbe: true}
+	w := httptest.NewRecorder()
+	srv.serveSubtitle(w, httptest.NewRequest("GET", subtitlePath+"?path=/movie.mkv&track=0", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("%d %s", w.Code, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/srt; charset=utf-8" {
+		t.Errorf("Content-Type %q", ct)
+	}
+	if body := w.Body.String(); body != "1\r\n00:00:01,000 --> 00:00:02,000\r\nCafé\r\n" {
+		t.Errorf("body %q", body)
+	}
+}
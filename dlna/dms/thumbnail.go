@@ -0,0 +1,185 @@
+package dms
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nfnt/resize"
+
+	"github.com/anacrolix/dms/misc"
+)
+
+const (
+	// Thumbnails fit within this square, as required by the JPEG_TN and PNG_TN profiles.
+	thumbnailSize = 160
+	// The default position in videos to take thumbnails from.
+	defaultThumbnailPosition = "10%"
+	// Further positions tried, as fractions of the duration, when a frame turns out to be black.
+	thumbnailRetryStep = 0.1
+	thumbnailAttempts  = 4
+	// Frames with fewer than this fraction of non-dark pixels are considered black.
+	blackFrameBrightFraction = 0.02
+	frameExtractionTimeout   = 30 * time.Second
+)
+
+// Returns the offset into a video of the given duration to take a thumbnail from. The position is
+// either a percentage of the duration like "10%", a duration like "1m30s", or "random".
+func thumbnailOffset(position string, duration time.Duration) (time.Duration, error) {
+	if position == "" {
+		position = defaultThumbnailPosition
+	}
+	if position == "random" {
+		return time.Duration(rand.Float64() * 0.9 * float64(duration)), nil
+	}
+	if strings.HasSuffix(position, "%") {
+		pct, err := strconv.ParseFloat(strings.TrimSuffix(position, "%"), 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad thumbnail position %q: %w", position, err)
+		}
+		return time.Duration(pct / 100 * float64(duration)), nil
+	}
+	offset, err := time.ParseDuration(position)
+	if err != nil {
+		return 0, fmt.Errorf("bad thumbnail position %q: %w", position, err)
+	}
+	if duration > 0 && offset >= duration {
+		offset = duration / 2
+	}
+	return offset, nil
+}
+
+// Returns an encoded thumbnail of the media file in the image MIME-type given, which must be
+// image/jpeg or image/png. Audio files yield their album art. Results are cached on disk.
+func (me *Server) thumbnail(filePath string, fi os.FileInfo, mimeType mimeType, thumbType string) ([]byte, error) {
+	return me.cache.getOrCreate(filePath, fi, "thumbnail:"+thumbType, func() ([]byte, error) {
+		var img image.Image
+		var err error
+		switch {
+		case mimeType.IsImage():
+			img, err = loadOrientedImage(filePath, thumbnailSize, thumbnailSize)
+		case mimeType.IsAudio():
+			src := me.albumArt(filePath, fi)
+			if !src.found() {
+				return nil, errNoAlbumArt
+			}
+			img, err = me.loadAlbumArt(src)
+		default:
+			img, err = me.videoThumbnail(filePath)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return encodeImage(img, thumbType)
+	})
+}
+
+// Extracts a frame from the video with ffmpeg, skipping frames that are black.
+func (me *Server) videoThumbnail(filePath string) (image.Image, error) {
+	var duration time.Duration
+	if !me.NoProbe {
+		if info, err := me.ffmpegProbe(filePath); err == nil {
+			duration = getDurationFromFFInfo(info)
+		}
+	}
+	offset, err := thumbnailOffset(me.ThumbnailPosition, duration)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		best           image.Image
+		bestBrightness = -1.
+	)
+	for i := 0; i < thumbnailAttempts; i++ {
+		img, err := extractFrame(filePath, offset)
+		if err != nil {
+			if best != nil {
+				break
+			}
+			return nil, err
+		}
+		brightness := brightFraction(img)
+		if brightness > bestBrightness {
+			best, bestBrightness = img, brightness
+		}
+		if brightness >= blackFrameBrightFraction || duration <= 0 {
+			break
+		}
+		offset += time.Duration(thumbnailRetryStep * float64(duration))
+		if offset >= duration {
+			break
+		}
+	}
+	return resize.Thumbnail(thumbnailSize, thumbnailSize, best, resize.Lanczos3), nil
+}
+
+// Limits how many ffmpegs extract frames at once, as browsing a folder of videos asks for all their
+// thumbnails together.
+var frameExtractionSem = make(chan struct{}, 2)
+
+// Decodes a single frame at the offset into the file using ffmpeg. The frame is scaled down
+// somewhat so there's less to decode and analyse.
+func extractFrame(filePath string, offset time.Duration) (image.Image, error) {
+	frameExtractionSem <- struct{}{}
+	defer func() { <-frameExtractionSem }()
+	ctx, cancel := context.WithTimeout(context.Background(), frameExtractionTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-nostdin", "-v", "error",
+		"-ss", misc.FormatDurationSexagesimal(offset),
+		"-i", filePath,
+		"-map", "0:v:0",
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale='min(%d,iw)':-2", 2*thumbnailSize),
+		"-f", "image2pipe",
+		"-c:v", "png",
+		"pipe:",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("extracting frame at %s: %w: %s", offset, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	if len(out) == 0 {
+		return nil, errors.New("no frame extracted")
+	}
+	img, _, err := image.Decode(bytes.NewReader(out))
+	return img, err
+}
+
+// Returns the fraction of sampled pixels in the image that aren't close to black.
+func brightFraction(img image.Image) float64 {
+	b := img.Bounds()
+	step := 1
+	for (b.Dx()/step)*(b.Dy()/step) > 10000 {
+		step++
+	}
+	var bright, total int
+	for y := b.Min.Y; y < b.Max.Y; y += step {
+		for x := b.Min.X; x < b.Max.X; x += step {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			// Rec. 601 luma, in the range of 16 bit colour components.
+			luma := (299*r + 587*g + 114*bl) / 1000
+			if luma > 0x2000 {
+				bright++
+			}
+			total++
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(bright) / float64(total)
+}
@@ -0,0 +1,269 @@
+package dms
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/anacrolix/ffprobe"
+
+	"github.com/anacrolix/dms/transcode"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// The most alternative audio and subtitle combinations offered per transcode of a video.
+const maxTrackCombinations = 8
+
+// RendererTrackPreferences overrides the preferred audio and subtitle languages for renderers
+// matching by User-Agent or address.
+type RendererTrackPreferences struct {
+	// Matches renderers with a User-Agent containing this, ignoring case.
+	UserAgent string
+	// Matches renderers with these addresses, given as IPs or CIDR networks.
+	IPs               []string
+	AudioLanguages    []string
+	SubtitleLanguages []string
+}
+
+func (p RendererTrackPreferences) validate() error {
+	for _, s := range p.IPs {
+		if _, err := parseIPNet(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p RendererTrackPreferences) matches(ip net.IP, userAgent string) bool {
+	if p.UserAgent != "" && strings.Contains(strings.ToLower(userAgent), strings.ToLower(p.UserAgent)) {
+		return true
+	}
+	for _, s := range p.IPs {
+		if ipNet, err := parseIPNet(s); err == nil && ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Parses an IP as a single address network, or a CIDR network.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * len(ip.To4())
+		if bits == 0 {
+			bits = 8 * net.IPv6len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("bad ip or network %q", s)
+	}
+	return ipNet, nil
+}
+
+// Returns the preferred audio and subtitle languages for the renderer making the request. The
+// first matching renderer preferences apply, falling back to the server's.
+func (me *Server) trackPreferences(r *http.Request) (audio, subtitle []string) {
+	ip := net.ParseIP(requestClientIP(r))
+	for _, p := range me.RendererTrackPreferences {
+		if p.matches(ip, r.UserAgent()) {
+			return p.AudioLanguages, p.SubtitleLanguages
+		}
+	}
+	return me.PreferredAudioLanguages, me.PreferredSubtitleLanguages
+}
+
+// An audio stream of a media file.
+type audioTrack struct {
+	// The index of the stream in the container.
+	Stream int
+	Lang   string
+}
+
+func audioTracks(info *ffprobe.Info) (ret []audioTrack) {
+	if info == nil {
+		return
+	}
+	for _, stream := range info.Streams {
+		if stream["codec_type"] != "audio" {
+			continue
+		}
+		index, err := ffprobe.AnyAsInt64(stream["index"])
+		if err != nil {
+			continue
+		}
+		t := audioTrack{Stream: int(index)}
+		if tags, ok := stream["tags"].(map[string]interface{}); ok {
+			t.Lang, _ = tags["language"].(string)
+		}
+		ret = append(ret, t)
+	}
+	return
+}
+
+// Returns the index of the first track in the most preferred language.
+func preferredTrack(langs []string, n int, trackLang func(int) string) (int, bool) {
+	for _, lang := range langs {
+		for i := 0; i < n; i++ {
+			if languagesMatch(lang, trackLang(i)) {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Resolves an audio or subtitle query parameter, which is either a track index or a language.
+func selectTrack(param string, n int, trackLang func(int) string) (int, error) {
+	if i, err := strconv.Atoi(param); err == nil {
+		if i < 0 || i >= n {
+			return 0, fmt.Errorf("no track %d", i)
+		}
+		return i, nil
+	}
+	if i, ok := preferredTrack([]string{param}, n, trackLang); ok {
+		return i, nil
+	}
+	return 0, fmt.Errorf("no track in language %q", param)
+}
+
+// Returns the options for a transcode of the file requested. The audio and subtitle query
+// parameters choose tracks by index or language, and the subtitle "none" turns subtitles off.
+// Without them, the renderer's preferred languages are used: audio in the most preferred language
+// available, and subtitles in a preferred language if the audio isn't in one.
+func (me *Server) transcodeOptions(r *http.Request, filePath string, info *ffprobe.Info) (opts transcode.Options, err error) {
+	opts = transcode.DefaultOptions()
+	audioLangs, subtitleLangs := me.trackPreferences(r)
+	q := r.URL.Query()
+
+	audios := audioTracks(info)
+	audioLang := func(i int) string { return audios[i].Lang }
+	selectedAudio := -1
+	if s := q.Get("audio"); s != "" {
+		selectedAudio, err = selectTrack(s, len(audios), audioLang)
+		if err != nil {
+			return
+		}
+	} else if i, ok := preferredTrack(audioLangs, len(audios), audioLang); ok {
+		selectedAudio = i
+	}
+	if selectedAudio >= 0 {
+		opts.AudioStream = audios[selectedAudio].Stream
+	}
+
+	subs := me.subtitleTracks(filePath, info)
+	subtitleLang := func(i int) string { return subs[i].Lang }
+	selectedSub := -1
+	switch s := q.Get("subtitle"); s {
+	case "none":
+	case "":
+		if selectedAudio >= 0 && len(audioLangs) != 0 && languageWanted(audioLangs, audios[selectedAudio].Lang) {
+			// The audio is already in a preferred language.
+			break
+		}
+		if i, ok := preferredTrack(subtitleLangs, len(subs), subtitleLang); ok {
+			selectedSub = i
+		}
+	default:
+		selectedSub, err = selectTrack(s, len(subs), subtitleLang)
+		if err != nil {
+			return
+		}
+	}
+	if selectedSub >= 0 {
+		if t := subs[selectedSub]; t.embedded() {
+			opts.SubtitleStream = t.Stream
+		} else {
+			opts.SubtitleFile = t.File
+		}
+	}
+	return
+}
+
+// Returns true if the language is one of those given, or if none are given.
+func languageWanted(langs []string, lang string) bool {
+	if len(langs) == 0 {
+		return true
+	}
+	for _, l := range langs {
+		if languagesMatch(l, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the languages preferred by the server and any renderer.
+func (me *Server) allPreferredLanguages() (audio, subtitle []string) {
+	audio = append(audio, me.PreferredAudioLanguages...)
+	subtitle = append(subtitle, me.PreferredSubtitleLanguages...)
+	for _, p := range me.RendererTrackPreferences {
+		audio = append(audio, p.AudioLanguages...)
+		subtitle = append(subtitle, p.SubtitleLanguages...)
+	}
+	return
+}
+
+// Returns the query parameters for the audio and subtitle combinations worth offering beyond the
+// default: each audio track and subtitle track in a language someone prefers, or every track if
+// there are no preferences, and subtitled versions of each of those audio tracks.
+func (me *Server) trackCombinations(filePath string, info *ffprobe.Info) (ret []url.Values) {
+	audioLangs, subtitleLangs := me.allPreferredLanguages()
+	audioParams := []string{""}
+	if audios := audioTracks(info); len(audios) > 1 {
+		for i, t := range audios {
+			if languageWanted(audioLangs, t.Lang) {
+				audioParams = append(audioParams, strconv.Itoa(i))
+			}
+		}
+	}
+	subtitleParams := []string{""}
+	for i, t := range me.subtitleTracks(filePath, info) {
+		if languageWanted(subtitleLangs, t.Lang) {
+			subtitleParams = append(subtitleParams, strconv.Itoa(i))
+		}
+	}
+	for _, a := range audioParams {
+		for _, s := range subtitleParams {
+			if a == "" && s == "" {
+				continue
+			}
+			if len(ret) == maxTrackCombinations {
+				return
+			}
+			v := url.Values{}
+			if a != "" {
+				v.Set("audio", a)
+			}
+			// An explicit choice of no subtitles, so preferences don't add any.
+			if s == "" {
+				s = "none"
+			}
+			v.Set("subtitle", s)
+			ret = append(ret, v)
+		}
+	}
+	return
+}
+
+// Returns the transcode resources for each audio and subtitle combination worth offering, for the
+// transcodes that can select streams.
+func (me *Server) trackCombinationResources(host, path, filePath, resolution, duration string, specs map[string]transcodeSpec, info *ffprobe.Info) (ret []upnpav.Resource) {
+	combos := me.trackCombinations(filePath, info)
+	if len(combos) == 0 {
+		return
+	}
+	for k, v := range specs {
+		if v.transcodeWithOptions == nil {
+			continue
+		}
+		for _, combo := range combos {
+			ret = append(ret, transcodeResource(host, path, resolution, duration, k, v, info, combo))
+		}
+	}
+	return
+}
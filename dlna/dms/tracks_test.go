@@ -0,0 +1,118 @@
+package dms
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func testTrackInfo() *ffprobe.Info {
+	stream := func(index, codecType, codec, lang string) map[string]interface{} {
+		return map[string]interface{}{
+			"index":      json.Number(index),
+			"codec_type": codecType,
+			"codec_name": codec,
+			"tags":       map[string]interface{}{"language": lang},
+		}
+	}
+	return &ffprobe.Info{Streams: []map[string]interface{}{
+		stream("0", "video", "h264", "und"),
+		stream("1", "audio", "ac3", "ger"),
+		stream("2", "audio", "aac", "eng"),
+		stream("3", "subtitle", "subrip", "eng"),
+		stream("4", "subtitle", "hdmv_pgs_subtitle", "ger"),
+	}}
+}
+
+func TestTranscodeOptions(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "movie.mkv")
+	for _, name := range []string{"movie.mkv", "movie.fr.srt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := &Server{
+		PreferredAudioLanguages:    []string{"en"},
+		PreferredSubtitleLanguages: []string{"en"},
+		RendererTrackPreferences: []RendererTrackPreferences{{
+			UserAgent:         "samsung",
+			AudioLanguages:    []string{"de"},
+			SubtitleLanguages: []string{"fre"},
+		}},
+	}
+	for _, tc := range []struct {
+		query, userAgent string
+		audio, subtitle  int
+		subtitleFile     string
+	}{
+		// English audio is preferred, so no subtitles are needed.
+		{"", "", 2, -1, ""},
+		{"audio=de", "", 1, 3, ""},
+		{"audio=0&subtitle=none", "", 1, -1, ""},
+		{"subtitle=2", "", 2, 4, ""},
+		{"subtitle=fr", "", 2, -1, filepath.Join(dir, "movie.fr.srt")},
+		{"", "SAMSUNG TV", 1, -1, ""},
+		{"audio=en", "Samsung TV", 2, -1, filepath.Join(dir, "movie.fr.srt")},
+	} {
+		r := httptest.NewRequest("GET", "/res?"+tc.query, nil)
+		r.Header.Set("User-Agent", tc.userAgent)
+		opts, err := srv.transcodeOptions(r, filePath, testTrackInfo())
+		if err != nil {
+			t.Fatalf("%q: %v", tc.query, err)
+		}
+		if opts.AudioStream != tc.audio || opts.SubtitleStream != tc.subtitle || opts.SubtitleFile != tc.subtitleFile {
+			t.Errorf("%q, %q: got %+v", tc.query, tc.userAgent, opts)
+		}
+	}
+	r := httptest.NewRequest("GET", "/res?audio=5", nil)
+	if _, err := srv.transcodeOptions(r, filePath, testTrackInfo()); err == nil {
+		t.Error("expected error for missing audio track")
+	}
+}
+
+func TestTrackCombinations(t *testing.T) {
+	srv := &Server{
+		PreferredAudioLanguages:    []string{"de"},
+		PreferredSubtitleLanguages: []string{"en"},
+	}
+	var actual []string
+	for _, v := range srv.trackCombinations(filepath.Join(t.TempDir(), "movie.mkv"), testTrackInfo()) {
+		actual = append(actual, v.Encode())
+	}
+	expected := []string{
+		"subtitle=0",
+		"audio=0&subtitle=none",
+		"audio=0&subtitle=0",
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("got %q", actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("got %q", actual)
+		}
+	}
+}
+
+func TestLanguagesMatch(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"de", "ger", true},
+		{"deu", "GER", true},
+		{"pt-BR", "por", true},
+		{"en", "fre", false},
+		{"", "", false},
+		{"tlh", "tlh", true},
+	} {
+		if languagesMatch(tc.a, tc.b) != tc.expected {
+			t.Errorf("%q, %q: expected %v", tc.a, tc.b, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package dms
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// How long clients are asked to wait before retrying when all transcode slots are taken.
+const transcodeRetryAfter = 10 * time.Second
+
+// A running transcode, as reported in the logs and on the status endpoint.
+type transcodeSession struct {
+	ID      int
+	Client  string
+	Path    string
+	Spec    string
+	Started time.Time
+	cancel  context.CancelFunc
+}
+
+// Bounds the number of transcodes running at once, both globally and per client. A zero limit
+// means unlimited.
+type transcodeSlots struct {
+	mu           sync.Mutex
+	max          int
+	maxPerClient int
+	nextID       int
+	// Ordered by start time, oldest first.
+	sessions []*transcodeSession
+	logger   log.Logger
+}
+
+func (me *transcodeSlots) clientSessions(client string) (ret []*transcodeSession) {
+	for _, s := range me.sessions {
+		if s.Client == client {
+			ret = append(ret, s)
+		}
+	}
+	return
+}
+
+// Takes a slot for a new transcode. A client starting a transcode of another file has its
+// transcodes of other files cancelled, since it has evidently moved on to a new stream. Those of
+// the same file are kept, as renderers overlap requests to probe, play and seek one stream, unless
+// the client is at its limit, when its oldest are cancelled to make room. Returns !ok if there's
+// no global slot available.
+func (me *transcodeSlots) acquire(
+	parent context.Context, client, path, spec string,
+) (s *transcodeSession, ctx context.Context, ok bool) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	for _, o := range me.clientSessions(client) {
+		if o.Path != path {
+			me.logger.Printf("cancelling transcode %d of %q for %s: client started a new stream", o.ID, o.Path, client)
+			me.remove(o)
+		}
+	}
+	if me.maxPerClient > 0 {
+		existing := me.clientSessions(client)
+		for len(existing) >= me.maxPerClient {
+			me.logger.Printf("cancelling transcode %d of %q for %s: client at its limit of %d transcodes", existing[0].ID, existing[0].Path, client, me.maxPerClient)
+			me.remove(existing[0])
+			existing = existing[1:]
+		}
+	}
+	if me.max > 0 && len(me.sessions) >= me.max {
+		me.logger.Printf("refusing transcode of %q for %s: all %d transcode slots in use", path, client, me.max)
+		return
+	}
+	me.nextID++
+	ctx, cancel := context.WithCancel(parent)
+	s = &transcodeSession{
+		ID:      me.nextID,
+		Client:  client,
+		Path:    path,
+		Spec:    spec,
+		Started: time.Now(),
+		cancel:  cancel,
+	}
+	me.sessions = append(me.sessions, s)
+	me.logSlots()
+	ok = true
+	return
+}
+
+// Frees the slot held by the session. It's safe to release a session more than once.
+func (me *transcodeSlots) release(s *transcodeSession) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	if me.remove(s) {
+		me.logSlots()
+	}
+}
+
+func (me *transcodeSlots) remove(s *transcodeSession) bool {
+	s.cancel()
+	for i, o := range me.sessions {
+		if o == s {
+			me.sessions = append(me.sessions[:i], me.sessions[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (me *transcodeSlots) logSlots() {
+	limit := "unlimited"
+	if me.max > 0 {
+		limit = strconv.Itoa(me.max)
+	}
+	me.logger.Printf("transcode slots: %d/%s in use", len(me.sessions), limit)
+}
+
+type transcodeSlotsStatus struct {
+	InUse          int
+	Limit          int
+	PerClientLimit int
+	Sessions       []transcodeSession
+}
+
+func (me *transcodeSlots) status() (ret transcodeSlotsStatus) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+	ret.InUse = len(me.sessions)
+	ret.Limit = me.max
+	ret.PerClientLimit = me.maxPerClient
+	for _, s := range me.sessions {
+		ret.Sessions = append(ret.Sessions, *s)
+	}
+	return
+}
+
+// Returns the IP address of the client that made the request, without any port or IPv6 zone.
+func requestClientIP(r *http.Request) string {
+	clientIp, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIp = r.RemoteAddr
+	}
+	if zoneDelimiterIdx := strings.Index(clientIp, "%"); zoneDelimiterIdx != -1 {
+		// IPv6 addresses may have the form address%zone (e.g. ::1%eth0)
+		clientIp = clientIp[:zoneDelimiterIdx]
+	}
+	return clientIp
+}
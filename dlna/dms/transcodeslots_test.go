@@ -0,0 +1,68 @@
+package dms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/anacrolix/log"
+)
+
+func TestTranscodeSlotsNewStreamCancelsOthers(t *testing.T) {
+	slots := &transcodeSlots{logger: log.Default}
+	first, firstCtx, ok := slots.acquire(context.Background(), "10.0.0.2", "/a.mkv", "t")
+	if !ok {
+		t.Fatal("expected first transcode to be allowed")
+	}
+	// A probe or seek overlapping the first request for the same file.
+	_, sameCtx, ok := slots.acquire(context.Background(), "10.0.0.2", "/a.mkv", "t")
+	if !ok || firstCtx.Err() != nil {
+		t.Fatal("expected transcodes of the same file to run side by side")
+	}
+	if _, _, ok := slots.acquire(context.Background(), "10.0.0.3", "/b.mkv", "t"); !ok || firstCtx.Err() != nil {
+		t.Fatal("expected other clients' transcodes to be left alone")
+	}
+	if _, _, ok := slots.acquire(context.Background(), "10.0.0.2", "/b.mkv", "t"); !ok {
+		t.Fatal("expected new stream to be allowed")
+	}
+	if firstCtx.Err() == nil || sameCtx.Err() == nil {
+		t.Fatal("expected the client's transcodes of the other file to be cancelled")
+	}
+	if n := slots.status().InUse; n != 2 {
+		t.Fatalf("expected 2 slots in use, got %d", n)
+	}
+	// Releasing an already cancelled session must not free another one.
+	slots.release(first)
+	if n := slots.status().InUse; n != 2 {
+		t.Fatalf("expected 2 slots in use, got %d", n)
+	}
+}
+
+func TestTranscodeSlotsPerClientCancelsOldest(t *testing.T) {
+	slots := &transcodeSlots{maxPerClient: 1, logger: log.Default}
+	_, firstCtx, ok := slots.acquire(context.Background(), "10.0.0.2", "/a.mkv", "t")
+	if !ok {
+		t.Fatal("expected first transcode to be allowed")
+	}
+	_, _, ok = slots.acquire(context.Background(), "10.0.0.2", "/a.mkv", "t")
+	if !ok {
+		t.Fatal("expected second transcode to replace the first")
+	}
+	if firstCtx.Err() == nil {
+		t.Fatal("expected the older session to be cancelled")
+	}
+	if n := slots.status().InUse; n != 1 {
+		t.Fatalf("expected 1 slot in use, got %d", n)
+	}
+}
+
+func TestTranscodeSlotsGlobalLimit(t *testing.T) {
+	slots := &transcodeSlots{max: 2, logger: log.Default}
+	for _, client := range []string{"10.0.0.2", "10.0.0.3"} {
+		if _, _, ok := slots.acquire(context.Background(), client, "/a.mkv", "t"); !ok {
+			t.Fatalf("expected transcode for %s to be allowed", client)
+		}
+	}
+	if _, _, ok := slots.acquire(context.Background(), "10.0.0.4", "/a.mkv", "t"); ok {
+		t.Fatal("expected transcode beyond the global limit to be refused")
+	}
+}
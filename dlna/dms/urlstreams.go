@@ -0,0 +1,144 @@
+package dms
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/anacrolix/dms/relay"
+	"github.com/anacrolix/dms/transcode"
+)
+
+// MIME-types that name the same format, by the one used here.
+var mimeTypeAliases = map[string]string{
+	"audio/mp3":               "audio/mpeg",
+	"audio/x-mpeg":            "audio/mpeg",
+	"audio/aacp":              "audio/aac",
+	"audio/x-aac":             "audio/aac",
+	"video/vnd.dlna.mpeg-tts": "video/mp2t",
+	"video/mpegts":            "video/mp2t",
+	"video/x-mkv":             "video/x-matroska",
+	"audio/x-flac":            "audio/flac",
+	"application/ogg":         "audio/ogg",
+}
+
+func canonicalMimeType(s string) string {
+	mt, _, err := mime.ParseMediaType(s)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(s))
+	}
+	if alias, ok := mimeTypeAliases[mt]; ok {
+		return alias
+	}
+	return mt
+}
+
+// The ffmpeg formats remote streams can be remuxed to, by MIME-type.
+var remuxFormats = map[string]string{
+	"video/mp2t":       "mpegts",
+	"video/mpeg":       "mpegts",
+	"video/mp4":        "mp4",
+	"video/x-matroska": "matroska",
+	"video/webm":       "webm",
+	"audio/mpeg":       "mp3",
+	"audio/aac":        "adts",
+	"audio/flac":       "flac",
+	"audio/ogg":        "ogg",
+	"audio/wav":        "wav",
+}
+
+// Checks an entry of Server.StreamURLs.
+func validateStreamURL(s string) error {
+	if !strings.Contains(s, "://") {
+		if s == "" || strings.ContainsAny(s, "/?#@") {
+			return fmt.Errorf("stream URL host %q is invalid", s)
+		}
+		return nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("stream URL %q: %w", s, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("stream URL %q isn't an HTTP URL", s)
+	}
+	return nil
+}
+
+// Returns an error if dynamic streams may not relay the URL, as it doesn't match any of
+// Server.StreamURLs.
+func (me *Server) streamURLAllowed(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("URL %q isn't an HTTP URL", s)
+	}
+	for _, a := range me.StreamURLs {
+		if !strings.Contains(a, "://") {
+			if strings.EqualFold(a, u.Host) || strings.EqualFold(a, u.Hostname()) {
+				return nil
+			}
+			continue
+		}
+		au, err := url.Parse(a)
+		if err != nil || au.Scheme != u.Scheme || !strings.EqualFold(au.Host, u.Host) {
+			continue
+		}
+		// Don't let dot segments climb out of the prefix.
+		if strings.HasPrefix(u.Path, au.Path) && !strings.Contains(u.Path+"/", "/../") {
+			return nil
+		}
+	}
+	return fmt.Errorf("URL %q isn't among the allowed stream URLs", s)
+}
+
+// Opens the remote stream of a dynamic stream resource, remuxing it to the resource's MIME-type
+// if the remote stream is in another.
+func (me *Server) relayStream(ctx context.Context, res dmsDynamicStreamResource, stderr io.Writer) (io.ReadCloser, error) {
+	header := make(http.Header, len(res.Headers))
+	for k, v := range res.Headers {
+		header.Set(k, v)
+	}
+	s, err := relay.Open(ctx, res.URL, relay.Options{
+		Header:    header,
+		Reconnect: res.Reconnect,
+		Client:    me.relayClient,
+		Allow:     me.streamURLAllowed,
+		Logger:    me.Logger.WithNames("relay"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	want := canonicalMimeType(res.MimeType)
+	if s.ContentType == "" || canonicalMimeType(s.ContentType) == want {
+		return s, nil
+	}
+	format, ok := remuxFormats[want]
+	if !ok {
+		s.Close()
+		return nil, fmt.Errorf("can't remux %s to %s", s.ContentType, res.MimeType)
+	}
+	out, err := transcode.Remux(ctx, s, format, stderr)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return &remuxedStream{out, s}, nil
+}
+
+// Closes the remote stream along with the output of the remux.
+type remuxedStream struct {
+	io.ReadCloser
+	remote io.Closer
+}
+
+func (me *remuxedStream) Close() error {
+	me.remote.Close()
+	return me.ReadCloser.Close()
+}
@@ -0,0 +1,248 @@
+package dms
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/dms/upnp"
+	"github.com/anacrolix/dms/upnpav"
+)
+
+// How long a scan of the library for a view is used before scanning again.
+const viewIndexMaxAge = time.Minute
+
+// Object IDs of virtual containers and the items in them start with this. Filesystem object IDs
+// are escaped absolute paths, so never do.
+const virtualIDPrefix = "@"
+
+// A virtualView presents media from the library in containers of its own, reached from the root
+// container alongside the directories.
+type virtualView struct {
+	// The first segment of the object IDs in the view.
+	name  string
+	title string
+	// Returns true if the view is turned on for the server.
+	enabled func(*Server) bool
+	// Returns the objects in the container with the ID given, which is the view or beneath it.
+	children func(me *contentDirectoryService, id virtualID, host, userAgent string) ([]interface{}, error)
+}
+
+var virtualViews []virtualView
+
+// The path of a virtual object, starting with the name of its view.
+type virtualID []string
+
+func parseVirtualID(s string) (id virtualID, ok bool) {
+	if !strings.HasPrefix(s, virtualIDPrefix) {
+		return
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(s, virtualIDPrefix), "/") {
+		seg, err := url.PathUnescape(seg)
+		if err != nil || seg == "" {
+			return nil, false
+		}
+		id = append(id, seg)
+	}
+	return id, true
+}
+
+func (id virtualID) String() string {
+	segs := make([]string, 0, len(id))
+	for _, seg := range id {
+		segs = append(segs, url.PathEscape(seg))
+	}
+	return virtualIDPrefix + strings.Join(segs, "/")
+}
+
+func (id virtualID) child(seg string) virtualID {
+	return append(id[:len(id):len(id)], seg)
+}
+
+// Returns the ID of the parent, which is the root container for a view.
+func (id virtualID) parentID() string {
+	if len(id) == 1 {
+		return "0"
+	}
+	return id[:len(id)-1].String()
+}
+
+func (me *Server) virtualView(name string) (virtualView, bool) {
+	for _, v := range virtualViews {
+		if v.name == name && v.enabled(me) {
+			return v, true
+		}
+	}
+	return virtualView{}, false
+}
+
+// Returns the containers of the enabled views, for the root container.
+func (me *contentDirectoryService) virtualViewContainers(host, userAgent string) (ret []interface{}) {
+	for _, v := range virtualViews {
+		if !v.enabled(me.Server) {
+			continue
+		}
+		c, err := me.virtualObject(virtualID{v.name}, host, userAgent)
+		if err != nil {
+			me.Logger.Printf("error with view %s: %s", v.name, err)
+			continue
+		}
+		ret = append(ret, c)
+	}
+	return
+}
+
+// Returns a virtual container, with its child count.
+func virtualContainer(id virtualID, title string, childCount int) upnpav.Container {
+	return upnpav.Container{
+		Object: upnpav.Object{
+			ID:         id.String(),
+			ParentID:   id.parentID(),
+			Restricted: true,
+			Title:      title,
+			Class:      "object.container",
+		},
+		ChildCount: childCount,
+	}
+}
+
+// Returns the children of a virtual container.
+func (me *contentDirectoryService) virtualChildren(id virtualID, host, userAgent string) ([]interface{}, error) {
+	v, ok := me.virtualView(id[0])
+	if !ok {
+		return nil, fmt.Errorf("no view %q", id[0])
+	}
+	return v.children(me, id, host, userAgent)
+}
+
+// Returns the virtual object with the ID given, by finding it among its parent's children.
+func (me *contentDirectoryService) virtualObject(id virtualID, host, userAgent string) (interface{}, error) {
+	if len(id) == 1 {
+		v, ok := me.virtualView(id[0])
+		if !ok {
+			return nil, fmt.Errorf("no view %q", id[0])
+		}
+		children, err := v.children(me, id, host, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		return virtualContainer(id, v.title, len(children)), nil
+	}
+	siblings, err := me.virtualChildren(id[:len(id)-1], host, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range siblings {
+		if didlObjectID(o) == id.String() {
+			return o, nil
+		}
+	}
+	return nil, fmt.Errorf("no object %q", id)
+}
+
+// Returns the item for a file in a virtual container. It refers to the item for the file in the
+// directory hierarchy.
+func (me *contentDirectoryService) virtualItem(parentID virtualID, objectPath, host, userAgent string) (item upnpav.Item, err error) {
+	o := object{objectPath, me.RootObjectPath}
+	fi, err := os.Stat(o.FilePath())
+	if err != nil {
+		return
+	}
+	obj, err := me.cdsObjectToUpnpavObject(o, fi, host, userAgent)
+	if err != nil {
+		return
+	}
+	item, ok := obj.(upnpav.Item)
+	if !ok {
+		err = fmt.Errorf("%q is not an item", objectPath)
+		return
+	}
+	item.RefID = item.ID
+	item.ID = parentID.child(item.ID).String()
+	item.ParentID = parentID.String()
+	return
+}
+
+// Calls f for each media file in the library that isn't ignored, for views to index.
+func (me *Server) walkLibrary(f func(filePath, objectPath string, mimeType mimeType)) error {
+	return filepath.WalkDir(me.RootObjectPath, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			me.Logger.Printf("error scanning library: %v", err)
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ignored, err := me.IgnorePath(filePath); err != nil || ignored {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		mimeType, err := MimeTypeByPath(filePath)
+		if err != nil || !mimeType.IsMedia() {
+			return nil
+		}
+		rel, err := filepath.Rel(me.RootObjectPath, filePath)
+		if err != nil {
+			return nil
+		}
+		f(filePath, path.Join("/", filepath.ToSlash(rel)), mimeType)
+		return nil
+	})
+}
+
+func didlObjectID(o interface{}) string {
+	switch o := o.(type) {
+	case upnpav.Container:
+		return o.ID
+	case upnpav.Item:
+		return o.ID
+	}
+	return ""
+}
+
+func init() {
+	// Registered here rather than in a variable initializer, as views browse the filesystem
+	// objects, which in turn list the views.
+	virtualViews = []virtualView{seriesView, photosView}
+}
+
+// Handles Browse for a virtual object.
+func (me *contentDirectoryService) browseVirtual(id virtualID, browse browse, r *http.Request) ([][2]string, error) {
+	host := r.Host
+	userAgent := r.UserAgent()
+	switch browse.BrowseFlag {
+	case "BrowseDirectChildren":
+		objs, err := me.virtualChildren(id, host, userAgent)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		}
+		return me.browseChildrenResult(me.filterAllowedObjects(r, objs), browse)
+	case "BrowseMetadata":
+		obj, err := me.virtualObject(id, host, userAgent)
+		if err != nil {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, err.Error())
+		}
+		obj, ok := me.allowedObject(r, me.clientProfile(r), obj)
+		if !ok {
+			return nil, upnp.Errorf(upnpav.NoSuchObjectErrorCode, "no such object %q", id)
+		}
+		return me.browseMetadataResult(obj)
+	default:
+		return nil, upnp.Errorf(
+			upnp.ArgumentValueInvalidErrorCode,
+			"unhandled browse flag: %v",
+			browse.BrowseFlag,
+		)
+	}
+}
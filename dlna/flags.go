@@ -0,0 +1,131 @@
+package dlna
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Flags are the primary flags of DLNA.ORG_FLAGS. They're the high 32 of its 128 bits, the rest
+// being reserved.
+type Flags uint32
+
+const (
+	// The server paces the stream, rather than the client by how fast it reads.
+	FlagSenderPaced Flags = 1 << (31 - iota)
+	// Limited random access: time seeks within what's been buffered.
+	FlagLOPTime
+	// Limited random access: byte ranges within what's been buffered.
+	FlagLOPBytes
+	// The resource is a playlist or other container for the renderer to play through.
+	FlagPlayContainer
+	// The start of the content moves forward over time, as in a time-shift buffer.
+	FlagS0Increasing
+	// The end of the content moves forward over time, as in a live recording.
+	FlagSNIncreasing
+	FlagRTSPPause
+	FlagStreamingTransferMode
+	FlagInteractiveTransferMode
+	FlagBackgroundTransferMode
+	// The client may stop reading without the connection being dropped.
+	FlagConnectionStall
+	FlagDLNAV15
+)
+
+// The flags used when none are given: streaming, background transfers, stalling and DLNA 1.5.
+const DefaultFlags = FlagStreamingTransferMode | FlagBackgroundTransferMode | FlagConnectionStall | FlagDLNAV15
+
+// The symbolic names of the flags, as accepted by ParseFlags.
+var flagNames = []struct {
+	flag Flags
+	name string
+}{
+	{FlagSenderPaced, "senderPaced"},
+	{FlagLOPTime, "lopTime"},
+	{FlagLOPBytes, "lopBytes"},
+	{FlagPlayContainer, "playContainer"},
+	{FlagS0Increasing, "s0Increasing"},
+	{FlagSNIncreasing, "snIncreasing"},
+	{FlagRTSPPause, "rtspPause"},
+	{FlagStreamingTransferMode, "streaming"},
+	{FlagInteractiveTransferMode, "interactive"},
+	{FlagBackgroundTransferMode, "background"},
+	{FlagConnectionStall, "connectionStall"},
+	{FlagDLNAV15, "dlnaV15"},
+}
+
+// String returns the flags as the 32 hex digits of DLNA.ORG_FLAGS.
+func (f Flags) String() string {
+	return fmt.Sprintf("%08X%024d", uint32(f), 0)
+}
+
+// Names returns the symbolic names of the flags set, separated by "|".
+func (f Flags) Names() string {
+	names := make([]string, 0, bits.OnesCount32(uint32(f)))
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+			f &^= fn.flag
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("0x%08X", uint32(f)))
+	}
+	return strings.Join(names, "|")
+}
+
+// ParseFlags parses DLNA.ORG_FLAGS hex digits, or symbolic names like "streaming|dlnaV15"
+// separated by "|" or ",". Hex with fewer than 32 digits is taken as the primary flags alone.
+func ParseFlags(s string) (Flags, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	if isHex(s) {
+		if len(s) > 8 {
+			if len(s) != 32 {
+				return 0, fmt.Errorf("parsing flags %q: expected 32 hex digits", s)
+			}
+			s = s[:8]
+		}
+		v, err := strconv.ParseUint(s, 16, 32)
+		return Flags(v), err
+	}
+	var f Flags
+	for _, name := range strings.FieldsFunc(s, func(r rune) bool { return r == '|' || r == ',' }) {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, fn := range flagNames {
+			if strings.EqualFold(fn.name, name) {
+				f |= fn.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("parsing flags %q: unknown flag %q", s, name)
+		}
+	}
+	return f, nil
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalText encodes the flags as DLNA.ORG_FLAGS does.
+func (f Flags) MarshalText() ([]byte, error) {
+	return []byte(f.String()), nil
+}
+
+// UnmarshalText accepts anything ParseFlags does, so configuration can use symbolic names.
+func (f *Flags) UnmarshalText(text []byte) (err error) {
+	*f, err = ParseFlags(string(text))
+	return
+}
@@ -0,0 +1,250 @@
+package dlna
+
+import (
+	"math"
+	"strings"
+)
+
+// A DLNA media format profile, as given in DLNA.ORG_PN, and the MIME-type it must be served as.
+type Profile struct {
+	Name     string
+	MimeType string
+}
+
+// Media describes a file for choosing its DLNA profile. Names are those ffprobe uses.
+type Media struct {
+	// The format names, like "mov,mp4,m4a,3gp,3g2,mj2" or "mpegts". Any of them may match.
+	Container string
+	// Empty if there is no video, which is distinct from an attached picture.
+	VideoCodec string
+	// The codec profile, like "Main" or "High" for H.264.
+	VideoProfile  string
+	Width, Height int
+	FrameRate     float64
+	AudioCodec    string
+	AudioChannels int
+	SampleRate    int
+	// Of the whole file, in bits per second.
+	Bitrate int64
+	// Whether MPEG transport stream packets carry the 4 byte timestamp, as in .m2ts files.
+	Timestamped bool
+}
+
+// Constrains the media matching a profile. Nil and zero fields are unconstrained, except that
+// profiles without video codecs only match media without video.
+type profileRule struct {
+	Profile
+	containers    []string
+	video         []string
+	videoProfiles []string
+	audio         []string
+	// Inclusive.
+	maxWidth, maxHeight int
+	frameRates          []float64
+	maxChannels         int
+	sampleRates         []int
+	maxBitrate          int64
+	// For MPEG transport streams, whether timestamped packets are required, or forbidden.
+	timestamped, iso bool
+}
+
+func (r profileRule) matches(m Media) bool {
+	if !containsAny(r.containers, strings.Split(m.Container, ",")) {
+		return false
+	}
+	if r.video == nil {
+		if m.VideoCodec != "" {
+			return false
+		}
+	} else if !contains(r.video, m.VideoCodec) {
+		return false
+	}
+	if r.videoProfiles != nil && !contains(r.videoProfiles, m.VideoProfile) {
+		return false
+	}
+	// Silent video is allowed for the video profiles.
+	if r.audio != nil && !(contains(r.audio, m.AudioCodec) || r.video != nil && m.AudioCodec == "") {
+		return false
+	}
+	if r.maxWidth != 0 && (m.Width > r.maxWidth || m.Height > r.maxHeight) {
+		return false
+	}
+	if r.frameRates != nil && !matchesFrameRate(r.frameRates, m.FrameRate) {
+		return false
+	}
+	if r.maxChannels != 0 && m.AudioChannels > r.maxChannels {
+		return false
+	}
+	if r.sampleRates != nil && !containsInt(r.sampleRates, m.SampleRate) {
+		return false
+	}
+	if r.maxBitrate != 0 && (m.Bitrate == 0 || m.Bitrate > r.maxBitrate) {
+		return false
+	}
+	if r.timestamped && !m.Timestamped || r.iso && m.Timestamped {
+		return false
+	}
+	return true
+}
+
+func contains(ss []string, s string) bool {
+	for _, t := range ss {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(ss, ts []string) bool {
+	for _, t := range ts {
+		if contains(ss, strings.TrimSpace(t)) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(is []int, i int) bool {
+	for _, j := range is {
+		if i == j {
+			return true
+		}
+	}
+	return false
+}
+
+// Frame rates like 29.97 are given and reported with limited precision.
+func matchesFrameRate(rates []float64, rate float64) bool {
+	for _, r := range rates {
+		if math.Abs(r-rate) < 0.01 {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	mp4Containers  = []string{"mov", "mp4", "m4a"}
+	h264Baseline   = []string{"Baseline", "Constrained Baseline"}
+	h264Main       = append([]string{"Main"}, h264Baseline...)
+	h264High       = append([]string{"High"}, h264Main...)
+	rates24        = []float64{23.976, 24}
+	rates50        = []float64{25, 50}
+	rates60        = []float64{29.97, 30, 59.94, 60}
+	ratesNA        = append(append([]float64{}, rates24...), rates60...)
+	mpegAudioRates = []int{32000, 44100, 48000}
+)
+
+// The rules tried in order, so more constrained profiles come before those they're a subset of.
+var profileRules = func() (ret []profileRule) {
+	ret = []profileRule{
+		{Profile: Profile{"MP3", "audio/mpeg"}, containers: []string{"mp3"}, audio: []string{"mp3"}, maxChannels: 2, sampleRates: mpegAudioRates, maxBitrate: 320000},
+		{Profile: Profile{"MP3X", "audio/mpeg"}, containers: []string{"mp3"}, audio: []string{"mp3"}, maxChannels: 2},
+		{Profile: Profile{"AAC_ADTS_320", "audio/vnd.dlna.adts"}, containers: []string{"aac"}, audio: []string{"aac"}, maxChannels: 2, maxBitrate: 320000},
+		{Profile: Profile{"AAC_ADTS", "audio/vnd.dlna.adts"}, containers: []string{"aac"}, audio: []string{"aac"}, maxChannels: 2},
+		{Profile: Profile{"AAC_MULT5_ADTS", "audio/vnd.dlna.adts"}, containers: []string{"aac"}, audio: []string{"aac"}, maxChannels: 6},
+		{Profile: Profile{"AAC_ISO_320", "audio/mp4"}, containers: mp4Containers, audio: []string{"aac"}, maxChannels: 2, maxBitrate: 320000},
+		{Profile: Profile{"AAC_ISO", "audio/mp4"}, containers: mp4Containers, audio: []string{"aac"}, maxChannels: 2},
+		{Profile: Profile{"AAC_MULT5_ISO", "audio/mp4"}, containers: mp4Containers, audio: []string{"aac"}, maxChannels: 6},
+		{Profile: Profile{"AC3", "audio/vnd.dolby.dd-raw"}, containers: []string{"ac3"}, audio: []string{"ac3"}},
+		{Profile: Profile{"WMABASE", "audio/x-ms-wma"}, containers: []string{"asf"}, audio: []string{"wmav1", "wmav2"}, maxChannels: 2, sampleRates: mpegAudioRates, maxBitrate: 193000},
+		{Profile: Profile{"WMAFULL", "audio/x-ms-wma"}, containers: []string{"asf"}, audio: []string{"wmav1", "wmav2"}, maxChannels: 2},
+		{Profile: Profile{"WMAPRO", "audio/x-ms-wma"}, containers: []string{"asf"}, audio: []string{"wmapro"}, maxChannels: 8},
+		{Profile: Profile{"LPCM", "audio/L16"}, containers: []string{"s16be"}, audio: []string{"pcm_s16be"}, maxChannels: 2, sampleRates: []int{44100, 48000}},
+
+		{Profile: Profile{"AVC_MP4_BL_CIF30_AAC_MULT5", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264Baseline, audio: []string{"aac"}, maxWidth: 352, maxHeight: 288, maxChannels: 6},
+		{Profile: Profile{"AVC_MP4_MP_SD_AAC_MULT5", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"aac"}, maxWidth: 720, maxHeight: 576, maxChannels: 6},
+		{Profile: Profile{"AVC_MP4_MP_SD_AC3", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"ac3"}, maxWidth: 720, maxHeight: 576},
+		{Profile: Profile{"AVC_MP4_MP_SD_MPEG1_L3", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"mp3"}, maxWidth: 720, maxHeight: 576},
+		{Profile: Profile{"AVC_MP4_MP_HD_720p_AAC", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"aac"}, maxWidth: 1280, maxHeight: 720, maxChannels: 2},
+		{Profile: Profile{"AVC_MP4_MP_HD_1080i_AAC", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"aac"}, maxWidth: 1920, maxHeight: 1080, maxChannels: 2},
+		{Profile: Profile{"AVC_MP4_HP_HD_AAC", "video/mp4"}, containers: mp4Containers, video: []string{"h264"}, videoProfiles: h264High, audio: []string{"aac"}, maxWidth: 1920, maxHeight: 1080, maxChannels: 2},
+		{Profile: Profile{"MPEG4_P2_MP4_SP_AAC", "video/mp4"}, containers: mp4Containers, video: []string{"mpeg4"}, audio: []string{"aac"}, maxWidth: 352, maxHeight: 288, maxChannels: 2},
+		{Profile: Profile{"MPEG4_P2_MP4_ASP_AAC", "video/mp4"}, containers: mp4Containers, video: []string{"mpeg4"}, audio: []string{"aac"}, maxWidth: 720, maxHeight: 576, maxChannels: 2},
+
+		{Profile: Profile{"MPEG1", "video/mpeg"}, containers: []string{"mpeg"}, video: []string{"mpeg1video"}, audio: []string{"mp2"}, maxWidth: 352, maxHeight: 288},
+		{Profile: Profile{"MPEG_PS_PAL", "video/mpeg"}, containers: []string{"mpeg"}, video: []string{"mpeg2video"}, audio: []string{"mp2", "ac3", "pcm_dvd"}, maxWidth: 720, maxHeight: 576, frameRates: rates50},
+		{Profile: Profile{"MPEG_PS_NTSC", "video/mpeg"}, containers: []string{"mpeg"}, video: []string{"mpeg2video"}, audio: []string{"mp2", "ac3", "pcm_dvd"}, maxWidth: 720, maxHeight: 480, frameRates: ratesNA},
+
+		{Profile: Profile{"WMVMED_FULL", "video/x-ms-wmv"}, containers: []string{"asf"}, video: []string{"wmv3"}, audio: []string{"wmav1", "wmav2"}, maxWidth: 720, maxHeight: 576, maxChannels: 2},
+		{Profile: Profile{"WMVMED_PRO", "video/x-ms-wmv"}, containers: []string{"asf"}, video: []string{"wmv3"}, audio: []string{"wmapro"}, maxWidth: 720, maxHeight: 576},
+		{Profile: Profile{"WMVHIGH_FULL", "video/x-ms-wmv"}, containers: []string{"asf"}, video: []string{"wmv3"}, audio: []string{"wmav1", "wmav2"}, maxWidth: 1920, maxHeight: 1080, maxChannels: 2},
+		{Profile: Profile{"WMVHIGH_PRO", "video/x-ms-wmv"}, containers: []string{"asf"}, video: []string{"wmv3"}, audio: []string{"wmapro"}, maxWidth: 1920, maxHeight: 1080},
+		{Profile: Profile{"VC1_ASF_AP_L2_WMA", "video/x-ms-asf"}, containers: []string{"asf"}, video: []string{"vc1"}, audio: []string{"wmav1", "wmav2"}, maxWidth: 720, maxHeight: 576, maxChannels: 2},
+	}
+	// Transport streams come in variants without timestamps, served as video/mpeg, and with them.
+	for _, r := range []profileRule{
+		{Profile: Profile{Name: "MPEG_TS_SD_EU"}, video: []string{"mpeg2video"}, audio: []string{"mp2", "ac3"}, maxWidth: 720, maxHeight: 576, frameRates: rates50},
+		{Profile: Profile{Name: "MPEG_TS_SD_NA"}, video: []string{"mpeg2video"}, audio: []string{"ac3"}, maxWidth: 720, maxHeight: 480, frameRates: ratesNA},
+		{Profile: Profile{Name: "MPEG_TS_SD_60_L2"}, video: []string{"mpeg2video"}, audio: []string{"mp2"}, maxWidth: 720, maxHeight: 480, frameRates: ratesNA},
+		{Profile: Profile{Name: "MPEG_TS_HD_NA"}, video: []string{"mpeg2video"}, audio: []string{"ac3"}, maxWidth: 1920, maxHeight: 1080, frameRates: ratesNA},
+		{Profile: Profile{Name: "MPEG_TS_HD_50_L2"}, video: []string{"mpeg2video"}, audio: []string{"mp2"}, maxWidth: 1920, maxHeight: 1080, frameRates: rates50},
+		{Profile: Profile{Name: "MPEG_TS_HD_60_L2"}, video: []string{"mpeg2video"}, audio: []string{"mp2"}, maxWidth: 1920, maxHeight: 1080, frameRates: rates60},
+		{Profile: Profile{Name: "AVC_TS_MP_SD_AAC_MULT5"}, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"aac"}, maxWidth: 720, maxHeight: 576, maxChannels: 6},
+		{Profile: Profile{Name: "AVC_TS_MP_SD_AC3"}, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"ac3"}, maxWidth: 720, maxHeight: 576},
+		{Profile: Profile{Name: "AVC_TS_MP_SD_MPEG1_L3"}, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"mp3"}, maxWidth: 720, maxHeight: 576},
+		{Profile: Profile{Name: "AVC_TS_HD_24_AC3"}, video: []string{"h264"}, videoProfiles: h264High, audio: []string{"ac3"}, maxWidth: 1920, maxHeight: 1080, frameRates: rates24},
+		{Profile: Profile{Name: "AVC_TS_HD_50_AC3"}, video: []string{"h264"}, videoProfiles: h264High, audio: []string{"ac3"}, maxWidth: 1920, maxHeight: 1080, frameRates: rates50},
+		{Profile: Profile{Name: "AVC_TS_HD_60_AC3"}, video: []string{"h264"}, videoProfiles: h264High, audio: []string{"ac3"}, maxWidth: 1920, maxHeight: 1080, frameRates: rates60},
+		{Profile: Profile{Name: "AVC_TS_MP_HD_AAC_MULT5"}, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"aac"}, maxWidth: 1920, maxHeight: 1080, maxChannels: 6},
+		{Profile: Profile{Name: "AVC_TS_MP_HD_MPEG1_L3"}, video: []string{"h264"}, videoProfiles: h264Main, audio: []string{"mp3"}, maxWidth: 1920, maxHeight: 1080},
+	} {
+		r.containers = []string{"mpegts"}
+		iso, timestamped := r, r
+		iso.Name += "_ISO"
+		iso.MimeType = "video/mpeg"
+		iso.iso = true
+		timestamped.Name += "_T"
+		timestamped.MimeType = "video/vnd.dlna.mpeg-tts"
+		timestamped.timestamped = true
+		ret = append(ret, iso, timestamped)
+	}
+	return
+}()
+
+// The image profiles, by MIME-type, smallest first. Images match the first they fit in.
+var imageProfiles = []struct {
+	Profile
+	maxWidth, maxHeight int
+}{
+	{Profile{"JPEG_TN", "image/jpeg"}, 160, 160},
+	{Profile{"JPEG_SM", "image/jpeg"}, 640, 480},
+	{Profile{"JPEG_MED", "image/jpeg"}, 1024, 768},
+	{Profile{"JPEG_LRG", "image/jpeg"}, 4096, 4096},
+	{Profile{"PNG_TN", "image/png"}, 160, 160},
+	{Profile{"PNG_LRG", "image/png"}, 4096, 4096},
+	{Profile{"GIF_LRG", "image/gif"}, 1600, 1200},
+}
+
+// Returns the profile the media conforms to. Many files, such as Matroska and FLAC, have no
+// profile.
+func DetectProfile(m Media) (Profile, bool) {
+	for _, r := range profileRules {
+		if r.matches(m) {
+			return r.Profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Returns the profile of an image with the given MIME-type and dimensions.
+func DetectImageProfile(mimeType string, width, height int) (Profile, bool) {
+	for _, p := range imageProfiles {
+		if p.MimeType == mimeType && width <= p.maxWidth && height <= p.maxHeight {
+			return p.Profile, true
+		}
+	}
+	return Profile{}, false
+}
+
+// Returns every profile that can be detected.
+func Profiles() (ret []Profile) {
+	for _, p := range imageProfiles {
+		ret = append(ret, p.Profile)
+	}
+	for _, r := range profileRules {
+		ret = append(ret, r.Profile)
+	}
+	return
+}
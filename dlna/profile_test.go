@@ -0,0 +1,54 @@
+package dlna
+
+import (
+	"testing"
+)
+
+func TestDetectProfile(t *testing.T) {
+	for _, tc := range []struct {
+		media    Media
+		expected string
+	}{
+		{Media{Container: "mp3", AudioCodec: "mp3", AudioChannels: 2, SampleRate: 44100, Bitrate: 192000}, "MP3"},
+		{Media{Container: "mp3", AudioCodec: "mp3", AudioChannels: 2, SampleRate: 22050, Bitrate: 64000}, "MP3X"},
+		{Media{Container: "mov,mp4,m4a,3gp,3g2,mj2", AudioCodec: "aac", AudioChannels: 2, SampleRate: 44100, Bitrate: 256000}, "AAC_ISO_320"},
+		{Media{Container: "mov,mp4,m4a,3gp,3g2,mj2", VideoCodec: "h264", VideoProfile: "High", Width: 1920, Height: 1080, FrameRate: 23.976, AudioCodec: "aac", AudioChannels: 2}, "AVC_MP4_HP_HD_AAC"},
+		{Media{Container: "mov,mp4,m4a,3gp,3g2,mj2", VideoCodec: "h264", VideoProfile: "Main", Width: 1280, Height: 720, AudioCodec: "aac", AudioChannels: 2}, "AVC_MP4_MP_HD_720p_AAC"},
+		{Media{Container: "mov,mp4,m4a,3gp,3g2,mj2", VideoCodec: "h264", VideoProfile: "High", Width: 720, Height: 576, AudioCodec: "aac", AudioChannels: 6}, ""},
+		{Media{Container: "mpegts", VideoCodec: "mpeg2video", Width: 1920, Height: 1080, FrameRate: 29.97, AudioCodec: "ac3", AudioChannels: 6}, "MPEG_TS_HD_NA_ISO"},
+		{Media{Container: "mpegts", VideoCodec: "mpeg2video", Width: 1920, Height: 1080, FrameRate: 29.97, AudioCodec: "ac3", Timestamped: true}, "MPEG_TS_HD_NA_T"},
+		{Media{Container: "mpegts", VideoCodec: "h264", VideoProfile: "High", Width: 1920, Height: 1080, FrameRate: 25, AudioCodec: "ac3"}, "AVC_TS_HD_50_AC3_ISO"},
+		{Media{Container: "mpegts", VideoCodec: "h264", VideoProfile: "Main", Width: 1920, Height: 1080, FrameRate: 25, AudioCodec: "aac", AudioChannels: 2}, "AVC_TS_MP_HD_AAC_MULT5_ISO"},
+		// MP profiles are Main profile only, which strict renderers check.
+		{Media{Container: "mpegts", VideoCodec: "h264", VideoProfile: "High", Width: 1920, Height: 1080, FrameRate: 25, AudioCodec: "aac", AudioChannels: 2}, ""},
+		{Media{Container: "mpegts", VideoCodec: "h264", VideoProfile: "High", Width: 1920, Height: 1080, FrameRate: 25, AudioCodec: "mp3"}, ""},
+		{Media{Container: "mpeg", VideoCodec: "mpeg2video", Width: 720, Height: 576, FrameRate: 25, AudioCodec: "mp2"}, "MPEG_PS_PAL"},
+		{Media{Container: "matroska,webm", VideoCodec: "h264", VideoProfile: "High", Width: 1920, Height: 1080, AudioCodec: "aac"}, ""},
+		{Media{Container: "flac", AudioCodec: "flac", AudioChannels: 2}, ""},
+	} {
+		p, ok := DetectProfile(tc.media)
+		if p.Name != tc.expected || ok != (tc.expected != "") {
+			t.Errorf("%+v: got %q, expected %q", tc.media, p.Name, tc.expected)
+		}
+	}
+}
+
+func TestDetectImageProfile(t *testing.T) {
+	for _, tc := range []struct {
+		mimeType      string
+		width, height int
+		expected      string
+	}{
+		{"image/jpeg", 160, 120, "JPEG_TN"},
+		{"image/jpeg", 1024, 768, "JPEG_MED"},
+		{"image/jpeg", 4000, 3000, "JPEG_LRG"},
+		{"image/jpeg", 8000, 6000, ""},
+		{"image/png", 800, 600, "PNG_LRG"},
+		{"image/heic", 800, 600, ""},
+	} {
+		p, _ := DetectImageProfile(tc.mimeType, tc.width, tc.height)
+		if p.Name != tc.expected {
+			t.Errorf("%s %dx%d: got %q, expected %q", tc.mimeType, tc.width, tc.height, p.Name, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,403 @@
+// Package exif reads the EXIF metadata that dms needs from image files.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+// Orientation is the EXIF orientation of an image, describing how the stored pixels must be
+// transformed to display the image upright. Values are 1 through 8 as in the EXIF spec.
+type Orientation int
+
+const (
+	OrientationNormal Orientation = 1 + iota
+	OrientationFlipHorizontal
+	OrientationRotate180
+	OrientationFlipVertical
+	OrientationTranspose
+	OrientationRotate90
+	OrientationTransverse
+	OrientationRotate270
+)
+
+// Returns true if the displayed image has its width and height swapped relative to the stored
+// pixels.
+func (o Orientation) SwapsDimensions() bool {
+	return o >= OrientationTranspose && o <= OrientationRotate270
+}
+
+// Info is the metadata extracted from an image.
+type Info struct {
+	Orientation Orientation
+	// When the photo was taken, from DateTimeOriginal, or DateTime failing that. Zero if not
+	// recorded. Cameras record the local time, so without an offset recorded alongside, this is
+	// the local time given as UTC.
+	DateTime time.Time
+	// The camera.
+	Make  string
+	Model string
+	// The dimensions of the stored pixels, before orientation. Zero if not recorded.
+	Width  int
+	Height int
+	// Where the photo was taken, in degrees north and east, if HasLocation.
+	Latitude    float64
+	Longitude   float64
+	HasLocation bool
+}
+
+// ErrNoExif is returned when an image doesn't contain any EXIF data.
+var ErrNoExif = errors.New("no exif data")
+
+// ErrUnsupported is returned by Decode for formats it doesn't read.
+var ErrUnsupported = errors.New("unsupported image format")
+
+const (
+	tagImageWidth         = 0x0100
+	tagImageLength        = 0x0101
+	tagMake               = 0x010f
+	tagModel              = 0x0110
+	tagOrientation        = 0x0112
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagPixelXDimension    = 0xa002
+	tagPixelYDimension    = 0xa003
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+)
+
+// File is what Decode reads images from, as implemented by *os.File and *bytes.Reader.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// Decode reads the EXIF metadata from a JPEG, TIFF or HEIF/HEIC image, detecting the format from
+// its contents.
+func Decode(f File) (info Info, err error) {
+	var magic [12]byte
+	n, _ := f.ReadAt(magic[:], 0)
+	b := magic[:n]
+	switch {
+	case bytes.HasPrefix(b, []byte{0xff, 0xd8}):
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		return DecodeJPEG(f)
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return DecodeTIFF(f)
+	case len(b) >= 8 && string(b[4:8]) == "ftyp":
+		return DecodeHEIF(f)
+	}
+	err = ErrUnsupported
+	return
+}
+
+// DecodeJPEG reads the EXIF metadata from a JPEG stream. Only the segments preceding the image
+// data are read.
+func DecodeJPEG(r io.Reader) (info Info, err error) {
+	tiff, err := jpegExifSegment(bufio.NewReader(r))
+	if err != nil {
+		return
+	}
+	return decodeTIFF(bytes.NewReader(tiff))
+}
+
+// DecodeTIFF reads the EXIF metadata from a TIFF image, or the TIFF structure that holds EXIF
+// in other formats. Camera raw formats like DNG and NEF are TIFF too.
+func DecodeTIFF(r io.ReaderAt) (Info, error) {
+	return decodeTIFF(r)
+}
+
+// Returns the TIFF structure embedded in the APP1 Exif segment of a JPEG.
+func jpegExifSegment(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi != [2]byte{0xff, 0xd8} {
+		return nil, errors.New("not a jpeg")
+	}
+	for {
+		marker, err := readMarker(r)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == 0xd9 || marker == 0xda:
+			// End of image, or start of scan. Exif must come before either.
+			return nil, ErrNoExif
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			// Markers without a length.
+			continue
+		}
+		var lenBuf [2]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			return nil, err
+		}
+		length := int(binary.BigEndian.Uint16(lenBuf[:])) - 2
+		if length < 0 {
+			return nil, fmt.Errorf("bad jpeg segment length %d", length)
+		}
+		if marker != 0xe1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, err
+		}
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// Reads up to and including the next marker byte, skipping any fill bytes.
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xff {
+		return 0, fmt.Errorf("expected jpeg marker, got %#x", b)
+	}
+	for b == 0xff {
+		b, err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// A directory entry within a TIFF IFD.
+type ifdEntry struct {
+	typ   uint16
+	count uint32
+	// The value if it fits in 4 bytes, otherwise the offset to it.
+	value []byte
+}
+
+type tiffReader struct {
+	r  io.ReaderAt
+	bo binary.ByteOrder
+}
+
+func decodeTIFF(r io.ReaderAt) (info Info, err error) {
+	info.Orientation = OrientationNormal
+	t, ifd0, err := newTIFFReader(r)
+	if err != nil {
+		return
+	}
+	entries, err := t.readIFD(ifd0)
+	if err != nil {
+		return
+	}
+	if e, ok := entries[tagOrientation]; ok {
+		if o := Orientation(t.uint(e)); o >= OrientationNormal && o <= OrientationRotate270 {
+			info.Orientation = o
+		}
+	}
+	info.Make = t.ascii(entries[tagMake])
+	info.Model = t.ascii(entries[tagModel])
+	info.Width = int(t.uint(entries[tagImageWidth]))
+	info.Height = int(t.uint(entries[tagImageLength]))
+	dateTime, offset := t.ascii(entries[tagDateTime]), ""
+	if e, ok := entries[tagExifIFD]; ok {
+		if sub, err := t.readIFD(t.uint(e)); err == nil {
+			if s := t.ascii(sub[tagDateTimeOriginal]); s != "" {
+				dateTime = s
+				offset = t.ascii(sub[tagOffsetTimeOriginal])
+			}
+			if w, h := t.uint(sub[tagPixelXDimension]), t.uint(sub[tagPixelYDimension]); w != 0 && h != 0 {
+				info.Width, info.Height = int(w), int(h)
+			}
+		}
+	}
+	info.DateTime, _ = parseDateTime(dateTime, offset)
+	if e, ok := entries[tagGPSIFD]; ok {
+		if gps, err := t.readIFD(t.uint(e)); err == nil {
+			info.Latitude, info.Longitude, info.HasLocation = t.location(gps)
+		}
+	}
+	return
+}
+
+// Parses an EXIF date and time like "2006:01:02 15:04:05", with an offset like "+02:00" if one
+// was recorded.
+func parseDateTime(s, offset string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.HasPrefix(s, "0000") {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", s)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if o, err := time.Parse("-07:00", strings.TrimSpace(offset)); err == nil {
+		_, secs := o.Zone()
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.FixedZone("", secs))
+	}
+	return t, true
+}
+
+func newTIFFReader(r io.ReaderAt) (t tiffReader, ifd0 uint32, err error) {
+	var b [8]byte
+	if _, err = r.ReadAt(b[:], 0); err != nil {
+		err = errors.New("short tiff header")
+		return
+	}
+	switch string(b[:2]) {
+	case "II":
+		t.bo = binary.LittleEndian
+	case "MM":
+		t.bo = binary.BigEndian
+	default:
+		err = errors.New("bad tiff byte order")
+		return
+	}
+	if t.bo.Uint16(b[2:]) != 42 {
+		err = errors.New("bad tiff magic")
+		return
+	}
+	t.r = r
+	ifd0 = t.bo.Uint32(b[4:])
+	return
+}
+
+// No real IFD has anywhere near this many entries.
+const maxIFDEntries = 1000
+
+func (t tiffReader) readIFD(off uint32) (map[uint16]ifdEntry, error) {
+	var countBuf [2]byte
+	if _, err := t.r.ReadAt(countBuf[:], int64(off)); err != nil {
+		return nil, errors.New("ifd offset out of range")
+	}
+	n := int(t.bo.Uint16(countBuf[:]))
+	if n > maxIFDEntries {
+		return nil, errors.New("too many ifd entries")
+	}
+	b := make([]byte, n*12)
+	if _, err := t.r.ReadAt(b, int64(off)+2); err != nil {
+		return nil, errors.New("ifd entries out of range")
+	}
+	ret := make(map[uint16]ifdEntry, n)
+	for i := 0; i < n; i++ {
+		e := b[i*12 : i*12+12]
+		ret[t.bo.Uint16(e)] = ifdEntry{
+			typ:   t.bo.Uint16(e[2:]),
+			count: t.bo.Uint32(e[4:]),
+			value: e[8:12],
+		}
+	}
+	return ret, nil
+}
+
+// Returns the first value of a SHORT or LONG entry.
+func (t tiffReader) uint(e ifdEntry) uint32 {
+	switch e.typ {
+	case 3: // SHORT
+		return uint32(t.bo.Uint16(e.value))
+	case 4: // LONG
+		return t.bo.Uint32(e.value)
+	default:
+		return 0
+	}
+}
+
+// Sizes of the values of entry types, by type.
+var tiffTypeSizes = map[uint16]uint32{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	7:  1, // UNDEFINED
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+// Values of entries larger than this are ignored.
+const maxEntryDataSize = 64 << 10
+
+// Returns the raw values of an entry, following the offset to them if they don't fit in the
+// entry.
+func (t tiffReader) data(e ifdEntry) ([]byte, bool) {
+	size := uint64(tiffTypeSizes[e.typ]) * uint64(e.count)
+	if size == 0 || size > maxEntryDataSize {
+		return nil, false
+	}
+	if size <= 4 {
+		return e.value[:size], true
+	}
+	b := make([]byte, size)
+	if _, err := t.r.ReadAt(b, int64(t.bo.Uint32(e.value))); err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// Returns the value of an ASCII entry.
+func (t tiffReader) ascii(e ifdEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	b, _ := t.data(e)
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Returns the values of a RATIONAL entry.
+func (t tiffReader) rationals(e ifdEntry) (ret []float64) {
+	if e.typ != 5 {
+		return
+	}
+	b, _ := t.data(e)
+	for ; len(b) >= 8; b = b[8:] {
+		den := t.bo.Uint32(b[4:])
+		if den == 0 {
+			return nil
+		}
+		ret = append(ret, float64(t.bo.Uint32(b))/float64(den))
+	}
+	return
+}
+
+// Returns the position from a GPS IFD, given as degrees, minutes and seconds with a reference
+// for the hemisphere.
+func (t tiffReader) location(gps map[uint16]ifdEntry) (lat, long float64, ok bool) {
+	coord := func(tag, refTag uint16, negativeRef string) (float64, bool) {
+		dms := t.rationals(gps[tag])
+		if len(dms) != 3 {
+			return 0, false
+		}
+		v := dms[0] + dms[1]/60 + dms[2]/3600
+		if strings.EqualFold(t.ascii(gps[refTag]), negativeRef) {
+			v = -v
+		}
+		return v, !math.IsNaN(v)
+	}
+	lat, latOK := coord(tagGPSLatitude, tagGPSLatitudeRef, "S")
+	long, longOK := coord(tagGPSLongitude, tagGPSLongitudeRef, "W")
+	ok = latOK && longOK && math.Abs(lat) <= 90 && math.Abs(long) <= 180
+	return
+}
@@ -0,0 +1,266 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+)
+
+// Builds a JPEG prefix with an APP1 Exif segment holding the given IFD0 entries.
+func makeJPEG(bo binary.ByteOrder, entries [][3]uint32) []byte {
+	var tiff bytes.Buffer
+	if bo == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(&tiff, bo, uint16(42))
+	binary.Write(&tiff, bo, uint32(8))
+	binary.Write(&tiff, bo, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&tiff, bo, uint16(e[0]))
+		binary.Write(&tiff, bo, uint16(e[1]))
+		binary.Write(&tiff, bo, uint32(1))
+		if e[1] == 3 {
+			binary.Write(&tiff, bo, uint16(e[2]))
+			binary.Write(&tiff, bo, uint16(0))
+		} else {
+			binary.Write(&tiff, bo, e[2])
+		}
+	}
+	binary.Write(&tiff, bo, uint32(0))
+	var b bytes.Buffer
+	b.Write([]byte{0xff, 0xd8})
+	// An unrelated segment first, to check it's skipped.
+	b.Write([]byte{0xff, 0xe0, 0, 4, 'J', 'F'})
+	b.Write([]byte{0xff, 0xe1})
+	binary.Write(&b, binary.BigEndian, uint16(2+6+tiff.Len()))
+	b.WriteString("Exif\x00\x00")
+	b.Write(tiff.Bytes())
+	b.Write([]byte{0xff, 0xda})
+	return b.Bytes()
+}
+
+func TestDecodeJPEGOrientation(t *testing.T) {
+	for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		info, err := DecodeJPEG(bytes.NewReader(makeJPEG(bo, [][3]uint32{{tagOrientation, 3, 6}})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Orientation != OrientationRotate90 {
+			t.Errorf("%v: got orientation %d", bo, info.Orientation)
+		}
+	}
+}
+
+func TestDecodeJPEGWithoutExif(t *testing.T) {
+	_, err := DecodeJPEG(bytes.NewReader([]byte{0xff, 0xd8, 0xff, 0xda}))
+	if err != ErrNoExif {
+		t.Fatalf("expected ErrNoExif, got %v", err)
+	}
+}
+
+// The byte orders the test files are built in.
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type testEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte
+}
+
+func asciiEntry(tag uint16, s string) testEntry {
+	return testEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+func rationalsEntry(bo byteOrder, tag uint16, vals ...[2]uint32) testEntry {
+	var b []byte
+	for _, v := range vals {
+		b = bo.AppendUint32(b, v[0])
+		b = bo.AppendUint32(b, v[1])
+	}
+	return testEntry{tag, 5, uint32(len(vals)), b}
+}
+
+func shortEntry(bo byteOrder, tag uint16, v uint16) testEntry {
+	return testEntry{tag, 3, 1, bo.AppendUint16(nil, v)}
+}
+
+// Builds a TIFF with IFD0 and, if they have entries, Exif and GPS IFDs pointed to from it.
+func makeTIFF(bo byteOrder, ifd0, exifIFD, gpsIFD []testEntry) []byte {
+	ifdSize := func(entries []testEntry) int {
+		if len(entries) == 0 {
+			return 0
+		}
+		return 2 + 12*len(entries) + 4
+	}
+	// Room for the pointer entries.
+	n0 := len(ifd0)
+	if len(exifIFD) != 0 {
+		n0++
+	}
+	if len(gpsIFD) != 0 {
+		n0++
+	}
+	exifOffset := 8 + 2 + 12*n0 + 4
+	gpsOffset := exifOffset + ifdSize(exifIFD)
+	dataOffset := gpsOffset + ifdSize(gpsIFD)
+	if len(exifIFD) != 0 {
+		ifd0 = append(ifd0, testEntry{tagExifIFD, 4, 1, bo.AppendUint32(nil, uint32(exifOffset))})
+	}
+	if len(gpsIFD) != 0 {
+		ifd0 = append(ifd0, testEntry{tagGPSIFD, 4, 1, bo.AppendUint32(nil, uint32(gpsOffset))})
+	}
+	var data []byte
+	writeIFD := func(b []byte, entries []testEntry) []byte {
+		b = bo.AppendUint16(b, uint16(len(entries)))
+		for _, e := range entries {
+			b = bo.AppendUint16(b, e.tag)
+			b = bo.AppendUint16(b, e.typ)
+			b = bo.AppendUint32(b, e.count)
+			if len(e.data) <= 4 {
+				b = append(b, e.data...)
+				b = append(b, make([]byte, 4-len(e.data))...)
+			} else {
+				b = bo.AppendUint32(b, uint32(dataOffset+len(data)))
+				data = append(data, e.data...)
+			}
+		}
+		return bo.AppendUint32(b, 0)
+	}
+	var b []byte
+	if bo == binary.LittleEndian {
+		b = append(b, "II"...)
+	} else {
+		b = append(b, "MM"...)
+	}
+	b = bo.AppendUint16(b, 42)
+	b = bo.AppendUint32(b, 8)
+	b = writeIFD(b, ifd0)
+	if len(exifIFD) != 0 {
+		b = writeIFD(b, exifIFD)
+	}
+	if len(gpsIFD) != 0 {
+		b = writeIFD(b, gpsIFD)
+	}
+	return append(b, data...)
+}
+
+func testTIFF(bo byteOrder) []byte {
+	return makeTIFF(bo,
+		[]testEntry{
+			asciiEntry(tagMake, "Canon"),
+			asciiEntry(tagModel, "EOS"),
+			shortEntry(bo, tagOrientation, 8),
+			asciiEntry(tagDateTime, "2001:01:01 00:00:00"),
+			shortEntry(bo, tagImageWidth, 100),
+			shortEntry(bo, tagImageLength, 50),
+		},
+		[]testEntry{
+			asciiEntry(tagDateTimeOriginal, "2019:07:14 13:45:10"),
+			asciiEntry(tagOffsetTimeOriginal, "+02:00"),
+			shortEntry(bo, tagPixelXDimension, 4000),
+			shortEntry(bo, tagPixelYDimension, 3000),
+		},
+		[]testEntry{
+			asciiEntry(tagGPSLatitudeRef, "N"),
+			rationalsEntry(bo, tagGPSLatitude, [2]uint32{51, 1}, [2]uint32{30, 1}, [2]uint32{36, 1}),
+			asciiEntry(tagGPSLongitudeRef, "W"),
+			rationalsEntry(bo, tagGPSLongitude, [2]uint32{0, 1}, [2]uint32{7, 1}, [2]uint32{3960, 100}),
+		},
+	)
+}
+
+func checkTestTIFFInfo(t *testing.T, info Info) {
+	t.Helper()
+	if info.Make != "Canon" || info.Model != "EOS" || info.Orientation != OrientationRotate270 {
+		t.Errorf("got %+v", info)
+	}
+	if info.Width != 4000 || info.Height != 3000 {
+		t.Errorf("got dimensions %dx%d", info.Width, info.Height)
+	}
+	expected := time.Date(2019, 7, 14, 11, 45, 10, 0, time.UTC)
+	if !info.DateTime.Equal(expected) || info.DateTime.Hour() != 13 {
+		t.Errorf("got date time %v", info.DateTime)
+	}
+	if !info.HasLocation || math.Abs(info.Latitude-51.51) > 1e-9 || math.Abs(info.Longitude+7.0/60+39.6/3600) > 1e-9 {
+		t.Errorf("got location %v, %v", info.Latitude, info.Longitude)
+	}
+}
+
+func TestDecodeTIFF(t *testing.T) {
+	for _, bo := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		info, err := Decode(bytes.NewReader(testTIFF(bo)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkTestTIFFInfo(t, info)
+	}
+}
+
+func TestDateTimeWithoutOffset(t *testing.T) {
+	info, err := DecodeTIFF(bytes.NewReader(makeTIFF(binary.BigEndian,
+		[]testEntry{asciiEntry(tagDateTime, "2001:02:03 04:05:06")}, nil, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.DateTime.Equal(time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)) || info.HasLocation {
+		t.Fatalf("got %+v", info)
+	}
+}
+
+func heifBoxBytes(typ string, payload ...[]byte) []byte {
+	body := bytes.Join(payload, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = append(b, typ...)
+	return append(b, body...)
+}
+
+func TestDecodeHEIF(t *testing.T) {
+	exifItem := append([]byte{0, 0, 0, 6}, "Exif\x00\x00"...)
+	exifItem = append(exifItem, testTIFF(binary.BigEndian)...)
+	infe := func(id uint16, itemType string) []byte {
+		b := []byte{2, 0, 0, 0}
+		b = binary.BigEndian.AppendUint16(b, id)
+		b = append(b, 0, 0)
+		b = append(b, itemType...)
+		return heifBoxBytes("infe", b, []byte{0})
+	}
+	iinf := heifBoxBytes("iinf", []byte{0, 0, 0, 0, 0, 2}, infe(1, "hvc1"), infe(2, "Exif"))
+	ftyp := heifBoxBytes("ftyp", []byte("heic\x00\x00\x00\x00mif1heic"))
+	// The iloc box has a fixed size, so the Exif item offset can be worked out before building it.
+	ilocFor := func(exifOffset uint32) []byte {
+		b := []byte{1, 0, 0, 0, 0x44, 0x00}
+		b = binary.BigEndian.AppendUint16(b, 2)
+		for _, item := range []struct {
+			id             uint16
+			offset, length uint32
+		}{{1, 0, 0}, {2, exifOffset, uint32(len(exifItem))}} {
+			b = binary.BigEndian.AppendUint16(b, item.id)
+			b = binary.BigEndian.AppendUint16(b, 0)
+			b = binary.BigEndian.AppendUint16(b, 0)
+			b = binary.BigEndian.AppendUint16(b, 1)
+			b = binary.BigEndian.AppendUint32(b, item.offset)
+			b = binary.BigEndian.AppendUint32(b, item.length)
+		}
+		return heifBoxBytes("iloc", b)
+	}
+	metaFor := func(exifOffset uint32) []byte {
+		return heifBoxBytes("meta", []byte{0, 0, 0, 0}, heifBoxBytes("hdlr", make([]byte, 25)), iinf, ilocFor(exifOffset))
+	}
+	exifOffset := uint32(len(ftyp) + len(metaFor(0)) + 8)
+	var b []byte
+	b = append(b, ftyp...)
+	b = append(b, metaFor(exifOffset)...)
+	b = append(b, heifBoxBytes("mdat", exifItem)...)
+	info, err := Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTestTIFFInfo(t, info)
+}
@@ -0,0 +1,236 @@
+package exif
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// An ISO base media file format box.
+type heifBox struct {
+	typ string
+	// The offset and size of the box's payload, after its header.
+	offset, size int64
+}
+
+// Boxes holding item metadata are small. Anything larger is assumed to be corrupt.
+const maxHEIFMetaBoxSize = 1 << 20
+
+// Lists the boxes in the region given. end is -1 to read until the end of r.
+func heifBoxes(r io.ReaderAt, offset, end int64) (ret []heifBox, err error) {
+	for end < 0 || offset+8 <= end {
+		var header [16]byte
+		if _, err = r.ReadAt(header[:8], offset); err != nil {
+			if err == io.EOF && end < 0 {
+				err = nil
+			}
+			return
+		}
+		size := int64(binary.BigEndian.Uint32(header[:]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			if end < 0 {
+				// It runs to the end of the file, and must be last.
+				ret = append(ret, heifBox{string(header[4:8]), offset + headerSize, -1})
+				return
+			}
+			size = end - offset
+		case 1:
+			if _, err = r.ReadAt(header[8:], offset+8); err != nil {
+				return
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || end >= 0 && offset+size > end {
+			err = errors.New("bad heif box size")
+			return
+		}
+		ret = append(ret, heifBox{string(header[4:8]), offset + headerSize, size - headerSize})
+		offset += size
+	}
+	return
+}
+
+func findHEIFBox(boxes []heifBox, typ string) (heifBox, bool) {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b, true
+		}
+	}
+	return heifBox{}, false
+}
+
+func readHEIFBox(r io.ReaderAt, b heifBox) ([]byte, error) {
+	if b.size < 0 || b.size > maxHEIFMetaBoxSize {
+		return nil, errors.New("implausible heif box size")
+	}
+	ret := make([]byte, b.size)
+	_, err := r.ReadAt(ret, b.offset)
+	return ret, err
+}
+
+// DecodeHEIF reads the EXIF metadata from a HEIF image, such as the HEIC photos taken by phones.
+// The metadata is an item of type "Exif", located by the iinf and iloc boxes within meta.
+func DecodeHEIF(r io.ReaderAt) (info Info, err error) {
+	top, err := heifBoxes(r, 0, -1)
+	if err != nil {
+		return
+	}
+	meta, ok := findHEIFBox(top, "meta")
+	if !ok || meta.size < 4 {
+		err = ErrNoExif
+		return
+	}
+	// meta is a full box, with a version and flags before its children.
+	children, err := heifBoxes(r, meta.offset+4, meta.offset+meta.size)
+	if err != nil {
+		return
+	}
+	iinf, ok1 := findHEIFBox(children, "iinf")
+	iloc, ok2 := findHEIFBox(children, "iloc")
+	if !ok1 || !ok2 {
+		err = ErrNoExif
+		return
+	}
+	b, err := readHEIFBox(r, iinf)
+	if err != nil {
+		return
+	}
+	itemID, ok := exifItemID(r, iinf, b)
+	if !ok {
+		err = ErrNoExif
+		return
+	}
+	if b, err = readHEIFBox(r, iloc); err != nil {
+		return
+	}
+	offset, length, ok := heifItemLocation(b, itemID)
+	if !ok || length < 4 {
+		err = ErrNoExif
+		return
+	}
+	// The item starts with the offset of the TIFF header past the 4 bytes giving it, usually to
+	// skip an "Exif\0\0" prefix.
+	var skipBuf [4]byte
+	if _, err = r.ReadAt(skipBuf[:], offset); err != nil {
+		return
+	}
+	skip := int64(binary.BigEndian.Uint32(skipBuf[:])) + 4
+	if skip >= length {
+		err = ErrNoExif
+		return
+	}
+	return decodeTIFF(io.NewSectionReader(r, offset+skip, length-skip))
+}
+
+// Returns the ID of the Exif item from the infe entries of an iinf box.
+func exifItemID(r io.ReaderAt, iinf heifBox, b []byte) (uint32, bool) {
+	if len(b) < 4 {
+		return 0, false
+	}
+	countSize := int64(2)
+	if b[0] != 0 {
+		countSize = 4
+	}
+	entries, err := heifBoxes(r, iinf.offset+4+countSize, iinf.offset+iinf.size)
+	if err != nil {
+		return 0, false
+	}
+	for _, e := range entries {
+		if e.typ != "infe" {
+			continue
+		}
+		b, err := readHEIFBox(r, e)
+		if err != nil || len(b) < 4 {
+			continue
+		}
+		// Versions 2 and 3 give the item type. Earlier ones predate HEIF.
+		var id uint32
+		var itemType []byte
+		switch b[0] {
+		case 2:
+			if len(b) < 12 {
+				continue
+			}
+			id, itemType = uint32(binary.BigEndian.Uint16(b[4:])), b[8:12]
+		case 3:
+			if len(b) < 14 {
+				continue
+			}
+			id, itemType = binary.BigEndian.Uint32(b[4:]), b[10:14]
+		default:
+			continue
+		}
+		if string(itemType) == "Exif" {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// Returns the file offset and length of the first extent of an item, from an iloc box. Only items
+// stored in the file itself are supported.
+func heifItemLocation(b []byte, itemID uint32) (offset, length int64, ok bool) {
+	if len(b) < 8 {
+		return
+	}
+	version := b[0]
+	offsetSize, lengthSize := int(b[4]>>4), int(b[4]&0xf)
+	baseOffsetSize, indexSize := int(b[5]>>4), int(b[5]&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+	b = b[6:]
+	readN := func(n int) (uint64, bool) {
+		if n > len(b) || n > 8 {
+			return 0, false
+		}
+		var v uint64
+		for _, c := range b[:n] {
+			v = v<<8 | uint64(c)
+		}
+		b = b[n:]
+		return v, true
+	}
+	idSize := 2
+	if version == 2 {
+		idSize = 4
+	}
+	count, ok := readN(idSize)
+	if !ok {
+		return
+	}
+	for i := uint64(0); i < count; i++ {
+		id, ok1 := readN(idSize)
+		var method uint64
+		ok2 := true
+		if version >= 1 {
+			method, ok2 = readN(2)
+			method &= 0xf
+		}
+		// The data reference index.
+		_, ok3 := readN(2)
+		base, ok4 := readN(baseOffsetSize)
+		extents, ok5 := readN(2)
+		if !(ok1 && ok2 && ok3 && ok4 && ok5) {
+			return 0, 0, false
+		}
+		for j := uint64(0); j < extents; j++ {
+			_, ok1 := readN(indexSize)
+			extentOffset, ok2 := readN(offsetSize)
+			extentLength, ok3 := readN(lengthSize)
+			if !(ok1 && ok2 && ok3) {
+				return 0, 0, false
+			}
+			if uint32(id) == itemID && j == 0 {
+				if method != 0 {
+					return 0, 0, false
+				}
+				return int64(base + extentOffset), int64(extentLength), true
+			}
+		}
+	}
+	return 0, 0, false
+}
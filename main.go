@@ -33,26 +33,54 @@ import (
 var defaultIcon []byte
 
 type dmsConfig struct {
-	Path                string
-	IfName              string
-	Http                string
-	FriendlyName        string
-	DeviceIcon          string
-	DeviceIconSizes     []string
-	LogHeaders          bool
-	FFprobeCachePath    string
-	NoTranscode         bool
-	DefaultTranscode    bool
-	ForceTranscodeTo    string
-	NoProbe             bool
-	StallEventSubscribe bool
-	NotifyInterval      time.Duration
-	IgnoreHidden        bool
-	IgnoreUnreadable    bool
-	IgnorePaths         []string
-	AllowedIpNets       []*net.IPNet
-	AllowDynamicStreams bool
-	TranscodeLogPattern string
+	Path                    string
+	IfName                  string
+	Http                    string
+	FriendlyName            string
+	DeviceIcon              string
+	DeviceIconSizes         []string
+	LogHeaders              bool
+	FFprobeCachePath        string
+	NoTranscode             bool
+	DefaultTranscode        bool
+	ForceTranscodeTo        string
+	NoProbe                 bool
+	StallEventSubscribe     bool
+	NotifyInterval          time.Duration
+	IgnoreHidden            bool
+	IgnoreUnreadable        bool
+	IgnorePaths             []string
+	AllowedIpNets           []*net.IPNet
+	DeniedIpNets            []*net.IPNet
+	AdminIpNets             []*net.IPNet
+	PathAccess              []dms.PathAccess
+	ClientProfiles          []dms.ClientProfile
+	AllowDynamicStreams     bool
+	StreamCommands          map[string]dms.StreamCommand
+	StreamURLs              []string
+	AllowStreamCommandLines bool
+	LiveStreamGracePeriod   time.Duration
+	LiveTimeShift           time.Duration
+	LiveTimeShiftMaxSizeMB  int64
+	RecordingsPath          string
+	TranscodeLogPattern     string
+	MaxTranscodes           int
+	MaxTranscodesPerClient  int
+	CachePath               string
+	CacheMaxSizeMB          int64
+	ThumbnailPosition       string
+	AlbumArtFiles           []string
+	NoEmbeddedAlbumArt      bool
+	SeriesView              bool
+	PhotosView              bool
+	AudioLanguages          []string
+	SubtitleLanguages       []string
+	RendererPreferences     []dms.RendererTrackPreferences
+	ExtraProtocolInfo       []string
+	DeviceRegistryPath      string
+	ApprovedDevices         []string
+	DeniedDevices           []string
+	RequireDeviceApproval   bool
 }
 
 func (config *dmsConfig) load(configPath string) {
@@ -72,15 +100,17 @@ func (config *dmsConfig) load(configPath string) {
 
 // default config
 var config = &dmsConfig{
-	Path:             "",
-	IfName:           "",
-	Http:             ":1338",
-	FriendlyName:     "",
-	DeviceIcon:       "",
-	DeviceIconSizes:  []string{"48,128"},
-	LogHeaders:       false,
-	FFprobeCachePath: getDefaultFFprobeCachePath(),
-	ForceTranscodeTo: "",
+	Path:               "",
+	IfName:             "",
+	Http:               ":1338",
+	FriendlyName:       "",
+	DeviceIcon:         "",
+	DeviceIconSizes:    []string{"48,128"},
+	LogHeaders:         false,
+	FFprobeCachePath:   getDefaultFFprobeCachePath(),
+	CachePath:          getDefaultCachePath(),
+	DeviceRegistryPath: getDefaultDeviceRegistryPath(),
+	ForceTranscodeTo:   "",
 }
 
 func getDefaultFFprobeCachePath() (path string) {
@@ -93,6 +123,26 @@ func getDefaultFFprobeCachePath() (path string) {
 	return
 }
 
+func getDefaultCachePath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	path = filepath.Join(_user.HomeDir, ".dms", "cache")
+	return
+}
+
+func getDefaultDeviceRegistryPath() (path string) {
+	_user, err := user.Current()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	path = filepath.Join(_user.HomeDir, ".dms", "devices.json")
+	return
+}
+
 type fFprobeCache struct {
 	c *rrcache.RRCache
 	sync.Mutex
@@ -127,6 +177,9 @@ func main() {
 }
 
 func mainErr() error {
+	if len(os.Args) > 1 && os.Args[1] == "check-streams" {
+		return checkStreams(os.Args[2:])
+	}
 	path := flag.String("path", config.Path, "browse root path")
 	ifName := flag.String("ifname", config.IfName, "specific SSDP network interface")
 	http := flag.String("http", config.Http, "http server port")
@@ -135,8 +188,24 @@ func mainErr() error {
 	deviceIconSizes := flag.String("deviceIconSizes", strings.Join(config.DeviceIconSizes, ","), "comma separated list of icon sizes to advertise, eg 48,128,256. Use 48:512,128:512 format to force actual size.")
 	logHeaders := flag.Bool("logHeaders", config.LogHeaders, "log HTTP headers")
 	fFprobeCachePath := flag.String("fFprobeCachePath", config.FFprobeCachePath, "path to FFprobe cache file")
+	flag.StringVar(&config.CachePath, "cachePath", config.CachePath, "directory to cache generated files such as resized images in, empty to disable")
+	flag.Int64Var(&config.CacheMaxSizeMB, "cacheMaxSizeMB", 512, "size in megabytes the cache directory is trimmed to, 0 for unbounded")
+	flag.StringVar(&config.ThumbnailPosition, "thumbnailPosition", "10%", "position in videos to take thumbnails from, as a percentage of the duration, a duration like 1m30s, or 'random'")
+	albumArtFiles := flag.String("albumArtFiles", "", "comma separated list of image file patterns used as album art in a directory, in order of preference (default folder.jpg,folder.png,cover.jpg,cover.png,front.jpg,AlbumArt*.jpg,poster.jpg,poster.png)")
+	flag.BoolVar(&config.NoEmbeddedAlbumArt, "noEmbeddedAlbumArt", false, "don't use cover art embedded in media files")
+	flag.BoolVar(&config.SeriesView, "seriesView", false, "add a container of TV series, seasons and episodes found anywhere in the library")
+	flag.BoolVar(&config.PhotosView, "photosView", false, "add a container of photos found anywhere in the library by the year and month they were taken")
+	audioLanguages := flag.String("audioLanguages", "", "comma separated list of preferred audio languages for transcodes, eg en,de")
+	subtitleLanguages := flag.String("subtitleLanguages", "", "comma separated list of preferred subtitle languages, burned into transcodes when the audio isn't in a preferred language")
+	extraProtocolInfo := flag.String("extraProtocolInfo", "", "comma separated list of protocolInfo entries to advertise in addition to those generated, eg http-get:*:video/mpeg:DLNA.ORG_PN=AVC_TS_HD_24_AC3")
+	flag.StringVar(&config.DeviceRegistryPath, "deviceRegistry", config.DeviceRegistryPath, "file to keep the media receivers that registered with the server in, and whether they're approved, empty to not persist them")
+	approvedDevices := flag.String("approvedDevices", "", "comma separated list of media receiver device IDs or addresses to approve")
+	deniedDevices := flag.String("deniedDevices", "", "comma separated list of media receiver device IDs or addresses to deny")
+	flag.BoolVar(&config.RequireDeviceApproval, "requireDeviceApproval", false, "keep every client but approved media receivers out")
 	configFilePath := flag.String("config", "", "json configuration file")
 	allowedIps := flag.String("allowedIps", "", "allowed ip of clients, separated by comma")
+	deniedIps := flag.String("deniedIps", "", "denied ip of clients, separated by comma")
+	adminIps := flag.String("adminIps", "", "ip of clients allowed to use the debug and admin endpoints, separated by comma (default the local host)")
 	forceTranscodeTo := flag.String("forceTranscodeTo", config.ForceTranscodeTo, "force transcoding to certain format, supported: 'chromecast', 'vp8', 'web'")
 	transcodeLogPattern := flag.String("transcodeLogPattern", "", "pattern where to write transcode logs to. The [tsname] placeholder is replaced with the name of the item currently being played. The default is $HOME/.dms/log/[tsname]")
 	flag.BoolVar(&config.NoTranscode, "noTranscode", false, "disable transcoding")
@@ -148,6 +217,13 @@ func mainErr() error {
 	flag.BoolVar(&config.IgnoreUnreadable, "ignoreUnreadable", false, "ignore unreadable files and directories")
 	ignorePaths := flag.String("ignore", "", "comma separated list of directories to ignore (i.e. thumbnails,thumbs)")
 	flag.BoolVar(&config.AllowDynamicStreams, "allowDynamicStreams", false, "activate support for dynamic streams described via .dms.json metadata files")
+	flag.BoolVar(&config.AllowStreamCommandLines, "allowStreamCommandLines", false, "let dynamic streams run any command line given in their .dms.json files, rather than only the stream commands configured")
+	flag.DurationVar(&config.LiveStreamGracePeriod, "liveStreamGrace", 10*time.Second, "how long a live dynamic stream keeps running after its last client leaves")
+	flag.DurationVar(&config.LiveTimeShift, "liveTimeShift", 0, "how much of live dynamic streams to keep in memory for clients to seek back within, 0 to disable")
+	flag.Int64Var(&config.LiveTimeShiftMaxSizeMB, "liveTimeShiftMaxSizeMB", 256, "most megabytes of each live dynamic stream kept for time-shifting, 0 for unbounded")
+	flag.StringVar(&config.RecordingsPath, "recordings", "", "directory under the path to write recordings of dynamic streams to, relative to the path unless absolute. Empty to disable recording")
+	flag.IntVar(&config.MaxTranscodes, "maxTranscodes", 0, "maximum number of concurrent transcodes, 0 for unlimited")
+	flag.IntVar(&config.MaxTranscodesPerClient, "maxTranscodesPerClient", 0, "maximum number of concurrent transcodes of the same file per client, older ones are cancelled. Starting a transcode of another file always cancels the client's others. 0 for unlimited")
 
 	flag.Parse()
 	if flag.NArg() != 0 {
@@ -167,9 +243,33 @@ func mainErr() error {
 	config.LogHeaders = *logHeaders
 	config.FFprobeCachePath = *fFprobeCachePath
 	config.AllowedIpNets = makeIpNets(*allowedIps)
+	if *deniedIps != "" {
+		config.DeniedIpNets = makeIpNets(*deniedIps)
+	}
+	if *adminIps != "" {
+		config.AdminIpNets = makeIpNets(*adminIps)
+	}
 	config.ForceTranscodeTo = *forceTranscodeTo
 	config.IgnorePaths = strings.Split(*ignorePaths, ",")
 	config.TranscodeLogPattern = *transcodeLogPattern
+	if *albumArtFiles != "" {
+		config.AlbumArtFiles = strings.Split(*albumArtFiles, ",")
+	}
+	if *audioLanguages != "" {
+		config.AudioLanguages = strings.Split(*audioLanguages, ",")
+	}
+	if *subtitleLanguages != "" {
+		config.SubtitleLanguages = strings.Split(*subtitleLanguages, ",")
+	}
+	if *extraProtocolInfo != "" {
+		config.ExtraProtocolInfo = strings.Split(*extraProtocolInfo, ",")
+	}
+	if *approvedDevices != "" {
+		config.ApprovedDevices = strings.Split(*approvedDevices, ",")
+	}
+	if *deniedDevices != "" {
+		config.DeniedDevices = strings.Split(*deniedDevices, ",")
+	}
 
 	if config.TranscodeLogPattern == "" {
 		u, err := user.Current()
@@ -183,11 +283,22 @@ func mainErr() error {
 		config.load(*configFilePath)
 	}
 
+	for _, env := range []string{"DMS_THUMBNAIL_RANDOM", "DMS_THUMBNAIL_FULLQUALITY"} {
+		if _, ok := os.LookupEnv(env); ok {
+			logger.Printf("%s is no longer used, see -thumbnailPosition", env)
+		}
+	}
 	logger.Printf("device icon sizes are %q", config.DeviceIconSizes)
 	logger.Printf("allowed ip nets are %q", config.AllowedIpNets)
+	if len(config.DeniedIpNets) != 0 {
+		logger.Printf("denied ip nets are %q", config.DeniedIpNets)
+	}
 	logger.Printf("serving folder %q", config.Path)
 	if config.AllowDynamicStreams {
 		logger.Printf("Dynamic streams ARE allowed")
+		if config.AllowStreamCommandLines {
+			logger.Printf("Dynamic streams may run ANY command line")
+		}
 	}
 
 	cache := &fFprobeCache{
@@ -230,16 +341,40 @@ func mainErr() error {
 			}
 			return conn
 		}(),
-		FriendlyName:        config.FriendlyName,
-		RootObjectPath:      filepath.Clean(config.Path),
-		FFProbeCache:        cache,
-		LogHeaders:          config.LogHeaders,
-		NoTranscode:         config.NoTranscode,
-		AllowDynamicStreams: config.AllowDynamicStreams,
-		DefaultTranscode:    config.DefaultTranscode,
-		ForceTranscodeTo:    config.ForceTranscodeTo,
-		TranscodeLogPattern: config.TranscodeLogPattern,
-		NoProbe:             config.NoProbe,
+		FriendlyName:               config.FriendlyName,
+		RootObjectPath:             filepath.Clean(config.Path),
+		FFProbeCache:               cache,
+		LogHeaders:                 config.LogHeaders,
+		NoTranscode:                config.NoTranscode,
+		AllowDynamicStreams:        config.AllowDynamicStreams,
+		StreamCommands:             config.StreamCommands,
+		StreamURLs:                 config.StreamURLs,
+		AllowStreamCommandLines:    config.AllowStreamCommandLines,
+		LiveStreamGracePeriod:      config.LiveStreamGracePeriod,
+		LiveTimeShift:              config.LiveTimeShift,
+		LiveTimeShiftMaxSize:       config.LiveTimeShiftMaxSizeMB << 20,
+		RecordingsPath:             config.RecordingsPath,
+		DefaultTranscode:           config.DefaultTranscode,
+		ForceTranscodeTo:           config.ForceTranscodeTo,
+		TranscodeLogPattern:        config.TranscodeLogPattern,
+		NoProbe:                    config.NoProbe,
+		MaxTranscodes:              config.MaxTranscodes,
+		MaxTranscodesPerClient:     config.MaxTranscodesPerClient,
+		CachePath:                  config.CachePath,
+		CacheMaxSize:               config.CacheMaxSizeMB << 20,
+		ThumbnailPosition:          config.ThumbnailPosition,
+		AlbumArtFiles:              config.AlbumArtFiles,
+		NoEmbeddedAlbumArt:         config.NoEmbeddedAlbumArt,
+		PreferredAudioLanguages:    config.AudioLanguages,
+		PreferredSubtitleLanguages: config.SubtitleLanguages,
+		RendererTrackPreferences:   config.RendererPreferences,
+		SeriesView:                 config.SeriesView,
+		PhotosView:                 config.PhotosView,
+		ExtraProtocolInfo:          config.ExtraProtocolInfo,
+		DeviceRegistryPath:         config.DeviceRegistryPath,
+		ApprovedDevices:            config.ApprovedDevices,
+		DeniedDevices:              config.DeniedDevices,
+		RequireDeviceApproval:      config.RequireDeviceApproval,
 		Icons: func() []dms.Icon {
 			var icons []dms.Icon
 			for _, size := range config.DeviceIconSizes {
@@ -275,6 +410,10 @@ func mainErr() error {
 		IgnoreUnreadable:    config.IgnoreUnreadable,
 		IgnorePaths:         config.IgnorePaths,
 		AllowedIpNets:       config.AllowedIpNets,
+		DeniedIpNets:        config.DeniedIpNets,
+		AdminIpNets:         config.AdminIpNets,
+		PathAccess:          config.PathAccess,
+		ClientProfiles:      config.ClientProfiles,
 	}
 	if err := dmsServer.Init(); err != nil {
 		log.Fatalf("error initing dms server: %v", err)
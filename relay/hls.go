@@ -0,0 +1,240 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An HLS playlist: a master playlist with variants, or a media playlist with segments.
+type playlist struct {
+	variants []variant
+	// The segment URLs, and the media sequence number of the first.
+	segments      []string
+	mediaSequence int
+	// The URL of the initialization section for fragmented MP4 segments, if any.
+	mapURL         string
+	targetDuration time.Duration
+	// No more segments will be added.
+	endList   bool
+	encrypted bool
+}
+
+type variant struct {
+	bandwidth int
+	url       string
+}
+
+// Parses an M3U8 playlist, resolving URIs against the playlist's URL.
+func parsePlaylist(r io.Reader, base *url.URL) (pl playlist, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	first := true
+	resolve := func(ref string) string {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+	var nextBandwidth int
+	var streamInf bool
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if first {
+			first = false
+			if line != "#EXTM3U" {
+				return pl, errors.New("not an M3U8 playlist")
+			}
+			continue
+		}
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			streamInf = true
+			nextBandwidth, _ = strconv.Atoi(playlistAttrs(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))["BANDWIDTH"])
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			pl.mediaSequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			secs, _ := strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+			pl.targetDuration = time.Duration(secs) * time.Second
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			pl.mapURL = resolve(playlistAttrs(strings.TrimPrefix(line, "#EXT-X-MAP:"))["URI"])
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			if playlistAttrs(strings.TrimPrefix(line, "#EXT-X-KEY:"))["METHOD"] != "NONE" {
+				pl.encrypted = true
+			}
+		case line == "#EXT-X-ENDLIST":
+			pl.endList = true
+		case strings.HasPrefix(line, "#"):
+		case streamInf:
+			pl.variants = append(pl.variants, variant{nextBandwidth, resolve(line)})
+			streamInf = false
+		default:
+			pl.segments = append(pl.segments, resolve(line))
+		}
+	}
+	if first {
+		return pl, errors.New("empty playlist")
+	}
+	return pl, s.Err()
+}
+
+// Parses an attribute list like `BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2"`.
+func playlistAttrs(s string) map[string]string {
+	ret := make(map[string]string)
+	for s != "" {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.IndexByte(s, ','); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		ret[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return ret
+}
+
+// Fetches and parses the playlist at the URL.
+func (opts Options) getPlaylist(ctx context.Context, playlistURL string) (playlist, error) {
+	resp, err := opts.get(ctx, playlistURL)
+	if err != nil {
+		return playlist{}, err
+	}
+	return readPlaylist(playlistURL, resp)
+}
+
+func readPlaylist(playlistURL string, resp *http.Response) (playlist, error) {
+	defer resp.Body.Close()
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return playlist{}, err
+	}
+	return parsePlaylist(resp.Body, base)
+}
+
+// Follows an HLS playlist, writing its segments one after another. The variant with the highest
+// bandwidth is taken from master playlists.
+func openHLS(ctx context.Context, playlistURL string, resp *http.Response, opts Options) (*Stream, error) {
+	pl, err := readPlaylist(playlistURL, resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(pl.variants) != 0 {
+		best := pl.variants[0]
+		for _, v := range pl.variants[1:] {
+			if v.bandwidth > best.bandwidth {
+				best = v
+			}
+		}
+		playlistURL = best.url
+		if pl, err = opts.getPlaylist(ctx, playlistURL); err != nil {
+			return nil, err
+		}
+	}
+	if pl.encrypted {
+		return nil, errors.New("encrypted HLS streams aren't supported")
+	}
+	if len(pl.variants) != 0 {
+		return nil, errors.New("nested HLS master playlists")
+	}
+	contentType := "video/mp2t"
+	if pl.mapURL != "" {
+		contentType = "video/mp4"
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(opts.followPlaylist(ctx, playlistURL, pl, pw))
+	}()
+	return &Stream{ReadCloser: pr, ContentType: contentType}, nil
+}
+
+func (opts Options) followPlaylist(ctx context.Context, playlistURL string, pl playlist, w io.Writer) error {
+	// The media sequence number of the next segment to write.
+	next := -1
+	mapURL := ""
+	failures := 0
+	for {
+		if pl.encrypted {
+			return errors.New("HLS stream became encrypted")
+		}
+		if next < pl.mediaSequence {
+			next = pl.mediaSequence
+		} else if next > pl.mediaSequence+len(pl.segments) {
+			// The sequence went back, as it does when the encoder restarts.
+			opts.Logger.Printf("HLS playlist %s restarted at media sequence %d, from %d", playlistURL, pl.mediaSequence, next)
+			next = pl.mediaSequence
+		}
+		if pl.mapURL != mapURL {
+			if err := opts.copySegment(ctx, pl.mapURL, w); err != nil {
+				return err
+			}
+			mapURL = pl.mapURL
+		}
+		for i := next - pl.mediaSequence; i < len(pl.segments); i++ {
+			if err := opts.copySegment(ctx, pl.segments[i], w); err != nil {
+				var pipeErr *writeError
+				if errors.As(err, &pipeErr) || !opts.Reconnect || ctx.Err() != nil {
+					return err
+				}
+				opts.Logger.Printf("error fetching HLS segment %s: %v", pl.segments[i], err)
+				break
+			}
+			next++
+		}
+		if pl.endList && next-pl.mediaSequence >= len(pl.segments) {
+			return nil
+		}
+		// Poll at half the target duration, as the spec suggests when a reload brings nothing new.
+		wait := pl.targetDuration / 2
+		if wait <= 0 {
+			wait = time.Second
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		newPL, err := opts.getPlaylist(ctx, playlistURL)
+		if err != nil {
+			if !opts.Reconnect || ctx.Err() != nil {
+				return err
+			}
+			opts.Logger.Printf("error reloading HLS playlist %s: %v", playlistURL, err)
+			if err := sleep(ctx, reconnectDelay(failures)); err != nil {
+				return err
+			}
+			failures++
+			continue
+		}
+		failures = 0
+		pl = newPL
+	}
+}
+
+func (opts Options) copySegment(ctx context.Context, segmentURL string, w io.Writer) error {
+	resp, err := opts.get(ctx, segmentURL)
+	if err != nil {
+		return fmt.Errorf("fetching segment: %w", err)
+	}
+	return copyBody(resp, w)
+}
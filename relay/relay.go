@@ -0,0 +1,300 @@
+// Package relay fetches remote streams for dms to serve: plain HTTP streams, including
+// Shoutcast/Icecast radio with the ICY metadata stripped, and HLS playlists, whose segments are
+// joined into one stream. Live streams can be reconnected to when they drop.
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// Options for opening a stream.
+type Options struct {
+	// Headers added to every request, such as Authorization or User-Agent.
+	Header http.Header
+	// Reconnect when the stream drops or a request fails after it's been opened, until the
+	// context is done. Meant for live streams, as reconnecting starts them over.
+	Reconnect bool
+	// The client to make requests with. If nil, one that refuses to connect to loopback and
+	// link-local addresses, such as cloud metadata endpoints. Other addresses, including private
+	// ones on the LAN, are only kept out by Allow.
+	Client *http.Client
+	// (optional) Checks every URL requested: the one opened, redirects, and the variant
+	// playlists, initialization sections and segments of HLS streams. Requests it returns an
+	// error for aren't made.
+	Allow func(url string) error
+	// Where dropped connections and failures reconnected on are reported.
+	Logger log.Logger
+}
+
+// Returned, wrapped, for connections to loopback and link-local addresses by the default client.
+var ErrLocalAddress = errors.New("refusing to connect to a loopback or link-local address")
+
+var defaultClient = func() *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would make the connections checked ones to it.
+	t.Proxy = nil
+	t.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   refuseLocalAddress,
+	}).DialContext
+	return &http.Client{Transport: t}
+}()
+
+// Checks the address being connected to, once it's resolved, so host names resolving to local
+// addresses are caught too.
+func refuseLocalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", ErrLocalAddress, host)
+	}
+	return nil
+}
+
+// The delays between reconnection attempts, the last repeating.
+var reconnectDelays = []time.Duration{
+	0, time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+// Stream is an opened remote stream.
+type Stream struct {
+	io.ReadCloser
+	// The MIME-type of the data read, without parameters. Empty if the server didn't say.
+	ContentType string
+}
+
+// Open starts fetching the stream at the URL. An HLS playlist is detected from its content type
+// or ".m3u8" extension. Failures before any data is available are returned, rather than
+// reconnected on.
+func Open(ctx context.Context, url string, opts Options) (*Stream, error) {
+	if opts.Client == nil {
+		opts.Client = defaultClient
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	resp, err := opts.get(ctx, url)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if isPlaylist(url, resp) {
+		s, err := openHLS(ctx, url, resp, opts)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		s.ReadCloser = &cancelCloser{s.ReadCloser, cancel}
+		return s, nil
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(opts.copyHTTP(ctx, url, resp, pw))
+	}()
+	return &Stream{
+		ReadCloser:  &cancelCloser{pr, cancel},
+		ContentType: mediaType(resp.Header.Get("Content-Type")),
+	}, nil
+}
+
+// Makes a GET request with the configured headers, failing on anything but a successful status.
+func (opts Options) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Shoutcast and Icecast servers only interleave metadata if asked, and some refuse clients
+	// that don't.
+	req.Header.Set("Icy-MetaData", "1")
+	for k, vs := range opts.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	if err := opts.allow(url); err != nil {
+		return nil, err
+	}
+	client := *opts.Client
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return opts.allow(req.URL.String())
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func (opts Options) allow(url string) error {
+	if opts.Allow == nil {
+		return nil
+	}
+	return opts.Allow(url)
+}
+
+// Copies the body of the response to w, reconnecting to the URL if it drops and that's wanted.
+func (opts Options) copyHTTP(ctx context.Context, url string, resp *http.Response, w io.Writer) error {
+	for attempt := 0; ; {
+		err := copyBody(resp, w)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var pipeErr *writeError
+		if errors.As(err, &pipeErr) {
+			return err
+		}
+		if !opts.Reconnect {
+			return err
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		opts.Logger.Printf("stream %s dropped (%v), reconnecting", url, err)
+		for {
+			if err := sleep(ctx, reconnectDelay(attempt)); err != nil {
+				return err
+			}
+			attempt++
+			resp, err = opts.get(ctx, url)
+			if err == nil {
+				attempt = 0
+				break
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			opts.Logger.Printf("error reconnecting to %s: %v", url, err)
+		}
+	}
+}
+
+// An error writing to the consumer of the stream, which means it's gone.
+type writeError struct{ err error }
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+// Copies the body to w, without any ICY metadata, and closes it.
+func copyBody(resp *http.Response, w io.Writer) error {
+	defer resp.Body.Close()
+	var r io.Reader = resp.Body
+	if metaint, err := strconv.Atoi(resp.Header.Get("icy-metaint")); err == nil && metaint > 0 {
+		r = &icyReader{r: r, metaint: metaint, left: metaint}
+	}
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return &writeError{werr}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func reconnectDelay(attempt int) time.Duration {
+	if attempt >= len(reconnectDelays) {
+		attempt = len(reconnectDelays) - 1
+	}
+	return reconnectDelays[attempt]
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mt
+}
+
+// Cancels the fetching when the stream is closed.
+type cancelCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (me *cancelCloser) Close() error {
+	me.cancel()
+	return me.ReadCloser.Close()
+}
+
+// Removes the metadata a Shoutcast or Icecast server interleaves with the audio every metaint
+// bytes: a length byte, and that many 16 byte blocks.
+type icyReader struct {
+	r       io.Reader
+	metaint int
+	// Audio bytes until the next metadata.
+	left int
+}
+
+func (me *icyReader) Read(p []byte) (int, error) {
+	if me.left == 0 {
+		var l [1]byte
+		if _, err := io.ReadFull(me.r, l[:]); err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(io.Discard, me.r, int64(l[0])*16); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		me.left = me.metaint
+	}
+	if len(p) > me.left {
+		p = p[:me.left]
+	}
+	n, err := me.r.Read(p)
+	me.left -= n
+	return n, err
+}
+
+func isPlaylist(url string, resp *http.Response) bool {
+	switch strings.ToLower(mediaType(resp.Header.Get("Content-Type"))) {
+	case "application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl", "audio/x-mpegurl":
+		return true
+	}
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		url = url[:i]
+	}
+	return strings.HasSuffix(strings.ToLower(url), ".m3u8")
+}
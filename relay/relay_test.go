@@ -0,0 +1,197 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestICYMetadataStripped(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "secret" || r.Header.Get("Icy-MetaData") != "1" {
+			http.Error(w, "no token", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("icy-metaint", "4")
+		meta := "StreamTitle='x';"
+		fmt.Fprintf(w, "abcd%c%-32s", 2, meta)
+		fmt.Fprintf(w, "efgh%c", 0)
+		fmt.Fprint(w, "ij")
+	}))
+	defer srv.Close()
+	if _, err := Open(context.Background(), srv.URL, Options{Client: srv.Client()}); err == nil {
+		t.Fatal("expected error without the header")
+	}
+	s, err := Open(context.Background(), srv.URL, Options{Header: http.Header{"X-Token": {"secret"}}, Client: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "abcdefghij" || s.ContentType != "audio/mpeg" {
+		t.Fatalf("got %q, %q", b, s.ContentType)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "part%d;", atomic.AddInt32(&requests, 1))
+	}))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := Open(ctx, srv.URL, Options{Reconnect: true, Logger: log.Default, Client: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	buf := make([]byte, len("part1;part2;"))
+	if _, err := io.ReadFull(s, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "part1;part2;" {
+		t.Fatalf("got %q", buf)
+	}
+}
+
+func TestHLS(t *testing.T) {
+	var reloads int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/master.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100000\nlow/index.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=900000,CODECS=\"avc1.4d401f,mp4a.40.2\"\nhigh/index.m3u8\n")
+	})
+	mux.HandleFunc("/high/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		// A live playlist that slides along, then ends.
+		if atomic.AddInt32(&reloads, 1) == 1 {
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0\n#EXT-X-MEDIA-SEQUENCE:7\n#EXTINF:2,\nseg7.ts\n#EXTINF:2,\nseg8.ts\n")
+			return
+		}
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0\n#EXT-X-MEDIA-SEQUENCE:8\n#EXTINF:2,\nseg8.ts\n#EXTINF:2,\n/high/seg9.ts\n#EXT-X-ENDLIST\n")
+	})
+	mux.HandleFunc("/high/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/high/"), ".ts")+";")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, err := Open(ctx, srv.URL+"/master.m3u8", Options{Client: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b, err := ioutil.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "seg7;seg8;seg9;" || s.ContentType != "video/mp2t" {
+		t.Fatalf("got %q, %q", b, s.ContentType)
+	}
+}
+
+func TestPlaylistAttrs(t *testing.T) {
+	attrs := playlistAttrs(`BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=640x360`)
+	if attrs["BANDWIDTH"] != "1280000" || attrs["CODECS"] != "avc1.4d401f,mp4a.40.2" || attrs["RESOLUTION"] != "640x360" {
+		t.Fatalf("got %q", attrs)
+	}
+}
+
+func TestLocalAddressRefused(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("connected to the local server")
+	}))
+	defer srv.Close()
+	for _, url := range []string{srv.URL, "http://169.254.169.254/latest/meta-data/", "http://[::1]:1/"} {
+		if _, err := Open(context.Background(), url, Options{}); !errors.Is(err, ErrLocalAddress) {
+			t.Errorf("%s: got %v", url, err)
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/private/stream", http.StatusFound)
+	})
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:1\n#EXTINF:1,\nseg1.ts\n#EXTINF:1,\n/private/seg2.ts\n#EXT-X-ENDLIST\n")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/private/") {
+			t.Errorf("requested %s", r.URL.Path)
+		}
+		fmt.Fprint(w, "data")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	errPrivate := errors.New("private")
+	opts := Options{
+		Client: srv.Client(),
+		Allow: func(url string) error {
+			if strings.Contains(url, "/private/") {
+				return errPrivate
+			}
+			return nil
+		},
+	}
+	if _, err := Open(context.Background(), srv.URL+"/private/stream", opts); !errors.Is(err, errPrivate) {
+		t.Fatalf("opening: got %v", err)
+	}
+	if _, err := Open(context.Background(), srv.URL+"/redirect", opts); !errors.Is(err, errPrivate) {
+		t.Fatalf("redirect: got %v", err)
+	}
+	s, err := Open(context.Background(), srv.URL+"/live.m3u8", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b, err := ioutil.ReadAll(s)
+	if !errors.Is(err, errPrivate) || string(b) != "data" {
+		t.Fatalf("segment: got %q, %v", b, err)
+	}
+}
+
+func TestHLSMediaSequenceRestart(t *testing.T) {
+	var reloads int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/live.m3u8", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&reloads, 1) == 1 {
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0\n#EXT-X-MEDIA-SEQUENCE:10\n#EXTINF:1,\ns10.ts\n#EXTINF:1,\ns11.ts\n")
+			return
+		}
+		// The encoder restarted, and its sequence with it.
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1,\ns0.ts\n#EXT-X-ENDLIST\n")
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".ts")+";")
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	s, err := Open(ctx, srv.URL+"/live.m3u8", Options{Client: srv.Client(), Logger: log.Default})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	b, err := ioutil.ReadAll(s)
+	if err != nil || string(b) != "s10;s11;s0;" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+}
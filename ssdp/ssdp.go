@@ -96,13 +96,15 @@ func ReadRequest(b *bufio.Reader) (req *http.Request, err error) {
 }
 
 type Server struct {
-	conn           *net.UDPConn
-	Interface      net.Interface
-	AddrString     string
-	NetAddr        *net.UDPAddr
-	Server         string
-	Services       []string
-	Devices        []string
+	conn       *net.UDPConn
+	Interface  net.Interface
+	AddrString string
+	NetAddr    *net.UDPAddr
+	Server     string
+	Services   []string
+	Devices    []string
+	// Filters the interface addresses announced on, and the clients M-SEARCH requests are
+	// answered for. Everything passes if nil.
 	IPFilter       func(net.IP) bool
 	Location       func(net.IP) string
 	UUID           string
@@ -304,6 +306,9 @@ func (me *Server) handle(buf []byte, sender *net.UDPAddr) {
 	if req.Method != "M-SEARCH" || req.Header.Get("man") != `"ssdp:discover"` {
 		return
 	}
+	if !me.IPFilter(sender.IP) {
+		return
+	}
 	var mx int64
 	if req.Header.Get("Host") == me.AddrString {
 		mxHeader := req.Header.Get("mx")
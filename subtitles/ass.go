@@ -0,0 +1,99 @@
+package subtitles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// The field order of [Events] lines when there's no Format line.
+var defaultASSEventFormat = []string{
+	"layer", "start", "end", "style", "name", "marginl", "marginr", "marginv", "effect", "text",
+}
+
+func parseASS(text string) (cues []Cue, err error) {
+	var (
+		inEvents bool
+		format   = defaultASSEventFormat
+	)
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			inEvents = strings.EqualFold(line, "[Events]")
+			continue
+		}
+		if !inEvents {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "format":
+			format = nil
+			for _, f := range strings.Split(value, ",") {
+				format = append(format, strings.ToLower(strings.TrimSpace(f)))
+			}
+		case "dialogue":
+			// Text is the last field, and may contain commas.
+			fields := strings.SplitN(strings.TrimSpace(value), ",", len(format))
+			if len(fields) != len(format) {
+				continue
+			}
+			var c Cue
+			var startErr, endErr error
+			for i, name := range format {
+				switch name {
+				case "start":
+					c.Start, startErr = parseTimestamp(fields[i])
+				case "end":
+					c.End, endErr = parseTimestamp(fields[i])
+				case "text":
+					c.Text = fromASSText(fields[i])
+				}
+			}
+			if startErr != nil || endErr != nil {
+				continue
+			}
+			cues = append(cues, c)
+		}
+	}
+	if cues == nil && !strings.Contains(text, "[Events]") {
+		err = errors.New("no ass events found")
+	}
+	return
+}
+
+func formatASSTimestamp(d time.Duration) string {
+	h, m, s, ms := clock(d)
+	return fmt.Sprintf("%d:%02d:%02d.%02d", h, m, s, ms/10)
+}
+
+// A minimal header with a single style readable on most displays.
+const assHeader = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 384
+PlayResY: 288
+WrapStyle: 0
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,16,&H00FFFFFF,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,1,0,2,10,10,10,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+`
+
+func writeASS(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(assHeader)
+	for _, c := range cues {
+		fmt.Fprintf(bw, "Dialogue: 0,%s,%s,Default,,0,0,0,,%s\n",
+			formatASSTimestamp(c.Start), formatASSTimestamp(c.End), toASSText(c.Text))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,79 @@
+package subtitles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ToUTF8 converts subtitle data to UTF-8 without a byte order mark. UTF-8 and UTF-16 are detected
+// from byte order marks, and UTF-16 without one from the pattern of zero bytes. Anything else that
+// isn't valid UTF-8 is assumed to be Windows-1252, the most common encoding of older releases.
+func ToUTF8(b []byte) []byte {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xef, 0xbb, 0xbf}):
+		return b[3:]
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		return decodeUTF16(b[2:], binary.LittleEndian)
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		return decodeUTF16(b[2:], binary.BigEndian)
+	}
+	if bo, ok := guessUTF16(b); ok {
+		return decodeUTF16(b, bo)
+	}
+	if utf8.Valid(b) {
+		return b
+	}
+	return decodeWindows1252(b)
+}
+
+// Text subtitles are mostly ASCII, so UTF-16 without a BOM has zeros in every other byte.
+func guessUTF16(b []byte) (binary.ByteOrder, bool) {
+	if len(b) < 4 || len(b)%2 != 0 {
+		return nil, false
+	}
+	var evenZeros, oddZeros int
+	for i := 0; i < len(b); i += 2 {
+		if b[i] == 0 {
+			evenZeros++
+		}
+		if b[i+1] == 0 {
+			oddZeros++
+		}
+	}
+	half := len(b) / 2
+	switch {
+	case oddZeros > half*3/4 && evenZeros == 0:
+		return binary.LittleEndian, true
+	case evenZeros > half*3/4 && oddZeros == 0:
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+func decodeUTF16(b []byte, bo binary.ByteOrder) []byte {
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = bo.Uint16(b[2*i:])
+	}
+	return []byte(string(utf16.Decode(units)))
+}
+
+// Windows-1252 differs from Latin-1 only in 0x80-0x9f. Unassigned bytes map to U+FFFD.
+var windows1252High = [32]rune{
+	'€', '�', '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', '�', 'Ž', '�',
+	'�', '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', '�', 'ž', 'Ÿ',
+}
+
+func decodeWindows1252(b []byte) []byte {
+	ret := make([]byte, 0, len(b)+len(b)/4)
+	for _, c := range b {
+		r := rune(c)
+		if c >= 0x80 && c < 0xa0 {
+			r = windows1252High[c-0x80]
+		}
+		ret = utf8.AppendRune(ret, r)
+	}
+	return ret
+}
@@ -0,0 +1,122 @@
+package subtitles
+
+import (
+	"strings"
+)
+
+// Tags that SRT, WebVTT and, through override codes, ASS all support.
+var basicTags = map[string]bool{"i": true, "b": true, "u": true}
+
+// Walks HTML-like markup in cue text, replacing each tag with the result of tag, and each run of
+// text between tags with the result of text. Tag names are lowercased and stripped of WebVTT
+// classes and annotations, so "<c.yellow>" is "c" and "<v Bob>" is "v". A '<' that doesn't start a
+// tag is text.
+func rewriteTags(s string, tag func(name string, closing bool) string, text func(string) string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '<')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '>')
+		if j < 0 {
+			break
+		}
+		inner := s[i+1 : i+j]
+		closing := strings.HasPrefix(inner, "/")
+		name := strings.TrimPrefix(inner, "/")
+		if k := strings.IndexAny(name, ". \t"); k >= 0 {
+			name = name[:k]
+		}
+		if !isTagName(name) {
+			// Not a tag, so the '<' is text.
+			b.WriteString(text(s[:i+1]))
+			s = s[i+1:]
+			continue
+		}
+		b.WriteString(text(s[:i]))
+		b.WriteString(tag(strings.ToLower(name), closing))
+		s = s[i+j+1:]
+	}
+	b.WriteString(text(s))
+	return b.String()
+}
+
+func isTagName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Keeps only the basic tags, in canonical form.
+func basicTag(name string, closing bool) string {
+	if !basicTags[name] {
+		return ""
+	}
+	if closing {
+		return "</" + name + ">"
+	}
+	return "<" + name + ">"
+}
+
+func identity(s string) string { return s }
+
+var (
+	vttEscaper   = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	vttUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "‎", "&rlm;", "‏")
+)
+
+// Converts WebVTT cue text to the SRT style markup cues hold.
+func fromVTTText(s string) string {
+	return rewriteTags(s, basicTag, vttUnescaper.Replace)
+}
+
+// Converts cue text to WebVTT, which unlike SRT requires escaping and has no <font> tag.
+func toVTTText(s string) string {
+	return rewriteTags(s, basicTag, vttEscaper.Replace)
+}
+
+// Converts ASS dialogue text to the SRT style markup cues hold. Override blocks are dropped,
+// except for italic, bold and underline codes.
+func fromASSText(s string) string {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '{')
+		if i < 0 {
+			break
+		}
+		j := strings.IndexByte(s[i:], '}')
+		if j < 0 {
+			break
+		}
+		b.WriteString(s[:i])
+		for _, code := range strings.Split(s[i+1:i+j], `\`) {
+			if len(code) == 2 && basicTags[code[:1]] && (code[1] == '0' || code[1] == '1') {
+				b.WriteString(basicTag(code[:1], code[1] == '0'))
+			}
+		}
+		s = s[i+j+1:]
+	}
+	b.WriteString(s)
+	return strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, " ").Replace(b.String())
+}
+
+// Converts cue text to ASS dialogue text.
+func toASSText(s string) string {
+	s = rewriteTags(s, func(name string, closing bool) string {
+		if !basicTags[name] {
+			return ""
+		}
+		if closing {
+			return `{\` + name + `0}`
+		}
+		return `{\` + name + `1}`
+	}, identity)
+	return strings.ReplaceAll(s, "\n", `\N`)
+}
@@ -0,0 +1,57 @@
+package subtitles
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+func parseSRT(text string) (cues []Cue, err error) {
+	for _, block := range blocks(text) {
+		// The numeric counter is optional in practice.
+		if len(block) > 1 && !strings.Contains(block[0], "-->") {
+			block = block[1:]
+		}
+		start, end, ok := parseTiming(block[0])
+		if !ok {
+			continue
+		}
+		cues = append(cues, Cue{
+			Start: start,
+			End:   end,
+			Text:  strings.Join(block[1:], "\n"),
+		})
+	}
+	if cues == nil && strings.TrimSpace(text) != "" {
+		err = fmt.Errorf("no srt cues found")
+	}
+	return
+}
+
+func formatSRTTimestamp(d time.Duration) string {
+	h, m, s, ms := clock(d)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", h, m, s, ms)
+}
+
+func writeSRT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	for i, c := range cues {
+		fmt.Fprintf(bw, "%d\n%s --> %s\n%s\n\n",
+			i+1, formatSRTTimestamp(c.Start), formatSRTTimestamp(c.End), blankLinesRemoved(c.Text))
+	}
+	return bw.Flush()
+}
+
+// Blank lines end cues in SRT and WebVTT, so they can't appear within the text.
+func blankLinesRemoved(text string) string {
+	lines := strings.Split(text, "\n")
+	ret := lines[:0]
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			ret = append(ret, l)
+		}
+	}
+	return strings.Join(ret, "\n")
+}
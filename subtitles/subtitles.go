@@ -0,0 +1,239 @@
+// Package subtitles converts text subtitles between SRT, WebVTT and basic ASS/SSA.
+//
+// Cue text is held with SRT style markup: lines separated by "\n", and <i>, <b> and <u> tags.
+// Styling that can't be expressed that way, such as ASS positioning, is dropped.
+package subtitles
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Format is a subtitle file format.
+type Format string
+
+const (
+	SRT Format = "srt"
+	VTT Format = "vtt"
+	// ASS also covers SSA, which it extends. SSA input is accepted, but output is always ASS.
+	ASS Format = "ass"
+)
+
+// ParseFormat returns the format for a name or file extension, like "vtt" or ".ssa".
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(s, ".")) {
+	case "srt", "subrip":
+		return SRT, nil
+	case "vtt", "webvtt":
+		return VTT, nil
+	case "ass", "ssa":
+		return ASS, nil
+	}
+	return "", fmt.Errorf("unsupported subtitle format %q", s)
+}
+
+// MimeType returns the MIME-type renderers expect for the format.
+func (f Format) MimeType() string {
+	switch f {
+	case VTT:
+		return "text/vtt"
+	case ASS:
+		return "text/x-ssa"
+	default:
+		return "text/srt"
+	}
+}
+
+// Cue is a piece of text shown for a period of time.
+type Cue struct {
+	Start, End time.Duration
+	Text       string
+}
+
+// DetectFormat guesses the format of UTF-8 subtitle data from its content, defaulting to SRT.
+func DetectFormat(b []byte) Format {
+	b = bytes.TrimLeft(b, " \t\r\n")
+	switch {
+	case bytes.HasPrefix(b, []byte("WEBVTT")):
+		return VTT
+	case bytes.HasPrefix(b, []byte("[Script Info]")), bytes.Contains(b, []byte("\nDialogue:")):
+		return ASS
+	default:
+		return SRT
+	}
+}
+
+// Parse reads cues from subtitle data in the given format. The data is converted to UTF-8 first,
+// see ToUTF8. Cues are returned ordered by start time.
+func Parse(b []byte, f Format) (cues []Cue, err error) {
+	text := normalizeNewlines(string(ToUTF8(b)))
+	switch f {
+	case SRT:
+		cues, err = parseSRT(text)
+	case VTT:
+		cues, err = parseVTT(text)
+	case ASS:
+		cues, err = parseASS(text)
+	default:
+		err = fmt.Errorf("unsupported subtitle format %q", f)
+	}
+	sort.SliceStable(cues, func(i, j int) bool {
+		return cues[i].Start < cues[j].Start
+	})
+	return
+}
+
+// Write outputs the cues in the given format, encoded as UTF-8.
+func Write(w io.Writer, cues []Cue, f Format) error {
+	switch f {
+	case SRT:
+		return writeSRT(w, cues)
+	case VTT:
+		return writeVTT(w, cues)
+	case ASS:
+		return writeASS(w, cues)
+	default:
+		return fmt.Errorf("unsupported subtitle format %q", f)
+	}
+}
+
+// Convert parses subtitle data in one format and writes it in another, with cue times moved by
+// offset.
+func Convert(w io.Writer, b []byte, from, to Format, offset time.Duration) error {
+	cues, err := Parse(b, from)
+	if err != nil {
+		return err
+	}
+	return Write(w, Shift(cues, offset), to)
+}
+
+// Shift moves the cues by offset, which may be negative. Cues that would end before zero are
+// dropped, and those that would start before zero are clipped.
+func Shift(cues []Cue, offset time.Duration) []Cue {
+	ret := make([]Cue, 0, len(cues))
+	for _, c := range cues {
+		c.Start += offset
+		c.End += offset
+		if c.End <= 0 {
+			continue
+		}
+		if c.Start < 0 {
+			c.Start = 0
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func normalizeNewlines(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
+
+// Splits text into blocks separated by blank lines.
+func blocks(text string) (ret [][]string) {
+	var cur []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if cur != nil {
+				ret = append(ret, cur)
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, line)
+	}
+	if cur != nil {
+		ret = append(ret, cur)
+	}
+	return
+}
+
+// Splits a duration into its components for formatting.
+func clock(d time.Duration) (h, m, s, ms int) {
+	if d < 0 {
+		d = 0
+	}
+	ms = int(d / time.Millisecond)
+	h = ms / 3600000
+	ms -= h * 3600000
+	m = ms / 60000
+	ms -= m * 60000
+	s = ms / 1000
+	ms -= s * 1000
+	return
+}
+
+// Parses timestamps like "01:02:03,456", "02:03.456" and "1:02:03.45". The fraction may be
+// separated by a comma or period, and hours are optional.
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	var frac time.Duration
+	if i := strings.LastIndexAny(s, ",."); i >= 0 {
+		digits := s[i+1:]
+		if digits == "" || len(digits) > 3 {
+			return 0, fmt.Errorf("bad timestamp %q", s)
+		}
+		n, err := parseDigits(digits)
+		if err != nil {
+			return 0, fmt.Errorf("bad timestamp %q", s)
+		}
+		for i := len(digits); i < 3; i++ {
+			n *= 10
+		}
+		frac = time.Duration(n) * time.Millisecond
+		s = s[:i]
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("bad timestamp %q", s)
+	}
+	var d time.Duration
+	for _, p := range parts {
+		n, err := parseDigits(p)
+		if err != nil {
+			return 0, fmt.Errorf("bad timestamp %q", s)
+		}
+		d = d*60 + time.Duration(n)*time.Second
+	}
+	return d + frac, nil
+}
+
+func parseDigits(s string) (n int, err error) {
+	if s == "" {
+		return 0, fmt.Errorf("no digits")
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("bad digit %q", r)
+		}
+		n = n*10 + int(r-'0')
+	}
+	return
+}
+
+// Parses a timing line like "00:00:01,000 --> 00:00:02,000", ignoring anything after the end
+// time, such as WebVTT cue settings.
+func parseTiming(line string) (start, end time.Duration, ok bool) {
+	startStr, rest, found := strings.Cut(line, "-->")
+	if !found {
+		return
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return
+	}
+	start, err := parseTimestamp(startStr)
+	if err != nil {
+		return
+	}
+	end, err = parseTimestamp(fields[0])
+	if err != nil {
+		return
+	}
+	return start, end, true
+}
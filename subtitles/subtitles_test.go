@@ -0,0 +1,117 @@
+package subtitles
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testSRT = "1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i>\r\nthere & you\r\n\r\n" +
+	"2\r\n00:01:02,030 --> 00:01:04,000\r\nBye\r\n"
+
+var testCues = []Cue{
+	{time.Second, 2500 * time.Millisecond, "<i>Hello</i>\nthere & you"},
+	{62030 * time.Millisecond, 64 * time.Second, "Bye"},
+}
+
+func mustParse(t *testing.T, b []byte, f Format) []Cue {
+	t.Helper()
+	cues, err := Parse(b, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cues
+}
+
+func TestParseSRT(t *testing.T) {
+	cues := mustParse(t, []byte(testSRT), SRT)
+	if !reflect.DeepEqual(cues, testCues) {
+		t.Fatalf("got %q", cues)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, f := range []Format{SRT, VTT, ASS} {
+		var buf bytes.Buffer
+		if err := Write(&buf, testCues, f); err != nil {
+			t.Fatal(err)
+		}
+		if DetectFormat(buf.Bytes()) != f {
+			t.Errorf("%s: detected as %s", f, DetectFormat(buf.Bytes()))
+		}
+		cues := mustParse(t, buf.Bytes(), f)
+		if !reflect.DeepEqual(cues, testCues) {
+			t.Errorf("%s: got %q from %q", f, cues, buf.String())
+		}
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testCues[:1], VTT); err != nil {
+		t.Fatal(err)
+	}
+	expected := "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\n<i>Hello</i>\nthere &amp; you\n\n"
+	if buf.String() != expected {
+		t.Fatalf("got %q, expected %q", buf.String(), expected)
+	}
+}
+
+func TestParseVTT(t *testing.T) {
+	const vtt = "WEBVTT - some title\n\nNOTE a comment\n\nSTYLE\n::cue { color: yellow }\n\n" +
+		"intro\n00:01.000 --> 00:02.000 align:start\n<v Bob><c.loud>Hi</c> &lt;3</v>\n"
+	cues := mustParse(t, []byte(vtt), VTT)
+	expected := []Cue{{time.Second, 2 * time.Second, "Hi <3"}}
+	if !reflect.DeepEqual(cues, expected) {
+		t.Fatalf("got %q", cues)
+	}
+}
+
+func TestParseASS(t *testing.T) {
+	const ass = "[Script Info]\nTitle: x\n\n[Events]\n" +
+		"Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Dialogue: 0,0:00:03.00,0:00:04.00,Default,,0,0,0,,Later\n" +
+		"Comment: 0,0:00:00.00,0:00:01.00,Default,,0,0,0,,ignored\n" +
+		"Dialogue: 0,0:00:01.50,0:00:02.00,Default,,0,0,0,,{\\pos(1,2)\\i1}Hi{\\i0}, you\\Nthere\n"
+	cues := mustParse(t, []byte(ass), ASS)
+	expected := []Cue{
+		{1500 * time.Millisecond, 2 * time.Second, "<i>Hi</i>, you\nthere"},
+		{3 * time.Second, 4 * time.Second, "Later"},
+	}
+	if !reflect.DeepEqual(cues, expected) {
+		t.Fatalf("got %q", cues)
+	}
+}
+
+func TestShift(t *testing.T) {
+	cues := Shift(testCues, -2*time.Second)
+	expected := []Cue{
+		{0, 500 * time.Millisecond, testCues[0].Text},
+		{60030 * time.Millisecond, 62 * time.Second, "Bye"},
+	}
+	if !reflect.DeepEqual(cues, expected) {
+		t.Fatalf("got %q", cues)
+	}
+	if len(Shift(testCues, -time.Hour)) != 0 {
+		t.Fatal("cues shifted before zero should be dropped")
+	}
+}
+
+func TestToUTF8(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		in       []byte
+		expected string
+	}{
+		{"utf-8 bom", []byte("\xef\xbb\xbfcafé"), "café"},
+		{"utf-8", []byte("café"), "café"},
+		{"utf-16le bom", []byte("\xff\xfec\x00a\x00f\x00\xe9\x00"), "café"},
+		{"utf-16be", []byte("\x00c\x00a\x00f\x00\xe9"), "café"},
+		{"windows-1252", []byte("caf\xe9 \x93quoted\x94 \x80"), "café “quoted” €"},
+	} {
+		if actual := string(ToUTF8(tc.in)); actual != tc.expected {
+			t.Errorf("%s: got %q, expected %q", tc.name, actual, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package subtitles
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+func parseVTT(text string) (cues []Cue, err error) {
+	bs := blocks(text)
+	if len(bs) == 0 || !strings.HasPrefix(bs[0][0], "WEBVTT") {
+		return nil, errors.New("missing WEBVTT header")
+	}
+	for _, block := range bs[1:] {
+		// Cue identifiers are optional. NOTE, STYLE and REGION blocks have no timing line and are
+		// skipped.
+		timing := 0
+		if !strings.Contains(block[0], "-->") {
+			timing = 1
+		}
+		if timing >= len(block) {
+			continue
+		}
+		start, end, ok := parseTiming(block[timing])
+		if !ok {
+			continue
+		}
+		cues = append(cues, Cue{
+			Start: start,
+			End:   end,
+			Text:  fromVTTText(strings.Join(block[timing+1:], "\n")),
+		})
+	}
+	return
+}
+
+func formatVTTTimestamp(d time.Duration) string {
+	h, m, s, ms := clock(d)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", h, m, s, ms)
+}
+
+func writeVTT(w io.Writer, cues []Cue) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("WEBVTT\n\n")
+	for _, c := range cues {
+		fmt.Fprintf(bw, "%s --> %s\n%s\n\n",
+			formatVTTTimestamp(c.Start), formatVTTTimestamp(c.End), toVTTText(blankLinesRemoved(c.Text)))
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,119 @@
+package tags
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+	flacPicture       = 6
+)
+
+// Reads the STREAMINFO, VORBIS_COMMENT and PICTURE metadata blocks of a FLAC file.
+func readFLAC(r io.ReadSeeker, t *Tags) error {
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		return err
+	}
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+		last := header[0]&0x80 != 0
+		size := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		switch header[0] & 0x7f {
+		case flacStreamInfo, flacVorbisComment, flacPicture:
+			b, err := readBlock(r, size)
+			if err != nil {
+				return err
+			}
+			switch header[0] & 0x7f {
+			case flacStreamInfo:
+				t.Duration = flacDuration(b)
+			case flacVorbisComment:
+				if err := parseVorbisComments(b, t); err != nil {
+					return err
+				}
+			case flacPicture:
+				if p, picType, err := parseFLACPicture(b); err == nil {
+					t.setPicture(p, picType == frontCover)
+				}
+			}
+		default:
+			if _, err := r.Seek(size, io.SeekCurrent); err != nil {
+				return err
+			}
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+func flacDuration(streamInfo []byte) time.Duration {
+	if len(streamInfo) < 18 {
+		return 0
+	}
+	b := streamInfo[10:18]
+	sampleRate := uint64(b[0])<<12 | uint64(b[1])<<4 | uint64(b[2])>>4
+	samples := uint64(b[3]&0x0f)<<32 | uint64(binary.BigEndian.Uint32(b[4:]))
+	if sampleRate == 0 {
+		return 0
+	}
+	return time.Duration(samples * uint64(time.Second) / sampleRate)
+}
+
+var errShortPicture = errors.New("short picture block")
+
+// Parses a FLAC PICTURE block, which is also how Ogg files embed pictures.
+func parseFLACPicture(b []byte) (p *Picture, picType uint32, err error) {
+	next := func(n uint32) ([]byte, bool) {
+		if uint64(n) > uint64(len(b)) {
+			return nil, false
+		}
+		ret := b[:n]
+		b = b[n:]
+		return ret, true
+	}
+	u32 := func() (uint32, bool) {
+		v, ok := next(4)
+		if !ok {
+			return 0, false
+		}
+		return binary.BigEndian.Uint32(v), true
+	}
+	picType, ok := u32()
+	if !ok {
+		return nil, 0, errShortPicture
+	}
+	n, ok := u32()
+	if !ok {
+		return nil, 0, errShortPicture
+	}
+	mimeType, ok := next(n)
+	if !ok {
+		return nil, 0, errShortPicture
+	}
+	// The description, then width, height, depth and number of colors.
+	if n, ok = u32(); !ok {
+		return nil, 0, errShortPicture
+	}
+	if _, ok = next(n); !ok {
+		return nil, 0, errShortPicture
+	}
+	if _, ok = next(16); !ok {
+		return nil, 0, errShortPicture
+	}
+	if n, ok = u32(); !ok {
+		return nil, 0, errShortPicture
+	}
+	data, ok := next(n)
+	if !ok {
+		return nil, 0, errShortPicture
+	}
+	return &Picture{MIMEType: string(mimeType), Data: data}, picType, nil
+}
@@ -0,0 +1,350 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ID3v2.2 frame IDs, by the ID3v2.3 ones they correspond to.
+var id3v22Frames = map[string]string{
+	"TT2": "TIT2",
+	"TP1": "TPE1",
+	"TP2": "TPE2",
+	"TAL": "TALB",
+	"TRK": "TRCK",
+	"TPA": "TPOS",
+	"TYE": "TYER",
+	"TCO": "TCON",
+	"TLE": "TLEN",
+	"PIC": "PIC",
+}
+
+// Reads an ID3v2 tag at the current position, returning the number of bytes it takes up.
+func readID3v2(r io.Reader, t *Tags) (size int64, err error) {
+	var header [10]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	if string(header[:3]) != "ID3" {
+		err = errors.New("no id3v2 tag")
+		return
+	}
+	version, flags := header[3], header[5]
+	bodySize := int64(synchsafe(header[6:10]))
+	size = 10 + bodySize
+	if flags&0x10 != 0 {
+		// A footer.
+		size += 10
+	}
+	body, err := readBlock(r, bodySize)
+	if err != nil {
+		return
+	}
+	if version < 4 && flags&0x80 != 0 {
+		body = removeUnsynchronisation(body)
+	}
+	if flags&0x40 != 0 && len(body) >= 4 {
+		// Skip the extended header. Its size includes itself only from version 4.
+		n := int(binary.BigEndian.Uint32(body))
+		if version >= 4 {
+			n = int(synchsafe(body[:4]))
+		} else {
+			n += 4
+		}
+		if n > len(body) {
+			return
+		}
+		body = body[n:]
+	}
+	switch version {
+	case 2:
+		parseID3v22Frames(body, t)
+	case 3, 4:
+		parseID3v2Frames(body, version, flags&0x80 != 0, t)
+	default:
+		err = errors.New("unsupported id3v2 version " + strconv.Itoa(int(version)))
+	}
+	return
+}
+
+func synchsafe(b []byte) uint32 {
+	var n uint32
+	for _, c := range b {
+		n = n<<7 | uint32(c&0x7f)
+	}
+	return n
+}
+
+// Reverses the unsynchronisation scheme, which inserts a zero after every 0xff.
+func removeUnsynchronisation(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0}, []byte{0xff})
+}
+
+func parseID3v22Frames(b []byte, t *Tags) {
+	for len(b) >= 6 && b[0] != 0 {
+		id := string(b[:3])
+		size := int(b[3])<<16 | int(b[4])<<8 | int(b[5])
+		if size > len(b)-6 {
+			return
+		}
+		if mapped, ok := id3v22Frames[id]; ok {
+			t.setID3Frame(mapped, b[6:6+size])
+		}
+		b = b[6+size:]
+	}
+}
+
+func parseID3v2Frames(b []byte, version byte, unsynchronised bool, t *Tags) {
+	for len(b) >= 10 && b[0] != 0 {
+		id := string(b[:4])
+		var size int
+		if version >= 4 {
+			size = int(synchsafe(b[4:8]))
+		} else {
+			size = int(binary.BigEndian.Uint32(b[4:8]))
+		}
+		if size < 0 || size > len(b)-10 {
+			return
+		}
+		formatFlags := b[9]
+		data := b[10 : 10+size]
+		b = b[10+size:]
+		if version >= 4 {
+			// Compressed or encrypted frames are skipped.
+			if formatFlags&0x0c != 0 {
+				continue
+			}
+			if formatFlags&0x02 != 0 || unsynchronised {
+				data = removeUnsynchronisation(data)
+			}
+			if formatFlags&0x01 != 0 {
+				// A data length indicator.
+				if len(data) < 4 {
+					continue
+				}
+				data = data[4:]
+			}
+		} else if formatFlags&0xc0 != 0 {
+			continue
+		}
+		t.setID3Frame(id, data)
+	}
+}
+
+func (t *Tags) setID3Frame(id string, data []byte) {
+	switch id {
+	case "TIT2":
+		setIfEmpty(&t.Title, id3Text(data))
+	case "TPE1":
+		setIfEmpty(&t.Artist, id3Text(data))
+	case "TPE2":
+		setIfEmpty(&t.AlbumArtist, id3Text(data))
+	case "TALB":
+		setIfEmpty(&t.Album, id3Text(data))
+	case "TCON":
+		setIfEmpty(&t.Genre, id3Genre(id3Text(data)))
+	case "TRCK":
+		n, total := parseNumber(id3Text(data))
+		setIfZero(&t.Track, n)
+		setIfZero(&t.TrackTotal, total)
+	case "TPOS":
+		n, total := parseNumber(id3Text(data))
+		setIfZero(&t.Disc, n)
+		setIfZero(&t.DiscTotal, total)
+	case "TYER", "TDRC", "TDOR", "TORY":
+		setIfZero(&t.Year, parseYear(id3Text(data)))
+	case "TLEN":
+		if ms, err := strconv.ParseInt(strings.TrimSpace(id3Text(data)), 10, 64); err == nil && t.Duration == 0 {
+			t.Duration = time.Duration(ms) * time.Millisecond
+		}
+	case "APIC":
+		if p, picType, ok := parseAPIC(data); ok {
+			t.setPicture(p, picType == frontCover)
+		}
+	case "PIC":
+		if p, picType, ok := parsePIC(data); ok {
+			t.setPicture(p, picType == frontCover)
+		}
+	}
+}
+
+// Text encodings of ID3v2 frames.
+const (
+	id3Latin1 = iota
+	id3UTF16
+	id3UTF16BE
+	id3UTF8
+)
+
+// Decodes a text frame. Only the first of multiple values is returned.
+func id3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	s, _ := splitID3String(data[1:], data[0])
+	return s
+}
+
+// Returns the string terminated by a null in the encoding given, and what follows it.
+func splitID3String(b []byte, enc byte) (s string, rest []byte) {
+	switch enc {
+	case id3UTF16, id3UTF16BE:
+		end := len(b)
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end = i
+				break
+			}
+		}
+		s = decodeUTF16(b[:end], enc == id3UTF16BE)
+		if end+2 <= len(b) {
+			rest = b[end+2:]
+		}
+	default:
+		end := bytes.IndexByte(b, 0)
+		if end < 0 {
+			end = len(b)
+		} else {
+			rest = b[end+1:]
+		}
+		if enc == id3Latin1 {
+			s = decodeLatin1(b[:end])
+		} else {
+			s = string(b[:end])
+		}
+	}
+	return
+}
+
+// Decodes UTF-16 text, which is big endian without a byte order mark if bigEndian is set.
+func decodeUTF16(b []byte, bigEndian bool) string {
+	var bo binary.ByteOrder = binary.LittleEndian
+	switch {
+	case bytes.HasPrefix(b, []byte{0xfe, 0xff}):
+		bo, b = binary.BigEndian, b[2:]
+	case bytes.HasPrefix(b, []byte{0xff, 0xfe}):
+		b = b[2:]
+	case bigEndian:
+		bo = binary.BigEndian
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = bo.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func decodeLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// Parses an attached picture frame: encoding, MIME type, picture type, description, data.
+func parseAPIC(data []byte) (p *Picture, picType byte, ok bool) {
+	if len(data) < 2 {
+		return
+	}
+	enc := data[0]
+	mimeType, rest := splitID3String(data[1:], id3Latin1)
+	if len(rest) < 1 {
+		return
+	}
+	picType = rest[0]
+	_, rest = splitID3String(rest[1:], enc)
+	if len(rest) == 0 {
+		return
+	}
+	if mimeType == "" || !strings.Contains(mimeType, "/") {
+		mimeType = "image/" + strings.ToLower(mimeType)
+	}
+	return &Picture{MIMEType: mimeType, Data: rest}, picType, true
+}
+
+// Parses an ID3v2.2 picture frame, which gives a three letter image format instead of a MIME
+// type.
+func parsePIC(data []byte) (p *Picture, picType byte, ok bool) {
+	if len(data) < 6 {
+		return
+	}
+	enc, format := data[0], strings.ToLower(string(data[1:4]))
+	picType = data[4]
+	_, rest := splitID3String(data[5:], enc)
+	if len(rest) == 0 {
+		return
+	}
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	return &Picture{MIMEType: "image/" + format, Data: rest}, picType, true
+}
+
+// Resolves ID3 genre references like "(17)", "17" or "(17)Rock" to names.
+func id3Genre(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "(") {
+		if i := strings.IndexByte(s, ')'); i > 0 {
+			if rest := strings.TrimSpace(s[i+1:]); rest != "" {
+				return rest
+			}
+			s = s[1:i]
+		}
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return id3v1Genre(n)
+	}
+	return s
+}
+
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop", "Jazz",
+	"Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock", "Techno",
+	"Industrial", "Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack", "Euro-Techno",
+	"Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical", "Instrumental",
+	"Acid", "House", "Game", "Sound Clip", "Gospel", "Noise", "Alternative Rock", "Bass", "Soul",
+	"Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic",
+	"Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer",
+	"Lo-Fi", "Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock",
+}
+
+// Returns the name of a genre by its ID3v1 number, or "" for numbers outside the standard list.
+func id3v1Genre(n int) string {
+	if n < 0 || n >= len(id3v1Genres) {
+		return ""
+	}
+	return id3v1Genres[n]
+}
+
+// Reads an ID3v1 tag from the 128 bytes at the end of a file.
+func parseID3v1(b []byte) (t Tags, ok bool) {
+	if len(b) != 128 || string(b[:3]) != "TAG" {
+		return
+	}
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(decodeLatin1(b))
+	}
+	t.Title = field(b[3:33])
+	t.Artist = field(b[33:63])
+	t.Album = field(b[63:93])
+	t.Year, _ = strconv.Atoi(field(b[93:97]))
+	// ID3v1.1 puts the track number at the end of the comment.
+	if comment := b[97:127]; comment[28] == 0 && comment[29] != 0 {
+		t.Track = int(comment[29])
+	}
+	t.Genre = id3v1Genre(int(b[127]))
+	return t, true
+}
@@ -0,0 +1,154 @@
+package tags
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+// How far past the ID3v2 tag to look for the first MPEG audio frame.
+const mp3SyncSearchSize = 64 << 10
+
+// An MPEG audio frame header.
+type mp3Frame struct {
+	mpeg1           bool
+	layer           int
+	bitrate         int // In bits per second.
+	sampleRate      int
+	samplesPerFrame int
+	mono            bool
+}
+
+var mp3Bitrates = map[[2]int][15]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+// Parses the four byte header of an MPEG audio frame, returning nil if it isn't one.
+func parseFrameHeader(b []byte) *mp3Frame {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return nil
+	}
+	versionBits := b[1] >> 3 & 3
+	layerBits := b[1] >> 1 & 3
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int(b[2] >> 2 & 3)
+	if versionBits == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return nil
+	}
+	f := mp3Frame{
+		mpeg1: versionBits == 3,
+		layer: 4 - int(layerBits),
+		mono:  b[3]>>6 == 3,
+	}
+	table := 2
+	if f.mpeg1 {
+		table = 1
+	}
+	f.bitrate = mp3Bitrates[[2]int{table, f.layer}][bitrateIndex] * 1000
+	f.sampleRate = [3]int{44100, 48000, 32000}[sampleRateIndex]
+	switch versionBits {
+	case 2:
+		f.sampleRate /= 2
+	case 0:
+		f.sampleRate /= 4
+	}
+	switch {
+	case f.layer == 1:
+		f.samplesPerFrame = 384
+	case f.layer == 2 || f.mpeg1:
+		f.samplesPerFrame = 1152
+	default:
+		f.samplesPerFrame = 576
+	}
+	return &f
+}
+
+// Returns the offset of the Xing or Info header in a Layer III frame, after the side information.
+func (f mp3Frame) xingOffset() int {
+	switch {
+	case f.mpeg1 && !f.mono:
+		return 4 + 32
+	case f.mpeg1, !f.mono:
+		return 4 + 17
+	}
+	return 4 + 9
+}
+
+// Reads the ID3v2 and ID3v1 tags of an MP3, and works out its duration from the VBR header of
+// the first frame or otherwise the bitrate.
+func readMP3(r io.ReadSeeker, t *Tags) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var audioStart int64
+	if n, err := readID3v2(r, t); err == nil {
+		audioStart = n
+	}
+	audioEnd := size
+	if size >= 128 {
+		if _, err := r.Seek(size-128, io.SeekStart); err != nil {
+			return err
+		}
+		var b [128]byte
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return err
+		}
+		if v1, ok := parseID3v1(b[:]); ok {
+			t.merge(v1)
+			audioEnd -= 128
+		}
+	}
+	if t.Duration == 0 {
+		t.Duration = mp3Duration(r, audioStart, audioEnd)
+	}
+	return nil
+}
+
+func mp3Duration(r io.ReadSeeker, audioStart, audioEnd int64) time.Duration {
+	if _, err := r.Seek(audioStart, io.SeekStart); err != nil {
+		return 0
+	}
+	b := make([]byte, mp3SyncSearchSize)
+	n, _ := io.ReadFull(r, b)
+	b = b[:n]
+	for i := 0; i+4 <= len(b); i++ {
+		f := parseFrameHeader(b[i:])
+		if f == nil {
+			continue
+		}
+		frame := b[i:]
+		if frames, ok := vbrFrameCount(*f, frame); ok {
+			return time.Duration(int64(frames) * int64(f.samplesPerFrame) * int64(time.Second) / int64(f.sampleRate))
+		}
+		audioBytes := audioEnd - (audioStart + int64(i))
+		return time.Duration(audioBytes * 8 * int64(time.Second) / int64(f.bitrate))
+	}
+	return 0
+}
+
+// Returns the number of frames given by a Xing, Info or VBRI header in the first frame.
+func vbrFrameCount(f mp3Frame, frame []byte) (uint32, bool) {
+	if f.layer == 3 {
+		if o := f.xingOffset(); len(frame) >= o+12 {
+			tag := string(frame[o : o+4])
+			flags := binary.BigEndian.Uint32(frame[o+4:])
+			if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+				return binary.BigEndian.Uint32(frame[o+8:]), true
+			}
+		}
+	}
+	// The Fraunhofer VBRI header is always 32 bytes after the frame header.
+	if o := 4 + 32; len(frame) >= o+18 && string(frame[o:o+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(frame[o+14:]), true
+	}
+	return 0, false
+}
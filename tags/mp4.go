@@ -0,0 +1,211 @@
+package tags
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+type mp4Atom struct {
+	typ string
+	// The offset and size of the atom's payload, after its header.
+	offset, size int64
+}
+
+// Lists the atoms in the region of the file given.
+func mp4Atoms(r io.ReadSeeker, offset, end int64) (ret []mp4Atom, err error) {
+	for offset+8 <= end {
+		if _, err = r.Seek(offset, io.SeekStart); err != nil {
+			return
+		}
+		var header [16]byte
+		if _, err = io.ReadFull(r, header[:8]); err != nil {
+			return
+		}
+		size := int64(binary.BigEndian.Uint32(header[:]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			// The atom runs to the end.
+			size = end - offset
+		case 1:
+			if _, err = io.ReadFull(r, header[8:]); err != nil {
+				return
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			err = errors.New("bad mp4 atom size")
+			return
+		}
+		ret = append(ret, mp4Atom{string(header[4:8]), offset + headerSize, size - headerSize})
+		offset += size
+	}
+	return
+}
+
+func findMP4Atom(atoms []mp4Atom, typ string) (mp4Atom, bool) {
+	for _, a := range atoms {
+		if a.typ == typ {
+			return a, true
+		}
+	}
+	return mp4Atom{}, false
+}
+
+func readMP4Atom(r io.ReadSeeker, a mp4Atom) ([]byte, error) {
+	if _, err := r.Seek(a.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return readBlock(r, a.size)
+}
+
+// Reads the duration from moov/mvhd and iTunes metadata from moov/udta/meta/ilst.
+func readMP4(r io.ReadSeeker, t *Tags) error {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	top, err := mp4Atoms(r, 0, end)
+	if err != nil && len(top) == 0 {
+		return err
+	}
+	moov, ok := findMP4Atom(top, "moov")
+	if !ok {
+		return errors.New("no moov atom")
+	}
+	moovAtoms, err := mp4Atoms(r, moov.offset, moov.offset+moov.size)
+	if err != nil {
+		return err
+	}
+	if mvhd, ok := findMP4Atom(moovAtoms, "mvhd"); ok {
+		b, err := readMP4Atom(r, mvhd)
+		if err != nil {
+			return err
+		}
+		t.Duration = mvhdDuration(b)
+	}
+	udta, ok := findMP4Atom(moovAtoms, "udta")
+	if !ok {
+		return nil
+	}
+	udtaAtoms, err := mp4Atoms(r, udta.offset, udta.offset+udta.size)
+	if err != nil {
+		return err
+	}
+	meta, ok := findMP4Atom(udtaAtoms, "meta")
+	if !ok {
+		return nil
+	}
+	// meta is a full box with version and flags before its children, except in some QuickTime
+	// files.
+	var peek [8]byte
+	if _, err := r.Seek(meta.offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(r, peek[:]); err != nil {
+		return err
+	}
+	metaOffset := meta.offset
+	if string(peek[4:]) != "hdlr" {
+		metaOffset += 4
+	}
+	metaAtoms, err := mp4Atoms(r, metaOffset, meta.offset+meta.size)
+	if err != nil {
+		return err
+	}
+	ilst, ok := findMP4Atom(metaAtoms, "ilst")
+	if !ok {
+		return nil
+	}
+	items, err := mp4Atoms(r, ilst.offset, ilst.offset+ilst.size)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		b, err := readMP4Atom(r, item)
+		if err != nil {
+			return err
+		}
+		t.setMP4Item(item.typ, b)
+	}
+	return nil
+}
+
+func mvhdDuration(b []byte) time.Duration {
+	var timescale, duration uint64
+	switch {
+	case len(b) >= 20 && b[0] == 0:
+		timescale = uint64(binary.BigEndian.Uint32(b[12:]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:]))
+	case len(b) >= 32 && b[0] == 1:
+		timescale = uint64(binary.BigEndian.Uint32(b[20:]))
+		duration = binary.BigEndian.Uint64(b[24:])
+	}
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second))
+}
+
+// Data atom type indicators.
+const (
+	mp4DataJPEG = 13
+	mp4DataPNG  = 14
+)
+
+// Sets the tag from an ilst item, whose value is in a data atom within it.
+func (t *Tags) setMP4Item(typ string, b []byte) {
+	// The data atom header, type indicator and locale.
+	if len(b) < 16 || string(b[4:8]) != "data" {
+		return
+	}
+	size := binary.BigEndian.Uint32(b)
+	if size < 16 || uint64(size) > uint64(len(b)) {
+		return
+	}
+	dataType := binary.BigEndian.Uint32(b[8:]) & 0xffffff
+	value := b[16:size]
+	switch typ {
+	case "\xa9nam":
+		setIfEmpty(&t.Title, string(value))
+	case "\xa9ART":
+		setIfEmpty(&t.Artist, string(value))
+	case "aART":
+		setIfEmpty(&t.AlbumArtist, string(value))
+	case "\xa9alb":
+		setIfEmpty(&t.Album, string(value))
+	case "\xa9gen":
+		setIfEmpty(&t.Genre, string(value))
+	case "gnre":
+		// An ID3v1 genre, plus one.
+		if len(value) >= 2 {
+			setIfEmpty(&t.Genre, id3v1Genre(int(binary.BigEndian.Uint16(value))-1))
+		}
+	case "\xa9day":
+		setIfZero(&t.Year, parseYear(string(value)))
+	case "trkn", "disk":
+		if len(value) < 6 {
+			return
+		}
+		n, total := int(binary.BigEndian.Uint16(value[2:])), int(binary.BigEndian.Uint16(value[4:]))
+		if typ == "trkn" {
+			setIfZero(&t.Track, n)
+			setIfZero(&t.TrackTotal, total)
+		} else {
+			setIfZero(&t.Disc, n)
+			setIfZero(&t.DiscTotal, total)
+		}
+	case "covr":
+		p := &Picture{Data: value}
+		switch dataType {
+		case mp4DataJPEG:
+			p.MIMEType = "image/jpeg"
+		case mp4DataPNG:
+			p.MIMEType = "image/png"
+		}
+		t.setPicture(p, true)
+	}
+}
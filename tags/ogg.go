@@ -0,0 +1,140 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// How far from the end of an Ogg file to look for the last page, which gives the duration.
+const oggTailSize = 64 << 10
+
+type oggPage struct {
+	granule  int64
+	serial   uint32
+	segments []byte
+	data     []byte
+}
+
+func readOggPage(r io.Reader) (p oggPage, err error) {
+	var header [27]byte
+	if _, err = io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	if string(header[:4]) != "OggS" {
+		err = errors.New("bad ogg page")
+		return
+	}
+	p.granule = int64(binary.LittleEndian.Uint64(header[6:]))
+	p.serial = binary.LittleEndian.Uint32(header[14:])
+	p.segments = make([]byte, header[26])
+	if _, err = io.ReadFull(r, p.segments); err != nil {
+		return
+	}
+	size := 0
+	for _, s := range p.segments {
+		size += int(s)
+	}
+	p.data = make([]byte, size)
+	_, err = io.ReadFull(r, p.data)
+	return
+}
+
+// Reads the identification and comment headers of the first logical stream of an Ogg Vorbis or
+// Opus file, and the granule position of its last page for the duration.
+func readOgg(r io.ReadSeeker, t *Tags) error {
+	var (
+		serial  uint32
+		packets [][]byte
+		packet  []byte
+	)
+	for len(packets) < 2 {
+		p, err := readOggPage(r)
+		if err != nil {
+			return err
+		}
+		if len(packets) == 0 && packet == nil {
+			serial = p.serial
+		} else if p.serial != serial {
+			continue
+		}
+		data := p.data
+		for _, s := range p.segments {
+			packet = append(packet, data[:s]...)
+			data = data[s:]
+			if len(packet) > maxBlockSize {
+				return errors.New("implausible ogg header packet")
+			}
+			// A segment shorter than the maximum ends a packet.
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+	var (
+		sampleRate uint32
+		preSkip    uint16
+		comments   []byte
+	)
+	ident, comment := packets[0], packets[1]
+	switch {
+	case bytes.HasPrefix(ident, []byte("\x01vorbis")) && len(ident) >= 16:
+		sampleRate = binary.LittleEndian.Uint32(ident[12:])
+		if !bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			return errors.New("missing vorbis comment header")
+		}
+		comments = comment[7:]
+	case bytes.HasPrefix(ident, []byte("OpusHead")) && len(ident) >= 12:
+		// Opus granule positions are always at 48kHz.
+		sampleRate = 48000
+		preSkip = binary.LittleEndian.Uint16(ident[10:])
+		if !bytes.HasPrefix(comment, []byte("OpusTags")) {
+			return errors.New("missing opus tags header")
+		}
+		comments = comment[8:]
+	default:
+		return ErrUnsupported
+	}
+	if err := parseVorbisComments(comments, t); err != nil {
+		return err
+	}
+	if granule, ok := lastOggGranule(r, serial); ok && sampleRate != 0 && granule > int64(preSkip) {
+		t.Duration = time.Duration(uint64(granule-int64(preSkip)) * uint64(time.Second) / uint64(sampleRate))
+	}
+	return nil
+}
+
+// Returns the granule position of the last page of the stream in the tail of the file.
+func lastOggGranule(r io.ReadSeeker, serial uint32) (granule int64, ok bool) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return
+	}
+	start := end - oggTailSize
+	if start < 0 {
+		start = 0
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+	tail, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		h := tail[i:]
+		if len(h) < 27 || binary.LittleEndian.Uint32(h[14:]) != serial {
+			continue
+		}
+		g := int64(binary.LittleEndian.Uint64(h[6:]))
+		// Pages where no packet ends have no granule position.
+		if g == -1 {
+			continue
+		}
+		return g, true
+	}
+	return
+}
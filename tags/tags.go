@@ -0,0 +1,147 @@
+// Package tags reads the metadata of audio files without external tools. It supports ID3v1 and
+// ID3v2 in MP3, Vorbis comments in FLAC, Ogg Vorbis and Opus, and iTunes metadata in MP4/M4A.
+package tags
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tags is the metadata of an audio file. Fields the file doesn't give are left zero.
+type Tags struct {
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Genre       string
+	Track       int
+	TrackTotal  int
+	Disc        int
+	DiscTotal   int
+	Year        int
+	Duration    time.Duration
+	// The front cover if the file has one, or otherwise the first picture in it.
+	Picture *Picture
+
+	frontCover bool
+}
+
+// Picture is an image embedded in an audio file.
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+// ErrUnsupported is returned for files in formats the package doesn't read.
+var ErrUnsupported = errors.New("unsupported format")
+
+// Embedded data larger than this is assumed to be corrupt, rather than read into memory.
+const maxBlockSize = 32 << 20
+
+// ReadFile reads the tags of the named file.
+func ReadFile(name string) (Tags, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Tags{}, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read reads the tags of an audio file, detecting the format from its contents.
+func Read(r io.ReadSeeker) (t Tags, err error) {
+	var magic [12]byte
+	n, err := io.ReadFull(r, magic[:])
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return
+	}
+	if _, err = r.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	b := magic[:n]
+	switch {
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		err = readFLAC(r, &t)
+	case bytes.HasPrefix(b, []byte("OggS")):
+		err = readOgg(r, &t)
+	case len(b) >= 8 && string(b[4:8]) == "ftyp":
+		err = readMP4(r, &t)
+	case bytes.HasPrefix(b, []byte("ID3")), len(b) >= 4 && parseFrameHeader(b) != nil:
+		err = readMP3(r, &t)
+	default:
+		err = ErrUnsupported
+	}
+	return
+}
+
+// Parses numbers like "3" or "3/12", as used for track and disc numbers.
+func parseNumber(s string) (n, total int) {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		total, _ = strconv.Atoi(strings.TrimSpace(s[i+1:]))
+		s = s[:i]
+	}
+	n, _ = strconv.Atoi(strings.TrimSpace(s))
+	return
+}
+
+// Returns the year from dates like "2004", "2004-05-06" or "2004-05-06T07:08".
+func parseYear(s string) int {
+	s = strings.TrimSpace(s)
+	if len(s) < 4 {
+		return 0
+	}
+	y, err := strconv.Atoi(s[:4])
+	if err != nil {
+		return 0
+	}
+	return y
+}
+
+func setIfEmpty(s *string, v string) {
+	if *s == "" {
+		*s = strings.TrimSpace(v)
+	}
+}
+
+func setIfZero(n *int, v int) {
+	if *n == 0 {
+		*n = v
+	}
+}
+
+// Fills in the fields of t that are unset from other.
+func (t *Tags) merge(other Tags) {
+	setIfEmpty(&t.Title, other.Title)
+	setIfEmpty(&t.Artist, other.Artist)
+	setIfEmpty(&t.AlbumArtist, other.AlbumArtist)
+	setIfEmpty(&t.Album, other.Album)
+	setIfEmpty(&t.Genre, other.Genre)
+	setIfZero(&t.Track, other.Track)
+	setIfZero(&t.TrackTotal, other.TrackTotal)
+	setIfZero(&t.Disc, other.Disc)
+	setIfZero(&t.DiscTotal, other.DiscTotal)
+	setIfZero(&t.Year, other.Year)
+	if t.Duration == 0 {
+		t.Duration = other.Duration
+	}
+	if t.Picture == nil {
+		t.Picture = other.Picture
+		t.frontCover = other.frontCover
+	}
+}
+
+// Reads a block of the size given, refusing implausible sizes.
+func readBlock(r io.Reader, size int64) ([]byte, error) {
+	if size < 0 || size > maxBlockSize {
+		return nil, errors.New("implausible block size")
+	}
+	b := make([]byte, size)
+	_, err := io.ReadFull(r, b)
+	return b, err
+}
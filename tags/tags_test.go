@@ -0,0 +1,310 @@
+package tags
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func synchsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+func id3v2Frame(version byte, id string, data []byte) []byte {
+	b := []byte(id)
+	if version >= 4 {
+		b = append(b, synchsafeBytes(len(data))...)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	}
+	b = append(b, 0, 0)
+	return append(b, data...)
+}
+
+func id3v2Tag(version byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	// Some padding.
+	body = append(body, make([]byte, 16)...)
+	b := []byte{'I', 'D', '3', version, 0, 0}
+	b = append(b, synchsafeBytes(len(body))...)
+	return append(b, body...)
+}
+
+func latin1Text(s string) []byte {
+	return append([]byte{id3Latin1}, s...)
+}
+
+// Returns an MPEG-1 Layer III stereo frame at 128kbps and 44.1kHz, with a Xing header giving the
+// number of frames if it's not zero.
+func mp3FrameBytes(xingFrames uint32) []byte {
+	b := make([]byte, 417)
+	copy(b, []byte{0xff, 0xfb, 0x90, 0x00})
+	if xingFrames != 0 {
+		copy(b[36:], "Xing")
+		binary.BigEndian.PutUint32(b[40:], 1)
+		binary.BigEndian.PutUint32(b[44:], xingFrames)
+	}
+	return b
+}
+
+func TestID3v23(t *testing.T) {
+	utf16Title := []byte{id3UTF16, 0xff, 0xfe, 'H', 0, 0xe9, 0, 0, 0}
+	apic := append([]byte{id3Latin1}, "image/png\x00"...)
+	apic = append(apic, frontCover)
+	apic = append(apic, "desc\x00"...)
+	apic = append(apic, "PNGDATA"...)
+	otherPic := append([]byte{id3Latin1}, "image/jpeg\x00\x04\x00JPEG"...)
+	var b []byte
+	b = append(b, id3v2Tag(3,
+		id3v2Frame(3, "TIT2", utf16Title),
+		id3v2Frame(3, "TPE1", latin1Text("Artist")),
+		id3v2Frame(3, "TPE2", latin1Text("Album Artist")),
+		id3v2Frame(3, "TALB", latin1Text("Album")),
+		id3v2Frame(3, "TCON", latin1Text("(17)")),
+		id3v2Frame(3, "TRCK", latin1Text("3/12")),
+		id3v2Frame(3, "TPOS", latin1Text("1/2")),
+		id3v2Frame(3, "TYER", latin1Text("1999")),
+		id3v2Frame(3, "APIC", otherPic),
+		id3v2Frame(3, "APIC", apic),
+	)...)
+	// 38.28 frames per second of 1152 samples at 44.1kHz.
+	b = append(b, mp3FrameBytes(3828)...)
+	tags, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Tags{
+		Title:       "Hé",
+		Artist:      "Artist",
+		AlbumArtist: "Album Artist",
+		Album:       "Album",
+		Genre:       "Rock",
+		Track:       3,
+		TrackTotal:  12,
+		Disc:        1,
+		DiscTotal:   2,
+		Year:        1999,
+		Duration:    3828 * 1152 * time.Second / 44100,
+		Picture:     &Picture{"image/png", []byte("PNGDATA")},
+		frontCover:  true,
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("got %+v", tags)
+	}
+}
+
+func TestID3v24AndV1(t *testing.T) {
+	var b []byte
+	b = append(b, id3v2Tag(4,
+		id3v2Frame(4, "TIT2", append([]byte{id3UTF8}, "Tïtle\x00Other"...)),
+		id3v2Frame(4, "TDRC", latin1Text("2004-05-06")),
+	)...)
+	// Constant bitrate: 10 frames of 417 bytes at 128kbps.
+	for i := 0; i < 10; i++ {
+		b = append(b, mp3FrameBytes(0)...)
+	}
+	v1 := make([]byte, 128)
+	copy(v1, "TAG")
+	copy(v1[3:], "v1 title")
+	copy(v1[33:], "v1 artist")
+	v1[126] = 7
+	v1[127] = 8
+	b = append(b, v1...)
+	tags, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Title != "Tïtle" || tags.Artist != "v1 artist" || tags.Year != 2004 || tags.Track != 7 || tags.Genre != "Jazz" {
+		t.Fatalf("got %+v", tags)
+	}
+	if expected := 4170 * 8 * time.Second / 128000; tags.Duration != expected {
+		t.Fatalf("got duration %v, expected %v", tags.Duration, expected)
+	}
+}
+
+func TestID3v22(t *testing.T) {
+	frame := func(id string, data []byte) []byte {
+		return append([]byte{id[0], id[1], id[2], 0, byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+	b := id3v2Tag(2,
+		frame("TT2", latin1Text("Old")),
+		frame("PIC", append([]byte{id3Latin1, 'J', 'P', 'G', frontCover, 0}, "JPEGDATA"...)),
+	)
+	b = append(b, mp3FrameBytes(0)...)
+	tags, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Title != "Old" || !reflect.DeepEqual(tags.Picture, &Picture{"image/jpeg", []byte("JPEGDATA")}) {
+		t.Fatalf("got %+v", tags)
+	}
+}
+
+func vorbisComments(comments ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 6)
+	b = append(b, "vendor"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+func flacPictureBlock(picType uint32, mimeType string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, picType)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(mimeType)))
+	b = append(b, mimeType...)
+	b = binary.BigEndian.AppendUint32(b, 0)
+	b = append(b, make([]byte, 16)...)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func TestFLAC(t *testing.T) {
+	streamInfo := make([]byte, 34)
+	// 44.1kHz, stereo, 16 bits, 441000 samples.
+	streamInfo[10], streamInfo[11], streamInfo[12] = 0x0a, 0xc4, 0x42
+	streamInfo[13] = 0xf0
+	binary.BigEndian.PutUint32(streamInfo[14:], 441000)
+	block := func(typ byte, last bool, data []byte) []byte {
+		if last {
+			typ |= 0x80
+		}
+		return append([]byte{typ, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+	var b []byte
+	b = append(b, "fLaC"...)
+	b = append(b, block(flacStreamInfo, false, streamInfo)...)
+	b = append(b, block(1, false, make([]byte, 8))...)
+	b = append(b, block(flacVorbisComment, false, vorbisComments(
+		"title=Song", "ARTIST=A", "ARTIST=B", "ALBUMARTIST=AA", "TRACKNUMBER=2", "TRACKTOTAL=9", "DATE=2010-01-01", "GENRE=Folk",
+	))...)
+	b = append(b, block(flacPicture, true, flacPictureBlock(frontCover, "image/jpeg", []byte("JPEG")))...)
+	tags, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Tags{
+		Title:       "Song",
+		Artist:      "A",
+		AlbumArtist: "AA",
+		Genre:       "Folk",
+		Track:       2,
+		TrackTotal:  9,
+		Year:        2010,
+		Duration:    10 * time.Second,
+		Picture:     &Picture{"image/jpeg", []byte("JPEG")},
+		frontCover:  true,
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("got %+v", tags)
+	}
+}
+
+func oggPageBytes(serial uint32, granule int64, packets ...[]byte) []byte {
+	var segments, data []byte
+	for _, p := range packets {
+		data = append(data, p...)
+		n := len(p)
+		for ; n >= 255; n -= 255 {
+			segments = append(segments, 255)
+		}
+		segments = append(segments, byte(n))
+	}
+	b := []byte("OggS\x00\x00")
+	b = binary.LittleEndian.AppendUint64(b, uint64(granule))
+	b = binary.LittleEndian.AppendUint32(b, serial)
+	// Sequence number and checksum, which aren't checked.
+	b = append(b, make([]byte, 8)...)
+	b = append(b, byte(len(segments)))
+	b = append(b, segments...)
+	return append(b, data...)
+}
+
+func TestOpus(t *testing.T) {
+	head := []byte("OpusHead\x01\x02")
+	head = binary.LittleEndian.AppendUint16(head, 312)
+	head = append(head, make([]byte, 7)...)
+	picture := base64.StdEncoding.EncodeToString(flacPictureBlock(frontCover, "image/png", []byte("PNG")))
+	// Long enough to span segments.
+	tagsPacket := append([]byte("OpusTags"), vorbisComments("TITLE=Opus", "ALBUM=Album", "METADATA_BLOCK_PICTURE="+picture, "COMMENT="+string(make([]byte, 600)))...)
+	var b []byte
+	b = append(b, oggPageBytes(7, 0, head)...)
+	// An unrelated stream's page, which is ignored.
+	b = append(b, oggPageBytes(8, 0, []byte("other"))...)
+	b = append(b, oggPageBytes(7, 0, tagsPacket)...)
+	b = append(b, oggPageBytes(7, 48000*3+312, []byte("audio"))...)
+	tags, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags.Title != "Opus" || tags.Album != "Album" || tags.Duration != 3*time.Second {
+		t.Fatalf("got %+v", tags)
+	}
+	if !reflect.DeepEqual(tags.Picture, &Picture{"image/png", []byte("PNG")}) {
+		t.Fatalf("got picture %+v", tags.Picture)
+	}
+}
+
+func mp4AtomBytes(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = append(b, typ...)
+	return append(b, body...)
+}
+
+func mp4Data(dataType uint32, value []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, dataType)
+	b = append(b, 0, 0, 0, 0)
+	return mp4AtomBytes("data", append(b, value...))
+}
+
+func TestMP4(t *testing.T) {
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 2500)
+	ilst := mp4AtomBytes("ilst",
+		mp4AtomBytes("\xa9nam", mp4Data(1, []byte("M4A"))),
+		mp4AtomBytes("aART", mp4Data(1, []byte("Various"))),
+		mp4AtomBytes("gnre", mp4Data(0, []byte{0, 10})),
+		mp4AtomBytes("trkn", mp4Data(0, []byte{0, 0, 0, 4, 0, 10, 0, 0})),
+		mp4AtomBytes("disk", mp4Data(0, []byte{0, 0, 0, 1, 0, 1})),
+		mp4AtomBytes("\xa9day", mp4Data(1, []byte("2015-02-03T00:00:00Z"))),
+		mp4AtomBytes("covr", mp4Data(mp4DataJPEG, []byte("JPEG"))),
+	)
+	meta := mp4AtomBytes("meta", make([]byte, 4), mp4AtomBytes("hdlr", make([]byte, 25)), ilst)
+	var b []byte
+	b = append(b, mp4AtomBytes("ftyp", []byte("M4A \x00\x00\x00\x00"))...)
+	b = append(b, mp4AtomBytes("mdat", make([]byte, 100))...)
+	b = append(b, mp4AtomBytes("moov", mp4AtomBytes("mvhd", mvhd), mp4AtomBytes("udta", meta))...)
+	tags, err := Read(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Tags{
+		Title:       "M4A",
+		AlbumArtist: "Various",
+		Genre:       "Metal",
+		Track:       4,
+		TrackTotal:  10,
+		Disc:        1,
+		DiscTotal:   1,
+		Year:        2015,
+		Duration:    2500 * time.Millisecond,
+		Picture:     &Picture{"image/jpeg", []byte("JPEG")},
+		frontCover:  true,
+	}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("got %+v", tags)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	if _, err := Read(bytes.NewReader([]byte("RIFF\x00\x00\x00\x00WAVE"))); err != ErrUnsupported {
+		t.Fatalf("got %v", err)
+	}
+}
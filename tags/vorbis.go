@@ -0,0 +1,98 @@
+package tags
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+var errShortComments = errors.New("short vorbis comments")
+
+// Parses a Vorbis comment block, as used by FLAC, Ogg Vorbis and Opus. The first value of each
+// field is used.
+func parseVorbisComments(b []byte, t *Tags) error {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := binary.LittleEndian.Uint32(b)
+		if uint64(n) > uint64(len(b)-4) {
+			return nil, false
+		}
+		field := b[4 : 4+n]
+		b = b[4+n:]
+		return field, true
+	}
+	// The vendor string.
+	if _, ok := next(); !ok {
+		return errShortComments
+	}
+	if len(b) < 4 {
+		return errShortComments
+	}
+	count := binary.LittleEndian.Uint32(b)
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		field, ok := next()
+		if !ok {
+			return errShortComments
+		}
+		key, value, ok := strings.Cut(string(field), "=")
+		if !ok {
+			continue
+		}
+		t.setVorbisComment(strings.ToUpper(key), value)
+	}
+	return nil
+}
+
+func (t *Tags) setVorbisComment(key, value string) {
+	switch key {
+	case "TITLE":
+		setIfEmpty(&t.Title, value)
+	case "ARTIST":
+		setIfEmpty(&t.Artist, value)
+	case "ALBUMARTIST", "ALBUM ARTIST", "ALBUM_ARTIST":
+		setIfEmpty(&t.AlbumArtist, value)
+	case "ALBUM":
+		setIfEmpty(&t.Album, value)
+	case "GENRE":
+		setIfEmpty(&t.Genre, value)
+	case "TRACKNUMBER":
+		n, total := parseNumber(value)
+		setIfZero(&t.Track, n)
+		setIfZero(&t.TrackTotal, total)
+	case "TRACKTOTAL", "TOTALTRACKS":
+		n, _ := parseNumber(value)
+		setIfZero(&t.TrackTotal, n)
+	case "DISCNUMBER":
+		n, total := parseNumber(value)
+		setIfZero(&t.Disc, n)
+		setIfZero(&t.DiscTotal, total)
+	case "DISCTOTAL", "TOTALDISCS":
+		n, _ := parseNumber(value)
+		setIfZero(&t.DiscTotal, n)
+	case "DATE", "YEAR", "ORIGINALDATE":
+		setIfZero(&t.Year, parseYear(value))
+	case "METADATA_BLOCK_PICTURE":
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return
+		}
+		if p, picType, err := parseFLACPicture(b); err == nil {
+			t.setPicture(p, picType == frontCover)
+		}
+	}
+}
+
+// The picture type of front covers in ID3v2 and FLAC.
+const frontCover = 3
+
+// Keeps the first picture, unless a front cover comes later.
+func (t *Tags) setPicture(p *Picture, isFrontCover bool) {
+	if t.Picture == nil || isFrontCover && !t.frontCover {
+		t.Picture = p
+		t.frontCover = isFrontCover
+	}
+}
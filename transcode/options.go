@@ -0,0 +1,128 @@
+package transcode
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+// Options select the streams of the input that go into a transcode.
+type Options struct {
+	// The index of the input stream to take audio from, as ffprobe numbers them, or -1 for the
+	// default choice.
+	AudioStream int
+	// The index of an embedded subtitle stream to burn into the video, or -1 for none. Both text
+	// and bitmap subtitles are supported.
+	SubtitleStream int
+	// A subtitle file to burn into the video. It takes precedence over SubtitleStream.
+	SubtitleFile string
+}
+
+// DefaultOptions returns Options that leave the stream choices to ffmpeg and burn in nothing.
+func DefaultOptions() Options {
+	return Options{
+		AudioStream:    -1,
+		SubtitleStream: -1,
+	}
+}
+
+// Returns true if the options select anything other than the defaults.
+func (o Options) selective() bool {
+	return o.AudioStream >= 0 || o.burnsSubtitles()
+}
+
+func (o Options) burnsSubtitles() bool {
+	return o.SubtitleFile != "" || o.SubtitleStream >= 0
+}
+
+// Subtitle codecs that are images rather than text, and so must be overlaid rather than rendered.
+var bitmapSubtitleCodecs = map[string]bool{
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"hdmv_pgs_subtitle": true,
+	"xsub":              true,
+}
+
+// IsBitmapSubtitle returns true if the subtitle codec is image based.
+func IsBitmapSubtitle(codecName string) bool {
+	return bitmapSubtitleCodecs[codecName]
+}
+
+func streamIndex(stream map[string]interface{}) int {
+	i, err := ffprobe.AnyAsInt64(stream["index"])
+	if err != nil {
+		return -1
+	}
+	return int(i)
+}
+
+// Returns the filtergraph that burns the selected subtitles into the video labelled in. The
+// input is assumed to be seeked to start, which the subtitles filter doesn't know about, so
+// timestamps are shifted back to the original timeline for it.
+func (o Options) burnInFilter(info *ffprobe.Info, path, in string, start time.Duration) (string, error) {
+	if o.SubtitleFile != "" {
+		return fmt.Sprintf("[%s]setpts=PTS+%f/TB,subtitles=filename=%s,setpts=PTS-STARTPTS",
+			in, start.Seconds(), escapeFilterValue(o.SubtitleFile)), nil
+	}
+	if info == nil {
+		return "", fmt.Errorf("no probe info for subtitle stream %d", o.SubtitleStream)
+	}
+	// The subtitles filter numbers streams among the subtitle streams only.
+	subtitleIndex := 0
+	for _, stream := range info.Streams {
+		if stream["codec_type"] != "subtitle" {
+			continue
+		}
+		if streamIndex(stream) != o.SubtitleStream {
+			subtitleIndex++
+			continue
+		}
+		codec, _ := stream["codec_name"].(string)
+		if IsBitmapSubtitle(codec) {
+			return fmt.Sprintf("[%s][0:%d]overlay", in, o.SubtitleStream), nil
+		}
+		return fmt.Sprintf("[%s]setpts=PTS+%f/TB,subtitles=filename=%s:si=%d,setpts=PTS-STARTPTS",
+			in, start.Seconds(), escapeFilterValue(path), subtitleIndex), nil
+	}
+	return "", fmt.Errorf("no subtitle stream %d", o.SubtitleStream)
+}
+
+// Escapes a filter option value, and then the result for the filtergraph it appears in.
+func escapeFilterValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(s)
+}
+
+// Returns the arguments that select the video, with any subtitles burned in, and audio for
+// transcodes producing a single stream of each. Nothing is returned for the default options, so
+// ffmpeg makes its usual choices.
+func (o Options) singleStreamArgs(path string, start time.Duration) (args []string, err error) {
+	if !o.selective() {
+		return
+	}
+	if o.burnsSubtitles() {
+		var info *ffprobe.Info
+		if o.SubtitleFile == "" {
+			info, err = ffprobe.Run(path)
+			if err != nil {
+				return
+			}
+		}
+		var filter string
+		filter, err = o.burnInFilter(info, path, "0:v:0", start)
+		if err != nil {
+			return
+		}
+		args = append(args, "-filter_complex", filter+"[v]", "-map", "[v]")
+	} else {
+		args = append(args, "-map", "0:v:0")
+	}
+	if o.AudioStream >= 0 {
+		args = append(args, "-map", fmt.Sprintf("0:%d", o.AudioStream))
+	} else {
+		args = append(args, "-map", "0:a:0?")
+	}
+	return
+}
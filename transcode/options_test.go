@@ -0,0 +1,69 @@
+package transcode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+var testInfo = &ffprobe.Info{
+	Streams: []map[string]interface{}{
+		{"index": json.Number("0"), "codec_type": "video", "codec_name": "h264"},
+		{"index": json.Number("1"), "codec_type": "audio", "codec_name": "aac"},
+		{"index": json.Number("2"), "codec_type": "subtitle", "codec_name": "hdmv_pgs_subtitle"},
+		{"index": json.Number("3"), "codec_type": "subtitle", "codec_name": "subrip"},
+	},
+}
+
+func TestBurnInFilter(t *testing.T) {
+	for _, tc := range []struct {
+		opts     Options
+		expected string
+	}{
+		{
+			Options{AudioStream: -1, SubtitleStream: 2},
+			"[0:v:0][0:2]overlay",
+		},
+		{
+			Options{AudioStream: -1, SubtitleStream: 3},
+			`[0:v:0]setpts=PTS+90.000000/TB,subtitles=filename=/media/it\\\'s\\:x \[1\]\,y.mkv:si=1,setpts=PTS-STARTPTS`,
+		},
+		{
+			Options{AudioStream: -1, SubtitleStream: -1, SubtitleFile: "/media/x.srt"},
+			"[0:v:0]setpts=PTS+90.000000/TB,subtitles=filename=/media/x.srt,setpts=PTS-STARTPTS",
+		},
+	} {
+		actual, err := tc.opts.burnInFilter(testInfo, "/media/it's:x [1],y.mkv", "0:v:0", 90*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Errorf("got %q, expected %q", actual, tc.expected)
+		}
+	}
+	if _, err := (Options{AudioStream: -1, SubtitleStream: 5}).burnInFilter(testInfo, "x", "0:v:0", 0); err == nil {
+		t.Error("expected error for missing stream")
+	}
+}
+
+func TestGenerateStreamArgsSelectsAudio(t *testing.T) {
+	info := &ffprobe.Info{Streams: append([]map[string]interface{}{
+		{"index": json.Number("4"), "codec_type": "audio", "codec_name": "ac3"},
+	}, testInfo.Streams...)}
+	args, err := generateStreamArgs(info, "x", 0, Options{AudioStream: 1, SubtitleStream: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var maps []string
+	for i, a := range args {
+		if a == "-map" {
+			maps = append(maps, args[i+1])
+		}
+	}
+	// The subrip stream isn't muxed, as only srt and dvdsub codec names are.
+	if len(maps) != 2 || maps[0] != "0:0" || maps[1] != "0:1" {
+		t.Fatalf("got maps %q", maps)
+	}
+}
@@ -0,0 +1,94 @@
+package transcode
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// Sandbox restricts a process run for a dynamic stream.
+type Sandbox struct {
+	// The working directory. The system's temporary directory if empty.
+	Dir string
+	// The entire environment, as "key=value" strings. Nothing is inherited from the server.
+	Env []string
+	// How long the process may run before it's interrupted. No limit if zero.
+	Timeout time.Duration
+	// Limits on the CPU seconds, address space in bytes and open files of the process. No limit
+	// if zero. Only supported on Linux, where they're set by /bin/sh before it runs the command.
+	MaxCPUTime   uint64
+	MaxMemory    uint64
+	MaxOpenFiles uint64
+	// Run the process as this user and group, which usually requires the server to run as root.
+	// Only supported on Unix.
+	Credential *Credential
+}
+
+// Credential is a user and group ID to run a process as.
+type Credential struct {
+	UID uint32
+	GID uint32
+}
+
+// An error for sandbox restrictions the platform doesn't support.
+var ErrSandboxUnsupported = errors.New("sandbox restriction not supported on this platform")
+
+// ExecSandboxed runs the command with the arguments given, restricted by the sandbox, and returns
+// a reader from its stdout. It does not support seeking. The command and its failure are logged
+// to the logger. Used by the dynamic stream feature.
+func ExecSandboxed(ctx context.Context, args []string, sb Sandbox, logger log.Logger, stderr io.Writer) (r io.ReadCloser, err error) {
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	cancel := func() {}
+	if sb.Timeout != 0 {
+		ctx, cancel = context.WithTimeout(ctx, sb.Timeout)
+	}
+	logger.Printf("sandboxed stream command: %q", args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.Dir = sb.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = os.TempDir()
+	}
+	cmd.Env = append([]string{}, sb.Env...)
+	cmd.Stderr = stderr
+	if err = sandboxCommand(cmd, sb); err != nil {
+		cancel()
+		return
+	}
+	// Not cmd.StdoutPipe, as Wait would close it while what the command wrote is still unread.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		cancel()
+		return
+	}
+	cmd.Stdout = pw
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		cancel()
+		return
+	}
+	r = pr
+	go func() {
+		defer cancel()
+		var esErr *exec.ExitError
+		err := cmd.Wait()
+		if err != nil {
+			if errors.As(err, &esErr) && esErr.ExitCode() == 255 {
+				return
+			}
+			logger.Printf("command %s failed: %s", args, err)
+		}
+	}()
+	return
+}
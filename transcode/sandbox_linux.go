@@ -0,0 +1,56 @@
+//go:build linux
+// +build linux
+
+package transcode
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+func sandboxCommand(cmd *exec.Cmd, sb Sandbox) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// Don't leave the process running if the server dies.
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if sb.Credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:         sb.Credential.UID,
+			Gid:         sb.Credential.GID,
+			NoSetGroups: true,
+		}
+	}
+	return limitCommand(cmd, sb)
+}
+
+// Runs the command through a shell that sets the resource limits before it execs it, as exec.Cmd
+// can't set them, and setting them once the process has started leaves it unrestricted until then.
+func limitCommand(cmd *exec.Cmd, sb Sandbox) error {
+	var script strings.Builder
+	for _, l := range []struct {
+		flag string
+		max  uint64
+	}{
+		{"-t", sb.MaxCPUTime},
+		// ulimit takes the address space in KiB.
+		{"-v", (sb.MaxMemory + 1023) / 1024},
+		{"-n", sb.MaxOpenFiles},
+	} {
+		if l.max != 0 {
+			fmt.Fprintf(&script, "ulimit %s %d && ", l.flag, l.max)
+		}
+	}
+	if script.Len() == 0 {
+		return nil
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	script.WriteString(`exec "$@"`)
+	// The command was already looked up in the server's PATH, which the shell doesn't get.
+	cmd.Args = append([]string{"sh", "-c", script.String(), "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+	return nil
+}
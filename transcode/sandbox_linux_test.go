@@ -0,0 +1,30 @@
+package transcode
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/log"
+)
+
+func TestExecSandboxedLimits(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip(err)
+	}
+	// The limits are read by a shell of its own, so they must be in place when it starts.
+	sb := Sandbox{MaxCPUTime: 30, MaxMemory: 1 << 30, MaxOpenFiles: 64}
+	r, err := ExecSandboxed(context.Background(), []string{"/bin/sh", "-c", "ulimit -t; ulimit -v; ulimit -n"}, sb, log.Default, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits := strings.Fields(string(b)); strings.Join(limits, " ") != "30 1048576 64" {
+		t.Fatalf("got limits %q", limits)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package transcode
+
+import "os/exec"
+
+func sandboxCommand(cmd *exec.Cmd, sb Sandbox) error {
+	if sb.MaxCPUTime != 0 || sb.MaxMemory != 0 || sb.MaxOpenFiles != 0 || sb.Credential != nil {
+		return ErrSandboxUnsupported
+	}
+	return nil
+}
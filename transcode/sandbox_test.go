@@ -0,0 +1,46 @@
+package transcode
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+func TestExecSandboxed(t *testing.T) {
+	if _, err := os.Stat("/usr/bin/env"); err != nil {
+		t.Skip(err)
+	}
+	os.Setenv("DMS_SANDBOX_TEST", "leaked")
+	defer os.Unsetenv("DMS_SANDBOX_TEST")
+	r, err := ExecSandboxed(context.Background(), []string{"/usr/bin/env"}, Sandbox{Env: []string{"A=1"}}, log.Default, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env := strings.TrimSpace(string(b)); env != "A=1" {
+		t.Fatalf("got environment %q", env)
+	}
+}
+
+func TestExecSandboxedTimeout(t *testing.T) {
+	if _, err := os.Stat("/bin/sleep"); err != nil {
+		t.Skip(err)
+	}
+	started := time.Now()
+	r, err := ExecSandboxed(context.Background(), []string{"/bin/sleep", "10"}, Sandbox{Timeout: 100 * time.Millisecond}, log.Default, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(r)
+	if d := time.Since(started); d > 5*time.Second {
+		t.Fatalf("ran for %v", d)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build darwin || freebsd || netbsd || openbsd
+// +build darwin freebsd netbsd openbsd
+
+package transcode
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func sandboxCommand(cmd *exec.Cmd, sb Sandbox) error {
+	if sb.MaxCPUTime != 0 || sb.MaxMemory != 0 || sb.MaxOpenFiles != 0 {
+		return ErrSandboxUnsupported
+	}
+	if sb.Credential != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid:         sb.Credential.UID,
+				Gid:         sb.Credential.GID,
+				NoSetGroups: true,
+			},
+		}
+	}
+	return nil
+}
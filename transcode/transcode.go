@@ -53,8 +53,11 @@ func transcodePipe(ctx context.Context, args []string, stderr io.Writer) (r io.R
 }
 
 // Return a series of ffmpeg arguments that pick specific codecs for specific
-// streams. This requires use of the -map flag.
-func generateStreamArgs(streams []map[string]interface{}) (args []string) {
+// streams. This requires use of the -map flag. Only the audio stream chosen in
+// the options is kept, if any, and chosen subtitles are burned into the first
+// video stream rather than muxed.
+func generateStreamArgs(info *ffprobe.Info, path string, start time.Duration, opts Options) (args []string, err error) {
+	streams := info.Streams
 	getStreamAlias := func(stream map[string]interface{}) (inputIndex int, streamAlias string) {
 		indexF, ok := stream["index"].(float64)
 		if !ok {
@@ -72,6 +75,7 @@ func generateStreamArgs(streams []map[string]interface{}) (args []string) {
 		outputVideoIndex    int
 		outputAudioIndex    int
 		outputSubtitleIndex int
+		burnedSubtitles     bool
 	)
 
 	canvas := "720x576"
@@ -88,8 +92,25 @@ func generateStreamArgs(streams []map[string]interface{}) (args []string) {
 					"-c:v:"+strconv.Itoa(outputVideoIndex), "copy",
 				)
 			default:
+				if opts.burnsSubtitles() && !burnedSubtitles {
+					var filter string
+					filter, err = opts.burnInFilter(info, path, streamAlias, start)
+					if err != nil {
+						return
+					}
+					label := "[v" + strconv.Itoa(outputVideoIndex) + "]"
+					args = append(args,
+						"-filter_complex", filter+",fieldorder=tff"+label,
+						"-map", label,
+					)
+					burnedSubtitles = true
+				} else {
+					args = append(args,
+						"-map", streamAlias,
+						"-filter:v:"+strconv.Itoa(outputVideoIndex), "fieldorder=tff",
+					)
+				}
 				args = append(args,
-					"-map", streamAlias,
 					"-c:v:"+strconv.Itoa(outputVideoIndex), "mpeg2video",
 					"-b:v:"+strconv.Itoa(outputVideoIndex), "6000k",
 					"-minrate:v:"+strconv.Itoa(outputVideoIndex), "3000k",
@@ -98,7 +119,6 @@ func generateStreamArgs(streams []map[string]interface{}) (args []string) {
 					"-g:v:"+strconv.Itoa(outputVideoIndex), "15",
 					"-bf:v:"+strconv.Itoa(outputVideoIndex), "2",
 					"-flags:v:"+strconv.Itoa(outputVideoIndex), "+ilme+ildct",
-					"-filter:v:"+strconv.Itoa(outputVideoIndex), "fieldorder=tff",
 					"-aspect:v:"+strconv.Itoa(outputVideoIndex), "16:9",
 					"-s:v:"+strconv.Itoa(outputVideoIndex), canvas,
 					"-r:v:"+strconv.Itoa(outputVideoIndex), "25",
@@ -107,6 +127,9 @@ func generateStreamArgs(streams []map[string]interface{}) (args []string) {
 
 			outputVideoIndex++
 		case "audio":
+			if opts.AudioStream >= 0 && streamIndex(stream) != opts.AudioStream {
+				continue
+			}
 			_, streamAlias := getStreamAlias(stream)
 			defaultFilter := true
 
@@ -156,6 +179,9 @@ func generateStreamArgs(streams []map[string]interface{}) (args []string) {
 
 			outputAudioIndex++
 		case "subtitle":
+			if opts.burnsSubtitles() {
+				continue
+			}
 			_, streamAlias := getStreamAlias(stream)
 
 			switch stream["codec_name"] {
@@ -189,6 +215,11 @@ func generateStreamArgs(streams []map[string]interface{}) (args []string) {
 
 // Streams the desired file in the MPEG_PS_PAL DLNA profile.
 func Transcode(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	return TranscodeWithOptions(ctx, path, start, length, DefaultOptions(), stderr)
+}
+
+// Streams the desired file in the MPEG_PS_PAL DLNA profile, with the streams chosen in opts.
+func TranscodeWithOptions(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
 	args := []string{
 		"ffmpeg",
 		"-threads", strconv.FormatInt(int64(runtime.NumCPU()), 10),
@@ -208,7 +239,11 @@ func Transcode(ctx context.Context, path string, start, length time.Duration, st
 		return
 	}
 
-	args = append(args, generateStreamArgs(info.Streams)...)
+	streamArgs, err := generateStreamArgs(info, path, start, opts)
+	if err != nil {
+		return
+	}
+	args = append(args, streamArgs...)
 	args = append(args,
 		"-f", "mpegts",
 		"-mpegts_flags", "+resend_headers+initial_discontinuity",
@@ -248,16 +283,28 @@ func VP8Transcode(ctx context.Context, path string, start, length time.Duration,
 
 // Returns a stream of Chromecast supported matroska.
 func ChromecastTranscode(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	return ChromecastTranscodeWithOptions(ctx, path, start, length, DefaultOptions(), stderr)
+}
+
+// Returns a stream of Chromecast supported matroska, with the streams chosen in opts.
+func ChromecastTranscodeWithOptions(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
+	selectArgs, err := opts.singleStreamArgs(path, start)
+	if err != nil {
+		return
+	}
 	args := []string{
 		"ffmpeg",
 		"-ss", FormatDurationSexagesimal(start),
 		"-i", path,
+	}
+	args = append(args, selectArgs...)
+	args = append(args,
 		"-c:v", "libx264", "-preset", "fast", "-profile:v", "high", "-level", "5.0",
 		"-g", "48", "-keyint_min", "48", "-sc_threshold", "0",
 		"-movflags", "+faststart+frag_keyframe+default_base_moof",
 		"-frag_duration", "1000000", "-min_frag_duration", "1000000",
 		"-force_key_frames", "expr:gte(n,n_forced*48)",
-	} // +empty_moov
+	) // +empty_moov
 	if length > 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
@@ -272,16 +319,28 @@ func ChromecastTranscode(ctx context.Context, path string, start, length time.Du
 
 // Returns a stream of h264 video and mp3 audio
 func WebTranscode(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	return WebTranscodeWithOptions(ctx, path, start, length, DefaultOptions(), stderr)
+}
+
+// Returns a stream of h264 video and mp3 audio, with the streams chosen in opts.
+func WebTranscodeWithOptions(ctx context.Context, path string, start, length time.Duration, opts Options, stderr io.Writer) (r io.ReadCloser, err error) {
+	selectArgs, err := opts.singleStreamArgs(path, start)
+	if err != nil {
+		return
+	}
 	args := []string{
 		"ffmpeg",
 		"-ss", FormatDurationSexagesimal(start),
 		"-i", path,
+	}
+	args = append(args, selectArgs...)
+	args = append(args,
 		"-pix_fmt", "yuv420p",
 		"-c:v", "libx264", "-crf", "25",
 		"-c:a", "mp3", "-ab", "128k", "-ar", "44100",
 		"-preset", "ultrafast",
 		"-movflags", "+faststart+frag_keyframe+empty_moov+default_base_moof",
-	}
+	)
 	if length > 0 {
 		args = append(args, []string{
 			"-t", FormatDurationSexagesimal(length),
@@ -294,6 +353,92 @@ func WebTranscode(ctx context.Context, path string, start, length time.Duration,
 	return transcodePipe(ctx, args, stderr)
 }
 
+// Returns the sample rate and channel count for an LPCM stream of the first audio stream in info,
+// restricted to those permitted by the DLNA LPCM profile. The info may be nil.
+func LPCMParams(info *ffprobe.Info) (sampleRate, channels int) {
+	sampleRate, channels = 44100, 2
+	if info == nil {
+		return
+	}
+	for _, stream := range info.Streams {
+		if stream["codec_type"] != "audio" {
+			continue
+		}
+		// Rates that don't divide evenly into 44.1kHz are resampled to 48kHz.
+		if rate, err := strconv.Atoi(fmt.Sprint(stream["sample_rate"])); err == nil && rate >= 48000 && rate%44100 != 0 {
+			sampleRate = 48000
+		}
+		if fmt.Sprint(stream["channels"]) == "1" {
+			channels = 1
+		}
+		break
+	}
+	return
+}
+
+// Returns the ffmpeg arguments common to the audio-only transcodes. The first audio stream of the
+// input is kept, and any attached pictures are dropped.
+func audioTranscodeArgs(path string, start, length time.Duration) []string {
+	args := []string{
+		"ffmpeg",
+		"-ss", FormatDurationSexagesimal(start),
+	}
+	if length > 0 {
+		args = append(args, "-t", FormatDurationSexagesimal(length))
+	}
+	return append(args,
+		"-i", path,
+		"-map", "0:a:0",
+		"-vn", "-sn",
+		"-map_metadata", "-1",
+	)
+}
+
+// Streams the audio of the desired file in the DLNA MP3 profile.
+func MP3Transcode(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipe(ctx, mp3TranscodeArgs(path, start, length), stderr)
+}
+
+func mp3TranscodeArgs(path string, start, length time.Duration) []string {
+	return append(audioTranscodeArgs(path, start, length),
+		"-c:a", "libmp3lame", "-b:a", "320k", "-ar", "44100",
+		"-id3v2_version", "0", "-write_xing", "0",
+		"-f", "mp3",
+		"pipe:",
+	)
+}
+
+// Streams the audio of the desired file as AAC-LC in an ADTS stream.
+func AACTranscode(ctx context.Context, path string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipe(ctx, aacTranscodeArgs(path, start, length), stderr)
+}
+
+func aacTranscodeArgs(path string, start, length time.Duration) []string {
+	return append(audioTranscodeArgs(path, start, length),
+		// DLNA's AAC_ADTS profiles only go up to 48kHz, and sources like hi-res FLAC are above it.
+		"-c:a", "aac", "-profile:a", "aac_low", "-b:a", "256k", "-ac", "2", "-ar", "48000",
+		"-f", "adts",
+		"pipe:",
+	)
+}
+
+// Streams the audio of the desired file as 16 bit big-endian PCM, in the DLNA LPCM profile, at
+// the sample rate and channels given. These should come from LPCMParams with the same probe info
+// the stream's MIME-type is advertised with, as renderers play it by those parameters.
+func LPCMTranscode(ctx context.Context, path string, start, length time.Duration, sampleRate, channels int, stderr io.Writer) (r io.ReadCloser, err error) {
+	return transcodePipe(ctx, lpcmTranscodeArgs(path, start, length, sampleRate, channels), stderr)
+}
+
+func lpcmTranscodeArgs(path string, start, length time.Duration, sampleRate, channels int) []string {
+	return append(audioTranscodeArgs(path, start, length),
+		"-c:a", "pcm_s16be",
+		"-ar", strconv.Itoa(sampleRate),
+		"-ac", strconv.Itoa(channels),
+		"-f", "s16be",
+		"pipe:",
+	)
+}
+
 // credit laurent @ https://stackoverflow.com/questions/34118732/parse-a-command-line-string-into-flags-and-arguments-in-golang
 func parseCommandLine(command string) ([]string, error) {
 	var args []string
@@ -360,6 +505,48 @@ func parseCommandLine(command string) ([]string, error) {
 	return args, nil
 }
 
+// Remux copies the streams read from r into the container format given, like "mpegts" or
+// "matroska", without transcoding them.
+func Remux(ctx context.Context, r io.Reader, format string, stderr io.Writer) (io.ReadCloser, error) {
+	args := []string{
+		"ffmpeg",
+		"-hide_banner",
+		"-i", "pipe:0",
+		"-map", "0",
+		"-c", "copy",
+	}
+	if format == "mp4" {
+		// MP4 can't be written to a pipe otherwise.
+		args = append(args, "-movflags", "+frag_keyframe+empty_moov")
+	}
+	args = append(args, "-f", format, "pipe:1")
+	log.Println("remux command:", args)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.Stdin = r
+	cmd.Stderr = stderr
+	// Not cmd.StdoutPipe, as Wait would close it while what ffmpeg wrote is still unread.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout = pw
+	err = cmd.Start()
+	pw.Close()
+	if err != nil {
+		pr.Close()
+		return nil, err
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil && ctx.Err() == nil {
+			log.Printf("command %s failed: %s", args, err)
+		}
+	}()
+	return pr, nil
+}
+
 // Exec runs the cmd to generate the video to stream. It does not support seeking. Used by the dynamic stream feature.
 func Exec(ctx context.Context, cmds string, start, length time.Duration, stderr io.Writer) (r io.ReadCloser, err error) {
 	cmda, aerr := parseCommandLine(cmds)
@@ -0,0 +1,82 @@
+package transcode
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/ffprobe"
+)
+
+func audioInfo(sampleRate, channels string) *ffprobe.Info {
+	return &ffprobe.Info{Streams: []map[string]interface{}{
+		{"index": json.Number("0"), "codec_type": "video", "codec_name": "mjpeg"},
+		{"index": json.Number("1"), "codec_type": "audio", "sample_rate": sampleRate, "channels": json.Number(channels)},
+	}}
+}
+
+func TestLPCMParams(t *testing.T) {
+	for _, tc := range []struct {
+		info                 *ffprobe.Info
+		sampleRate, channels int
+	}{
+		{nil, 44100, 2},
+		{audioInfo("44100", "2"), 44100, 2},
+		{audioInfo("88200", "2"), 44100, 2},
+		{audioInfo("96000", "6"), 48000, 2},
+		{audioInfo("48000", "1"), 48000, 1},
+		{audioInfo("22050", "1"), 44100, 1},
+	} {
+		sampleRate, channels := LPCMParams(tc.info)
+		if sampleRate != tc.sampleRate || channels != tc.channels {
+			t.Errorf("got %d/%d, expected %d/%d", sampleRate, channels, tc.sampleRate, tc.channels)
+		}
+	}
+}
+
+// Returns the value following the flag in the arguments.
+func argValue(args []string, flag string) string {
+	for i, a := range args {
+		if a == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+func TestAudioTranscodeArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args                      []string
+		codec, format, sampleRate string
+	}{
+		{mp3TranscodeArgs("x.flac", time.Minute, 0), "libmp3lame", "mp3", "44100"},
+		{aacTranscodeArgs("x.flac", time.Minute, 0), "aac", "adts", "48000"},
+		{lpcmTranscodeArgs("x.flac", time.Minute, 0, 48000, 1), "pcm_s16be", "s16be", "48000"},
+	} {
+		if c := argValue(tc.args, "-c:a"); c != tc.codec {
+			t.Errorf("codec %q, expected %q", c, tc.codec)
+		}
+		if f := argValue(tc.args, "-f"); f != tc.format {
+			t.Errorf("format %q, expected %q", f, tc.format)
+		}
+		if ar := argValue(tc.args, "-ar"); ar != tc.sampleRate {
+			t.Errorf("sample rate %q, expected %q", ar, tc.sampleRate)
+		}
+		if m := argValue(tc.args, "-map"); m != "0:a:0" {
+			t.Errorf("map %q", m)
+		}
+		if ss := argValue(tc.args, "-ss"); ss != "0:01:00" {
+			t.Errorf("start %q", ss)
+		}
+		if l := argValue(tc.args, "-t"); l != "" {
+			t.Errorf("length %q without one given", l)
+		}
+	}
+	args := lpcmTranscodeArgs("x.flac", 0, time.Second, 48000, 1)
+	if argValue(args, "-ar") != "48000" || argValue(args, "-ac") != "1" {
+		t.Errorf("LPCM args %q don't have the given rate and channels", args)
+	}
+	if argValue(args, "-t") != "0:00:01" {
+		t.Errorf("length in %q", args)
+	}
+}
@@ -76,10 +76,63 @@ func (me *Eventing) Subscribe(callback []*url.URL, timeoutSeconds int) (sid stri
 	return
 }
 
+// Renew extends an existing subscription.
+func (me *Eventing) Renew(sid string, timeoutSeconds int) (actualTimeout int, err error) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ssr, ok := me.subscribers[sid]
+	if !ok || time.Now().After(ssr.expiry) {
+		err = fmt.Errorf("no such subscription: %s", sid)
+		return
+	}
+	ssr.expiry = time.Now().Add(time.Duration(timeoutSeconds) * time.Second)
+	actualTimeout = timeoutSeconds
+	return
+}
+
 func (me *Eventing) Unsubscribe(sid string) error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	if _, ok := me.subscribers[sid]; !ok {
+		return fmt.Errorf("no such subscription: %s", sid)
+	}
+	delete(me.subscribers, sid)
 	return nil
 }
 
+// Where, and with what sequence number, to send a subscriber an event.
+type Subscription struct {
+	SID  string
+	URLs []*url.URL
+	Seq  uint32
+}
+
+func (me *subscriber) nextEvent() (ret Subscription) {
+	ret = Subscription{SID: me.sid, URLs: me.urls, Seq: me.nextSeq}
+	me.nextSeq++
+	if me.nextSeq == 0 {
+		me.nextSeq = 1
+	}
+	return
+}
+
+// NextEvent returns the subscription to send its next event to, and advances its sequence
+// number. The first event for a subscription is its initial event. Expired subscriptions are
+// dropped.
+func (me *Eventing) NextEvent(sid string) (Subscription, bool) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	ssr, ok := me.subscribers[sid]
+	if !ok {
+		return Subscription{}, false
+	}
+	if time.Now().After(ssr.expiry) {
+		delete(me.subscribers, sid)
+		return Subscription{}, false
+	}
+	return ssr.nextEvent(), true
+}
+
 var callbackURLRegexp = regexp.MustCompile("<(.*?)>")
 
 // Parse the CALLBACK HTTP header in an event subscription request. See UPnP
@@ -59,3 +59,29 @@ func TestSubscribeRace(t *testing.T) {
 	<-done
 	<-done
 }
+
+func TestEventSequence(t *testing.T) {
+	e := &Eventing{}
+	sid, _, err := e.Subscribe(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := e.NextEvent(sid); !ok || s.Seq != 0 {
+		t.Fatalf("got %+v, %v", s, ok)
+	}
+	if s, ok := e.NextEvent(sid); !ok || s.SID != sid || s.Seq != 1 {
+		t.Fatalf("got %+v, %v", s, ok)
+	}
+	if _, err := e.Renew(sid, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Unsubscribe(sid); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := e.NextEvent(sid); ok {
+		t.Fatalf("got %+v", s)
+	}
+	if _, err := e.Renew(sid, 10); err == nil {
+		t.Fatal("expected error renewing after unsubscribing")
+	}
+}
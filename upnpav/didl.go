@@ -0,0 +1,150 @@
+package upnpav
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// The namespaces of DIDL-Lite documents, and the vendor extensions seen in them.
+const (
+	NamespaceDIDLLite = "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"
+	NamespaceDC       = "http://purl.org/dc/elements/1.1/"
+	NamespaceUPnP     = "urn:schemas-upnp-org:metadata-1-0/upnp/"
+	NamespaceDLNA     = "urn:schemas-dlna-org:metadata-1-0/"
+	NamespaceSEC      = "http://www.sec.co.kr/"
+	NamespacePV       = "http://www.pv.com/pvns/"
+	namespaceXML      = "http://www.w3.org/XML/1998/namespace"
+)
+
+// The prefixes the struct tags in this package use for each namespace. DIDL-Lite elements
+// themselves are unprefixed.
+var namespacePrefixes = map[string]string{
+	NamespaceDIDLLite: "",
+	NamespaceDC:       "dc",
+	NamespaceUPnP:     "upnp",
+	NamespaceDLNA:     "dlna",
+	NamespaceSEC:      "sec",
+	NamespacePV:       "pv",
+	namespaceXML:      "xml",
+}
+
+// DIDLLite is a DIDL-Lite document, as in the Result of a ContentDirectory Browse or Search.
+type DIDLLite struct {
+	Containers []Container
+	Items      []Item
+	Desc       []Desc
+}
+
+// Objects returns the containers and items in the document, containers first.
+func (d DIDLLite) Objects() (ret []Object) {
+	for _, c := range d.Containers {
+		ret = append(ret, c.Object)
+	}
+	for _, i := range d.Items {
+		ret = append(ret, i.Object)
+	}
+	return
+}
+
+// StartDIDLLite returns the DIDL-Lite root element, declaring the namespaces used by this package.
+func StartDIDLLite() xml.StartElement {
+	start := xml.StartElement{
+		Name: xml.Name{Local: "DIDL-Lite"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: NamespaceDIDLLite}},
+	}
+	for _, ns := range []string{NamespaceDC, NamespaceUPnP, NamespaceDLNA, NamespaceSEC, NamespacePV} {
+		start.Attr = append(start.Attr, xml.Attr{
+			Name:  xml.Name{Local: "xmlns:" + namespacePrefixes[ns]},
+			Value: ns,
+		})
+	}
+	return start
+}
+
+// MarshalXML writes the document with its namespace declarations.
+func (d DIDLLite) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start = StartDIDLLite()
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, v := range []interface{}{d.Containers, d.Items, d.Desc} {
+		if err := e.Encode(v); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// UnmarshalXML reads a document from any server, however it prefixes the namespaces. Elements in
+// namespaces this package doesn't know are ignored.
+func (d *DIDLLite) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	type didlLite struct {
+		Containers []Container `xml:"container"`
+		Items      []Item      `xml:"item"`
+		Desc       []Desc      `xml:"desc"`
+	}
+	var v didlLite
+	if err := xml.NewTokenDecoder(&prefixingTokenReader{d: dec, next: start}).Decode(&v); err != nil {
+		return err
+	}
+	*d = DIDLLite(v)
+	return nil
+}
+
+// Renames the elements and attributes of a single element and its contents from namespace URLs to
+// the prefixes used by the struct tags in this package. encoding/xml can't otherwise match both a
+// prefix in a tag for marshalling, and the namespace URL when unmarshalling.
+type prefixingTokenReader struct {
+	d     *xml.Decoder
+	next  xml.Token
+	depth int
+	done  bool
+}
+
+func (me *prefixingTokenReader) Token() (t xml.Token, err error) {
+	if me.done {
+		return nil, io.EOF
+	}
+	if me.next != nil {
+		t, me.next = me.next, nil
+	} else {
+		t, err = me.d.Token()
+		if err != nil {
+			return
+		}
+		t = xml.CopyToken(t)
+	}
+	switch tt := t.(type) {
+	case xml.StartElement:
+		me.depth++
+		tt.Name = prefixedName(tt.Name)
+		attrs := make([]xml.Attr, 0, len(tt.Attr))
+		for _, a := range tt.Attr {
+			if a.Name.Space == "xmlns" || a.Name.Space == "" && a.Name.Local == "xmlns" {
+				// The declarations have been applied already.
+				continue
+			}
+			a.Name = prefixedName(a.Name)
+			attrs = append(attrs, a)
+		}
+		tt.Attr = attrs
+		t = tt
+	case xml.EndElement:
+		me.depth--
+		me.done = me.depth == 0
+		tt.Name = prefixedName(tt.Name)
+		t = tt
+	}
+	return
+}
+
+func prefixedName(n xml.Name) xml.Name {
+	prefix, ok := namespacePrefixes[n.Space]
+	if !ok {
+		return n
+	}
+	if prefix == "" {
+		return xml.Name{Local: n.Local}
+	}
+	return xml.Name{Local: prefix + ":" + n.Local}
+}
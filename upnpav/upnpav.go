@@ -2,6 +2,8 @@ package upnpav
 
 import (
 	"encoding/xml"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -15,10 +17,29 @@ type Resource struct {
 	XMLName      xml.Name `xml:"res"`
 	ProtocolInfo string   `xml:"protocolInfo,attr"`
 	URL          string   `xml:",chardata"`
+	ImportURI    string   `xml:"importUri,attr,omitempty"`
 	Size         uint64   `xml:"size,attr,omitempty"`
-	Bitrate      uint     `xml:"bitrate,attr,omitempty"`
 	Duration     string   `xml:"duration,attr,omitempty"`
-	Resolution   string   `xml:"resolution,attr,omitempty"`
+	// In bytes per second, despite the name.
+	Bitrate         uint   `xml:"bitrate,attr,omitempty"`
+	SampleFrequency uint   `xml:"sampleFrequency,attr,omitempty"`
+	BitsPerSample   uint   `xml:"bitsPerSample,attr,omitempty"`
+	NrAudioChannels uint   `xml:"nrAudioChannels,attr,omitempty"`
+	Resolution      string `xml:"resolution,attr,omitempty"`
+	ColorDepth      uint   `xml:"colorDepth,attr,omitempty"`
+	Protection      string `xml:"protection,attr,omitempty"`
+	Language        string `xml:"xml:lang,attr,omitempty"`
+	// The DVD IFO file for a VOB resource.
+	IFOFileURI string `xml:"dlna:ifoFileURI,attr,omitempty"`
+	// Panasonic renderers look for a video's subtitles here.
+	PVSubtitleFileURI  string `xml:"pv:subtitleFileUri,attr,omitempty"`
+	PVSubtitleFileType string `xml:"pv:subtitleFileType,attr,omitempty"`
+}
+
+// SecCaptionInfo points Samsung renderers at the subtitles for a video.
+type SecCaptionInfo struct {
+	Type string `xml:"sec:type,attr"`
+	URL  string `xml:",chardata"`
 }
 
 // Container description
@@ -26,31 +47,150 @@ type Container struct {
 	Object
 	XMLName    xml.Name `xml:"container"`
 	ChildCount int      `xml:"childCount,attr"`
+	// The classes of object that can be created in, or searched for within, the container.
+	CreateClasses []ClassSpec `xml:"upnp:createClass,omitempty"`
+	SearchClasses []ClassSpec `xml:"upnp:searchClass,omitempty"`
 }
 
 // Item description
 type Item struct {
 	Object
-	XMLName  xml.Name `xml:"item"`
-	Res      []Resource
-	InnerXML string `xml:",innerxml"`
+	XMLName       xml.Name         `xml:"item"`
+	Res           []Resource       `xml:"res"`
+	CaptionInfoEx []SecCaptionInfo `xml:"sec:CaptionInfoEx"`
 }
 
-// Object description
+// Object description. Properties that DIDL-Lite allows more than once are slices.
 type Object struct {
-	ID          string    `xml:"id,attr"`
-	ParentID    string    `xml:"parentID,attr"`
-	Restricted  int       `xml:"restricted,attr"` // indicates whether the object is modifiable
-	Title       string    `xml:"dc:title"`
-	Class       string    `xml:"upnp:class"`
-	Icon        string    `xml:"upnp:icon,omitempty"`
-	Date        Timestamp `xml:"dc:date"`
-	Artist      string    `xml:"upnp:artist,omitempty"`
-	Album       string    `xml:"upnp:album,omitempty"`
-	Genre       string    `xml:"upnp:genre,omitempty"`
-	AlbumArtURI string    `xml:"upnp:albumArtURI,omitempty"`
-	Searchable  int       `xml:"searchable,attr"`
-	SearchXML   string    `xml:",innerxml"`
+	ID       string `xml:"id,attr"`
+	ParentID string `xml:"parentID,attr"`
+	// The ID of the object this one refers to, for objects that appear in several containers.
+	RefID        string        `xml:"refID,attr,omitempty"`
+	Restricted   Bool          `xml:"restricted,attr"` // indicates whether the object is modifiable
+	Searchable   Bool          `xml:"searchable,attr"`
+	Title        string        `xml:"dc:title"`
+	Creator      string        `xml:"dc:creator,omitempty"`
+	Class        string        `xml:"upnp:class"`
+	WriteStatus  string        `xml:"upnp:writeStatus,omitempty"`
+	Icon         string        `xml:"upnp:icon,omitempty"`
+	Date         Timestamp     `xml:"dc:date"`
+	Artists      []Person      `xml:"upnp:artist,omitempty"`
+	Actors       []Person      `xml:"upnp:actor,omitempty"`
+	Authors      []Person      `xml:"upnp:author,omitempty"`
+	Producers    []string      `xml:"upnp:producer,omitempty"`
+	Directors    []string      `xml:"upnp:director,omitempty"`
+	Publishers   []string      `xml:"dc:publisher,omitempty"`
+	Contributors []string      `xml:"dc:contributor,omitempty"`
+	Album        string        `xml:"upnp:album,omitempty"`
+	Genres       []string      `xml:"upnp:genre,omitempty"`
+	Playlists    []string      `xml:"upnp:playlist,omitempty"`
+	AlbumArtURIs []AlbumArtURI `xml:"upnp:albumArtURI,omitempty"`
+	// The number of the track on its album.
+	OriginalTrackNumber int      `xml:"upnp:originalTrackNumber,omitempty"`
+	Description         string   `xml:"dc:description,omitempty"`
+	LongDescription     string   `xml:"upnp:longDescription,omitempty"`
+	Languages           []string `xml:"dc:language,omitempty"`
+	Rights              []string `xml:"dc:rights,omitempty"`
+	Relations           []string `xml:"dc:relation,omitempty"`
+	Rating              string   `xml:"upnp:rating,omitempty"`
+	Region              string   `xml:"upnp:region,omitempty"`
+	StorageMedium       string   `xml:"upnp:storageMedium,omitempty"`
+	// For episodes of a series.
+	SeriesTitle   string `xml:"upnp:seriesTitle,omitempty"`
+	EpisodeSeason int    `xml:"upnp:episodeSeason,omitempty"`
+	EpisodeNumber int    `xml:"upnp:episodeNumber,omitempty"`
+	// For broadcasts.
+	ProgramTitle string `xml:"upnp:programTitle,omitempty"`
+	ChannelName  string `xml:"upnp:channelName,omitempty"`
+	ChannelNr    int    `xml:"upnp:channelNr,omitempty"`
+	// Vendor metadata.
+	Desc []Desc `xml:"desc,omitempty"`
+}
+
+// Person is a contributor to an object, such as an upnp:artist or upnp:actor, in a role such as
+// "AlbumArtist" or "Composer" for artists, or a character for actors.
+type Person struct {
+	Name string `xml:",chardata"`
+	Role string `xml:"role,attr,omitempty"`
+}
+
+// AlbumArtURI is a link to cover art, optionally with the DLNA profile of the image.
+type AlbumArtURI struct {
+	URL       string `xml:",chardata"`
+	ProfileID string `xml:"dlna:profileID,attr,omitempty"`
+}
+
+// ClassSpec is a upnp:createClass or upnp:searchClass.
+type ClassSpec struct {
+	Class          string `xml:",chardata"`
+	Name           string `xml:"name,attr,omitempty"`
+	IncludeDerived Bool   `xml:"includeDerived,attr"`
+}
+
+// Desc holds metadata in another namespace, as the XML it was given in.
+type Desc struct {
+	ID        string `xml:"id,attr"`
+	NameSpace string `xml:"nameSpace,attr"`
+	Type      string `xml:"type,attr,omitempty"`
+	InnerXML  string `xml:",innerxml"`
+}
+
+// UnmarshalXML re-encodes the contents of the desc, as the namespaced decoding DIDLLite does can't
+// use innerxml.
+func (d *Desc) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	for _, a := range start.Attr {
+		switch a.Name.Local {
+		case "id":
+			d.ID = a.Value
+		case "nameSpace":
+			d.NameSpace = a.Value
+		case "type":
+			d.Type = a.Value
+		}
+	}
+	var b strings.Builder
+	e := xml.NewEncoder(&b)
+	for depth := 0; ; {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch t.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				if err := e.Flush(); err != nil {
+					return err
+				}
+				d.InnerXML = b.String()
+				return nil
+			}
+			depth--
+		}
+		if err := e.EncodeToken(t); err != nil {
+			return err
+		}
+	}
+}
+
+// Bool is an XML boolean that's written as 1 or 0, which renderers handle better than true and
+// false.
+type Bool bool
+
+// MarshalXMLAttr writes the Bool as 1 or 0.
+func (b Bool) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	if b {
+		return xml.Attr{Name: name, Value: "1"}, nil
+	}
+	return xml.Attr{Name: name, Value: "0"}, nil
+}
+
+// UnmarshalXMLAttr reads any of the XML boolean spellings.
+func (b *Bool) UnmarshalXMLAttr(attr xml.Attr) error {
+	v, err := strconv.ParseBool(strings.TrimSpace(attr.Value))
+	*b = Bool(v)
+	return err
 }
 
 // Timestamp wraps time.Time for formatting purposes
@@ -62,3 +202,30 @@ type Timestamp struct {
 func (t Timestamp) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	return e.EncodeElement(t.Format("2006-01-02"), start)
 }
+
+// The forms of dc:date seen from servers, from the ISO 8601 subset the spec allows.
+var timestampLayouts = []string{
+	"2006-01-02",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01",
+	"2006",
+}
+
+// UnmarshalXML parses a date, with or without a time. Dates that can't be parsed are left zero,
+// rather than failing the whole document.
+func (t *Timestamp) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var s string
+	if err := d.DecodeElement(&s, &start); err != nil {
+		return err
+	}
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if tm, err := time.Parse(layout, s); err == nil {
+			t.Time = tm
+			return nil
+		}
+	}
+	t.Time = time.Time{}
+	return nil
+}
@@ -0,0 +1,126 @@
+package upnpav
+
+import (
+	"encoding/xml"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDIDLLiteRoundTrip(t *testing.T) {
+	in := DIDLLite{
+		Containers: []Container{{
+			Object: Object{
+				ID:         "1",
+				ParentID:   "0",
+				Restricted: true,
+				Searchable: true,
+				Title:      "Music",
+				Class:      "object.container.storageFolder",
+				Date:       Timestamp{time.Date(2020, 3, 4, 0, 0, 0, 0, time.UTC)},
+			},
+			ChildCount:    2,
+			SearchClasses: []ClassSpec{{Class: "object.item.audioItem", IncludeDerived: true}},
+		}},
+		Items: []Item{{
+			Object: Object{
+				ID:         "2",
+				ParentID:   "1",
+				Restricted: true,
+				Title:      "Song",
+				Class:      "object.item.audioItem.musicTrack",
+				Date:       Timestamp{time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)},
+				Artists:    []Person{{Name: "Artist"}, {Name: "Band", Role: "AlbumArtist"}},
+				Genres:     []string{"Rock", "Pop"},
+				AlbumArtURIs: []AlbumArtURI{
+					{URL: "http://host/art.jpg", ProfileID: "JPEG_TN"},
+				},
+			},
+			Res: []Resource{{
+				ProtocolInfo:    "http-get:*:audio/flac:*",
+				URL:             "http://host/song.flac",
+				Size:            1234,
+				Duration:        "0:03:00.000",
+				SampleFrequency: 44100,
+				BitsPerSample:   16,
+				NrAudioChannels: 2,
+				Language:        "en",
+			}},
+		}},
+	}
+	data, err := xml.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		`xmlns:dc="http://purl.org/dc/elements/1.1/"`,
+		`restricted="1"`,
+		`<upnp:artist role="AlbumArtist">Band</upnp:artist>`,
+		`<upnp:albumArtURI dlna:profileID="JPEG_TN">`,
+		`nrAudioChannels="2"`,
+		`xml:lang="en"`,
+	} {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("missing %s in %s", s, data)
+		}
+	}
+	var out DIDLLite
+	if err := xml.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	// The names of elements are only filled when decoding.
+	for i := range out.Containers {
+		out.Containers[i].XMLName = xml.Name{}
+	}
+	for i := range out.Items {
+		out.Items[i].XMLName = xml.Name{}
+		for j := range out.Items[i].Res {
+			out.Items[i].Res[j].XMLName = xml.Name{}
+		}
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("got %+v\nwant %+v", out, in)
+	}
+}
+
+func TestDIDLLiteUnmarshalForeign(t *testing.T) {
+	const doc = `<DIDL-Lite xmlns="urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/"
+	xmlns:d="http://purl.org/dc/elements/1.1/"
+	xmlns:u="urn:schemas-upnp-org:metadata-1-0/upnp/"
+	xmlns:x="urn:example">
+<item id="a" parentID="b" restricted="true">
+	<d:title>Film</d:title>
+	<u:class>object.item.videoItem.movie</u:class>
+	<d:date>2011-05-06T07:08:09</d:date>
+	<u:actor role="Hero">Someone</u:actor>
+	<u:actor>Someone Else</u:actor>
+	<x:unknown>ignored</x:unknown>
+	<res protocolInfo="http-get:*:video/mp4:*" resolution="1920x1080">http://host/film.mp4</res>
+	<desc id="info" nameSpace="urn:example"><x:rating>5</x:rating></desc>
+</item>
+</DIDL-Lite>`
+	var d DIDLLite
+	if err := xml.Unmarshal([]byte(doc), &d); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Items) != 1 {
+		t.Fatalf("got %+v", d)
+	}
+	item := d.Items[0]
+	if item.Title != "Film" || item.Class != "object.item.videoItem.movie" || !item.Restricted {
+		t.Errorf("got %+v", item.Object)
+	}
+	if !item.Date.Equal(time.Date(2011, 5, 6, 7, 8, 9, 0, time.UTC)) {
+		t.Errorf("date %v", item.Date)
+	}
+	if !reflect.DeepEqual(item.Actors, []Person{{"Someone", "Hero"}, {"Someone Else", ""}}) {
+		t.Errorf("actors %+v", item.Actors)
+	}
+	if len(item.Res) != 1 || item.Res[0].URL != "http://host/film.mp4" || item.Res[0].Resolution != "1920x1080" {
+		t.Errorf("res %+v", item.Res)
+	}
+	if len(item.Desc) != 1 || item.Desc[0].NameSpace != "urn:example" || !strings.Contains(item.Desc[0].InnerXML, ">5</rating>") {
+		t.Errorf("desc %+v", item.Desc)
+	}
+}